@@ -19,18 +19,7 @@
 #
 set -euo pipefail
 
-yum install -y python unzip lvm2
-curl "https://s3.amazonaws.com/aws-cli/awscli-bundle.zip" -o "awscli-bundle.zip"
-unzip awscli-bundle.zip
-./awscli-bundle/install -i /usr/local/aws -b /usr/bin/aws
-
-mkfs.ext4 /dev/xvdc
-echo -e '/dev/xvdc\t/var/lib/gravity/planet/etcd\text4\tdefaults\t0\t2' >> /etc/fstab
-
-mkdir -p /var/lib/gravity/planet/etcd /var/lib/data
-mount /var/lib/gravity/planet/etcd
-
-chown -R 1000:1000 /var/lib/gravity /var/lib/data /var/lib/gravity/planet/etcd
+# Only SSH access is set up here, robotest prepares the node over SSH
 sed -i.bak 's/Defaults    requiretty/#Defaults    requiretty/g' /etc/sudoers
 
 # robotest might SSH before bootstrap script is complete (and will fail)
@@ -0,0 +1,48 @@
+#
+# SSH bastion host
+#
+# Provisioned with bastion=true to access nodes without public IPs.
+# The nodes still require outbound connectivity (i.e. Cloud NAT on the subnet)
+# to bootstrap.
+
+resource "google_compute_instance" "bastion" {
+  description  = "Instance is the SSH bastion host of a single robotest cluster"
+  count        = var.bastion ? 1 : 0
+  name         = "${var.node_tag}-bastion"
+  machine_type = "e2-small"
+  zone         = local.zone
+
+  tags = [
+    "robotest",
+    "${var.node_tag}-bastion",
+  ]
+
+  labels = {
+    robotest = ""
+    cluster  = var.node_tag
+  }
+
+  network_interface {
+    subnetwork = data.google_compute_subnetwork.robotest.self_link
+
+    access_config {
+      # Ephemeral IP
+    }
+  }
+
+  metadata = {
+    ssh-keys = "${var.os_user}:${file(var.ssh_pub_key_path)}"
+  }
+
+  boot_disk {
+    initialize_params {
+      image = var.oss["ubuntu:latest"]
+    }
+    auto_delete = true
+  }
+
+  scheduling {
+    preemptible       = var.preemptible
+    automatic_restart = var.preemptible ? "false" : "true"
+  }
+}
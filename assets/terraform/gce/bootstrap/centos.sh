@@ -15,7 +15,8 @@
 # limitations under the License.
 
 #
-# VM bootstrap script for CentOS/RHEL
+# VM bootstrap script for CentOS/RHEL.
+# Only sets up SSH access, robotest prepares the node over SSH
 #
 set -o errexit
 set -o errtrace
@@ -27,10 +28,6 @@ rm -f /var/lib/bootstrap_*
 touch /var/lib/bootstrap_started
 trap exit_handler EXIT
 
-etcd_device_name=sdb
-etcd_dir=/var/lib/gravity/planet/etcd
-DIR="$(cd "$(dirname "$${BASH_SOURCE[0]}")" >/dev/null && pwd)"
-
 function exit_handler {
   if [[ $? -ne 0 ]]; then
     touch -f /var/lib/bootstrap_failed
@@ -99,60 +96,8 @@ rm -f /etc/yum.repos.d/epel.repo
 rm -f /etc/yum.repos.d/epel-testing.repo
 rm -f /etc/yum.repos.d/google-cloud.repo
 
+# setup-user installs semanage with yum
 yum -y update ca-certificates
-yum -y install chrony
 
-mkdir -p $etcd_dir /var/lib/data
 secure-ssh
 setup-user
-
-dns_running=0
-systemctl is-active --quiet dnsmasq || dns_running=$?
-if [ $dns_running -eq 0 ] ; then
-  systemctl stop dnsmasq || true
-  systemctl disable dnsmasq
-fi
-
-if ! /usr/local/bin/aws --version; then
-  yum -y install unzip
-  curl "https://awscli.amazonaws.com/awscli-exe-linux-x86_64-2.0.30.zip" -o "awscliv2.zip"
-  unzip awscliv2.zip
-  ./aws/install
-  rm -rf awscliv2.zip ./aws
-fi
-
-if ! grep -qs "$etcd_dir" /proc/mounts; then
-  mkfs.ext4 -F /dev/$etcd_device_name
-  sed -i.bak "/$etcd_device_name/d" /etc/fstab
-  echo -e "/dev/$etcd_device_name\t$etcd_dir\text4\tdefaults\t0\t2" >> /etc/fstab
-  mount $etcd_dir
-fi
-
-## Setup modules / sysctls
-# Load required kernel modules
-modules="br_netfilter overlay ebtable_filter ip_tables iptable_filter iptable_nat"
-for module in $modules; do
-  modprobe $module || true
-done
-# Store the modules into a file so that the modules will be auto-reloaded
-# on reboot
-echo '' > /etc/modules-load.d/telekube.conf
-for module in $modules; do
-  echo $module >> /etc/modules-load.d/telekube.conf
-done
-
-# Make changes permanent
-cat > /etc/sysctl.d/50-telekube.conf <<EOF
-net.ipv4.ip_forward=1
-net.bridge.bridge-nf-call-iptables=1
-net.ipv4.tcp_keepalive_time=60
-net.ipv4.tcp_keepalive_intvl=60
-net.ipv4.tcp_keepalive_probes=5
-EOF
-if sysctl -w fs.may_detach_mounts=1; then
-  # fs.may_detach_mounts is needed in rhel/cent 7, but not present in rhel/cent 8
-  # https://gravitational.com/gravity/docs/faq/#kubernetes-pods-stuck-in-terminating-state
-  echo "fs.may_detach_mounts=1" >> /etc/sysctl.d/50-telekube.conf
-fi
-
-sysctl -p /etc/sysctl.d/50-telekube.conf
@@ -15,7 +15,8 @@
 # limitations under the License.
 
 #
-# VM bootstrap script for SuSE
+# VM bootstrap script for SuSE.
+# Only sets up SSH access and the root filesystem, robotest prepares the node over SSH
 #
 set -o errexit
 set -o errtrace
@@ -27,10 +28,6 @@ rm -f /var/lib/bootstrap_*
 touch /var/lib/bootstrap_started
 trap exit_handler EXIT
 
-etcd_device_name=sdb
-etcd_dir=/var/lib/gravity/planet/etcd
-DIR="$(cd "$(dirname "$${BASH_SOURCE[0]}")" >/dev/null && pwd)"
-
 function exit_handler {
   if [[ $? -ne 0 ]]; then
     touch -f /var/lib/bootstrap_failed
@@ -76,28 +73,9 @@ function setup-user {
   sed -i.bak 's/Defaults    requiretty/#Defaults    requiretty/g' /etc/sudoers
 }
 
-mkdir -p $etcd_dir /var/lib/data
-
 secure-ssh
 setup-user
 
-if ! /usr/local/bin/aws --version; then
-  curl "https://awscli.amazonaws.com/awscli-exe-linux-x86_64-2.0.30.zip" -o "awscliv2.zip"
-  unzip awscliv2.zip
-  ./aws/install
-  rm -rf awscliv2.zip ./aws
-fi
-
-
-mkdir -p /var/lib/gravity/planet/etcd /var/lib/data
-
-if ! grep -qs "$etcd_dir" /proc/mounts; then
-  mkfs.ext4 -F /dev/$etcd_device_name
-  sed -i.bak "/$etcd_device_name/d" /etc/fstab
-  echo -e "/dev/$etcd_device_name\t$etcd_dir\text4\tdefaults\t0\t2" >> /etc/fstab
-  mount $etcd_dir
-fi
-
 # grow-root-fs expands '/' to use all available space on the device.
 #
 # The GCP sles-12-sp5-v20200610 image doesn't recognize extra space in drives
@@ -129,26 +107,3 @@ function grow-root-fs {
 }
 
 grow-root-fs
-
-## Setup modules / sysctls
-# Load required kernel modules
-modules="br_netfilter overlay ebtable_filter ip_tables iptable_filter iptable_nat"
-for module in $modules; do
-  modprobe $module || true
-done
-# Store the modules into a file so that the modules will be auto-reloaded
-# on reboot
-echo '' > /etc/modules-load.d/telekube.conf
-for module in $modules; do
-  echo $module >> /etc/modules-load.d/telekube.conf
-done
-
-# Make changes permanent
-cat > /etc/sysctl.d/50-telekube.conf <<EOF
-net.ipv4.ip_forward=1
-net.bridge.bridge-nf-call-iptables=1
-net.ipv4.tcp_keepalive_time=60
-net.ipv4.tcp_keepalive_intvl=60
-net.ipv4.tcp_keepalive_probes=5
-EOF
-sysctl -p /etc/sysctl.d/50-telekube.conf
@@ -47,16 +47,40 @@ variable "ssh_pub_key_path" {
 }
 
 variable "nodes" {
-  description = "Number of nodes to provision"
+  description = "Number of nodes to provision. Ignored if node_groups is not empty"
   type        = string
   default     = 1
 }
 
+variable "node_groups" {
+  description = "Named groups of nodes, each with its own count, VM type (empty to use vm_type), extra disk sizes (GB) and labels"
+  type = list(object({
+    name    = string
+    count   = number
+    vm_type = string
+    disks   = list(number)
+    labels  = map(string)
+  }))
+  default = []
+}
+
 variable "os" {
   description = "Linux distribution as name:version, i.e. debian:9"
   type        = string
 }
 
+variable "image" {
+  description = "OS image to boot from the OS catalog. Defaults to the image for os in oss"
+  type        = string
+  default     = ""
+}
+
+variable "bootstrap_script" {
+  description = "Path to the bootstrap script from the OS catalog. Defaults to bootstrap/<vendor>.sh"
+  type        = string
+  default     = ""
+}
+
 variable "disk_type" {
   description = "Disk type for VM. See https://cloud.google.com/compute/docs/disks"
   type        = string
@@ -69,6 +93,12 @@ variable "preemptible" {
   default     = "false"
 }
 
+variable "bastion" {
+  description = "Whether to provision nodes without public IPs and an SSH bastion host to access them through"
+  type        = string
+  default     = "false"
+}
+
 variable "network" {
   description = "Network for VM NIC."
   type        = string
@@ -109,3 +139,41 @@ resource "random_shuffle" "zones" {
 locals {
   zone = random_shuffle.zones.result[0]
 }
+
+# Expand node groups into a list with an entry per node.
+# Without node groups, all nodes belong to the default group named "node"
+locals {
+  nodes = concat(
+    flatten([
+      for group in var.node_groups : [
+        for i in range(group.count) : {
+          group   = group.name
+          vm_type = group.vm_type != "" ? group.vm_type : var.vm_type
+          disks   = group.disks
+          labels  = group.labels
+        }
+      ]
+    ]),
+    [
+      for i in range(length(var.node_groups) > 0 ? 0 : var.nodes) : {
+        group   = "node"
+        vm_type = var.vm_type
+        disks   = []
+        labels  = {}
+      }
+    ],
+  )
+
+  # Extra disks keyed by node index and disk index
+  extra_disks = {
+    for disk in flatten([
+      for index, node in local.nodes : [
+        for disk, size in node.disks : {
+          node = index
+          disk = disk
+          size = size
+        }
+      ]
+    ]) : "${disk.node}-${disk.disk}" => disk
+  }
+}
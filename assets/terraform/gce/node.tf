@@ -16,9 +16,9 @@ resource "google_compute_instance_group" "robotest" {
 
 resource "google_compute_instance" "node" {
   description  = "Instance is a single robotest cluster node"
-  count        = var.nodes
+  count        = length(local.nodes)
   name         = "${var.node_tag}-node-${count.index}"
-  machine_type = var.vm_type
+  machine_type = local.nodes[count.index].vm_type
   zone         = local.zone
 
   tags = [
@@ -26,16 +26,21 @@ resource "google_compute_instance" "node" {
     "${var.node_tag}-node-${count.index}",
   ]
 
-  labels = {
+  labels = merge(local.nodes[count.index].labels, {
     robotest = ""
     cluster = var.node_tag
-  }
+    node_group = local.nodes[count.index].group
+  })
 
   network_interface {
     subnetwork = data.google_compute_subnetwork.robotest.self_link
 
-    access_config {
-      # Ephemeral IP
+    # Nodes behind the bastion only have private IPs
+    dynamic "access_config" {
+      for_each = var.bastion ? [] : [1]
+      content {
+        # Ephemeral IP
+      }
     }
     # # https://www.terraform.io/docs/providers/google/r/compute_instance.html#alias_ip_range
     # # https://cloud.google.com/vpc/docs/alias-ip#key_benefits_of_alias_ip_ranges
@@ -80,6 +85,14 @@ resource "google_compute_instance" "node" {
     mode   = "READ_WRITE"
   }
 
+  dynamic "attached_disk" {
+    for_each = [for disk in values(local.extra_disks) : disk if disk.node == count.index]
+    content {
+      source = google_compute_disk.extra["${attached_disk.value.node}-${attached_disk.value.disk}"].self_link
+      mode   = "READ_WRITE"
+    }
+  }
+
   service_account {
     # TODO: consider using robotest-specific service account instead of
     # the default service account
@@ -105,12 +118,12 @@ locals {
 }
 
 resource "google_compute_disk" "boot" {
-  count = var.nodes
+  count = length(local.nodes)
   name  = "${var.node_tag}-disk-boot-${count.index}"
   type  = var.disk_type
   zone  = local.zone
   size  = local.boot_disk_size
-  image = var.oss[var.os]
+  image = coalesce(var.image, lookup(var.oss, var.os, ""))
 
   labels = {
     robotest = ""
@@ -119,7 +132,7 @@ resource "google_compute_disk" "boot" {
 }
 
 resource "google_compute_disk" "etcd" {
-  count = var.nodes
+  count = length(local.nodes)
   name  = "${var.node_tag}-disk-etcd-${count.index}"
   type  = var.disk_type
   zone  = local.zone
@@ -131,8 +144,21 @@ resource "google_compute_disk" "etcd" {
   }
 }
 
+resource "google_compute_disk" "extra" {
+  for_each = local.extra_disks
+  name     = "${var.node_tag}-disk-extra-${each.key}"
+  type     = var.disk_type
+  zone     = local.zone
+  size     = each.value.size
+
+  labels = {
+    robotest = ""
+    cluster = var.node_tag
+  }
+}
+
 data "template_file" "bootstrap" {
-  template = file("./bootstrap/${element(split(":", var.os), 0)}.sh")
+  template = file(coalesce(var.bootstrap_script, "./bootstrap/${element(split(":", var.os), 0)}.sh"))
 
   vars = {
     os_user             = var.os_user
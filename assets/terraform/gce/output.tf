@@ -7,5 +7,14 @@ output "private_ips" {
 }
 
 output "public_ips" {
-  value = google_compute_instance.node.*.network_interface.0.access_config.0.nat_ip
+  # empty if the nodes are behind the bastion
+  value = flatten([for node in google_compute_instance.node : node.network_interface.0.access_config.*.nat_ip])
+}
+
+output "bastion_ip" {
+  value = join("", google_compute_instance.bastion.*.network_interface.0.access_config.0.nat_ip)
+}
+
+output "node_groups" {
+  value = [for node in local.nodes : node.group]
 }
@@ -37,6 +37,8 @@ ALWAYS_COLLECT_LOGS=${ALWAYS_COLLECT_LOGS:-true}
 GCE_VM=${GCE_VM:-'custom-8-8192'}
 GCE_REGION=${GCE_REGION:-'northamerica-northeast1,us-west1,us-west2,us-east1,us-east4,us-central1'}
 GCE_PREEMPTIBLE=${GCE_PREEMPTIBLE:-'false'}
+# provision GCE nodes without public IPs, accessed through an SSH bastion host
+GCE_BASTION=${GCE_BASTION:-'false'}
 DOCKER_DEVICE=${DOCKER_DEVICE:-'/dev/sdc'}
 
 # choose something relatively unique to avoid intersection with other people runs
@@ -46,6 +48,14 @@ TAG=${TAG:-$(id -run)}
 # what should happen with provisioned VMs on individual test success or failure
 DESTROY_ON_SUCCESS=${DESTROY_ON_SUCCESS:-true}
 DESTROY_ON_FAILURE=${DESTROY_ON_FAILURE:-true}
+# when set, destroy resources leaked by previous runs older than GC_TTL (i.e. 24h) instead of running tests
+GC_TTL=${GC_TTL:-}
+# reset and reuse cloud VMs across tests instead of provisioning VMs for each test
+REUSE_VMS=${REUSE_VMS:-false}
+# block outbound traffic of the nodes once the installer has been transferred
+AIRGAP=${AIRGAP:-false}
+# check the configuration, credentials and installers before scheduling any test
+PREFLIGHT=${PREFLIGHT:-true}
 
 # PIN robotest version if needed
 ROBOTEST_VERSION=${ROBOTEST_VERSION:-stable}
@@ -67,7 +77,8 @@ check_files () {
 if [ $DEPLOY_TO != "azure" ] && \
     [ $DEPLOY_TO != "aws" ] && \
     [ $DEPLOY_TO != "gce" ] && \
-    [ $DEPLOY_TO != "ops" ] ; then
+    [ $DEPLOY_TO != "ops" ] && \
+    [ $DEPLOY_TO != "static" ] ; then
 	echo "Unsupported deployment cloud ${DEPLOY_TO}"
 	exit 1
 fi
@@ -103,13 +114,6 @@ fi
 if [ $DEPLOY_TO == "gce" ] ; then
 check_files ${SSH_KEY} ${SSH_PUB} ${GOOGLE_APPLICATION_CREDENTIALS}
 
-CUSTOM_VAR_FILE=$(mktemp)
-trap "{ rm -f $CUSTOM_VAR_FILE; }" EXIT
-cat <<EOF > $CUSTOM_VAR_FILE
-{"preemptible": "${GCE_PREEMPTIBLE}"}
-EOF
-EXTRA_VOLUME_MOUNTS=${EXTRA_VOLUME_MOUNTS:-}" -v "$CUSTOM_VAR_FILE:/robotest/config/vars.json
-
 GCE_CONFIG="gce:
   project: ${GCL_PROJECT_ID}
   credentials: /robotest/config/creds.json
@@ -117,7 +121,8 @@ GCE_CONFIG="gce:
   region: ${GCE_REGION}
   ssh_key_path: /robotest/config/ops.pem
   ssh_pub_key_path: /robotest/config/ops_rsa.pub
-  var_file_path: /robotest/config/vars.json"
+  preemptible: ${GCE_PREEMPTIBLE}
+  bastion: ${GCE_BASTION}"
 fi
 
 if [ $DEPLOY_TO == "ops" ] ; then
@@ -132,6 +137,21 @@ OPS_CONFIG="ops:
   key_path: /robotest/config/ops.pem"
 fi
 
+# STATIC_INVENTORY is a YAML file listing existing hosts to test on:
+#
+# hosts:
+# - addr: 10.0.0.1
+#   private_addr: 192.168.0.1
+#   ssh_user: centos
+#   key_path: /robotest/config/ops.pem
+if [ $DEPLOY_TO == "static" ] ; then
+check_files ${SSH_KEY} ${STATIC_INVENTORY}
+STATIC_CONFIG="static:
+  docker_device: ${DOCKER_DEVICE}
+${STATIC_CLEANUP_SCRIPT:+  cleanup_script: /robotest/config/cleanup.sh}
+$(sed 's/^/  /' ${STATIC_INVENTORY})"
+fi
+
 if [ -n "${GCL_PROJECT_ID:-}" ] ; then
 	check_files ${GOOGLE_APPLICATION_CREDENTIALS}
 fi
@@ -142,10 +162,12 @@ gravity_url: ${GRAVITY_FILE:-${GRAVITY_URL}}
 script_path: /robotest/terraform/${DEPLOY_TO}
 state_dir: /robotest/state
 cloud: ${DEPLOY_TO}
+airgap: ${AIRGAP}
 ${AWS_CONFIG:-}
 ${AZURE_CONFIG:-}
 ${GCE_CONFIG:-}
 ${OPS_CONFIG:-}
+${STATIC_CONFIG:-}
 "
 
 # will make verbose logging to console, pass -test.v if needed
@@ -164,6 +186,7 @@ exec docker run ${DOCKER_RUN_FLAGS} \
 	${AZURE_CONFIG:+'-v' "${SSH_PUB}:/robotest/config/ops_rsa.pub"} \
 	${GCE_CONFIG:+'-v' "${SSH_PUB}:/robotest/config/ops_rsa.pub"} \
 	${GCE_CONFIG:+'-v' "${GOOGLE_APPLICATION_CREDENTIALS}:/robotest/config/creds.json"} \
+	${STATIC_CLEANUP_SCRIPT:+'-v' "${STATIC_CLEANUP_SCRIPT}:/robotest/config/cleanup.sh"} \
 	${ROBOTEST_DEV:+'-v' "${P}/assets/terraform:/robotest/terraform"} \
 	${ROBOTEST_DEV:+'-v' "${P}/build/robotest-suite:/usr/bin/robotest-suite"} \
 	${EXTRA_VOLUME_MOUNTS:-} \
@@ -173,7 +196,10 @@ exec docker run ${DOCKER_RUN_FLAGS} \
 	${GCL_PROJECT_ID:+"-gcl-project-id=${GCL_PROJECT_ID}"} \
 	-test.parallel=${PARALLEL_TESTS} -repeat=${REPEAT_TESTS} -retries=${RETRIES} -fail-fast=${FAIL_FAST} \
 	-provision="${CLOUD_CONFIG}" -always-collect-logs=${ALWAYS_COLLECT_LOGS} \
-	-resourcegroup-file=/robotest/state/alloc.txt \
+	-resourcegroup-file=/robotest/state/ledger.jsonl \
+	${GC_TTL:+"-gc" "-gc-ttl=${GC_TTL}"} \
 	-destroy-on-success=${DESTROY_ON_SUCCESS} -destroy-on-failure=${DESTROY_ON_FAILURE} \
+	-reuse-vms=${REUSE_VMS} -preflight=${PREFLIGHT} \
+	-file-cache-dir=/robotest/state/cache \
 	-tag=${TAG} -suite=sanity -debug \
 	$@
@@ -178,6 +178,9 @@ func InitializeCluster() {
 		Expect(saveState(withoutBackup)).To(Succeed())
 		// Validate provisioning
 		Expect(err).NotTo(HaveOccurred())
+		if provisioner != nil && TestContext.Provisioner.Type == provisionerTerraform {
+			Expect(prepareNodes(context.TODO(), provisioner)).To(Succeed())
+		}
 	}
 
 	var application *loc.Locator
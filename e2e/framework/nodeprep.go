@@ -0,0 +1,131 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/nodeprep"
+	"github.com/gravitational/robotest/infra/oscatalog"
+	"github.com/gravitational/robotest/infra/providers/aws"
+	"github.com/gravitational/robotest/infra/providers/azure"
+	"github.com/gravitational/robotest/infra/providers/gce"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/wait"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+// bootstrapTimeout limits the time to wait for the bootstrap scripts
+// and to prepare the nodes
+const bootstrapTimeout = 30 * time.Minute
+
+// bootstrapCompleteFile is created by the bootstrap scripts once the SSH user is set up
+const bootstrapCompleteFile = "/var/lib/bootstrap_complete"
+
+// prepareNodes prepares the nodes created by the terraform provisioner for installation.
+// The bootstrap scripts only set up SSH access, see nodeprep
+func prepareNodes(ctx context.Context, provisioner infra.Provisioner) error {
+	catalog, err := oscatalog.LoadDir(TestContext.Onprem.ScriptPath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	image, err := catalog.Lookup(TestContext.CloudProvider, TestContext.Onprem.OS)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	config := nodeprep.Config{
+		Provider:     TestContext.CloudProvider,
+		Image:        *image,
+		EtcdDevice:   etcdDevice(),
+		DockerDevice: TestContext.Onprem.DockerDevice,
+	}
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+	group, ctx := errgroup.WithContext(ctx)
+	for _, node := range provisioner.NodePool().Nodes() {
+		node := node
+		group.Go(func() error {
+			return trace.Wrap(prepareNode(ctx, node, config))
+		})
+	}
+	return trace.Wrap(group.Wait())
+}
+
+// prepareNode waits for SSH access and the bootstrap script to complete
+// on the node and prepares the node
+func prepareNode(ctx context.Context, node infra.Node, config nodeprep.Config) error {
+	logger := log.WithField("node", node.Addr())
+	var client *ssh.Client
+	err := wait.Retry(ctx, func() (err error) {
+		client, err = node.Client()
+		if err != nil {
+			return wait.Continue("waiting for SSH: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer client.Close()
+	prep := &prepNode{
+		addr:   node.Addr(),
+		client: client,
+		log:    logger,
+	}
+	err = sshutil.WaitForFile(ctx, client, prep.log, bootstrapCompleteFile, sshutil.TestRegularFile)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(nodeprep.Prepare(ctx, prep, config))
+}
+
+// etcdDevice returns the etcd device of the configured cloud provider
+func etcdDevice() string {
+	var device, defaultDevice string
+	switch TestContext.CloudProvider {
+	case constants.AWS:
+		device, defaultDevice = TestContext.AWS.EtcdDevice, aws.DefaultEtcdDevice
+	case constants.Azure:
+		device, defaultDevice = TestContext.Azure.EtcdDevice, azure.DefaultEtcdDevice
+	case constants.GCE:
+		device, defaultDevice = TestContext.GCE.EtcdDevice, gce.DefaultEtcdDevice
+	}
+	if device == "" {
+		return defaultDevice
+	}
+	return device
+}
+
+// prepNode adapts a provisioned node for nodeprep
+type prepNode struct {
+	addr   string
+	client *ssh.Client
+	log    log.FieldLogger
+}
+
+func (r *prepNode) Client() *ssh.Client { return r.client }
+
+func (r *prepNode) Logger() log.FieldLogger { return r.log }
+
+func (r *prepNode) String() string { return r.addr }
@@ -27,6 +27,7 @@ import (
 
 	"github.com/gravitational/robotest/e2e/framework/defaults"
 	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/oscatalog"
 	"github.com/gravitational/robotest/infra/providers/aws"
 	"github.com/gravitational/robotest/infra/providers/azure"
 	"github.com/gravitational/robotest/infra/providers/gce"
@@ -148,6 +149,15 @@ func (r *TestContextType) Validate() error {
 		errors = append(errors, trace.BadParameter("Onprem configuration is required for provisioner %v",
 			TestContext.Provisioner.Type))
 	}
+	if !TestContext.Onprem.IsEmpty() && TestContext.Onprem.OS != "" {
+		catalog, err := oscatalog.LoadDir(TestContext.Onprem.ScriptPath)
+		if err == nil {
+			err = catalog.Validate(TestContext.CloudProvider, TestContext.Onprem.OS)
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err))
+		}
+	}
 	// Do not mandate AWS.AccessKey/AWS.SecretKey for terraform as scripts can be written to consume
 	// credentials not only from environment
 	return trace.NewAggregate(errors...)
@@ -321,8 +331,9 @@ type OnpremConfig struct {
 	// ExpandProfile specifies an optional name of the server profile for On-Premise expand operation.
 	// If the profile is unspecified, the test will use the first available.
 	ExpandProfile string `json:"expand_profile" yaml:"expand_profile"`
-	// OS defines OS flavor, ubuntu | redhat | centos | debian
-	OS string `json:"os" yaml:"os" validate:"required,eq=ubuntu|eq=redhat|eq=centos|eq=debian"`
+	// OS defines OS flavor as vendor[:version], i.e. ubuntu or centos:7.
+	// It is validated against the OS catalog
+	OS string `json:"os" yaml:"os" validate:"required"`
 	// DockerDevice block device for docker data - set to /dev/xvdb
 	DockerDevice string `json:"docker_device" yaml:"docker_device" validate:"required"`
 	// ClusterAddress defines configuration for accessing installed cluster web page
@@ -480,6 +491,11 @@ func makeTerraformConfig(infraConfig infra.Config) (config *terraform.Config, er
 		return nil, trace.Errorf("cloud provider parameter is required for Terraform provisioner")
 	}
 
+	catalog, err := oscatalog.LoadDir(TestContext.Onprem.ScriptPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
 	config = &terraform.Config{
 		Config:              infraConfig,
 		ScriptPath:          TestContext.Onprem.ScriptPath,
@@ -494,6 +510,7 @@ func makeTerraformConfig(infraConfig infra.Config) (config *terraform.Config, er
 		PostInstallerScript: TestContext.Onprem.PostInstallerScript,
 		VarFilePath:         TestContext.Onprem.VarFilePath,
 		OnpremProvider:      TestContext.Onprem.OnpremProvider,
+		Catalog:             catalog,
 	}
 
 	err = config.Validate()
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"github.com/gravitational/robotest/infra"
+
+	"github.com/gravitational/trace"
+)
+
+// Validate validates the configuration
+func (r *Config) Validate() error {
+	var errors []error
+	if r.ClusterName == "" {
+		errors = append(errors, trace.BadParameter("cluster name is required"))
+	}
+	if r.Image == "" {
+		errors = append(errors, trace.BadParameter("node image is required"))
+	}
+	if r.SSHUser == "" {
+		errors = append(errors, trace.BadParameter("SSH user is required"))
+	}
+	if r.SSHKeyPath == "" {
+		errors = append(errors, trace.BadParameter("SSH key path is required"))
+	}
+	if r.NumNodes <= 0 {
+		errors = append(errors, trace.BadParameter("cannot provision %v nodes", r.NumNodes))
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// Config describes the parameters to provision nodes as docker containers
+type Config struct {
+	infra.Config `yaml:",inline"`
+	// Image is the node image.
+	// The image is expected to run systemd and sshd and
+	// to have SSHUser configured with passwordless sudo
+	Image string `json:"image" yaml:"image" validate:"required"`
+	// SSHUser defines SSH user used to connect to the nodes
+	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
+	// SSHKeyPath specifies the location of the SSH key to use for remote access.
+	// Its public key is authorized for SSHUser on every node
+	SSHKeyPath string `json:"key_path" yaml:"key_path" validate:"required"`
+	// BootstrapScript specifies the location of an optional script to run
+	// on every node once it is up
+	BootstrapScript string `json:"bootstrap_script" yaml:"bootstrap_script"`
+	// InstallerURL is a path to the installer
+	InstallerURL string `json:"installer_url" yaml:"-"`
+	// NumNodes defines the capacity of the cluster to provision
+	NumNodes int `json:"nodes" yaml:"-"`
+	// DockerDevice block device for docker data
+	DockerDevice string `json:"docker_device" yaml:"docker_device"`
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/lib/constants"
+	"github.com/gravitational/robotest/lib/defaults"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/system"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// New creates a provisioner that runs nodes as containers.
+// Every cluster gets a dedicated network named after the cluster
+func New(stateDir string, config Config) (*docker, error) {
+	entry := log.WithFields(log.Fields{
+		constants.FieldProvisioner: "docker",
+		constants.FieldCluster:     config.ClusterName,
+	})
+	return &docker{
+		Entry:    entry,
+		Config:   config,
+		stateDir: stateDir,
+		// will be reset in Create
+		pool: infra.NewNodePool(nil, nil),
+		run:  execDocker(entry),
+	}, nil
+}
+
+// Create starts the node containers on a new network and authorizes
+// the configured SSH key on every node.
+// Use Destroy to remove partially created clusters after a failure
+func (r *docker) Create(ctx context.Context, withInstaller bool) (installer infra.Node, err error) {
+	publicKey, err := authorizedKey(r.SSHKeyPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	_, err = r.run(ctx, "", "network", "create", "--label", r.label(), r.ClusterName)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to create network")
+	}
+
+	nodes := make([]infra.Node, 0, r.NumNodes)
+	for i := 1; i <= r.NumNodes; i++ {
+		node, err := r.startNode(ctx, i, publicKey)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		nodes = append(nodes, node)
+	}
+	r.pool = infra.NewNodePool(nodes, nil)
+
+	if !withInstaller {
+		// No need to pick installer node
+		return nil, nil
+	}
+
+	err = r.copyInstaller(ctx, nodes...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// Use first node as installer
+	r.installerIP = nodes[0].Addr()
+	return nodes[0], nil
+}
+
+// Destroy removes the node containers and the cluster network
+func (r *docker) Destroy(ctx context.Context) error {
+	r.Debugf("destroying docker cluster: %v", r.ClusterName)
+	out, err := r.run(ctx, "", "ps", "--all", "--quiet", "--filter", "label="+r.label())
+	if err != nil {
+		return trace.Wrap(err, "failed to list containers")
+	}
+	if containers := strings.Fields(out); len(containers) != 0 {
+		_, err = r.run(ctx, "", append([]string{"rm", "--force", "--volumes"}, containers...)...)
+		if err != nil {
+			return trace.Wrap(err, "failed to remove containers")
+		}
+	}
+	_, err = r.run(ctx, "", "network", "rm", r.ClusterName)
+	return trace.Wrap(err, "failed to remove network")
+}
+
+func (r *docker) SelectInterface(installer infra.Node, addrs []string) (int, error) {
+	for i, addr := range addrs {
+		if addr == installer.Addr() {
+			return i, nil
+		}
+	}
+	return -1, trace.NotFound("failed to select installer interface from %v", addrs)
+}
+
+// Connect establishes an SSH connection to the specified address
+func (r *docker) Connect(addrIP string) (*ssh.Session, error) {
+	node, err := r.pool.Node(addrIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node.Connect()
+}
+
+func (r *docker) Client(addrIP string) (*ssh.Client, error) {
+	node, err := r.pool.Node(addrIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node.Client()
+}
+
+func (r *docker) StartInstall(session *ssh.Session) error {
+	return session.Start(installerCommand)
+}
+
+func (r *docker) UploadUpdate(session *ssh.Session) error {
+	// upload new installer to all remote nodes
+	err := r.copyInstaller(context.TODO(), r.pool.Nodes()...)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return session.Run(uploadUpdateCommand)
+}
+
+func (r *docker) NodePool() infra.NodePool {
+	return r.pool
+}
+
+func (r *docker) InstallerLogPath() string {
+	home := filepath.Join("/home", r.SSHUser)
+	if r.SSHUser == "root" {
+		home = "/root"
+	}
+	return filepath.Join(home, "installer", defaults.AgentLogPath)
+}
+
+func (r *docker) State() infra.ProvisionerState {
+	nodes := make([]infra.StateNode, 0, r.pool.Size())
+	for _, n := range r.pool.Nodes() {
+		nodes = append(nodes, infra.StateNode{Addr: n.Addr(), KeyPath: r.SSHKeyPath})
+	}
+	allocated := make([]string, 0, r.pool.SizeAllocated())
+	for _, node := range r.pool.AllocatedNodes() {
+		allocated = append(allocated, node.Addr())
+	}
+	return infra.ProvisionerState{
+		Dir:           r.stateDir,
+		InstallerAddr: r.installerIP,
+		Nodes:         nodes,
+		Allocated:     allocated,
+	}
+}
+
+// startNode starts the container for the node with the given index,
+// authorizes publicKey for the SSH user and returns the node
+func (r *docker) startNode(ctx context.Context, index int, publicKey []byte) (*node, error) {
+	name := fmt.Sprintf("%v-node-%v", r.ClusterName, index)
+	_, err := r.run(ctx, "", "run", "--detach", "--privileged",
+		"--name", name,
+		"--hostname", fmt.Sprintf("node-%v", index),
+		"--network", r.ClusterName,
+		"--label", r.label(),
+		"--tmpfs", "/run",
+		"--tmpfs", "/run/lock",
+		"--volume", "/sys/fs/cgroup:/sys/fs/cgroup:ro",
+		r.Image)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to start node %v", name)
+	}
+
+	out, err := r.run(ctx, "", "inspect", "--format",
+		fmt.Sprintf(`{{(index .NetworkSettings.Networks %q).IPAddress}}`, r.ClusterName), name)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to discover address of node %v", name)
+	}
+	addrIP := strings.TrimSpace(out)
+	if addrIP == "" {
+		return nil, trace.NotFound("node %v has no address on network %v", name, r.ClusterName)
+	}
+
+	_, err = r.run(ctx, string(publicKey), "exec", "--interactive", name,
+		"sh", "-c", fmt.Sprintf(authorizeKeyCommand, r.SSHUser))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to authorize SSH key on node %v", name)
+	}
+
+	return &node{
+		container:    name,
+		addrIP:       addrIP,
+		user:         r.SSHUser,
+		identityFile: r.SSHKeyPath,
+	}, nil
+}
+
+// copyInstaller copies the installer tarball into the specified node containers
+func (r *docker) copyInstaller(ctx context.Context, nodes ...infra.Node) error {
+	if r.InstallerURL == "" {
+		return nil
+	}
+	for _, n := range nodes {
+		target := fmt.Sprintf("%v:%v", n.(*node).container, installerTarball)
+		_, err := r.run(ctx, "", "cp", r.InstallerURL, target)
+		if err != nil {
+			return trace.Wrap(err, "failed to copy installer tarball %q to %q", r.InstallerURL, target)
+		}
+	}
+	return nil
+}
+
+// label returns the label attached to all resources of this cluster
+func (r *docker) label() string {
+	return fmt.Sprintf("%v=%v", clusterLabel, r.ClusterName)
+}
+
+// authorizedKey returns the public key for the private key at path
+// in authorized_keys format
+func authorizedKey(path string) ([]byte, error) {
+	signer, err := sshutils.MakePrivateKeySignerFromFile(path)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return ssh.MarshalAuthorizedKey(signer.PublicKey()), nil
+}
+
+// execDocker returns a function that runs the docker command with the given
+// args and input and returns its output
+func execDocker(logger log.FieldLogger) func(ctx context.Context, input string, args ...string) (string, error) {
+	return func(ctx context.Context, input string, args ...string) (string, error) {
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, "docker", args...)
+		err := system.ExecWithInput(cmd, input, &out)
+		logger.WithField("error", err).Debug(strings.Join(cmd.Args, " "))
+		if err != nil {
+			return out.String(), trace.Wrap(err, "command %q failed: %s", cmd.Args, out.Bytes())
+		}
+		return out.String(), nil
+	}
+}
+
+type docker struct {
+	*log.Entry
+	Config
+
+	pool        infra.NodePool
+	stateDir    string
+	installerIP string
+	// run runs a docker command
+	run func(ctx context.Context, input string, args ...string) (string, error)
+}
+
+// clusterLabel labels the containers and network of a cluster
+const clusterLabel = "robotest.cluster"
+
+// authorizeKeyCommand appends the key read from stdin to the authorized keys
+// of the user given as the single format argument
+const authorizeKeyCommand = `home=$(getent passwd %[1]s | cut -d: -f6) && \
+mkdir -p $home/.ssh && cat >> $home/.ssh/authorized_keys && \
+chmod 700 $home/.ssh && chmod 600 $home/.ssh/authorized_keys && \
+chown -R %[1]s: $home/.ssh`
+
+// installerTarball is the location of the installer tarball in node containers
+const installerTarball = "/tmp/installer.tar.gz"
+
+const installerCommand = `
+mkdir -p ~/installer; \
+tar -xvf /tmp/installer.tar.gz -C ~/installer; \
+~/installer/install`
+
+const uploadUpdateCommand = `
+rm -rf ~/installer; mkdir -p ~/installer; \
+tar -xvf /tmp/installer.tar.gz -C ~/installer; \
+cd ~/installer/; sudo ./upload`
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/robotest/infra"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatesAndDestroysCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyPath := writeKey(t, dir)
+
+	config := Config{
+		Config:       infra.Config{ClusterName: "robotest-1"},
+		Image:        "robotest/node:centos7",
+		SSHUser:      "robotest",
+		SSHKeyPath:   keyPath,
+		InstallerURL: "/build/installer.tar",
+		NumNodes:     2,
+	}
+	require.NoError(t, config.Validate())
+	p, err := New(dir, config)
+	require.NoError(t, err)
+
+	var calls []string
+	var input string
+	p.run = func(ctx context.Context, in string, args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		switch args[0] {
+		case "inspect":
+			return fmt.Sprintf("172.18.0.%v\n", len(calls)), nil
+		case "exec":
+			input = in
+		case "ps":
+			return "c1\nc2\n", nil
+		}
+		return "", nil
+	}
+
+	installer, err := p.Create(context.Background(), true)
+	require.NoError(t, err)
+	require.NotNil(t, installer)
+	assert.Equal(t, "172.18.0.3", installer.Addr())
+	assert.Equal(t, 2, p.NodePool().Size())
+	assert.True(t, strings.HasPrefix(input, "ssh-rsa "), input)
+	assert.True(t, strings.HasPrefix(p.InstallerLogPath(), "/home/robotest/installer/"), p.InstallerLogPath())
+
+	require.NoError(t, p.Destroy(context.Background()))
+	assert.Equal(t, []string{
+		"network create --label robotest.cluster=robotest-1 robotest-1",
+		"run --detach --privileged --name robotest-1-node-1 --hostname node-1 --network robotest-1 --label robotest.cluster=robotest-1 " +
+			"--tmpfs /run --tmpfs /run/lock --volume /sys/fs/cgroup:/sys/fs/cgroup:ro robotest/node:centos7",
+		`inspect --format {{(index .NetworkSettings.Networks "robotest-1").IPAddress}} robotest-1-node-1`,
+		"exec --interactive robotest-1-node-1 sh -c " + fmt.Sprintf(authorizeKeyCommand, "robotest"),
+		"run --detach --privileged --name robotest-1-node-2 --hostname node-2 --network robotest-1 --label robotest.cluster=robotest-1 " +
+			"--tmpfs /run --tmpfs /run/lock --volume /sys/fs/cgroup:/sys/fs/cgroup:ro robotest/node:centos7",
+		`inspect --format {{(index .NetworkSettings.Networks "robotest-1").IPAddress}} robotest-1-node-2`,
+		"exec --interactive robotest-1-node-2 sh -c " + fmt.Sprintf(authorizeKeyCommand, "robotest"),
+		"cp /build/installer.tar robotest-1-node-1:/tmp/installer.tar.gz",
+		"cp /build/installer.tar robotest-1-node-2:/tmp/installer.tar.gz",
+		"ps --all --quiet --filter label=robotest.cluster=robotest-1",
+		"rm --force --volumes c1 c2",
+		"network rm robotest-1",
+	}, calls)
+}
+
+func TestValidatesConfig(t *testing.T) {
+	config := Config{Config: infra.Config{ClusterName: "robotest-1"}, Image: "robotest/node:centos7"}
+	err := config.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SSH user is required")
+	assert.Contains(t, err.Error(), "cannot provision 0 nodes")
+}
+
+func writeKey(t *testing.T, dir string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "id_rsa")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
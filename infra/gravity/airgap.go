@@ -0,0 +1,149 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+
+	"github.com/gravitational/trace"
+)
+
+// lockdownEgress blocks all outbound traffic from the given nodes except
+// to the other nodes of the cluster and the replies on established
+// connections, i.e. the SSH path to the runner.
+// The installer must have been transferred before the lockdown as the nodes
+// can only receive files uploaded by the runner afterwards
+func (c *TestContext) lockdownEgress(ctx context.Context, nodes []Gravity) error {
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node *gravity) {
+			if node.airgapped {
+				errs <- nil
+				return
+			}
+			node.Logger().Info("Lock down egress.")
+			err := sshutil.Run(ctx, node.Client(), node.Logger(), egressLockdownScript(c.egressPeers), nil)
+			if err != nil {
+				errs <- trace.Wrap(err, "failed to lock down egress on %v", node)
+				return
+			}
+			node.airgapped = true
+			errs <- nil
+		}(node.(*gravity))
+	}
+	return trace.Wrap(utils.CollectErrors(ctx, errs))
+}
+
+// verifyNoEgress fails if any of the given nodes attempted an outbound
+// connection since the lockdown
+func (c *TestContext) verifyNoEgress(ctx context.Context, nodes []Gravity) error {
+	var errs []error
+	for _, node := range nodes {
+		g := node.(*gravity)
+		if !g.airgapped {
+			continue
+		}
+		packets, err := egressAttempts(ctx, g)
+		if err != nil {
+			errs = append(errs, trace.Wrap(err, "failed to query egress attempts on %v", g))
+			continue
+		}
+		if packets == 0 {
+			continue
+		}
+		var attempts string
+		err = sshutil.RunAndParse(ctx, g.Client(), g.Logger(), egressLogCmd, nil, sshutil.ParseAsString(&attempts))
+		if err != nil {
+			g.Logger().WithError(err).Warn("Failed to read egress log.")
+		}
+		errs = append(errs, trace.CompareFailed("%v sent %v packets to blocked destinations:\n%v",
+			g, packets, attempts))
+	}
+	return trace.NewAggregate(errs...)
+}
+
+// egressAttempts returns the number of outbound packets rejected on the node
+func egressAttempts(ctx context.Context, node *gravity) (packets int64, err error) {
+	var out string
+	err = sshutil.RunAndParse(ctx, node.Client(), node.Logger(), egressCountCmd, nil, sshutil.ParseAsString(&out))
+	if err != nil {
+		return 0, trace.Wrap(err)
+	}
+	packets, err = strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, trace.BadParameter("unexpected rejected packet count %q", out)
+	}
+	return packets, nil
+}
+
+// egressLockdownScript returns the command that installs the egress firewall.
+// Outbound traffic on the interface with the default route is only allowed to
+// the specified peers, the name servers and the instance metadata service.
+// Pod traffic forwarded to the same interface is subject to the same rules.
+// The rules are not persisted and are removed by a reboot
+func egressLockdownScript(peers []string) string {
+	script := []string{
+		"set -o errexit",
+		egressIfaceCmd,
+		fmt.Sprintf("iptables -w -N %[1]v 2>/dev/null || iptables -w -F %[1]v", egressChain),
+		fmt.Sprintf("iptables -w -A %v -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN", egressChain),
+	}
+	for _, peer := range peers {
+		script = append(script, fmt.Sprintf("iptables -w -A %v -d %v -j RETURN", egressChain, peer))
+	}
+	script = append(script,
+		fmt.Sprintf("iptables -w -A %v -d %v -j RETURN", egressChain, instanceMetadataAddr),
+		fmt.Sprintf("for ns in $(awk \"/^nameserver/ {print \\$2}\" /etc/resolv.conf | grep -v :); do iptables -w -A %v -d $ns -j RETURN; done", egressChain),
+		fmt.Sprintf("iptables -w -A %v -m limit --limit 10/min -j LOG --log-prefix %q", egressChain, egressLogPrefix),
+		fmt.Sprintf("iptables -w -A %v -j REJECT", egressChain),
+		fmt.Sprintf("iptables -w -C OUTPUT -o $iface -j %[1]v 2>/dev/null || iptables -w -I OUTPUT 1 -o $iface -j %[1]v", egressChain),
+		fmt.Sprintf("iptables -w -C FORWARD -o $iface -j %[1]v 2>/dev/null || iptables -w -I FORWARD 1 -o $iface -j %[1]v", egressChain),
+	)
+	return fmt.Sprintf("sudo bash -c '%v'", strings.Join(script, "\n"))
+}
+
+// egressUnlockScript returns the command that removes the egress firewall
+func egressUnlockScript() string {
+	script := []string{
+		egressIfaceCmd,
+		fmt.Sprintf("while iptables -w -D OUTPUT -o $iface -j %v 2>/dev/null; do :; done", egressChain),
+		fmt.Sprintf("while iptables -w -D FORWARD -o $iface -j %v 2>/dev/null; do :; done", egressChain),
+		fmt.Sprintf("iptables -w -F %[1]v 2>/dev/null && iptables -w -X %[1]v || true", egressChain),
+	}
+	return fmt.Sprintf("sudo bash -c '%v'", strings.Join(script, "\n"))
+}
+
+const (
+	// egressChain is the iptables chain that filters outbound traffic of airgapped nodes
+	egressChain = "ROBOTEST-EGRESS"
+	// egressLogPrefix prefixes kernel log entries of rejected outbound packets
+	egressLogPrefix = "robotest-egress: "
+	// egressIfaceCmd sets iface to the name of the interface with the default route
+	egressIfaceCmd = `iface=$(ip route show default | awk "{print \$5; exit}")`
+	// instanceMetadataAddr is the address of the cloud instance metadata service
+	instanceMetadataAddr = "169.254.169.254"
+	// egressCountCmd prints the number of packets rejected by the egress chain
+	egressCountCmd = "sudo iptables -w -nvxL " + egressChain + ` | awk '$3 == "REJECT" {print $1}'`
+	// egressLogCmd prints the most recent rejected packets
+	egressLogCmd = "sudo dmesg | grep '" + egressLogPrefix + "' | tail -n 10"
+)
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEgressLockdownAllowsPeers(t *testing.T) {
+	cmd := egressLockdownScript([]string{"10.0.0.1", "10.0.0.2"})
+	script := sudoScript(t, cmd)
+
+	peer := strings.Index(script, "-d 10.0.0.2 -j RETURN")
+	reject := strings.Index(script, "-j REJECT")
+	require.NotEqual(t, -1, peer)
+	require.NotEqual(t, -1, reject)
+	assert.True(t, peer < reject, "peers are allowed before the catch-all reject")
+	assert.Contains(t, script, "-I OUTPUT 1 -o $iface -j "+egressChain)
+	assert.Contains(t, script, "-I FORWARD 1 -o $iface -j "+egressChain)
+	assertValidBash(t, script)
+}
+
+func TestEgressUnlockScript(t *testing.T) {
+	assertValidBash(t, sudoScript(t, egressUnlockScript()))
+}
+
+func TestAirgapUnsupportedProviders(t *testing.T) {
+	for _, provider := range []string{constants.Ops, constants.Docker} {
+		err := validateConfig(ProvisionerConfig{CloudProvider: provider, Airgap: true})
+		assert.True(t, trace.IsBadParameter(err), "%v: expected bad parameter, got %v", provider, err)
+	}
+}
+
+// sudoScript returns the script run by cmd with sudo bash -c
+func sudoScript(t *testing.T, cmd string) string {
+	const prefix = "sudo bash -c '"
+	require.True(t, strings.HasPrefix(cmd, prefix) && strings.HasSuffix(cmd, "'"), cmd)
+	script := strings.TrimSuffix(strings.TrimPrefix(cmd, prefix), "'")
+	require.NotContains(t, script, "'", "script must not break out of single quotes")
+	return script
+}
+
+func assertValidBash(t *testing.T, script string) {
+	out, err := exec.Command("bash", "-n", "-c", script).CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
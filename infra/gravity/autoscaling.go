@@ -135,17 +135,20 @@ func (c *TestContext) getAWSNodes(ec2svc *ec2.EC2, filterName string, filterValu
 
 	for _, reservation := range resp.Reservations {
 		for _, inst := range reservation.Instances {
-			node := ops.New(*inst.PublicIpAddress, *inst.PrivateIpAddress,
-				c.provisionerCfg.Ops.SSHUser, c.provisionerCfg.Ops.SSHKeyPath)
+			// nodes on private subnets have no public address
+			node := ops.New(aws.StringValue(inst.PublicIpAddress), aws.StringValue(inst.PrivateIpAddress),
+				c.provisionerCfg.Ops.SSHUser, c.provisionerCfg.Ops.SSHKeyPath, c.provisionerCfg.Ops.JumpHosts)
 
 			gravityNode, err := connectVM(c.Context(), c.Logger(), node, *cloudParams)
 			if err != nil {
+				disconnect(asNodes(nodes))
 				return nil, trace.Wrap(err)
 			}
 			gravityNode.installDir = "/bin"
 
 			err = configureVM(c.Context(), c.Logger(), gravityNode, *cloudParams)
 			if err != nil {
+				disconnect(asNodes(append(nodes, gravityNode)))
 				return nil, trace.Wrap(err)
 			}
 
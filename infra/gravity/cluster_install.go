@@ -71,7 +71,13 @@ func (c *TestContext) SetInstaller(nodes []Gravity, installerUrl string, tag str
 	}
 
 	_, err = utils.Collect(ctx, cancel, errs, nil)
-	return trace.Wrap(err)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	return trace.Wrap(c.setInstallerManifest(ctx, nodes[0], installerUrl))
 }
 
 // OfflineInstall sets up cluster using nodes provided
@@ -98,6 +104,13 @@ func (c *TestContext) OfflineInstall(nodes []Gravity, param InstallParam) error
 		param.GCENodeTag = gce.TranslateClusterName(param.Cluster)
 	}
 
+	if c.provisionerCfg.Airgap {
+		err := c.lockdownEgress(ctx, nodes)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
 	errs := make(chan error, len(nodes))
 	go func() {
 		c.Logger().WithField("node", master).Info("Install on leader node.")
@@ -126,6 +139,13 @@ func (c *TestContext) OfflineInstall(nodes []Gravity, param InstallParam) error
 		return trace.Wrap(err)
 	}
 
+	if c.provisionerCfg.Airgap {
+		err = c.verifyNoEgress(c.ctx, nodes)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
 	if param.EnableRemoteSupport {
 		_, err = master.RunInPlanet(ctx, "/usr/bin/gravity",
 			"site", "complete", "--support=on", "--insecure",
@@ -222,6 +242,11 @@ func (c *TestContext) uploadInstaller(master Gravity, nodes []Gravity, installer
 		return trace.Wrap(err)
 	}
 
+	err = c.setInstallerManifest(ctx, master, installerURL)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
 	err = c.WaitForActiveStatus(nodes)
 	if err != nil {
 		return trace.Wrap(err)
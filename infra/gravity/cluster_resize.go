@@ -80,8 +80,20 @@ func (c *TestContext) joinNode(peer, nodeToJoin Gravity, token string, p Install
 	c.Logger().WithField("node", nodeToJoin).Info("Join.")
 	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.Install)
 	defer cancel()
+	if c.provisionerCfg.Airgap {
+		err := c.lockdownEgress(ctx, []Gravity{nodeToJoin})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
 	err := nodeToJoin.Join(ctx, cmd)
-	return trace.Wrap(err)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if c.provisionerCfg.Airgap {
+		return trace.Wrap(c.verifyNoEgress(c.ctx, []Gravity{nodeToJoin}))
+	}
+	return nil
 
 }
 
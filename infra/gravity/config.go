@@ -25,11 +25,16 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/gravitational/robotest/infra/docker"
 	"github.com/gravitational/robotest/infra/providers/aws"
 	"github.com/gravitational/robotest/infra/providers/azure"
 	"github.com/gravitational/robotest/infra/providers/gce"
 	"github.com/gravitational/robotest/infra/providers/ops"
+	"github.com/gravitational/robotest/infra/providers/static"
+	"github.com/gravitational/robotest/infra/terraform"
+	"github.com/gravitational/robotest/infra/vagrant"
 	"github.com/gravitational/robotest/lib/constants"
 
 	"github.com/gravitational/trace"
@@ -85,7 +90,7 @@ func (drv StorageDriver) Driver() string {
 // CloudProvider, AWS, Azure, ScriptPath and InstallerURL
 type ProvisionerConfig struct {
 	// DeployTo defines cloud to deploy to
-	CloudProvider string `yaml:"cloud" validate:"required,eq=aws|eq=azure|eq=gce|eq=ops"`
+	CloudProvider string `yaml:"cloud" validate:"required,eq=aws|eq=azure|eq=gce|eq=ops|eq=static|eq=docker|eq=vagrant"`
 	// AWS defines AWS connection parameters
 	AWS *aws.Config `yaml:"aws"`
 	// Azure defines Azure connection parameters
@@ -94,6 +99,12 @@ type ProvisionerConfig struct {
 	GCE *gce.Config `yaml:"gce"`
 	// Ops defines Ops Center connection parameters
 	Ops *ops.Config `yaml:"ops"`
+	// Static defines an inventory of existing hosts
+	Static *static.Config `yaml:"static"`
+	// Docker defines parameters to run nodes as local containers
+	Docker *docker.Config `yaml:"docker"`
+	// Vagrant defines parameters to provision nodes with vagrant
+	Vagrant *vagrant.Config `yaml:"vagrant"`
 
 	// ScriptPath is the path to the terraform script or directory for provisioning
 	ScriptPath string `yaml:"script_path" validate:"required"`
@@ -107,6 +118,21 @@ type ProvisionerConfig struct {
 	GravityURL string `yaml:"gravity_url" validate:"required"`
 	// StateDir defines base directory where to keep state (i.e. terraform configs/vars)
 	StateDir string `yaml:"state_dir" validate:"required"`
+	// NodeGroups optionally splits the nodes provisioned with terraform into
+	// named groups of differently configured nodes.
+	// When set, the node count is the total of the group counts
+	NodeGroups []terraform.NodeGroup `yaml:"node_groups" validate:"omitempty,dive"`
+	// OSCatalog optionally specifies the path to the OS catalog file.
+	// The catalog is merged over the catalog in ScriptPath
+	// (os_catalog.yaml) and the built-in OS catalog
+	OSCatalog string `yaml:"os_catalog"`
+	// Airgap blocks the outbound traffic of the nodes except to other nodes
+	// once the installer has been transferred and fails the test if
+	// the nodes attempted to connect elsewhere during install
+	Airgap bool `yaml:"airgap"`
+	// DiskQualification optionally overrides the disk performance required
+	// of the nodes, see DefaultDiskThresholds
+	DiskQualification *DiskThresholds `yaml:"disk_qualification"`
 
 	// Tag will group provisioned resources under for easy removal afterwards
 	tag string `validate:"required"`
@@ -118,29 +144,53 @@ type ProvisionerConfig struct {
 	storageDriver StorageDriver
 	// dockerDevice is a physical volume where Docker data would be stored
 	dockerDevice string `validate:"required"`
+	// etcdDevice is the device the etcd directory is mounted from on cloud VMs
+	etcdDevice string
 	// clusterName is the name of the resulting robotest cluster
 	clusterName  string
 	cloudRegions *cloudRegions
+	// region optionally pins the cloud region to provision in instead of
+	// distributing the tests across cloudRegions
+	region string
+	// osCatalog caches the OS catalog shared by the copies of this config
+	osCatalog *osCatalog
+	// opsKeys hands out the Ops Center keys to the tests
+	opsKeys *ops.KeyPool
 }
 
 // LoadConfig loads essential parameters from YAML
 func LoadConfig(t *testing.T, configBytes []byte) (cfg ProvisionerConfig) {
 	err := yaml.Unmarshal(configBytes, &cfg)
 	require.NoError(t, err, string(configBytes))
+	cfg.osCatalog = &osCatalog{}
 
 	switch cfg.CloudProvider {
 	case constants.Azure:
 		require.NotNil(t, cfg.Azure)
 		cfg.dockerDevice = cfg.Azure.DockerDevice
+		cfg.etcdDevice = cfg.Azure.EtcdDevice
+		if cfg.etcdDevice == "" {
+			cfg.etcdDevice = azure.DefaultEtcdDevice
+		}
 		cfg.cloudRegions = newCloudRegions(strings.Split(cfg.Azure.Location, ","))
 	case constants.AWS:
 		require.NotNil(t, cfg.AWS)
 		cfg.dockerDevice = cfg.AWS.DockerDevice
+		cfg.etcdDevice = cfg.AWS.EtcdDevice
+		if cfg.etcdDevice == "" {
+			cfg.etcdDevice = aws.DefaultEtcdDevice
+		}
+		cfg.cloudRegions = newCloudRegions(strings.Split(cfg.AWS.Region, ","))
 	case constants.GCE:
 		require.NotNil(t, cfg.GCE)
+		cfg.etcdDevice = cfg.GCE.EtcdDevice
+		if cfg.etcdDevice == "" {
+			cfg.etcdDevice = gce.DefaultEtcdDevice
+		}
 		cfg.cloudRegions = newCloudRegions(strings.Split(cfg.GCE.Region, ","))
 	case constants.Ops:
 		require.NotNil(t, cfg.Ops)
+		cfg.opsKeys = ops.NewKeyPool(cfg.Ops.Keys())
 		// set AWS environment variables to be used by subsequent commands
 		os.Setenv("AWS_ACCESS_KEY_ID", cfg.Ops.EC2AccessKey)
 		os.Setenv("AWS_SECRET_ACCESS_KEY", cfg.Ops.EC2SecretKey)
@@ -149,6 +199,20 @@ func LoadConfig(t *testing.T, configBytes []byte) (cfg ProvisionerConfig) {
 		// the raw block device will have a partition on it, so we want to instead test
 		// on the installation directory
 		cfg.dockerDevice = "/var/lib/gravity"
+	case constants.Static:
+		require.NotNil(t, cfg.Static)
+		require.NoError(t, cfg.Static.CheckAndSetDefaults())
+		cfg.dockerDevice = cfg.Static.DockerDevice
+	case constants.Docker:
+		require.NotNil(t, cfg.Docker)
+		cfg.dockerDevice = cfg.Docker.DockerDevice
+	case constants.Vagrant:
+		require.NotNil(t, cfg.Vagrant)
+		cfg.dockerDevice = cfg.Vagrant.DockerDevice
+		if cfg.dockerDevice == "" {
+			// direct-lvm device attached by assets/vagrant/Vagrantfile
+			cfg.dockerDevice = "/dev/vdc"
+		}
 	default:
 		t.Fatalf("unknown cloud provider %s", cfg.CloudProvider)
 	}
@@ -174,12 +238,14 @@ func (config ProvisionerConfig) WithTag(tag string) ProvisionerConfig {
 	return cfg
 }
 
-// WithNodes returns copy of config with specific number of nodes
+// WithNodes returns copy of config with specific number of nodes.
+// Any configured node groups are dropped in favor of uniform nodes
 func (config ProvisionerConfig) WithNodes(nodes uint) ProvisionerConfig {
 	extra := fmt.Sprintf("%dn", nodes)
 
 	cfg := config
 	cfg.NodeCount = nodes
+	cfg.NodeGroups = nil
 	cfg.tag = fmt.Sprintf("%s-%s", cfg.tag, extra)
 	cfg.StateDir = filepath.Join(cfg.StateDir, extra)
 
@@ -196,6 +262,24 @@ func (config ProvisionerConfig) WithOS(os OS) ProvisionerConfig {
 	return cfg
 }
 
+// WithNodeGroups returns copy of config with the specified node groups.
+// The node count is set to the total of the group counts
+func (config ProvisionerConfig) WithNodeGroups(groups ...terraform.NodeGroup) ProvisionerConfig {
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, fmt.Sprintf("%d%s", group.Count, group.Name))
+	}
+	extra := strings.Join(names, "-")
+
+	cfg := config
+	cfg.NodeGroups = groups
+	cfg.NodeCount = uint(terraform.NodeCount(groups))
+	cfg.tag = fmt.Sprintf("%s-%s", cfg.tag, extra)
+	cfg.StateDir = filepath.Join(cfg.StateDir, extra)
+
+	return cfg
+}
+
 // WithStorageDriver returns copy of config with specific storage driver
 func (config ProvisionerConfig) WithStorageDriver(storageDriver StorageDriver) ProvisionerConfig {
 	cfg := config
@@ -211,14 +295,56 @@ func (config ProvisionerConfig) WithStorageDriver(storageDriver StorageDriver) P
 	return cfg
 }
 
+// withRegion returns copy of config pinned to the specified cloud region
+func (config ProvisionerConfig) withRegion(region string) ProvisionerConfig {
+	cfg := config
+	cfg.region = region
+	return cfg
+}
+
+// nextRegion returns the pinned region if any or the next configured region
+func (config ProvisionerConfig) nextRegion() string {
+	if config.region != "" {
+		return config.region
+	}
+	return config.cloudRegions.Next()
+}
+
 // validateConfig checks that key parameters are present
 func validateConfig(config ProvisionerConfig) error {
 	switch config.CloudProvider {
-	case constants.AWS, constants.Azure, constants.GCE, constants.Ops:
+	case constants.AWS, constants.Azure, constants.GCE, constants.Ops, constants.Static, constants.Docker, constants.Vagrant:
 	default:
 		return trace.BadParameter("unknown cloud provider %s", config.CloudProvider)
 	}
 
+	if len(config.NodeGroups) != 0 {
+		// only the GCE terraform variables define node groups
+		if config.CloudProvider != constants.GCE {
+			return trace.BadParameter("node groups are not supported with cloud provider %s", config.CloudProvider)
+		}
+		if count := terraform.NodeCount(config.NodeGroups); count != int(config.NodeCount) {
+			return trace.BadParameter("node groups define %v nodes, expected %v", count, config.NodeCount)
+		}
+	}
+
+	// AMI IDs in the AWS terraform assets are region specific, so failing over
+	// to another region would not find the image
+	if config.CloudProvider == constants.AWS && config.AWS != nil && strings.Contains(config.AWS.Region, ",") {
+		return trace.BadParameter("multiple AWS regions are not supported: %v", config.AWS.Region)
+	}
+
+	if config.Airgap {
+		switch config.CloudProvider {
+		case constants.Ops, constants.Docker:
+			return trace.BadParameter("airgap is not supported with cloud provider %s", config.CloudProvider)
+		}
+	}
+
+	if _, err := config.diskThresholds().minThroughput(); err != nil {
+		return trace.Wrap(err)
+	}
+
 	err := validator.New().Struct(&config)
 	if err == nil {
 		return nil
@@ -247,19 +373,46 @@ func newCloudRegions(regions []string) *cloudRegions {
 	return &cloudRegions{idx: 0, regions: regions}
 }
 
-// Next returns the next region.
-// It wraps around once it has reached the end of the list
+// Next returns the next region that is not cooling down.
+// It wraps around once it has reached the end of the list.
+// If all regions are cooling down, the one to become available first is returned
 func (r *cloudRegions) Next() (region string) {
 	r.Lock()
 	defer r.Unlock()
 
-	r.idx = (r.idx + 1) % len(r.regions)
+	now := time.Now()
+	next := -1
+	for i := 1; i <= len(r.regions); i++ {
+		idx := (r.idx + i) % len(r.regions)
+		if !r.cooldown[r.regions[idx]].After(now) {
+			next = idx
+			break
+		}
+		if next == -1 || r.cooldown[r.regions[idx]].Before(r.cooldown[r.regions[next]]) {
+			next = idx
+		}
+	}
+	r.idx = next
 	return r.regions[r.idx]
 }
 
+// Cooldown excludes the region from selection for the specified duration,
+// i.e. after it has run out of capacity
+func (r *cloudRegions) Cooldown(region string, d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.cooldown == nil {
+		r.cooldown = make(map[string]time.Time)
+	}
+	r.cooldown[region] = time.Now().Add(d)
+}
+
 // cloudRegions is used for round-robin distribution of workload across regions
 type cloudRegions struct {
 	sync.Mutex
 	idx     int
 	regions []string
+	// cooldown maps regions to the time they can be used again
+	cooldown map[string]time.Time
 }
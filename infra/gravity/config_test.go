@@ -0,0 +1,135 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravitational/robotest/infra/oscatalog"
+	"github.com/gravitational/robotest/infra/providers/aws"
+	"github.com/gravitational/robotest/infra/terraform"
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithNodeGroups(t *testing.T) {
+	cfg := ProvisionerConfig{StateDir: "/state", tag: "install"}.WithNodeGroups(
+		terraform.NodeGroup{Name: "master", Count: 3, VMType: "n1-standard-8"},
+		terraform.NodeGroup{Name: "worker", Count: 2},
+	)
+	assert.EqualValues(t, 5, cfg.NodeCount)
+	assert.Equal(t, "install-3master-2worker", cfg.Tag())
+	assert.Equal(t, "/state/3master-2worker", cfg.StateDir)
+	assert.Len(t, cfg.NodeGroups, 2)
+}
+
+func TestWithNodesDropsNodeGroups(t *testing.T) {
+	cfg := ProvisionerConfig{StateDir: "/state", tag: "install"}.WithNodeGroups(
+		terraform.NodeGroup{Name: "master", Count: 3},
+	).WithNodes(1)
+	assert.EqualValues(t, 1, cfg.NodeCount)
+	assert.Empty(t, cfg.NodeGroups)
+}
+
+func TestValidateConfigRejectsNodeGroupsWithoutGCE(t *testing.T) {
+	for _, provider := range []string{constants.AWS, constants.Azure, constants.Vagrant} {
+		cfg := ProvisionerConfig{CloudProvider: provider}.WithNodeGroups(
+			terraform.NodeGroup{Name: "master", Count: 1},
+		)
+		err := validateConfig(cfg)
+		assert.True(t, trace.IsBadParameter(err), "expected bad parameter with %v, got %v", provider, err)
+		assert.Contains(t, err.Error(), "node groups are not supported")
+	}
+}
+
+func TestValidateConfigRejectsMultipleAWSRegions(t *testing.T) {
+	cfg := ProvisionerConfig{
+		CloudProvider: constants.AWS,
+		AWS:           &aws.Config{Region: "us-east-1,us-west-2"},
+	}
+	err := validateConfig(cfg)
+	assert.True(t, trace.IsBadParameter(err), "expected bad parameter, got %v", err)
+	assert.Contains(t, err.Error(), "multiple AWS regions")
+}
+
+func TestCloudRegionsSkipRegionsCoolingDown(t *testing.T) {
+	regions := &cloudRegions{regions: []string{"us-west1", "us-east1", "us-central1"}}
+	assert.Equal(t, "us-east1", regions.Next())
+	assert.Equal(t, "us-central1", regions.Next())
+
+	regions.Cooldown("us-west1", time.Hour)
+	assert.Equal(t, "us-east1", regions.Next())
+	assert.Equal(t, "us-central1", regions.Next())
+	assert.Equal(t, "us-east1", regions.Next())
+
+	// expired cooldown
+	regions.Cooldown("us-central1", -time.Minute)
+	assert.Equal(t, "us-central1", regions.Next())
+
+	// with all regions cooling down, the first to become available is used
+	regions.Cooldown("us-east1", 2*time.Hour)
+	regions.Cooldown("us-central1", 3*time.Hour)
+	assert.Equal(t, "us-west1", regions.Next())
+	assert.Equal(t, "us-west1", regions.Next())
+}
+
+func TestLoadOSCatalogMergesScriptCatalog(t *testing.T) {
+	scriptDir := t.TempDir()
+	writeFile(t, filepath.Join(scriptDir, oscatalog.FileName), `images:
+- provider: gce
+  vendor: rocky
+  image: rocky-linux-cloud/rocky-linux-8
+  ssh_user: rocky
+`)
+	customPath := filepath.Join(t.TempDir(), "catalog.yaml")
+	writeFile(t, customPath, `images:
+- provider: gce
+  vendor: rocky
+  version: "9"
+  image: rocky-linux-cloud/rocky-linux-9
+  ssh_user: rocky
+`)
+	cfg := ProvisionerConfig{ScriptPath: scriptDir, OSCatalog: customPath, osCatalog: &osCatalog{}}
+
+	catalog, err := loadOSCatalog(cfg)
+	require.NoError(t, err)
+	image, err := catalog.Lookup(constants.GCE, "rocky:9")
+	require.NoError(t, err)
+	assert.Equal(t, "rocky-linux-cloud/rocky-linux-9", image.Image)
+	image, err = catalog.Lookup(constants.GCE, "rocky:8")
+	require.NoError(t, err)
+	assert.Equal(t, "rocky-linux-cloud/rocky-linux-8", image.Image)
+	_, err = catalog.Lookup(constants.GCE, "ubuntu:18")
+	require.NoError(t, err, "expected built-in images")
+
+	// the catalog is loaded once for all copies of the config
+	require.NoError(t, os.Remove(customPath))
+	cached, err := loadOSCatalog(cfg.WithTag("install"))
+	require.NoError(t, err)
+	assert.Equal(t, catalog, cached)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
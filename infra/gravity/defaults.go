@@ -37,8 +37,19 @@ const (
 	autoscaleRetries = 20               // total number of attempts when checking autoscale changes
 	autoscaleWait    = time.Second * 15 // amount of time to wait between attempts to autoscale the cluster
 
+	// healthMonitorInterval is the default interval between cluster health checks
+	healthMonitorInterval = 30 * time.Second
+
 	// minimum required disk speed (10MB/s)
 	minDiskSpeed = uint64(1e7)
+
+	// defaultTerraformPluginDir is the terraform plugin directory used unless configured
+	defaultTerraformPluginDir = "/etc/terraform/plugins"
+	// preflightTimeout limits each network request made by the preflight checks
+	preflightTimeout = 30 * time.Second
+	// defaultFileCacheDir is the name of the file cache directory in the temporary directory
+	// used unless configured
+	defaultFileCacheDir = "robotest-files"
 )
 
 var DefaultTimeouts = OpTimeouts{
@@ -55,4 +66,6 @@ var DefaultTimeouts = OpTimeouts{
 	TimeSync:         time.Minute * 5,  // wait for ntp to converge
 	ResolveInPlanet:  time.Minute * 1,  // resolve a hostname inside planet with dig
 	GetPods:          time.Minute * 1,  // use kubectl to query pods on the API master
+	KubeHealth:       time.Minute * 10, // wait for kubernetes workloads to become healthy
+	Rollout:          time.Minute * 10, // wait for applied workloads to roll out
 }
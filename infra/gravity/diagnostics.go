@@ -0,0 +1,177 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gravitational/robotest/lib/constants"
+	"github.com/gravitational/robotest/lib/defaults"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// diagnosticCommand is a command whose output is captured into a diagnostic snapshot
+type diagnosticCommand struct {
+	// file names the file the output is saved to
+	file string
+	// run executes the command on node and returns its output
+	run func(ctx context.Context, node Gravity) (string, error)
+}
+
+// diagnosticCommands lists the commands captured from every node
+var diagnosticCommands = []diagnosticCommand{
+	{file: "status.json", run: func(ctx context.Context, node Gravity) (string, error) {
+		cmd := fmt.Sprintf("sudo gravity status --output=json --system-log-file=%v", defaults.AgentLogPath)
+		var out string
+		err := sshutils.RunAndParse(ctx, node.Client(), node.Logger(), cmd, nil, sshutils.ParseAsString(&out))
+		return out, trace.Wrap(err)
+	}},
+	{file: "events.txt", run: inPlanet("/usr/bin/kubectl", "get", "events", "--all-namespaces", "--sort-by=.lastTimestamp")},
+	{file: "describe-nodes.txt", run: inPlanet("/usr/bin/kubectl", "describe", "nodes")},
+	{file: "etcd-health.txt", run: inPlanet("/usr/bin/etcdctl", "cluster-health")},
+	{file: "serf-members.txt", run: inPlanet("/usr/bin/serf", "members")},
+}
+
+func inPlanet(cmd string, args ...string) func(context.Context, Gravity) (string, error) {
+	return func(ctx context.Context, node Gravity) (string, error) {
+		return node.RunInPlanet(ctx, cmd, args...)
+	}
+}
+
+// CaptureDiagnostics saves a diagnostic snapshot from every reachable node
+// under StateDir/diagnostics/attempt-<attempt>/<step>/<node address>/.
+// Failures of individual commands are recorded in the snapshot instead of failing it.
+// Returns the directory of the snapshot
+func (c *TestContext) CaptureDiagnostics(step string, nodes []Gravity) (dir string, err error) {
+	dir = filepath.Join(c.provisionerCfg.StateDir, "diagnostics",
+		fmt.Sprintf("attempt-%v", c.attempt), diagnosticStep(step))
+	if err := os.MkdirAll(dir, constants.SharedDirMask); err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	log := c.Logger().WithFields(logrus.Fields{"step": step, "dir": dir})
+	log.Info("Capturing diagnostics.")
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.CollectLogs)
+	defer cancel()
+
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node Gravity) {
+			errs <- trace.Wrap(captureNodeDiagnostics(ctx, node, filepath.Join(dir, node.Node().PrivateAddr())))
+		}(node)
+	}
+	if err := utils.CollectErrors(ctx, errs); err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	c.diagnostics.add(dir)
+	c.recordEvent(diagnosticsSource, "Diagnostic snapshot for %q saved to %v.", step, dir)
+	return dir, nil
+}
+
+// captureDiagnostics captures a diagnostic snapshot logging but otherwise ignoring failures,
+// as diagnostics are only captured when the test has already failed
+func (c *TestContext) captureDiagnostics(step string, nodes []Gravity) {
+	if _, err := c.CaptureDiagnostics(step, nodes); err != nil {
+		c.Logger().WithError(err).WithField("step", step).Warn("Failed to capture diagnostics.")
+	}
+}
+
+func captureNodeDiagnostics(ctx context.Context, node Gravity, dir string) error {
+	if err := os.MkdirAll(dir, constants.SharedDirMask); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	history, err := json.MarshalIndent(node.ConnHistory(), "", "  ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := writeDiagnostic(dir, "ssh-connection.json", string(history)); err != nil {
+		return trace.Wrap(err)
+	}
+	if node.Offline() {
+		return trace.Wrap(writeDiagnostic(dir, "offline.txt", "node is offline\n"))
+	}
+	for _, cmd := range diagnosticCommands {
+		out, err := cmd.run(ctx, node)
+		if err != nil {
+			out = fmt.Sprintf("%v\nerror: %v\n", out, trace.UserMessage(err))
+		}
+		if err := writeDiagnostic(dir, cmd.file, out); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func writeDiagnostic(dir, file, content string) error {
+	err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), constants.SharedReadMask)
+	return trace.ConvertSystemError(err)
+}
+
+// diagnosticStep turns a test step description into a directory name
+func diagnosticStep(step string) string {
+	step = reDiagnosticStep.ReplaceAllString(strings.ToLower(step), "-")
+	step = strings.Trim(step, "-")
+	if step == "" {
+		return "unnamed"
+	}
+	return step
+}
+
+var reDiagnosticStep = regexp.MustCompile(`[^a-z0-9.]+`)
+
+// diagnosticsList lists the diagnostic snapshots captured during a test
+type diagnosticsList struct {
+	sync.Mutex
+	dirs []string
+	// seq counts the snapshots captured so far, to make step names unique
+	seq int
+}
+
+func (r *diagnosticsList) add(dir string) {
+	r.Lock()
+	r.dirs = append(r.dirs, dir)
+	r.Unlock()
+}
+
+func (r *diagnosticsList) list() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.dirs...)
+}
+
+// next returns a unique step name with the given prefix
+func (r *diagnosticsList) next(prefix string) string {
+	r.Lock()
+	defer r.Unlock()
+	r.seq++
+	return fmt.Sprintf("%v-%v", prefix, r.seq)
+}
+
+const diagnosticsSource = "diagnostics"
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosticStep(t *testing.T) {
+	var testCases = []struct {
+		step     string
+		expected string
+	}{
+		{step: "failed wait for active status", expected: "failed-wait-for-active-status"},
+		{step: "failed install on 3 node", expected: "failed-install-on-3-node"},
+		{step: "failed expand to 10.0.0.1/24 (node)", expected: "failed-expand-to-10.0.0.1-24-node"},
+		{step: "  ", expected: "unnamed"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, diagnosticStep(tc.step), tc.step)
+	}
+}
+
+func TestDiagnosticsList(t *testing.T) {
+	var diagnostics diagnosticsList
+	assert.Equal(t, "status-wait-1", diagnostics.next("status-wait"))
+	assert.Equal(t, "status-wait-2", diagnostics.next("status-wait"))
+
+	diagnostics.add("/state/diagnostics/status-wait-1")
+	dirs := diagnostics.list()
+	diagnostics.add("/state/diagnostics/failed-install")
+	assert.Equal(t, []string{"/state/diagnostics/status-wait-1"}, dirs, "list returns a snapshot")
+	assert.Len(t, diagnostics.list(), 2)
+}
+
+func TestDiagnosticsPerAttempt(t *testing.T) {
+	stateDir := t.TempDir()
+	var dirs []string
+	for attempt := 1; attempt <= 2; attempt++ {
+		c := &TestContext{
+			ctx:            context.Background(),
+			log:            logrus.New(),
+			timeouts:       DefaultTimeouts,
+			timeline:       &timeline{},
+			attempt:        attempt,
+			diagnostics:    &diagnosticsList{},
+			provisionerCfg: ProvisionerConfig{StateDir: stateDir},
+		}
+		dir, err := c.CaptureDiagnostics("failed install", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{dir}, c.diagnostics.list())
+		dirs = append(dirs, dir)
+	}
+	assert.Equal(t, []string{
+		filepath.Join(stateDir, "diagnostics", "attempt-1", "failed-install"),
+		filepath.Join(stateDir, "diagnostics", "attempt-2", "failed-install"),
+	}, dirs)
+}
@@ -0,0 +1,420 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/robotest/infra/nodeprep"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+	"github.com/gravitational/robotest/lib/wait"
+
+	"github.com/dustin/go-humanize"
+	"github.com/gravitational/trace"
+)
+
+// DiskThresholds defines the disk performance required of the nodes.
+// Zero thresholds are not enforced, although the metrics are still measured
+type DiskThresholds struct {
+	// MinThroughput is the minimum sequential write throughput per second, i.e. 10MB
+	MinThroughput string `yaml:"min_throughput"`
+	// MaxFsyncP50 is the maximum median latency of small synchronized writes
+	MaxFsyncP50 time.Duration `yaml:"max_fsync_p50"`
+	// MaxFsyncP99 is the maximum 99th percentile latency of small synchronized writes
+	MaxFsyncP99 time.Duration `yaml:"max_fsync_p99"`
+	// MinIOPS is the minimum number of random 4K writes per second
+	MinIOPS float64 `yaml:"min_iops"`
+	// Disabled skips the disk qualification
+	Disabled bool `yaml:"disabled"`
+}
+
+// DefaultDiskThresholds are the thresholds used unless configured.
+// The fsync latency follows the etcd hardware recommendations
+var DefaultDiskThresholds = DiskThresholds{
+	MinThroughput: humanize.Bytes(minDiskSpeed),
+	MaxFsyncP99:   10 * time.Millisecond,
+}
+
+// diskThresholds returns the configured disk thresholds or the defaults
+func (config ProvisionerConfig) diskThresholds() DiskThresholds {
+	if config.DiskQualification != nil {
+		return *config.DiskQualification
+	}
+	return DefaultDiskThresholds
+}
+
+// needsFio returns true if the thresholds require the metrics measured with fio
+func (r DiskThresholds) needsFio() bool {
+	return r.MaxFsyncP50 != 0 || r.MaxFsyncP99 != 0 || r.MinIOPS != 0
+}
+
+// minThroughput returns the minimum throughput in bytes per second
+func (r DiskThresholds) minThroughput() (uint64, error) {
+	if r.MinThroughput == "" {
+		return 0, nil
+	}
+	value, err := humanize.ParseBytes(r.MinThroughput)
+	if err != nil {
+		return 0, trace.BadParameter("invalid disk throughput %q: %v", r.MinThroughput, err)
+	}
+	return value, nil
+}
+
+// check returns an error listing the thresholds the metrics do not meet
+func (r DiskThresholds) check(metrics DiskMetrics) error {
+	minThroughput, err := r.minThroughput()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var errs []error
+	paths := make([]string, 0, len(metrics.Throughput))
+	for path := range metrics.Throughput {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if speed := metrics.Throughput[path]; speed < minThroughput {
+			errs = append(errs, trace.CompareFailed("%v has %v/s < minimum of %v/s",
+				path, humanize.Bytes(speed), humanize.Bytes(minThroughput)))
+		}
+	}
+	if r.MaxFsyncP50 != 0 && metrics.FsyncP50 > r.MaxFsyncP50 {
+		errs = append(errs, trace.CompareFailed("fsync p50 latency %v > maximum of %v", metrics.FsyncP50, r.MaxFsyncP50))
+	}
+	if r.MaxFsyncP99 != 0 && metrics.FsyncP99 > r.MaxFsyncP99 {
+		errs = append(errs, trace.CompareFailed("fsync p99 latency %v > maximum of %v", metrics.FsyncP99, r.MaxFsyncP99))
+	}
+	if metrics.IOPS < r.MinIOPS {
+		errs = append(errs, trace.CompareFailed("%.0f random write IOPS < minimum of %.0f", metrics.IOPS, r.MinIOPS))
+	}
+	return trace.NewAggregate(errs...)
+}
+
+// DiskMetrics describes the disk performance measured on a node
+type DiskMetrics struct {
+	// Node identifies the node
+	Node string `json:"node"`
+	// Throughput maps the tested path to its sequential write throughput in bytes per second
+	Throughput map[string]uint64 `json:"throughput"`
+	// FsyncP50 is the median latency of small synchronized writes.
+	// Zero if fio is not available
+	FsyncP50 time.Duration `json:"fsync_p50_ns"`
+	// FsyncP99 is the 99th percentile latency of small synchronized writes.
+	// Zero if fio is not available
+	FsyncP99 time.Duration `json:"fsync_p99_ns"`
+	// IOPS is the number of random 4K writes per second.
+	// Zero if fio is not available
+	IOPS float64 `json:"iops"`
+	// Passed is true if the metrics meet the thresholds
+	Passed bool `json:"passed"`
+}
+
+// String returns a one-line summary of the metrics
+func (r DiskMetrics) String() string {
+	paths := make([]string, 0, len(r.Throughput))
+	for path := range r.Throughput {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fields := make([]string, 0, len(paths)+4)
+	for _, path := range paths {
+		fields = append(fields, fmt.Sprintf("%v=%v/s", path, humanize.Bytes(r.Throughput[path])))
+	}
+	if r.FsyncP99 != 0 {
+		fields = append(fields, fmt.Sprintf("fsync_p50=%v", r.FsyncP50), fmt.Sprintf("fsync_p99=%v", r.FsyncP99))
+	}
+	if r.IOPS != 0 {
+		fields = append(fields, fmt.Sprintf("iops=%.0f", r.IOPS))
+	}
+	status := "passed"
+	if !r.Passed {
+		status = "failed"
+	}
+	return fmt.Sprintf("%v %v: %v", r.Node, status, strings.Join(fields, " "))
+}
+
+// diskMetricsList collects the disk metrics of the nodes provisioned for a test
+type diskMetricsList struct {
+	sync.Mutex
+	metrics []DiskMetrics
+}
+
+func (r *diskMetricsList) add(metrics DiskMetrics) {
+	r.Lock()
+	r.metrics = append(r.metrics, metrics)
+	r.Unlock()
+}
+
+func (r *diskMetricsList) list() []DiskMetrics {
+	r.Lock()
+	defer r.Unlock()
+	return append([]DiskMetrics(nil), r.metrics...)
+}
+
+// DiskMetrics returns the disk metrics measured on the nodes of this test
+func (c *TestContext) DiskMetrics() []DiskMetrics {
+	return c.diskMetrics.list()
+}
+
+// qualifyDisks measures the disk performance of the nodes and fails
+// unless all nodes meet the configured thresholds.
+// The nodes are measured repeatedly as disks of some cloud VMs only reach
+// their performance once initialized.
+// Nodes that fail the qualification are marked as disqualified: cloud VMs are
+// torn down and provisioned anew on retry and inventory hosts are rejected
+// (see provisionStatic)
+func (c *TestContext) qualifyDisks(ctx context.Context, nodes []*gravity) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	param := nodes[0].param
+	thresholds := param.diskThresholds()
+	if thresholds.Disabled || param.CloudProvider == constants.Docker {
+		// Containers share the disk of the host
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, diskWaitTimeout)
+	defer cancel()
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node *gravity) {
+			errs <- c.qualifyDisk(ctx, node, thresholds)
+		}(node)
+	}
+	err := utils.CollectErrors(ctx, errs)
+	if err != nil {
+		c.Logger().WithError(err).Error("Node disks did not meet performance requirements.")
+		return trace.Wrap(err, "node disks did not meet performance requirements")
+	}
+	return nil
+}
+
+func (c *TestContext) qualifyDisk(ctx context.Context, node *gravity, thresholds DiskThresholds) error {
+	withFio, err := ensureFio(ctx, node)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !withFio {
+		if thresholds.needsFio() && node.param.DiskQualification != nil {
+			return trace.NotFound("fio is required for the configured disk qualification "+
+				"but is not installed on inventory host %v", node)
+		}
+		node.Logger().Warn("fio is not installed, only measuring disk throughput.")
+		thresholds = DiskThresholds{MinThroughput: thresholds.MinThroughput}
+	}
+	var metrics DiskMetrics
+	var failure error
+	err = wait.Retry(ctx, func() error {
+		measured, err := measureDisk(ctx, node, withFio)
+		if err != nil {
+			return wait.Abort(trace.Wrap(err))
+		}
+		metrics = measured
+		failure = thresholds.check(metrics)
+		if trace.IsBadParameter(failure) {
+			return wait.Abort(failure)
+		}
+		if failure != nil {
+			return wait.Continue("%v", trace.UserMessage(failure))
+		}
+		return nil
+	})
+	if metrics.Node != "" {
+		metrics.Passed = failure == nil
+		c.diskMetrics.add(metrics)
+		node.Logger().WithField("disk_metrics", metrics).Info("Measured disk performance.")
+	}
+	if err != nil && failure != nil {
+		node.disqualified = true
+		return trace.Wrap(failure, "node %v failed disk qualification", node)
+	}
+	return trace.Wrap(err)
+}
+
+// measureDisk measures the sequential throughput of the root filesystem and
+// the Docker device, and the fsync latency and random IOPS of the etcd directory
+// if withFio is set
+func measureDisk(ctx context.Context, node *gravity, withFio bool) (metrics DiskMetrics, err error) {
+	metrics = DiskMetrics{Node: node.String(), Throughput: make(map[string]uint64)}
+	for _, path := range throughputPaths(node.param.dockerDevice) {
+		var out string
+		err := sshutil.RunAndParse(ctx, node.Client(), node.Logger(), throughputCmd(path), nil, sshutil.ParseAsString(&out))
+		if err != nil {
+			return metrics, trace.Wrap(err)
+		}
+		metrics.Throughput[path], err = ParseDDOutput(out)
+		if err != nil {
+			return metrics, trace.Wrap(err)
+		}
+	}
+
+	if !withFio {
+		return metrics, nil
+	}
+
+	var out string
+	err = sshutil.RunAndParse(ctx, node.Client(), node.Logger(), fioCmd(fsyncJob), nil, sshutil.ParseAsString(&out))
+	if err != nil {
+		return metrics, trace.Wrap(err)
+	}
+	metrics.FsyncP50, metrics.FsyncP99, err = parseFioFsyncLatency(out)
+	if err != nil {
+		return metrics, trace.Wrap(err)
+	}
+
+	err = sshutil.RunAndParse(ctx, node.Client(), node.Logger(), fioCmd(iopsJob), nil, sshutil.ParseAsString(&out))
+	if err != nil {
+		return metrics, trace.Wrap(err)
+	}
+	metrics.IOPS, err = parseFioWriteIOPS(out)
+	return metrics, trace.Wrap(err)
+}
+
+// throughputPaths returns the paths to measure the sequential throughput of.
+// A Docker device that is not a block device names a directory
+func throughputPaths(dockerDevice string) []string {
+	paths := []string{"/iotest"}
+	switch {
+	case dockerDevice == "":
+	case strings.HasPrefix(dockerDevice, "/dev/"):
+		paths = append(paths, dockerDevice)
+	default:
+		paths = append(paths, strings.TrimSuffix(dockerDevice, "/")+"/iotest")
+	}
+	return paths
+}
+
+// throughputCmd returns the command that measures the sequential write
+// throughput of the path with dd. Files are removed afterwards
+func throughputCmd(path string) string {
+	cmd := fmt.Sprintf("dd if=/dev/zero of=%v bs=100K count=1024 conv=fdatasync 2>&1", path)
+	if !strings.HasPrefix(path, "/dev/") {
+		cmd = fmt.Sprintf("trap \"rm -f %v\" EXIT; %v", path, cmd)
+	}
+	return fmt.Sprintf("sudo bash -c '%v'", cmd)
+}
+
+const (
+	// fsyncJob measures the latency of small synchronized sequential writes,
+	// the write pattern of the etcd write-ahead log.
+	// See https://www.ibm.com/cloud/blog/using-fio-to-tell-whether-your-storage-is-fast-enough-for-etcd
+	fsyncJob = "--name=robotest-fsync --rw=write --ioengine=sync --fdatasync=1 --size=22m --bs=2300"
+	// iopsJob measures the number of random 4K writes per second
+	iopsJob = "--name=robotest-iops --rw=randwrite --bs=4k --size=64m --direct=1 " +
+		"--ioengine=libaio --iodepth=16 --runtime=10 --time_based"
+)
+
+// fioCmd returns the command that runs the fio job on the etcd directory,
+// or on /var/lib if the directory does not exist
+func fioCmd(job string) string {
+	script := []string{
+		fmt.Sprintf("dir=%v", nodeprep.EtcdDir),
+		"[ -d $dir ] || dir=/var/lib",
+		`trap "rm -f $dir/robotest-fio" EXIT`,
+		fmt.Sprintf("fio %v --filename=$dir/robotest-fio --output-format=json", job),
+	}
+	return fmt.Sprintf("sudo bash -c '%v'", strings.Join(script, "\n"))
+}
+
+// ensureFio installs fio on the node unless already installed.
+// Packages are not installed on inventory hosts as they are not owned by robotest.
+// Returns false if fio is not available
+func ensureFio(ctx context.Context, node *gravity) (bool, error) {
+	err := sshutil.Run(ctx, node.Client(), node.Logger(), "command -v fio", nil)
+	if err == nil {
+		return true, nil
+	}
+	if node.param.CloudProvider == constants.Static {
+		return false, nil
+	}
+	cmd, err := nodeprep.InstallCmd(node.param.image, "fio")
+	if err != nil {
+		return false, trace.Wrap(err, "fio is required for disk qualification")
+	}
+	err = sshutil.Run(ctx, node.Client(), node.Logger(), cmd, nil)
+	if err != nil {
+		return false, trace.Wrap(err, "failed to install fio")
+	}
+	return true, nil
+}
+
+// fioOutput is the subset of the fio JSON output used for disk qualification
+type fioOutput struct {
+	Jobs []struct {
+		Write struct {
+			IOPS float64 `json:"iops"`
+		} `json:"write"`
+		Sync struct {
+			Latency struct {
+				Percentile map[string]float64 `json:"percentile"`
+			} `json:"lat_ns"`
+		} `json:"sync"`
+	} `json:"jobs"`
+}
+
+// parseFioOutput parses the JSON output of a single fio job.
+// fio might print warnings before the JSON document
+func parseFioOutput(out string) (*fioOutput, error) {
+	start := strings.Index(out, "{")
+	if start == -1 {
+		return nil, trace.BadParameter("no fio JSON output in %q", out)
+	}
+	var output fioOutput
+	if err := json.Unmarshal([]byte(out[start:]), &output); err != nil {
+		return nil, trace.Wrap(err, "failed to parse fio output")
+	}
+	if len(output.Jobs) != 1 {
+		return nil, trace.BadParameter("expected a single fio job, got %v", len(output.Jobs))
+	}
+	return &output, nil
+}
+
+// parseFioFsyncLatency returns the p50 and p99 fdatasync latency reported by fio
+func parseFioFsyncLatency(out string) (p50, p99 time.Duration, err error) {
+	output, err := parseFioOutput(out)
+	if err != nil {
+		return 0, 0, trace.Wrap(err)
+	}
+	percentiles := output.Jobs[0].Sync.Latency.Percentile
+	median, ok := percentiles["50.000000"]
+	if !ok {
+		return 0, 0, trace.NotFound("no p50 fsync latency in fio output")
+	}
+	tail, ok := percentiles["99.000000"]
+	if !ok {
+		return 0, 0, trace.NotFound("no p99 fsync latency in fio output")
+	}
+	return time.Duration(median), time.Duration(tail), nil
+}
+
+// parseFioWriteIOPS returns the write IOPS reported by fio
+func parseFioWriteIOPS(out string) (float64, error) {
+	output, err := parseFioOutput(out)
+	if err != nil {
+		return 0, trace.Wrap(err)
+	}
+	return output.Jobs[0].Write.IOPS, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseFioFsyncLatency(t *testing.T) {
+	out, err := ioutil.ReadFile("testdata/fio-fsync.json")
+	require.NoError(t, err)
+
+	p50, p99, err := parseFioFsyncLatency(string(out))
+	require.NoError(t, err)
+	assert.Equal(t, 2056192*time.Nanosecond, p50)
+	assert.Equal(t, 3620864*time.Nanosecond, p99)
+
+	out, err = ioutil.ReadFile("testdata/fio-randwrite.json")
+	require.NoError(t, err)
+	_, _, err = parseFioFsyncLatency(string(out))
+	assert.True(t, trace.IsNotFound(err), "expected not found, got %v", err)
+}
+
+func TestParseFioWriteIOPS(t *testing.T) {
+	out, err := ioutil.ReadFile("testdata/fio-randwrite.json")
+	require.NoError(t, err)
+
+	iops, err := parseFioWriteIOPS(string(out))
+	require.NoError(t, err)
+	assert.InDelta(t, 3021.6, iops, 0.1)
+
+	_, err = parseFioWriteIOPS("fio: pid=0, err=22/file:filesetup.c:703, func=open, error=Invalid argument")
+	assert.True(t, trace.IsBadParameter(err), "expected bad parameter, got %v", err)
+}
+
+func TestDiskThresholds(t *testing.T) {
+	metrics := DiskMetrics{
+		Throughput: map[string]uint64{"/iotest": 120e6, "/dev/sdc": 8e6},
+		FsyncP50:   2 * time.Millisecond,
+		FsyncP99:   15 * time.Millisecond,
+		IOPS:       400,
+	}
+
+	err := DefaultDiskThresholds.check(metrics)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/dev/sdc has 8.0 MB/s < minimum of 10 MB/s")
+	assert.Contains(t, err.Error(), "fsync p99 latency 15ms > maximum of 10ms")
+	assert.NotContains(t, err.Error(), "/iotest")
+
+	thresholds := DiskThresholds{
+		MinThroughput: "5MB",
+		MaxFsyncP50:   5 * time.Millisecond,
+		MaxFsyncP99:   20 * time.Millisecond,
+		MinIOPS:       300,
+	}
+	assert.NoError(t, thresholds.check(metrics))
+
+	thresholds.MinIOPS = 500
+	err = thresholds.check(metrics)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 random write IOPS < minimum of 500")
+
+	thresholds.MinThroughput = "fast"
+	assert.True(t, trace.IsBadParameter(thresholds.check(metrics)))
+}
+
+func TestDiskThresholdsConfig(t *testing.T) {
+	for _, provider := range []string{"azure", "aws", "gce", "ops", "static", "vagrant"} {
+		config := ProvisionerConfig{CloudProvider: provider}
+		assert.Equal(t, DefaultDiskThresholds, config.diskThresholds(), "%v", provider)
+	}
+	config := ProvisionerConfig{CloudProvider: "gce"}
+
+	err := yaml.Unmarshal([]byte(`
+disk_qualification:
+  min_throughput: 50MB
+  max_fsync_p99: 5ms
+  min_iops: 1000
+`), &config)
+	require.NoError(t, err)
+	assert.Equal(t, DiskThresholds{
+		MinThroughput: "50MB",
+		MaxFsyncP99:   5 * time.Millisecond,
+		MinIOPS:       1000,
+	}, config.diskThresholds())
+
+	config = ProvisionerConfig{CloudProvider: "gce"}
+	require.NoError(t, yaml.Unmarshal([]byte("disk_qualification: {disabled: true}"), &config))
+	assert.True(t, config.diskThresholds().Disabled, "disk qualification can be disabled")
+}
+
+func TestDiskMetricsString(t *testing.T) {
+	metrics := DiskMetrics{
+		Node:       "node-1",
+		Throughput: map[string]uint64{"/iotest": 120e6, "/dev/sdc": 8e6},
+		FsyncP50:   2 * time.Millisecond,
+		FsyncP99:   15 * time.Millisecond,
+		IOPS:       400,
+	}
+	assert.Equal(t, "node-1 failed: /dev/sdc=8.0 MB/s /iotest=120 MB/s fsync_p50=2ms fsync_p99=15ms iops=400",
+		metrics.String())
+
+	metrics = DiskMetrics{Node: "node-2", Throughput: map[string]uint64{"/iotest": 120e6}, Passed: true}
+	assert.Equal(t, "node-2 passed: /iotest=120 MB/s", metrics.String())
+	assert.False(t, DiskThresholds{MinThroughput: "10MB"}.needsFio())
+	assert.True(t, DefaultDiskThresholds.needsFio())
+}
+
+func TestThroughputPaths(t *testing.T) {
+	assert.Equal(t, []string{"/iotest", "/dev/xvdb"}, throughputPaths("/dev/xvdb"))
+	assert.Equal(t, []string{"/iotest", "/var/lib/gravity/iotest"}, throughputPaths("/var/lib/gravity/"))
+	assert.Equal(t, []string{"/iotest"}, throughputPaths(""))
+}
+
+func TestDiskCommands(t *testing.T) {
+	assert.Equal(t, `sudo bash -c 'dd if=/dev/zero of=/dev/sdc bs=100K count=1024 conv=fdatasync 2>&1'`,
+		throughputCmd("/dev/sdc"))
+	assert.Equal(t, `sudo bash -c 'trap "rm -f /iotest" EXIT; dd if=/dev/zero of=/iotest bs=100K count=1024 conv=fdatasync 2>&1'`,
+		throughputCmd("/iotest"))
+
+	for _, job := range []string{fsyncJob, iopsJob} {
+		script := sudoScript(t, fioCmd(job))
+		assert.Contains(t, script, "fio "+job+" --filename=$dir/robotest-fio --output-format=json")
+		assertValidBash(t, script)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gravitational/robotest/infra/docker"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+)
+
+// provisionDocker starts the cluster nodes as local containers
+func (c *TestContext) provisionDocker(cfg ProvisionerConfig) (cluster Cluster, err error) {
+	log := c.Logger().WithField("config", cfg)
+	log.Debug("Starting node containers.")
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	params, err := makeDynamicParams(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	config := *cfg.Docker
+	config.ClusterName = dockerClusterName(cfg.Tag())
+	config.NumNodes = int(cfg.NodeCount)
+	err = config.Validate()
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	p, err := docker.New(filepath.Join(cfg.StateDir, "docker"), config)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), cloudInitTimeout)
+	defer cancel()
+
+	_, err = p.Create(ctx, false)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if errDestroy := destroyResource(p.Destroy); errDestroy != nil {
+			log.WithError(errDestroy).Error("Failed to destroy node containers.")
+		}
+	}()
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	log.Debug("Connecting to node containers.")
+	gravityNodes, err := connectVMs(ctx, c.Logger(), *params, p.NodePool().Nodes())
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			disconnect(asNodes(gravityNodes))
+		}
+	}()
+	c.streamLogs(gravityNodes)
+
+	log.Debug("Configuring node containers.")
+	err = configureVMs(ctx, c.Logger(), *params, gravityNodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	err = c.postProvision(gravityNodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	log.WithField("nodes", gravityNodes).Debug("Provisioning complete.")
+
+	cluster.Nodes = asNodes(gravityNodes)
+	cluster.Destroy = wrapDestroyFunc(c, cfg.Tag(), cluster.Nodes, p.Destroy, nil)
+	return cluster, nil
+}
+
+// bootstrapDocker runs the optional bootstrap script on a node container
+func bootstrapDocker(ctx context.Context, g *gravity, param cloudDynamicParams) error {
+	if param.Docker.BootstrapScript == "" {
+		return nil
+	}
+	err := sshutil.RunScript(ctx, g.Client(), g.Logger(), param.Docker.BootstrapScript, sshutil.SUDO)
+	return trace.Wrap(err)
+}
+
+// dockerClusterName turns a test tag into a valid container and network name
+func dockerClusterName(tag string) string {
+	name := strings.Trim(reDockerName.ReplaceAllString(strings.ToLower(tag), "-"), "-")
+	if name == "" {
+		return "robotest"
+	}
+	return name
+}
+
+var reDockerName = regexp.MustCompile(`[^a-z0-9_.-]+`)
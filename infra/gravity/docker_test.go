@@ -0,0 +1,29 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerClusterName(t *testing.T) {
+	assert.Equal(t, "robotest-install-3n-centos7.8", dockerClusterName("robotest-install-3n-centos7.8"))
+	assert.Equal(t, "sanity-upgrade-from-6.1-1n", dockerClusterName("Sanity/Upgrade from 6.1 1n"))
+	assert.Equal(t, "robotest", dockerClusterName("//"))
+}
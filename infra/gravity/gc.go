@@ -0,0 +1,281 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/robotest/infra/terraform"
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectGarbage destroys cloud resources allocated more than ttl ago that have not been destroyed.
+// Resources are looked up in the resource ledger at ledgerPath and in the terraform
+// state directories under the configured state directory.
+// Only resources of the configured cloud provider can be destroyed
+func CollectGarbage(ctx context.Context, cfg ProvisionerConfig, ledgerPath string, ttl time.Duration, log logrus.FieldLogger) error {
+	records, err := ReadLedger(ledgerPath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	states, err := findTerraformStates(cfg.StateDir)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	stale := staleResources(liveResources(records), states, time.Now().Add(-ttl))
+	if len(stale) == 0 {
+		log.Info("No stale resources found.")
+		return nil
+	}
+
+	var errs []error
+	for _, record := range stale {
+		logger := log.WithFields(logrus.Fields{
+			"tag":       record.Tag,
+			"provider":  record.Provider,
+			"region":    record.Region,
+			"state-dir": record.StateDir,
+			"allocated": record.Time,
+		})
+		if record.Provider == "" {
+			logger.Warn("Skipping resources of unknown cloud provider recorded by a former version.")
+			continue
+		}
+		if record.Provider != cfg.CloudProvider {
+			logger.Warn("Skipping resources of another cloud provider.")
+			continue
+		}
+
+		exists, err := stateDirExists(record)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if exists {
+			logger.Info("Destroying stale resources.")
+			err = destroyStaleResource(ctx, cfg, record)
+			if err != nil {
+				logger.WithError(err).Error("Failed to destroy stale resources.")
+				errs = append(errs, err)
+				continue
+			}
+		} else {
+			// terraform state is removed along with the resources
+			logger.Info("State directory not found, assuming resources have been destroyed.")
+		}
+		if record.Tag == "" {
+			// not in the ledger
+			continue
+		}
+		record.Event = ResourceDestroyed
+		record.Time = time.Now().UTC()
+		if err := appendLedger(ledgerPath, record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return trace.NewAggregate(errs...)
+}
+
+// staleResources returns the resources allocated before cutoff.
+// live lists resources from the ledger, states maps terraform state directories
+// with resources to their state.
+// States not tracked by the ledger are skipped unless their cloud provider is known
+func staleResources(live []ResourceRecord, states map[string]terraformState, cutoff time.Time) (stale []ResourceRecord) {
+	tracked := make(map[string]bool)
+	for _, record := range live {
+		tracked[record.StateDir] = true
+		if record.Time.Before(cutoff) {
+			stale = append(stale, record)
+		}
+	}
+	for dir, state := range states {
+		if tracked[dir] || state.provider == "" || !state.modTime.Before(cutoff) {
+			continue
+		}
+		stale = append(stale, ResourceRecord{
+			Provider: state.provider,
+			StateDir: dir,
+			Time:     state.modTime,
+		})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Time.Before(stale[j].Time)
+	})
+	return stale
+}
+
+// destroyStaleResource destroys the resources described by record
+func destroyStaleResource(ctx context.Context, cfg ProvisionerConfig, record ResourceRecord) error {
+	config := terraform.Config{
+		CloudProvider: record.Provider,
+		ScriptPath:    cfg.ScriptPath,
+		PluginDir:     cfg.TerraformPluginDir,
+	}
+	switch record.Provider {
+	case constants.Azure:
+		// resources are removed with the resource group named after the tag
+		if record.Tag == "" || cfg.Azure == nil {
+			return trace.BadParameter("cannot determine resource group for %v", record.StateDir)
+		}
+		azure := *cfg.Azure
+		azure.ResourceGroup = record.Tag
+		config.Azure = &azure
+	case constants.GCE, constants.AWS:
+		config.AWS = cfg.AWS
+		if cfg.GCE != nil {
+			gce := *cfg.GCE
+			config.GCE = &gce
+			config.VarFilePath = cfg.GCE.VarFilePath
+		}
+	default:
+		return trace.NotImplemented("cannot destroy resources of cloud provider %v", record.Provider)
+	}
+
+	p, err := terraform.New(record.StateDir, config)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(p.Destroy(ctx))
+}
+
+// stateDirExists returns true unless the resources are destroyed with terraform
+// and their state directory does not exist
+func stateDirExists(record ResourceRecord) (bool, error) {
+	if record.Provider == constants.Azure {
+		// resources are removed with the resource group
+		return true, nil
+	}
+	_, err := os.Stat(record.StateDir)
+	if err == nil {
+		return true, nil
+	}
+	err = trace.ConvertSystemError(err)
+	if trace.IsNotFound(err) {
+		return false, nil
+	}
+	return false, trace.Wrap(err)
+}
+
+// terraformState describes a terraform state directory with resources
+type terraformState struct {
+	// modTime is the time the state was last modified
+	modTime time.Time
+	// provider is the cloud provider of the resources or empty if unknown
+	provider string
+}
+
+// findTerraformStates returns the directories under root with terraform state
+// that has resources, mapped to their state
+func findTerraformStates(root string) (map[string]terraformState, error) {
+	states := make(map[string]terraformState)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return trace.ConvertSystemError(err)
+		}
+		if info.IsDir() || info.Name() != terraformStateFile {
+			return nil
+		}
+		resources, err := stateResources(path)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if len(resources) != 0 {
+			states[filepath.Dir(path)] = terraformState{
+				modTime:  info.ModTime(),
+				provider: resourcesProvider(resources),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return states, nil
+}
+
+// stateResource is a resource listed in the terraform state
+type stateResource struct {
+	// Type is the resource type, i.e. google_compute_instance
+	Type string `json:"type"`
+}
+
+// stateResources returns the resources listed in the terraform state file at path
+func stateResources(path string) ([]stateResource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var state struct {
+		Resources []stateResource `json:"resources"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, trace.BadParameter("invalid terraform state %v: %v", path, err)
+	}
+	return state.Resources, nil
+}
+
+// resourcesProvider returns the cloud provider of the resources determined
+// from the resource types or an empty string if the resources do not belong
+// to a single known cloud provider
+func resourcesProvider(resources []stateResource) (provider string) {
+	for _, resource := range resources {
+		var current string
+		for prefix, name := range resourceTypeProviders {
+			if strings.HasPrefix(resource.Type, prefix) {
+				current = name
+				break
+			}
+		}
+		switch {
+		case current == "":
+			// i.e. random_string or null_resource
+			continue
+		case provider != "" && provider != current:
+			return ""
+		}
+		provider = current
+	}
+	return provider
+}
+
+// resourceTypeProviders maps terraform resource type prefixes to cloud providers
+var resourceTypeProviders = map[string]string{
+	"google_":  constants.GCE,
+	"aws_":     constants.AWS,
+	"azurerm_": constants.Azure,
+}
+
+// terraformStateFile is the name of the terraform state file in the state directory
+const terraformStateFile = "terraform.tfstate"
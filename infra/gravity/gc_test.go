@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindsTerraformStatesWithResources(t *testing.T) {
+	root, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	for dir, state := range map[string]string{
+		"install-1/ubuntu18/tf":  `{"version": 4, "resources": [{"type": "google_compute_instance"}, {"type": "random_string"}]}`,
+		"install-2/ubuntu18/tf":  `{"version": 4, "resources": []}`,
+		"install-5/ubuntu18/tf":  `{"version": 4, "resources": [{"type": "google_compute_instance"}, {"type": "aws_instance"}]}`,
+		"install-3/centos7/tf":   ``,
+		"install-4/centos7/logs": `not a state`,
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+		name := terraformStateFile
+		if filepath.Base(dir) == "logs" {
+			name = "report.txt"
+		}
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, dir, name), []byte(state), 0644))
+	}
+
+	states, err := findTerraformStates(root)
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+	assert.Equal(t, "gce", states[filepath.Join(root, "install-1/ubuntu18/tf")].provider)
+	assert.Empty(t, states[filepath.Join(root, "install-5/ubuntu18/tf")].provider, "provider is ambiguous")
+
+	states, err = findTerraformStates(filepath.Join(root, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, states)
+}
+
+func TestSelectsStaleResources(t *testing.T) {
+	now := time.Date(2020, 9, 22, 22, 33, 0, 0, time.UTC)
+	cutoff := now.Add(-24 * time.Hour)
+	live := []ResourceRecord{
+		{Tag: "fresh", Provider: "gce", StateDir: "/state/fresh/tf", Time: now.Add(-time.Hour)},
+		{Tag: "old", Provider: "azure", StateDir: "/state/old/tf", Time: now.Add(-48 * time.Hour)},
+	}
+	states := map[string]terraformState{
+		// tracked by the ledger
+		"/state/fresh/tf": {modTime: now.Add(-72 * time.Hour), provider: "gce"},
+		"/state/lost/tf":  {modTime: now.Add(-36 * time.Hour), provider: "gce"},
+		"/state/new/tf":   {modTime: now.Add(-time.Minute), provider: "gce"},
+		// provider unknown
+		"/state/unknown/tf": {modTime: now.Add(-36 * time.Hour)},
+	}
+
+	stale := staleResources(live, states, cutoff)
+	assert.Equal(t, []ResourceRecord{
+		{Tag: "old", Provider: "azure", StateDir: "/state/old/tf", Time: now.Add(-48 * time.Hour)},
+		{Provider: "gce", StateDir: "/state/lost/tf", Time: now.Add(-36 * time.Hour)},
+	}, stale)
+}
+
+func TestStateDirExists(t *testing.T) {
+	root, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	exists, err := stateDirExists(ResourceRecord{Provider: "gce", StateDir: root})
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = stateDirExists(ResourceRecord{Provider: "gce", StateDir: filepath.Join(root, "gone")})
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = stateDirExists(ResourceRecord{Provider: "azure", StateDir: filepath.Join(root, "gone")})
+	require.NoError(t, err)
+	assert.True(t, exists, "azure resources are destroyed with the resource group")
+}
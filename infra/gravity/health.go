@@ -0,0 +1,285 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// HealthActive is a cluster that is active with all kubernetes nodes ready
+	HealthActive = ClusterStateActive
+	// HealthDegraded is a cluster reported degraded by gravity or with kubernetes nodes not ready
+	HealthDegraded = ClusterStateDegraded
+	// HealthUnknown is a cluster whose health could not be queried
+	HealthUnknown = "unknown"
+)
+
+// TimelineEvent is a notable event recorded during the lifetime of a test
+type TimelineEvent struct {
+	// Time is when the event happened
+	Time time.Time `json:"time"`
+	// Source identifies the component that recorded the event
+	Source string `json:"source"`
+	// Message describes the event
+	Message string `json:"message"`
+}
+
+// timeline is a list of test events safe for concurrent use
+type timeline struct {
+	sync.Mutex
+	events []TimelineEvent
+}
+
+func (r *timeline) record(source, format string, args ...interface{}) TimelineEvent {
+	event := TimelineEvent{
+		Time:    time.Now().UTC(),
+		Source:  source,
+		Message: fmt.Sprintf(format, args...),
+	}
+	r.Lock()
+	r.events = append(r.events, event)
+	r.Unlock()
+	return event
+}
+
+func (r *timeline) list() []TimelineEvent {
+	r.Lock()
+	defer r.Unlock()
+	return append([]TimelineEvent(nil), r.events...)
+}
+
+// Timeline returns the events recorded so far for this test
+func (c *TestContext) Timeline() []TimelineEvent {
+	return c.timeline.list()
+}
+
+// recordEvent adds an event to this test's timeline
+func (c *TestContext) recordEvent(source, format string, args ...interface{}) {
+	event := c.timeline.record(source, format, args...)
+	c.Logger().WithField("source", source).Info(event.Message)
+}
+
+// StartHealthMonitor starts polling `gravity status` and kubernetes node readiness
+// on the given nodes every interval for the remainder of the test.
+// If interval is zero, a default interval is used.
+//
+// Every change of cluster health is recorded in the test timeline.
+// If the cluster degrades outside of a window declared with ExpectDegradation,
+// the test fails at its next step (see OK) like on any other failed step.
+//
+// Calling StartHealthMonitor on a test with a running monitor only updates
+// the set of monitored nodes, i.e. after an expand or shrink.
+func (c *TestContext) StartHealthMonitor(nodes []Gravity, interval time.Duration) {
+	if interval == 0 {
+		interval = healthMonitorInterval
+	}
+	c.health.Lock()
+	defer c.health.Unlock()
+	c.health.nodes = nodes
+	if c.health.started {
+		return
+	}
+	c.health.started = true
+	c.health.state = HealthUnknown
+	go c.monitorHealth(interval)
+}
+
+// ExpectDegradation declares that the cluster may degrade until the returned
+// function is called, i.e.:
+//
+//   done := g.ExpectDegradation("power off node")
+//   ...
+//   g.OK("wait for active status", g.WaitForActiveStatus(nodes))
+//   done()
+//
+// The window should only be closed once the cluster has recovered, as
+// degradation observed after the window is closed fails the test.
+func (c *TestContext) ExpectDegradation(reason string) (done func()) {
+	c.health.Lock()
+	c.health.expected[reason]++
+	c.health.Unlock()
+	c.recordEvent(healthSource, "Expected degradation window opened: %v.", reason)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.health.Lock()
+			c.health.expected[reason]--
+			if c.health.expected[reason] == 0 {
+				delete(c.health.expected, reason)
+			}
+			c.health.Unlock()
+			c.recordEvent(healthSource, "Expected degradation window closed: %v.", reason)
+		})
+	}
+}
+
+// healthError returns the error recorded by the health monitor if the cluster
+// degraded unexpectedly
+func (c *TestContext) healthError() error {
+	c.health.Lock()
+	defer c.health.Unlock()
+	return c.health.err
+}
+
+func (c *TestContext) monitorHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.monitorCtx.Done():
+			return
+		case <-ticker.C:
+			c.checkHealth()
+		}
+	}
+}
+
+// checkHealth polls the cluster health once and records the change, if any
+func (c *TestContext) checkHealth() {
+	c.health.Lock()
+	nodes := onlineNodes(c.health.nodes)
+	c.health.Unlock()
+	if len(nodes) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.monitorCtx, c.timeouts.NodeStatus)
+	defer cancel()
+	state, reason := queryHealth(ctx, nodes)
+	if c.monitorCtx.Err() != nil {
+		// The monitor has been stopped while polling
+		return
+	}
+
+	c.health.Lock()
+	prev := c.health.state
+	c.health.state = state
+	expected := c.health.expectedReasons()
+	c.health.Unlock()
+
+	if state == prev {
+		return
+	}
+	message := fmt.Sprintf("Cluster health %v -> %v", prev, state)
+	if reason != "" {
+		message = fmt.Sprintf("%v: %v", message, reason)
+	}
+	if state != HealthDegraded {
+		c.recordEvent(healthSource, "%v.", message)
+		return
+	}
+	if len(expected) != 0 {
+		c.recordEvent(healthSource, "%v (expected: %v).", message, strings.Join(expected, ", "))
+		return
+	}
+
+	c.recordEvent(healthSource, "%v (unexpected).", message)
+	c.health.Lock()
+	if c.health.err == nil {
+		c.health.err = trace.CompareFailed("cluster degraded unexpectedly: %v", reason)
+		close(c.health.failed)
+	}
+	c.health.Unlock()
+	c.Logger().WithField("reason", reason).Error("Unexpected cluster degradation, failing test.")
+}
+
+// queryHealth determines the health of the cluster formed by nodes.
+// Returns the health state along with the reason for the state
+func queryHealth(ctx context.Context, nodes []Gravity) (state, reason string) {
+	reports, err := statusReports(ctx, nodes)
+	if err != nil {
+		return HealthUnknown, trace.UserMessage(err)
+	}
+	kubeNodes, err := NewKubectl(nodes[0]).Nodes(ctx, ListOptions{})
+	if err != nil {
+		return HealthUnknown, trace.UserMessage(err)
+	}
+	return clusterHealth(reports, kubeNodes)
+}
+
+// clusterHealth classifies cluster health given the statuses reported by gravity
+// and the readiness of kubernetes nodes.
+// Clusters that are neither degraded nor active are classified by their
+// state, e.g. "updating"
+func clusterHealth(reports []NodeReport, kubeNodes []KubeNode) (state, reason string) {
+	if len(reports) == 0 {
+		return HealthUnknown, "no status reported"
+	}
+	if err := NotDegraded(reports); err != nil {
+		return HealthDegraded, trace.UserMessage(err)
+	}
+	var notReady []string
+	for _, node := range kubeNodes {
+		if !node.Ready() {
+			notReady = append(notReady, node.Name())
+		}
+	}
+	if len(notReady) != 0 {
+		return HealthDegraded, fmt.Sprintf("kubernetes nodes not ready: %v", strings.Join(notReady, ", "))
+	}
+	return reports[0].Status.Cluster.State, ""
+}
+
+func onlineNodes(nodes []Gravity) (online []Gravity) {
+	for _, node := range nodes {
+		if !node.Offline() {
+			online = append(online, node)
+		}
+	}
+	return online
+}
+
+// healthMonitor keeps the state of the background cluster health monitor
+type healthMonitor struct {
+	sync.Mutex
+	started bool
+	nodes   []Gravity
+	// state is the last observed cluster health
+	state string
+	// expected counts open degradation windows by reason
+	expected map[string]int
+	// err is set once the cluster degraded unexpectedly
+	err error
+	// failed is closed once err is set
+	failed chan struct{}
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{
+		expected: make(map[string]int),
+		failed:   make(chan struct{}),
+	}
+}
+
+// expectedReasons lists the reasons for open degradation windows.
+// Must be called with the lock held
+func (r *healthMonitor) expectedReasons() (reasons []string) {
+	for reason := range r.expected {
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+const healthSource = "health"
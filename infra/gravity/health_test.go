@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterHealth(t *testing.T) {
+	active := loadReports(t, "testdata/status-active-7.0.12.json", "node-0")
+	degraded := loadReports(t, "testdata/status-degraded-1641.json", "node-0")
+	updating := loadReports(t, "testdata/status-updating-7.0.30.json", "node-0")
+	ready := []KubeNode{kubeNode("10.138.0.71", "True"), kubeNode("10.138.0.72", "True")}
+	notReady := []KubeNode{kubeNode("10.138.0.71", "True"), kubeNode("10.138.0.72", "False")}
+
+	var testCases = []struct {
+		comment   string
+		reports   []NodeReport
+		kubeNodes []KubeNode
+		state     string
+		reason    string
+	}{
+		{comment: "active", reports: active, kubeNodes: ready, state: HealthActive},
+		{comment: "gravity degraded", reports: degraded, kubeNodes: ready, state: HealthDegraded,
+			reason: "NotDegraded failed on node-0: expected system_status running, found degraded"},
+		{comment: "kubernetes node not ready", reports: active, kubeNodes: notReady, state: HealthDegraded,
+			reason: "kubernetes nodes not ready: 10.138.0.72"},
+		{comment: "operation in progress", reports: updating, kubeNodes: ready, state: "updating"},
+		{comment: "no reports", state: HealthUnknown, reason: "no status reported"},
+	}
+
+	for _, tc := range testCases {
+		state, reason := clusterHealth(tc.reports, tc.kubeNodes)
+		assert.Equal(t, tc.state, state, tc.comment)
+		assert.Equal(t, tc.reason, reason, tc.comment)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	var events timeline
+	events.record(healthSource, "Cluster health %v -> %v.", HealthUnknown, HealthActive)
+	list := events.list()
+	events.record(healthSource, "Cluster health %v -> %v.", HealthActive, HealthDegraded)
+
+	assert.Len(t, list, 1, "list returns a snapshot")
+	assert.Equal(t, "Cluster health unknown -> active.", list[0].Message)
+	assert.Len(t, events.list(), 2)
+}
+
+func TestUnexpectedDegradationFailsNextStep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &TestContext{
+		ctx:    ctx,
+		log:    logrus.New(),
+		health: newHealthMonitor(),
+	}
+	c.OK("install", nil)
+
+	c.health.Lock()
+	c.health.err = trace.CompareFailed("cluster degraded unexpectedly")
+	close(c.health.failed)
+	c.health.Unlock()
+
+	assert.True(t, c.Failed())
+	c.Sleep("wait for degradation", time.Hour)
+	assert.Panics(t, func() { c.OK("expand", nil) })
+	assert.Equal(t, "expand", c.failedStep)
+	assert.True(t, trace.IsCompareFailed(c.Error()))
+	assert.NoError(t, ctx.Err(), "the test context is not cancelled")
+}
+
+func kubeNode(name, ready string) KubeNode {
+	return KubeNode{
+		Metadata: ObjectMeta{Name: name},
+		Status: KubeNodeStatus{
+			Conditions: []Condition{{Type: "Ready", Status: ready}},
+		},
+	}
+}
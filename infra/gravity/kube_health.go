@@ -0,0 +1,416 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gravitational/robotest/lib/utils"
+	"github.com/gravitational/robotest/lib/wait"
+
+	"github.com/cenkalti/backoff"
+	"github.com/gravitational/trace"
+)
+
+// KubeHealthParam configures kubernetes-level health verification
+type KubeHealthParam struct {
+	// KubeletVersion is the expected kubelet version, e.g. "v1.17.9".
+	// If empty, all nodes are only required to run the same kubelet version
+	KubeletVersion string
+	// Namespaces lists the namespaces whose pods must all be ready.
+	// Defaults to kube-system and monitoring
+	Namespaces []string
+	// MaxRestarts is the maximum number of restarts tolerated per container.
+	// Defaults to 5 if unset, 0 means no restarts are tolerated
+	MaxRestarts *int
+	// Units lists the systemd units inside planet that must be active on every node
+	Units []string
+	// MasterUnits lists the systemd units inside planet that must be active on master nodes
+	MasterUnits []string
+}
+
+// CheckAndSetDefaults validates the parameters and fills in defaults
+func (p *KubeHealthParam) CheckAndSetDefaults() error {
+	if p.MaxRestarts == nil {
+		maxRestarts := defaultMaxRestarts
+		p.MaxRestarts = &maxRestarts
+	}
+	if *p.MaxRestarts < 0 {
+		return trace.BadParameter("max restarts must be >= 0")
+	}
+	if len(p.Namespaces) == 0 {
+		p.Namespaces = []string{kubeSystemNS, monitoringNS}
+	}
+	if len(p.Units) == 0 {
+		p.Units = []string{"docker", "etcd", "flanneld", "kube-kubelet", "kube-proxy", "serf", "planet-agent"}
+	}
+	if len(p.MasterUnits) == 0 {
+		p.MasterUnits = []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+	}
+	return nil
+}
+
+const (
+	// ViolationNode is a violation by a kubernetes node
+	ViolationNode = "node"
+	// ViolationPod is a violation by a kubernetes pod
+	ViolationPod = "pod"
+	// ViolationUnit is a violation by a systemd unit inside planet
+	ViolationUnit = "unit"
+)
+
+// Violation describes a single failed kubernetes health check
+type Violation struct {
+	// Kind is the kind of the offending object, one of node, pod or unit
+	Kind string `json:"kind"`
+	// Object identifies the offending object
+	Object string `json:"object"`
+	// Node is the node the object belongs to, if any
+	Node string `json:"node,omitempty"`
+	// Reason describes the violation
+	Reason string `json:"reason"`
+	// Permanent is set for violations that cannot clear up over time,
+	// e.g. exceeded container restart counts
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// String returns a textual representation of this violation
+func (v Violation) String() string {
+	if v.Node == "" {
+		return fmt.Sprintf("%v %v: %v", v.Kind, v.Object, v.Reason)
+	}
+	return fmt.Sprintf("%v %v on %v: %v", v.Kind, v.Object, v.Node, v.Reason)
+}
+
+// KubeHealthReport lists violations found by kubernetes-level health verification
+type KubeHealthReport struct {
+	// Violations lists the failed checks
+	Violations []Violation `json:"violations"`
+}
+
+// OK returns true if no violations have been found
+func (r KubeHealthReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Permanent returns true if any of the violations cannot clear up over time
+func (r KubeHealthReport) Permanent() bool {
+	for _, v := range r.Violations {
+		if v.Permanent {
+			return true
+		}
+	}
+	return false
+}
+
+// Error returns an error listing all violations or nil if there are none
+func (r KubeHealthReport) Error() error {
+	if r.OK() {
+		return nil
+	}
+	violations := make([]string, 0, len(r.Violations))
+	for _, v := range r.Violations {
+		violations = append(violations, v.String())
+	}
+	return trace.CompareFailed("%v kubernetes health violations:\n%v",
+		len(violations), strings.Join(violations, "\n"))
+}
+
+// WaitForKubeHealth blocks until kubernetes-level health verification passes
+// or an internal timeout expires.
+// Returns the last report along with an error listing the violations on timeout.
+// Waiting is aborted early if the report contains permanent violations
+func (c *TestContext) WaitForKubeHealth(nodes []Gravity, param KubeHealthParam) (*KubeHealthReport, error) {
+	c.Logger().WithField("nodes", Nodes(nodes)).Info("Waiting for kubernetes health.")
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = c.timeouts.KubeHealth
+
+	var report *KubeHealthReport
+	err := wait.RetryWithInterval(c.ctx, b, func() (err error) {
+		report, err = c.CheckKubeHealth(nodes, param)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if report.Permanent() {
+			return &backoff.PermanentError{Err: report.Error()}
+		}
+		return trace.Wrap(report.Error())
+	}, c.Logger())
+	return report, trace.Wrap(err)
+}
+
+// CheckKubeHealth verifies the cluster health as seen by kubernetes once:
+//
+//   - all nodes are ready and run the expected kubelet version
+//   - all pods in the configured namespaces are ready
+//   - no pod is crash-looping or has restarted more than the configured threshold
+//   - critical systemd units inside planet are active
+//
+// Returns the report of found violations. An error is only returned if the
+// cluster could not be queried
+func (c *TestContext) CheckKubeHealth(nodes []Gravity, param KubeHealthParam) (*KubeHealthReport, error) {
+	if len(nodes) == 0 {
+		return nil, trace.BadParameter("at least one node required")
+	}
+	if err := param.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.GetPods)
+	defer cancel()
+
+	kubectl := NewKubectl(nodes[0])
+	kubeNodes, err := kubectl.Nodes(ctx, ListOptions{})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	pods, err := kubectl.Pods(ctx, ListOptions{AllNamespaces: true})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var violations []Violation
+	var addrs []string
+	for _, node := range nodes {
+		addrs = append(addrs, node.Node().PrivateAddr())
+	}
+	violations = append(violations, checkKubeNodes(addrs, kubeNodes, param)...)
+	violations = append(violations, checkPods(pods, param)...)
+
+	masters := make(map[string]bool)
+	for _, node := range kubeNodes {
+		masters[node.InternalIP()] = node.Metadata.Labels[gravityRoleLabel] == gravityRoleMaster
+	}
+	unitViolations, err := checkUnits(ctx, nodes, masters, param)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	violations = append(violations, unitViolations...)
+
+	return &KubeHealthReport{Violations: violations}, nil
+}
+
+// checkKubeNodes verifies that every node given with its private address is registered
+// with kubernetes and that all kubernetes nodes are ready and run the expected kubelet version
+func checkKubeNodes(addrs []string, kubeNodes []KubeNode, param KubeHealthParam) (violations []Violation) {
+	byAddr := make(map[string]KubeNode)
+	for _, node := range kubeNodes {
+		byAddr[node.InternalIP()] = node
+	}
+	for _, addr := range addrs {
+		if _, ok := byAddr[addr]; !ok {
+			violations = append(violations, Violation{
+				Kind:   ViolationNode,
+				Object: addr,
+				Reason: "not registered with kubernetes",
+			})
+		}
+	}
+
+	expectedVersion := param.KubeletVersion
+	for i, node := range kubeNodes {
+		if !node.Ready() {
+			violations = append(violations, Violation{
+				Kind:   ViolationNode,
+				Object: node.Name(),
+				Reason: "not ready",
+			})
+		}
+		version := node.Status.NodeInfo.KubeletVersion
+		if param.KubeletVersion == "" {
+			if i == 0 {
+				expectedVersion = version
+				continue
+			}
+			if version != expectedVersion {
+				violations = append(violations, Violation{
+					Kind:   ViolationNode,
+					Object: node.Name(),
+					Reason: fmt.Sprintf("kubelet %v differs from %v on %v", version, expectedVersion, kubeNodes[0].Name()),
+				})
+			}
+			continue
+		}
+		if version != expectedVersion {
+			violations = append(violations, Violation{
+				Kind:   ViolationNode,
+				Object: node.Name(),
+				Reason: fmt.Sprintf("expected kubelet %v, found %v", expectedVersion, version),
+			})
+		}
+	}
+	return violations
+}
+
+// checkPods verifies that pods in the configured namespaces are ready and
+// that no pod is crash-looping or restarting too often.
+// Pods created by jobs are not checked as they are expected to complete
+func checkPods(pods []KubePod, param KubeHealthParam) (violations []Violation) {
+	namespaces := make(map[string]bool)
+	for _, namespace := range param.Namespaces {
+		namespaces[namespace] = true
+	}
+	for _, pod := range pods {
+		if pod.Metadata.OwnedBy("Job") || pod.Status.Phase == podPhaseSucceeded {
+			continue
+		}
+		object := fmt.Sprintf("%v/%v", pod.Metadata.Namespace, pod.Name())
+		if namespaces[pod.Metadata.Namespace] && !pod.Ready() {
+			violations = append(violations, Violation{
+				Kind:   ViolationPod,
+				Object: object,
+				Node:   pod.Status.HostIP,
+				Reason: fmt.Sprintf("not ready (phase %v)", pod.Status.Phase),
+			})
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			if waiting := container.State.Waiting; waiting != nil && waiting.Reason == crashLoopBackOff {
+				violations = append(violations, Violation{
+					Kind:   ViolationPod,
+					Object: object,
+					Node:   pod.Status.HostIP,
+					Reason: fmt.Sprintf("container %v is in %v", container.Name, crashLoopBackOff),
+				})
+			}
+			if container.RestartCount > *param.MaxRestarts {
+				violations = append(violations, Violation{
+					Kind:   ViolationPod,
+					Object: object,
+					Node:   pod.Status.HostIP,
+					Reason: fmt.Sprintf("container %v restarted %v times (> %v)",
+						container.Name, container.RestartCount, *param.MaxRestarts),
+					Permanent: true,
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// checkUnits verifies that the critical systemd units inside planet are active on all nodes.
+// masters maps node addresses to whether the node is a kubernetes master
+func checkUnits(ctx context.Context, nodes []Gravity, masters map[string]bool, param KubeHealthParam) ([]Violation, error) {
+	errC := make(chan error, len(nodes))
+	valueC := make(chan interface{}, len(nodes))
+	for _, node := range nodes {
+		go func(node Gravity) {
+			units := param.Units
+			if masters[node.Node().PrivateAddr()] {
+				units = append(append([]string{}, units...), param.MasterUnits...)
+			}
+			states, err := unitStates(ctx, node, units)
+			if err != nil {
+				valueC <- nil
+				errC <- trace.Wrap(err)
+				return
+			}
+			valueC <- checkUnitStates(node.Node().PrivateAddr(), units, states)
+			errC <- nil
+		}(node)
+	}
+	values, err := utils.Collect(ctx, nil, errC, valueC)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var violations []Violation
+	for _, value := range values {
+		if value != nil {
+			violations = append(violations, value.([]Violation)...)
+		}
+	}
+	return violations, nil
+}
+
+// unitStates queries the active state of the given systemd units inside planet
+func unitStates(ctx context.Context, node Gravity, units []string) (map[string]string, error) {
+	args := []string{"show", "--property=Id", "--property=ActiveState"}
+	for _, unit := range units {
+		args = append(args, unitName(unit))
+	}
+	out, err := node.RunInPlanet(ctx, "/bin/systemctl", args...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return parseUnitStates(out)
+}
+
+// parseUnitStates parses the output of `systemctl show --property=Id --property=ActiveState`
+// into a map of unit name to its active state
+func parseUnitStates(out string) (map[string]string, error) {
+	states := make(map[string]string)
+	var id string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "Id="):
+			id = strings.TrimPrefix(line, "Id=")
+		case strings.HasPrefix(line, "ActiveState="):
+			if id == "" {
+				return nil, trace.BadParameter("unexpected systemctl output %q", out)
+			}
+			states[id] = strings.TrimPrefix(line, "ActiveState=")
+			id = ""
+		}
+	}
+	return states, trace.Wrap(scanner.Err())
+}
+
+// checkUnitStates verifies that all units are active given their states queried on node
+func checkUnitStates(node string, units []string, states map[string]string) (violations []Violation) {
+	for _, unit := range units {
+		state, ok := states[unitName(unit)]
+		if !ok {
+			state = "not found"
+		}
+		if state != unitActive {
+			violations = append(violations, Violation{
+				Kind:   ViolationUnit,
+				Object: unitName(unit),
+				Node:   node,
+				Reason: state,
+			})
+		}
+	}
+	return violations
+}
+
+// unitName returns the fully qualified systemd unit name
+func unitName(unit string) string {
+	if strings.Contains(unit, ".") {
+		return unit
+	}
+	return unit + ".service"
+}
+
+const (
+	monitoringNS = "monitoring"
+	// gravityRoleLabel is the node label gravity uses to mark the node's kubernetes role
+	gravityRoleLabel  = "gravitational.io/k8s-role"
+	gravityRoleMaster = "master"
+
+	podPhaseSucceeded = "Succeeded"
+	crashLoopBackOff  = "CrashLoopBackOff"
+	unitActive        = "active"
+
+	// defaultMaxRestarts is the default number of tolerated container restarts
+	defaultMaxRestarts = 5
+)
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckKubeNodes(t *testing.T) {
+	nodes, err := fakeKubectl(t, "testdata/kubectl-get-nodes.json", nil).Nodes(context.Background(), ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, nodes, 3)
+	assert.Equal(t, "10.138.0.72", nodes[1].InternalIP())
+	assert.True(t, nodes[2].Spec.Unschedulable)
+
+	param := KubeHealthParam{}
+	require.NoError(t, param.CheckAndSetDefaults())
+	violations := checkKubeNodes([]string{"10.138.0.71", "10.138.0.72", "10.138.0.74"}, nodes, param)
+	assert.Equal(t, []Violation{
+		{Kind: ViolationNode, Object: "10.138.0.74", Reason: "not registered with kubernetes"},
+		{Kind: ViolationNode, Object: "10.138.0.73", Reason: "not ready"},
+		{Kind: ViolationNode, Object: "10.138.0.73", Reason: "kubelet v1.16.13 differs from v1.17.9 on 10.138.0.71"},
+	}, violations)
+
+	param.KubeletVersion = "v1.17.9"
+	violations = checkKubeNodes(nil, nodes[:2], param)
+	assert.Empty(t, violations)
+
+	param.KubeletVersion = "v1.18.8"
+	violations = checkKubeNodes(nil, nodes[:1], param)
+	assert.Equal(t, []Violation{
+		{Kind: ViolationNode, Object: "10.138.0.71", Reason: "expected kubelet v1.18.8, found v1.17.9"},
+	}, violations)
+}
+
+func TestCheckPods(t *testing.T) {
+	pods, err := fakeKubectl(t, "testdata/kubectl-get-pods.json", nil).Pods(context.Background(), ListOptions{AllNamespaces: true})
+	require.NoError(t, err)
+	require.Len(t, pods, 5)
+
+	param := KubeHealthParam{}
+	require.NoError(t, param.CheckAndSetDefaults())
+	violations := checkPods(pods, param)
+	assert.Equal(t, []Violation{
+		{Kind: ViolationPod, Object: "kube-system/gravity-site-9xkzp", Node: "10.138.0.72",
+			Reason: "not ready (phase Running)"},
+		{Kind: ViolationPod, Object: "kube-system/gravity-site-9xkzp", Node: "10.138.0.72",
+			Reason: "container gravity-site is in CrashLoopBackOff"},
+		{Kind: ViolationPod, Object: "kube-system/gravity-site-9xkzp", Node: "10.138.0.72",
+			Reason: "container gravity-site restarted 12 times (> 5)", Permanent: true},
+		{Kind: ViolationPod, Object: "monitoring/grafana-6b9d8c7f4-tq2xw",
+			Reason: "not ready (phase Pending)"},
+		{Kind: ViolationPod, Object: "default/nginx-7bb7cd8db5-8dvzp", Node: "10.138.0.73",
+			Reason: "container nginx restarted 6 times (> 5)", Permanent: true},
+	}, violations)
+
+	report := KubeHealthReport{Violations: violations}
+	assert.False(t, report.OK())
+	assert.True(t, report.Permanent())
+	require.Error(t, report.Error())
+	assert.Contains(t, report.Error().Error(),
+		"pod default/nginx-7bb7cd8db5-8dvzp on 10.138.0.73: container nginx restarted 6 times (> 5)")
+
+	maxRestarts := 12
+	report = KubeHealthReport{Violations: checkPods(pods, KubeHealthParam{
+		Namespaces:  param.Namespaces,
+		MaxRestarts: &maxRestarts,
+	})}
+	assert.False(t, report.OK())
+	assert.False(t, report.Permanent())
+}
+
+func TestMaxRestartsDefaults(t *testing.T) {
+	param := KubeHealthParam{}
+	require.NoError(t, param.CheckAndSetDefaults())
+	require.NotNil(t, param.MaxRestarts)
+	assert.Equal(t, defaultMaxRestarts, *param.MaxRestarts)
+
+	noRestarts := 0
+	param = KubeHealthParam{MaxRestarts: &noRestarts}
+	require.NoError(t, param.CheckAndSetDefaults())
+	assert.Equal(t, 0, *param.MaxRestarts)
+
+	negative := -1
+	param = KubeHealthParam{MaxRestarts: &negative}
+	assert.Error(t, param.CheckAndSetDefaults())
+}
+
+func TestCheckUnitStates(t *testing.T) {
+	output := "Id=docker.service\r\nActiveState=active\r\n\r\n" +
+		"Id=etcd.service\r\nActiveState=failed\r\n\r\n" +
+		"Id=serf.service\r\nActiveState=active\r\n"
+	states, err := parseUnitStates(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"docker.service": "active",
+		"etcd.service":   "failed",
+		"serf.service":   "active",
+	}, states)
+
+	violations := checkUnitStates("10.138.0.71", []string{"docker", "etcd", "serf", "kube-proxy.service"}, states)
+	assert.Equal(t, []Violation{
+		{Kind: ViolationUnit, Object: "etcd.service", Node: "10.138.0.71", Reason: "failed"},
+		{Kind: ViolationUnit, Object: "kube-proxy.service", Node: "10.138.0.71", Reason: "not found"},
+	}, violations)
+
+	_, err = parseUnitStates("ActiveState=active")
+	assert.Error(t, err)
+}
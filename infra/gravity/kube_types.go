@@ -0,0 +1,401 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// The types below are minimal copies of the kubernetes API types,
+// limited to the fields robotest needs. See https://github.com/kubernetes/api/blob/v0.17.9/core/v1/types.go
+
+// ObjectMeta is the metadata of a kubernetes object
+type ObjectMeta struct {
+	// Name is the object name
+	Name string `json:"name"`
+	// Namespace is the object namespace, empty for cluster-scoped objects
+	Namespace string `json:"namespace,omitempty"`
+	// Labels are the object labels
+	Labels map[string]string `json:"labels,omitempty"`
+	// CreationTimestamp is the time the object was created
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+	// OwnerReferences lists the objects this object is owned by
+	OwnerReferences []OwnerReference `json:"ownerReferences,omitempty"`
+}
+
+// OwnerReference references the owner of a kubernetes object
+type OwnerReference struct {
+	// Kind is the kind of the owner, e.g. "ReplicaSet"
+	Kind string `json:"kind"`
+	// Name is the name of the owner
+	Name string `json:"name"`
+}
+
+// OwnedBy returns true if the object is owned by an object of the given kind
+func (m ObjectMeta) OwnedBy(kind string) bool {
+	for _, owner := range m.OwnerReferences {
+		if owner.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Condition describes the state of a kubernetes object at a certain point
+type Condition struct {
+	// Type is the condition type, e.g. "Ready"
+	Type string `json:"type"`
+	// Status is the condition status, one of "True", "False" or "Unknown"
+	Status string `json:"status"`
+	// Reason is a machine-readable reason for the condition's last transition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable description of the condition
+	Message string `json:"message,omitempty"`
+}
+
+// conditionTrue returns true if the condition of the given type is present and true
+func conditionTrue(conditions []Condition, conditionType string) bool {
+	for _, condition := range conditions {
+		if condition.Type == conditionType {
+			return condition.Status == "True"
+		}
+	}
+	return false
+}
+
+// KubeNode is a kubernetes node
+type KubeNode struct {
+	// Metadata is the node metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// Spec is the node specification
+	Spec KubeNodeSpec `json:"spec"`
+	// Status is the most recently observed node status
+	Status KubeNodeStatus `json:"status"`
+}
+
+// KubeNodeSpec is the kubernetes node specification
+type KubeNodeSpec struct {
+	// Unschedulable is true for cordoned nodes
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// KubeNodeStatus is the kubernetes node status
+type KubeNodeStatus struct {
+	// Conditions lists the node conditions
+	Conditions []Condition `json:"conditions"`
+	// Addresses lists the node addresses
+	Addresses []NodeAddress `json:"addresses"`
+	// NodeInfo describes the node software
+	NodeInfo NodeSystemInfo `json:"nodeInfo"`
+}
+
+// NodeAddress is an address of a kubernetes node
+type NodeAddress struct {
+	// Type is the address type, e.g. "InternalIP" or "Hostname"
+	Type string `json:"type"`
+	// Address is the address value
+	Address string `json:"address"`
+}
+
+// NodeSystemInfo describes the software running on a kubernetes node
+type NodeSystemInfo struct {
+	// KubeletVersion is the kubelet version, e.g. "v1.17.9"
+	KubeletVersion string `json:"kubeletVersion"`
+	// KubeProxyVersion is the kube-proxy version
+	KubeProxyVersion string `json:"kubeProxyVersion"`
+	// ContainerRuntimeVersion is the container runtime version, e.g. "docker://18.9.9"
+	ContainerRuntimeVersion string `json:"containerRuntimeVersion"`
+	// OSImage is the operating system reported by the node
+	OSImage string `json:"osImage"`
+	// KernelVersion is the kernel version reported by the node
+	KernelVersion string `json:"kernelVersion"`
+}
+
+// Name returns the node name
+func (n KubeNode) Name() string {
+	return n.Metadata.Name
+}
+
+// Ready returns true if the node is ready
+func (n KubeNode) Ready() bool {
+	return conditionTrue(n.Status.Conditions, "Ready")
+}
+
+// InternalIP returns the internal address of the node
+func (n KubeNode) InternalIP() string {
+	for _, addr := range n.Status.Addresses {
+		if addr.Type == "InternalIP" {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// KubePod is a kubernetes pod
+type KubePod struct {
+	// Metadata is the pod metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// Spec is the pod specification
+	Spec KubePodSpec `json:"spec"`
+	// Status is the most recently observed pod status
+	Status KubePodStatus `json:"status"`
+}
+
+// KubePodSpec is the kubernetes pod specification
+type KubePodSpec struct {
+	// NodeName is the name of the node the pod is scheduled on
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// KubePodStatus is the kubernetes pod status
+type KubePodStatus struct {
+	// Phase is the pod phase, e.g. "Running" or "Succeeded"
+	Phase string `json:"phase"`
+	// Conditions lists the pod conditions
+	Conditions []Condition `json:"conditions,omitempty"`
+	// HostIP is the address of the node the pod runs on
+	HostIP string `json:"hostIP,omitempty"`
+	// PodIP is the pod address
+	PodIP string `json:"podIP,omitempty"`
+	// ContainerStatuses lists the status of each container in the pod
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+// ContainerStatus is the status of a container in a pod
+type ContainerStatus struct {
+	// Name is the container name
+	Name string `json:"name"`
+	// Ready is true if the container passes its readiness probe
+	Ready bool `json:"ready"`
+	// RestartCount is the number of times the container has been restarted
+	RestartCount int `json:"restartCount"`
+	// State is the current container state
+	State ContainerState `json:"state"`
+}
+
+// ContainerState is the state of a container. Only one of the members is set
+type ContainerState struct {
+	// Waiting is set for a container that is not yet running
+	Waiting *ContainerStateWaiting `json:"waiting,omitempty"`
+	// Running is set for a running container
+	Running *ContainerStateRunning `json:"running,omitempty"`
+	// Terminated is set for a container that has exited
+	Terminated *ContainerStateTerminated `json:"terminated,omitempty"`
+}
+
+// ContainerStateWaiting describes a container that is not yet running
+type ContainerStateWaiting struct {
+	// Reason is the reason the container is not running, e.g. "CrashLoopBackOff"
+	Reason string `json:"reason,omitempty"`
+	// Message describes why the container is not running
+	Message string `json:"message,omitempty"`
+}
+
+// ContainerStateRunning describes a running container
+type ContainerStateRunning struct {
+	// StartedAt is the time the container was last started
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// ContainerStateTerminated describes a container that has exited
+type ContainerStateTerminated struct {
+	// ExitCode is the exit code of the container
+	ExitCode int `json:"exitCode"`
+	// Reason is the reason the container has exited
+	Reason string `json:"reason,omitempty"`
+}
+
+// Name returns the pod name
+func (p KubePod) Name() string {
+	return p.Metadata.Name
+}
+
+// Ready returns true if the pod is ready
+func (p KubePod) Ready() bool {
+	return conditionTrue(p.Status.Conditions, "Ready")
+}
+
+// KubeDeployment is a kubernetes deployment
+type KubeDeployment struct {
+	// Metadata is the deployment metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// Spec is the deployment specification
+	Spec KubeDeploymentSpec `json:"spec"`
+	// Status is the most recently observed deployment status
+	Status KubeDeploymentStatus `json:"status"`
+}
+
+// KubeDeploymentSpec is the kubernetes deployment specification
+type KubeDeploymentSpec struct {
+	// Replicas is the number of desired pods
+	Replicas int `json:"replicas"`
+}
+
+// KubeDeploymentStatus is the kubernetes deployment status
+type KubeDeploymentStatus struct {
+	// Replicas is the number of pods targeted by the deployment
+	Replicas int `json:"replicas"`
+	// UpdatedReplicas is the number of pods running the latest specification
+	UpdatedReplicas int `json:"updatedReplicas"`
+	// ReadyReplicas is the number of ready pods
+	ReadyReplicas int `json:"readyReplicas"`
+	// AvailableReplicas is the number of available pods
+	AvailableReplicas int `json:"availableReplicas"`
+	// Conditions lists the deployment conditions
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Ready returns true if all desired replicas are updated and ready
+func (d KubeDeployment) Ready() bool {
+	return d.Status.UpdatedReplicas == d.Spec.Replicas &&
+		d.Status.ReadyReplicas == d.Spec.Replicas
+}
+
+// KubeDaemonSet is a kubernetes daemon set
+type KubeDaemonSet struct {
+	// Metadata is the daemon set metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// Status is the most recently observed daemon set status
+	Status KubeDaemonSetStatus `json:"status"`
+}
+
+// KubeDaemonSetStatus is the kubernetes daemon set status
+type KubeDaemonSetStatus struct {
+	// DesiredNumberScheduled is the number of nodes that should run the daemon pod
+	DesiredNumberScheduled int `json:"desiredNumberScheduled"`
+	// CurrentNumberScheduled is the number of nodes running at least one daemon pod
+	CurrentNumberScheduled int `json:"currentNumberScheduled"`
+	// UpdatedNumberScheduled is the number of nodes running the latest daemon pod
+	UpdatedNumberScheduled int `json:"updatedNumberScheduled"`
+	// NumberReady is the number of nodes with a ready daemon pod
+	NumberReady int `json:"numberReady"`
+	// NumberAvailable is the number of nodes with an available daemon pod
+	NumberAvailable int `json:"numberAvailable"`
+}
+
+// Ready returns true if the daemon pod is updated and ready on all desired nodes
+func (d KubeDaemonSet) Ready() bool {
+	return d.Status.UpdatedNumberScheduled == d.Status.DesiredNumberScheduled &&
+		d.Status.NumberReady == d.Status.DesiredNumberScheduled
+}
+
+// KubeEvent is a kubernetes event
+type KubeEvent struct {
+	// Metadata is the event metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// InvolvedObject is the object the event is about
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	// Type is the event type, "Normal" or "Warning"
+	Type string `json:"type"`
+	// Reason is a machine-readable reason for the event, e.g. "FailedScheduling"
+	Reason string `json:"reason"`
+	// Message is a human-readable description of the event
+	Message string `json:"message"`
+	// Count is the number of times the event has occurred
+	Count int `json:"count"`
+	// FirstTimestamp is the time the event was first recorded
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	// LastTimestamp is the time the event was most recently recorded
+	LastTimestamp time.Time `json:"lastTimestamp"`
+}
+
+// ObjectReference references a kubernetes object
+type ObjectReference struct {
+	// Kind is the kind of the object, e.g. "Pod"
+	Kind string `json:"kind"`
+	// Namespace is the object namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the object name
+	Name string `json:"name"`
+}
+
+// KubePersistentVolumeClaim is a kubernetes persistent volume claim
+type KubePersistentVolumeClaim struct {
+	// Metadata is the claim metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// Spec is the claim specification
+	Spec KubePersistentVolumeClaimSpec `json:"spec"`
+	// Status is the most recently observed claim status
+	Status KubePersistentVolumeClaimStatus `json:"status"`
+}
+
+// KubePersistentVolumeClaimSpec is the kubernetes persistent volume claim specification
+type KubePersistentVolumeClaimSpec struct {
+	// StorageClassName is the name of the storage class requested by the claim
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// VolumeName is the name of the volume bound to the claim
+	VolumeName string `json:"volumeName,omitempty"`
+}
+
+// KubePersistentVolumeClaimStatus is the kubernetes persistent volume claim status
+type KubePersistentVolumeClaimStatus struct {
+	// Phase is the claim phase, e.g. "Pending" or "Bound"
+	Phase string `json:"phase"`
+}
+
+// Bound returns true if the claim is bound to a volume
+func (c KubePersistentVolumeClaim) Bound() bool {
+	return c.Status.Phase == "Bound"
+}
+
+// KubeService is a kubernetes service
+type KubeService struct {
+	// Metadata is the service metadata
+	Metadata ObjectMeta `json:"metadata"`
+	// Spec is the service specification
+	Spec KubeServiceSpec `json:"spec"`
+}
+
+// KubeServiceSpec is the kubernetes service specification
+type KubeServiceSpec struct {
+	// Type is the service type, e.g. "ClusterIP" or "NodePort"
+	Type string `json:"type"`
+	// ClusterIP is the service address
+	ClusterIP string `json:"clusterIP,omitempty"`
+	// Ports lists the ports exposed by the service
+	Ports []ServicePort `json:"ports,omitempty"`
+	// Selector selects the pods the service routes traffic to
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// ServicePort is a port exposed by a kubernetes service
+type ServicePort struct {
+	// Name is the port name
+	Name string `json:"name,omitempty"`
+	// Protocol is the port protocol, e.g. "TCP"
+	Protocol string `json:"protocol"`
+	// Port is the port exposed by the service
+	Port int `json:"port"`
+	// NodePort is the port exposed on every node for NodePort and LoadBalancer services
+	NodePort int `json:"nodePort,omitempty"`
+}
+
+// decodeKubeJSON decodes the JSON output of kubectl into out.
+// As commands inside planet run with a terminal attached, the output
+// might be prefixed with warnings printed to stderr, which are skipped
+func decodeKubeJSON(output string, out interface{}) error {
+	start := strings.Index(output, "{")
+	if start == -1 {
+		return trace.BadParameter("expected JSON output, got %q", output)
+	}
+	err := json.NewDecoder(strings.NewReader(output[start:])).Decode(out)
+	return trace.Wrap(err, "failed to decode %q", output)
+}
@@ -18,57 +18,145 @@ package gravity
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gravitational/robotest/lib/wait"
 
 	"github.com/gravitational/trace"
 )
 
-type Pod struct {
-	Name   string
-	Ready  bool
-	NodeIP string
-}
-
 const (
 	kubeSystemNS    = "kube-system"
 	appGravityLabel = "app=gravity-site"
 )
 
-func KubectlGetPods(ctx context.Context, g Gravity, namespace, label string) ([]Pod, error) {
-	args := []string{
-		"get", "pods", "-n", namespace,
-		`-ojsonpath='{range .items[*]}{.metadata.name},{.status.conditions[?(@.type=="Ready")].status},{.status.hostIP}{"\n"}{end}'`,
+// Kubectl queries kubernetes by running kubectl inside planet on a cluster node
+type Kubectl struct {
+	// run executes kubectl with the given arguments and returns its output
+	run func(ctx context.Context, args ...string) (string, error)
+}
+
+// NewKubectl returns a kubectl client that runs commands on the given node
+func NewKubectl(node Gravity) *Kubectl {
+	return &Kubectl{
+		run: func(ctx context.Context, args ...string) (string, error) {
+			return node.RunInPlanet(ctx, "/usr/bin/kubectl", args...)
+		},
 	}
-	if label != "" {
-		args = append(args, "-l", label)
+}
+
+// ListOptions selects kubernetes objects to list
+type ListOptions struct {
+	// Namespace limits the objects to the namespace.
+	// If empty, the default namespace is used
+	Namespace string
+	// AllNamespaces lists objects in all namespaces
+	AllNamespaces bool
+	// LabelSelector filters objects by label, e.g. "app=gravity-site"
+	LabelSelector string
+	// FieldSelector filters objects by field, e.g. "spec.nodeName=10.0.0.1"
+	FieldSelector string
+}
+
+// args returns the kubectl arguments for these options
+func (o ListOptions) args() (args []string) {
+	if o.AllNamespaces {
+		args = append(args, "--all-namespaces")
+	} else if o.Namespace != "" {
+		args = append(args, "--namespace", o.Namespace)
+	}
+	if o.LabelSelector != "" {
+		args = append(args, "--selector", o.LabelSelector)
 	}
-	out, err := g.RunInPlanet(ctx, "/usr/bin/kubectl", args...)
+	if o.FieldSelector != "" {
+		args = append(args, "--field-selector", o.FieldSelector)
+	}
+	return args
+}
 
-	if err != nil {
+// Nodes lists kubernetes nodes
+func (k *Kubectl) Nodes(ctx context.Context, opts ListOptions) ([]KubeNode, error) {
+	var list struct {
+		Items []KubeNode `json:"items"`
+	}
+	opts.Namespace, opts.AllNamespaces = "", false
+	if err := k.get(ctx, "nodes", opts, &list); err != nil {
 		return nil, trace.Wrap(err)
 	}
+	return list.Items, nil
+}
 
-	pods := []Pod{}
+// Pods lists kubernetes pods
+func (k *Kubectl) Pods(ctx context.Context, opts ListOptions) ([]KubePod, error) {
+	var list struct {
+		Items []KubePod `json:"items"`
+	}
+	if err := k.get(ctx, "pods", opts, &list); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
+}
 
-	for _, line := range strings.Split(out, "\n") {
-		v := strings.Split(line, ",")
-		if line == "" {
-			continue
-		}
-		if len(v) != 3 {
-			return nil, trace.Errorf("unexpected string %q", line)
-		}
+// Deployments lists kubernetes deployments
+func (k *Kubectl) Deployments(ctx context.Context, opts ListOptions) ([]KubeDeployment, error) {
+	var list struct {
+		Items []KubeDeployment `json:"items"`
+	}
+	if err := k.get(ctx, "deployments", opts, &list); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
+}
 
-		pods = append(pods, Pod{Name: v[0], Ready: v[1] == "True", NodeIP: v[2]})
+// DaemonSets lists kubernetes daemon sets
+func (k *Kubectl) DaemonSets(ctx context.Context, opts ListOptions) ([]KubeDaemonSet, error) {
+	var list struct {
+		Items []KubeDaemonSet `json:"items"`
+	}
+	if err := k.get(ctx, "daemonsets", opts, &list); err != nil {
+		return nil, trace.Wrap(err)
 	}
+	return list.Items, nil
+}
 
-	return pods, nil
+// Events lists kubernetes events
+func (k *Kubectl) Events(ctx context.Context, opts ListOptions) ([]KubeEvent, error) {
+	var list struct {
+		Items []KubeEvent `json:"items"`
+	}
+	if err := k.get(ctx, "events", opts, &list); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
 }
 
-func KubectlDeletePod(ctx context.Context, g Gravity, namespace, pod string) error {
-	out, err := g.RunInPlanet(ctx, "/usr/bin/kubectl", "delete", "po", "-n", namespace, pod)
+// PersistentVolumeClaims lists kubernetes persistent volume claims
+func (k *Kubectl) PersistentVolumeClaims(ctx context.Context, opts ListOptions) ([]KubePersistentVolumeClaim, error) {
+	var list struct {
+		Items []KubePersistentVolumeClaim `json:"items"`
+	}
+	if err := k.get(ctx, "persistentvolumeclaims", opts, &list); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
+}
+
+// Services lists kubernetes services
+func (k *Kubectl) Services(ctx context.Context, opts ListOptions) ([]KubeService, error) {
+	var list struct {
+		Items []KubeService `json:"items"`
+	}
+	if err := k.get(ctx, "services", opts, &list); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
+}
+
+// DeletePod deletes the pod and waits for it to disappear
+func (k *Kubectl) DeletePod(ctx context.Context, namespace, name string) error {
+	out, err := k.run(ctx, "delete", "pod", "--namespace", namespace, name)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -80,19 +168,131 @@ func KubectlDeletePod(ctx context.Context, g Gravity, namespace, pod string) err
 
 	// wait for the pod to disappear
 	err = wait.Retry(ctx, func() error {
-		pods, err := KubectlGetPods(ctx, g, namespace, "")
+		pods, err := k.Pods(ctx, ListOptions{
+			Namespace:     namespace,
+			FieldSelector: fmt.Sprintf("metadata.name=%v", name),
+		})
 		if err != nil {
 			return wait.Abort(err)
 		}
-
-		for _, p := range pods {
-			if p.Name == pod {
-				return wait.Continue("pod is still present")
-			}
+		if len(pods) != 0 {
+			return wait.Continue("pod is still present")
 		}
-
 		return nil
 	})
 
 	return trace.Wrap(err)
 }
+
+// WaitParam describes the condition to wait for with kubectl wait
+type WaitParam struct {
+	// Resource is the kind of objects to wait for, e.g. "pods" or "deployment/coredns"
+	Resource string
+	// Condition is the condition to wait for, e.g. "Ready" or "Available"
+	Condition string
+	// ListOptions selects the objects to wait for
+	ListOptions
+	// Timeout is the maximum time to wait
+	Timeout time.Duration
+}
+
+// Wait blocks until the selected objects satisfy the condition or the timeout expires
+func (k *Kubectl) Wait(ctx context.Context, param WaitParam) error {
+	if param.Resource == "" || param.Condition == "" {
+		return trace.BadParameter("resource and condition are required")
+	}
+	args := []string{"wait", param.Resource, fmt.Sprintf("--for=condition=%v", param.Condition)}
+	args = append(args, param.ListOptions.args()...)
+	if param.LabelSelector == "" && !strings.Contains(param.Resource, "/") {
+		args = append(args, "--all")
+	}
+	if param.Timeout != 0 {
+		args = append(args, fmt.Sprintf("--timeout=%v", param.Timeout))
+	}
+	out, err := k.run(ctx, args...)
+	if err != nil {
+		return trace.Wrap(err, "failed to wait for %v: %v", param.Resource, out)
+	}
+	return nil
+}
+
+// ApplyFile applies the manifest at path inside planet.
+// Returns references to the objects created or updated by the manifest
+func (k *Kubectl) ApplyFile(ctx context.Context, path string) ([]ObjectReference, error) {
+	out, err := k.run(ctx, "apply", "--filename", path, "--output=json")
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to apply %v: %v", path, out)
+	}
+	objects, err := appliedObjects(out)
+	return objects, trace.Wrap(err)
+}
+
+// DeleteFile deletes the objects described by the manifest at path inside planet
+// and waits for them to disappear. Objects that do not exist are ignored
+func (k *Kubectl) DeleteFile(ctx context.Context, path string) error {
+	out, err := k.run(ctx, "delete", "--filename", path, "--ignore-not-found", "--wait")
+	if err != nil {
+		return trace.Wrap(err, "failed to delete %v: %v", path, out)
+	}
+	return nil
+}
+
+// RolloutStatus blocks until the rollout of the given deployment, stateful set
+// or daemon set completes or the timeout expires
+func (k *Kubectl) RolloutStatus(ctx context.Context, object ObjectReference, timeout time.Duration) error {
+	args := []string{"rollout", "status", fmt.Sprintf("%v/%v", strings.ToLower(object.Kind), object.Name)}
+	if object.Namespace != "" {
+		args = append(args, "--namespace", object.Namespace)
+	}
+	if timeout != 0 {
+		args = append(args, fmt.Sprintf("--timeout=%v", timeout))
+	}
+	out, err := k.run(ctx, args...)
+	if err != nil {
+		return trace.Wrap(err, "rollout of %v/%v failed: %v", object.Kind, object.Name, out)
+	}
+	return nil
+}
+
+// appliedObjects returns references to the objects in the output of `kubectl apply --output=json`,
+// which is either a single object or a list for manifests with multiple objects
+func appliedObjects(output string) (objects []ObjectReference, err error) {
+	type object struct {
+		Kind     string     `json:"kind"`
+		Metadata ObjectMeta `json:"metadata"`
+	}
+	var applied struct {
+		object
+		Items []object `json:"items"`
+	}
+	if err := decodeKubeJSON(output, &applied); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	items := applied.Items
+	if applied.Kind != "List" {
+		items = []object{applied.object}
+	}
+	for _, item := range items {
+		objects = append(objects, ObjectReference{
+			Kind:      item.Kind,
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+		})
+	}
+	return objects, nil
+}
+
+// get lists objects of the given resource into out
+func (k *Kubectl) get(ctx context.Context, resource string, opts ListOptions, out interface{}) error {
+	args := append([]string{"get", resource, "--output=json"}, opts.args()...)
+	output, err := k.run(ctx, args...)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(decodeKubeJSON(output, out))
+}
+
+// KubectlDeletePod deletes the pod using kubectl on node g and waits for it to disappear
+func KubectlDeletePod(ctx context.Context, g Gravity, namespace, pod string) error {
+	return trace.Wrap(NewKubectl(g).DeletePod(ctx, namespace, pod))
+}
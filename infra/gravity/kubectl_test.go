@@ -0,0 +1,157 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKubectl returns a kubectl client that replies with the contents of file
+// and records the arguments of every invocation
+func fakeKubectl(t *testing.T, file string, calls *[]string) *Kubectl {
+	var output string
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		// kubectl warnings precede the JSON document when run with a terminal
+		output = "Warning: kubectl is older than the server\r\n" + string(data)
+	}
+	return &Kubectl{
+		run: func(ctx context.Context, args ...string) (string, error) {
+			if calls != nil {
+				*calls = append(*calls, strings.Join(args, " "))
+			}
+			return output, nil
+		},
+	}
+}
+
+func TestKubectlList(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+
+	nodes, err := fakeKubectl(t, "testdata/kubectl-get-nodes.json", &calls).
+		Nodes(ctx, ListOptions{Namespace: "ignored", LabelSelector: "gravitational.io/k8s-role=master"})
+	require.NoError(t, err)
+	require.Len(t, nodes, 3)
+	assert.Equal(t, "v1.17.9", nodes[0].Status.NodeInfo.KubeletVersion)
+
+	pods, err := fakeKubectl(t, "testdata/kubectl-get-gravity-site.json", &calls).
+		Pods(ctx, ListOptions{Namespace: kubeSystemNS, LabelSelector: appGravityLabel, FieldSelector: "spec.nodeName=10.138.0.72"})
+	require.NoError(t, err)
+	require.Len(t, pods, 2)
+	assert.Equal(t, "gravity-site-r8w2m", clusterMasterPod(pods).Name())
+	assert.Equal(t, "10.138.0.72", clusterMasterPod(pods).Status.HostIP)
+	assert.Nil(t, clusterMasterPod(pods[:1]))
+
+	deployments, err := fakeKubectl(t, "testdata/kubectl-get-deployments.json", &calls).
+		Deployments(ctx, ListOptions{Namespace: "monitoring"})
+	require.NoError(t, err)
+	require.Len(t, deployments, 2)
+	assert.True(t, deployments[0].Ready())
+	assert.False(t, deployments[1].Ready())
+
+	daemonSets, err := fakeKubectl(t, "testdata/kubectl-get-daemonsets.json", &calls).
+		DaemonSets(ctx, ListOptions{AllNamespaces: true, Namespace: "ignored"})
+	require.NoError(t, err)
+	require.Len(t, daemonSets, 2)
+	assert.True(t, daemonSets[0].Ready())
+	assert.False(t, daemonSets[1].Ready())
+
+	events, err := fakeKubectl(t, "testdata/kubectl-get-events.json", &calls).
+		Events(ctx, ListOptions{AllNamespaces: true, FieldSelector: "type=Warning"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "FailedScheduling", events[0].Reason)
+	assert.Equal(t, ObjectReference{Kind: "Pod", Namespace: "monitoring", Name: "grafana-6b9d8c7f4-tq2xw"},
+		events[0].InvolvedObject)
+	assert.Equal(t, 14, events[0].Count)
+
+	claims, err := fakeKubectl(t, "testdata/kubectl-get-pvcs.json", &calls).
+		PersistentVolumeClaims(ctx, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, claims, 2)
+	assert.True(t, claims[0].Bound())
+	assert.False(t, claims[1].Bound())
+	assert.Equal(t, "openebs-hostpath", claims[1].Spec.StorageClassName)
+
+	services, err := fakeKubectl(t, "testdata/kubectl-get-services.json", &calls).
+		Services(ctx, ListOptions{Namespace: kubeSystemNS})
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, []ServicePort{
+		{Name: "web", Protocol: "TCP", Port: 3009, NodePort: 32009},
+		{Name: "agents", Protocol: "TCP", Port: 3007, NodePort: 32007},
+	}, services[0].Spec.Ports)
+
+	assert.Equal(t, []string{
+		"get nodes --output=json --selector gravitational.io/k8s-role=master",
+		"get pods --output=json --namespace kube-system --selector app=gravity-site --field-selector spec.nodeName=10.138.0.72",
+		"get deployments --output=json --namespace monitoring",
+		"get daemonsets --output=json --all-namespaces",
+		"get events --output=json --all-namespaces --field-selector type=Warning",
+		"get persistentvolumeclaims --output=json",
+		"get services --output=json --namespace kube-system",
+	}, calls)
+}
+
+func TestKubectlUnexpectedOutput(t *testing.T) {
+	kubectl := &Kubectl{
+		run: func(ctx context.Context, args ...string) (string, error) {
+			return "The connection to the server localhost:8080 was refused", nil
+		},
+	}
+	_, err := kubectl.Pods(context.Background(), ListOptions{})
+	assert.Error(t, err)
+}
+
+func TestKubectlWait(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	kubectl := fakeKubectl(t, "", &calls)
+
+	require.NoError(t, kubectl.Wait(ctx, WaitParam{
+		Resource:    "pods",
+		Condition:   "Ready",
+		ListOptions: ListOptions{Namespace: kubeSystemNS, LabelSelector: appGravityLabel},
+		Timeout:     time.Minute,
+	}))
+	require.NoError(t, kubectl.Wait(ctx, WaitParam{
+		Resource:    "nodes",
+		Condition:   "Ready",
+		ListOptions: ListOptions{},
+	}))
+	require.NoError(t, kubectl.Wait(ctx, WaitParam{
+		Resource:    "deployment/grafana",
+		Condition:   "Available",
+		ListOptions: ListOptions{Namespace: "monitoring"},
+	}))
+	assert.Error(t, kubectl.Wait(ctx, WaitParam{Resource: "pods"}))
+
+	assert.Equal(t, []string{
+		"wait pods --for=condition=Ready --namespace kube-system --selector app=gravity-site --timeout=1m0s",
+		"wait nodes --for=condition=Ready --all",
+		"wait deployment/grafana --for=condition=Available --namespace monitoring",
+	}, calls)
+}
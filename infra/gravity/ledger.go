@@ -0,0 +1,142 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// ResourceAllocated is the ledger event for allocated cloud resources
+	ResourceAllocated = "allocated"
+	// ResourceDestroyed is the ledger event for destroyed cloud resources
+	ResourceDestroyed = "destroyed"
+)
+
+// ResourceRecord is an entry in the resource ledger.
+// The ledger is an append-only journal of cloud resource allocations and
+// destructions used to find and clean up leaked resources
+type ResourceRecord struct {
+	// Event is either ResourceAllocated or ResourceDestroyed
+	Event string `json:"event"`
+	// Tag is the unique tag the resources have been allocated with
+	Tag string `json:"tag"`
+	// Provider is the cloud provider the resources have been allocated with
+	Provider string `json:"provider"`
+	// Region is the cloud region the resources have been allocated in
+	Region string `json:"region,omitempty"`
+	// StateDir is the terraform state directory of the resources
+	StateDir string `json:"state_dir"`
+	// Time is the time of the event
+	Time time.Time `json:"time"`
+}
+
+// appendLedger appends the record to the ledger file at path.
+// No-op if path is empty
+func appendLedger(path string, record ResourceRecord) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, constants.SharedReadMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return trace.ConvertSystemError(err)
+}
+
+// ReadLedger reads all records from the ledger file at path.
+// A missing ledger is treated as empty.
+// Lines with a plain tag written by the former resource list file are read
+// as allocation records without provider, see legacyRecord
+func ReadLedger(path string) (records []ResourceRecord, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = trace.ConvertSystemError(err)
+		if trace.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, trace.Wrap(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if data[0] != '{' {
+			records = append(records, legacyRecord(string(data)))
+			continue
+		}
+		var record ResourceRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, trace.BadParameter("invalid ledger record on line %v: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, trace.ConvertSystemError(scanner.Err())
+}
+
+// legacyRecord returns the allocation record for the tag listed in the former
+// plain resource list file. The provider and state directory are unknown
+func legacyRecord(tag string) ResourceRecord {
+	return ResourceRecord{Event: ResourceAllocated, Tag: tag}
+}
+
+// liveResources returns the allocation records of the resources
+// that have not been destroyed according to the ledger
+func liveResources(records []ResourceRecord) (live []ResourceRecord) {
+	allocated := make(map[string]ResourceRecord)
+	var tags []string
+	for _, record := range records {
+		switch record.Event {
+		case ResourceAllocated:
+			if _, ok := allocated[record.Tag]; !ok {
+				tags = append(tags, record.Tag)
+			}
+			allocated[record.Tag] = record
+		case ResourceDestroyed:
+			delete(allocated, record.Tag)
+		}
+	}
+	for _, tag := range tags {
+		if record, ok := allocated[tag]; ok {
+			live = append(live, record)
+			// the tag might be reused after destroy
+			delete(allocated, tag)
+		}
+	}
+	return live
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedgerTracksLiveResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.jsonl")
+
+	records, err := ReadLedger(path)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	ts := time.Date(2020, 9, 22, 22, 33, 0, 0, time.UTC)
+	for _, record := range []ResourceRecord{
+		{Event: ResourceAllocated, Tag: "a", Provider: "gce", Region: "us-west1", StateDir: "/state/a/tf", Time: ts},
+		{Event: ResourceAllocated, Tag: "b", Provider: "azure", Region: "westus", StateDir: "/state/b/tf", Time: ts.Add(time.Minute)},
+		{Event: ResourceDestroyed, Tag: "a", Provider: "gce", Region: "us-west1", StateDir: "/state/a/tf", Time: ts.Add(time.Hour)},
+		// tag reused after destroy
+		{Event: ResourceAllocated, Tag: "a", Provider: "gce", Region: "us-east1", StateDir: "/state/a/tf", Time: ts.Add(2 * time.Hour)},
+	} {
+		require.NoError(t, appendLedger(path, record))
+	}
+
+	records, err = ReadLedger(path)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, ts, records[0].Time)
+
+	live := liveResources(records)
+	require.Len(t, live, 2)
+	assert.Equal(t, "a", live[0].Tag)
+	assert.Equal(t, "us-east1", live[0].Region)
+	assert.Equal(t, "b", live[1].Tag)
+}
+
+func TestLedgerRejectsInvalidRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"event": "allocated"`+"\n"), 0644))
+
+	_, err = ReadLedger(path)
+	require.Error(t, err)
+}
+
+func TestLedgerReadsLegacyResourceList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, []byte("robotest-1\nrobotest-2\n"), 0644))
+	require.NoError(t, appendLedger(path, ResourceRecord{Event: ResourceDestroyed, Tag: "robotest-1"}))
+
+	records, err := ReadLedger(path)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, ResourceRecord{Event: ResourceAllocated, Tag: "robotest-1"}, records[0])
+
+	live := liveResources(records)
+	assert.Equal(t, []ResourceRecord{{Event: ResourceAllocated, Tag: "robotest-2"}}, live)
+}
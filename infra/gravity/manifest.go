@@ -0,0 +1,214 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"text/template"
+	"time"
+
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// Manifest describes kubernetes objects to deploy to a cluster
+type Manifest struct {
+	// URL is the location of the manifest: either a local path or
+	// a remote URL supported by sshutils.TransferFile.
+	// Mutually exclusive with Inline
+	URL string
+	// Inline is the manifest YAML. Mutually exclusive with URL
+	Inline string
+	// Values are the template values. If set, the manifest is rendered with text/template,
+	// i.e. `replicas: {{.Replicas}}`. Only local and inline manifests can be templated
+	Values interface{}
+}
+
+// CheckAndSetDefaults validates the manifest
+func (m Manifest) CheckAndSetDefaults() error {
+	if (m.URL == "") == (m.Inline == "") {
+		return trace.BadParameter("either manifest URL or inline manifest required")
+	}
+	if m.Values != nil && m.URL != "" && !isLocalURL(m.URL) {
+		return trace.BadParameter("only local or inline manifests can be templated, got %v", m.URL)
+	}
+	return nil
+}
+
+// String returns a textual representation of this manifest
+func (m Manifest) String() string {
+	if m.URL != "" {
+		return m.URL
+	}
+	return "inline manifest"
+}
+
+// Apply deploys the manifest to the cluster formed by nodes and waits for
+// the rollout of the deployments, stateful sets and daemon sets it describes
+func (c *TestContext) Apply(nodes []Gravity, manifest Manifest) error {
+	if len(nodes) == 0 {
+		return trace.BadParameter("at least one node required")
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.Rollout)
+	defer cancel()
+
+	log := c.Logger().WithField("manifest", manifest.String())
+	log.Info("Applying manifest.")
+
+	path, err := stageManifest(ctx, nodes[0], log, manifest)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	kubectl := NewKubectl(nodes[0])
+	objects, err := kubectl.ApplyFile(ctx, path)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, object := range rolloutTargets(objects) {
+		log.WithField("object", fmt.Sprintf("%v/%v", object.Kind, object.Name)).Info("Waiting for rollout.")
+		deadline, _ := ctx.Deadline()
+		if err := kubectl.RolloutStatus(ctx, object, time.Until(deadline)); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// Delete removes the objects described by the manifest from the cluster formed by nodes.
+// The manifest is expected to render identically to when it was applied
+func (c *TestContext) Delete(nodes []Gravity, manifest Manifest) error {
+	if len(nodes) == 0 {
+		return trace.BadParameter("at least one node required")
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.Rollout)
+	defer cancel()
+
+	log := c.Logger().WithField("manifest", manifest.String())
+	log.Info("Deleting manifest.")
+
+	path, err := stageManifest(ctx, nodes[0], log, manifest)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(NewKubectl(nodes[0]).DeleteFile(ctx, path))
+}
+
+// stageManifest renders the manifest if necessary and transfers it to the
+// planet share directory on node.
+// Returns the path of the manifest inside planet
+func stageManifest(ctx context.Context, node Gravity, log logrus.FieldLogger, manifest Manifest) (planetPath string, err error) {
+	if err := manifest.CheckAndSetDefaults(); err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	src := manifest.URL
+	if manifest.Inline != "" || manifest.Values != nil {
+		data := []byte(manifest.Inline)
+		if manifest.URL != "" {
+			data, err = ioutil.ReadFile(manifest.URL)
+			if err != nil {
+				return "", trace.ConvertSystemError(err)
+			}
+		}
+		if manifest.Values != nil {
+			data, err = renderManifest(manifest.String(), data, manifest.Values)
+			if err != nil {
+				return "", trace.Wrap(err)
+			}
+		}
+		src, err = writeTempManifest(data)
+		if err != nil {
+			return "", trace.Wrap(err)
+		}
+		defer os.Remove(src)
+	}
+
+	remotePath, err := sshutils.TransferFile(ctx, node.Client(), log, src, manifestStagingDir, nil)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	// Planet only sees the host filesystem through the share directory
+	name := path.Base(remotePath)
+	cmd := fmt.Sprintf("sudo mkdir -p %[1]v && sudo cp %[2]v %[1]v/%[3]v",
+		path.Join(hostPlanetShareDir, manifestShareSubdir), remotePath, name)
+	if err := sshutils.Run(ctx, node.Client(), log, cmd, nil); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return path.Join(planetShareDir, manifestShareSubdir, name), nil
+}
+
+// renderManifest renders the manifest template with the given values.
+// References to missing values are an error
+func renderManifest(name string, data []byte, values interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to parse manifest %v", name)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, trace.Wrap(err, "failed to render manifest %v", name)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTempManifest(data []byte) (path string, err error) {
+	f, err := ioutil.TempFile("", "manifest-*.yaml")
+	if err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", trace.ConvertSystemError(err)
+	}
+	return f.Name(), nil
+}
+
+// rolloutTargets returns the objects whose rollout can be waited for
+func rolloutTargets(objects []ObjectReference) (targets []ObjectReference) {
+	for _, object := range objects {
+		switch object.Kind {
+		case "Deployment", "StatefulSet", "DaemonSet":
+			targets = append(targets, object)
+		}
+	}
+	return targets
+}
+
+func isLocalURL(manifestURL string) bool {
+	u, err := url.Parse(manifestURL)
+	return err == nil && u.Scheme == ""
+}
+
+const (
+	// manifestStagingDir is the directory on the node manifests are transferred to
+	manifestStagingDir = "/tmp/robotest/manifests"
+	// hostPlanetShareDir is the host directory shared with planet
+	hostPlanetShareDir = "/var/lib/gravity/planet/share"
+	// planetShareDir is the location of hostPlanetShareDir inside planet
+	planetShareDir = "/ext/share"
+	// manifestShareSubdir is the sub-directory of the share directory manifests are copied to
+	manifestShareSubdir = "robotest"
+)
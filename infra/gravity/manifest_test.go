@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderManifest(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/manifests/nginx.yaml")
+	require.NoError(t, err)
+
+	out, err := renderManifest("nginx.yaml", data, map[string]interface{}{
+		"Replicas":     3,
+		"NodeSelector": map[string]string{"gravitational.io/k8s-role": "node"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "  replicas: 3\n")
+	assert.Contains(t, string(out), "      nodeSelector:\n        gravitational.io/k8s-role: \"node\"\n      containers:")
+
+	out, err = renderManifest("nginx.yaml", data, struct {
+		Replicas     int
+		NodeSelector map[string]string
+	}{Replicas: 1})
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "nodeSelector")
+
+	_, err = renderManifest("nginx.yaml", data, map[string]interface{}{"NodeSelector": nil})
+	assert.Error(t, err, "missing values are an error")
+}
+
+func TestManifestValidation(t *testing.T) {
+	var testCases = []struct {
+		comment  string
+		manifest Manifest
+		valid    bool
+	}{
+		{comment: "local", manifest: Manifest{URL: "testdata/manifests/nginx.yaml"}, valid: true},
+		{comment: "remote", manifest: Manifest{URL: "s3://bucket/nginx.yaml"}, valid: true},
+		{comment: "inline templated", manifest: Manifest{Inline: "replicas: {{.}}", Values: 1}, valid: true},
+		{comment: "local templated", manifest: Manifest{URL: "/tmp/nginx.yaml", Values: 1}, valid: true},
+		{comment: "remote templated", manifest: Manifest{URL: "s3://bucket/nginx.yaml", Values: 1}},
+		{comment: "empty", manifest: Manifest{}},
+		{comment: "both", manifest: Manifest{URL: "/tmp/nginx.yaml", Inline: "kind: Pod"}},
+	}
+	for _, tc := range testCases {
+		err := tc.manifest.CheckAndSetDefaults()
+		if tc.valid {
+			assert.NoError(t, err, tc.comment)
+		} else {
+			assert.Error(t, err, tc.comment)
+		}
+	}
+}
+
+func TestApplyFile(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+
+	objects, err := fakeKubectl(t, "testdata/kubectl-apply.json", &calls).ApplyFile(ctx, "/ext/share/robotest/nginx.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []ObjectReference{
+		{Kind: "Service", Namespace: "default", Name: "nginx"},
+		{Kind: "Deployment", Namespace: "default", Name: "nginx"},
+		{Kind: "StatefulSet", Namespace: "default", Name: "postgres"},
+	}, objects)
+	targets := rolloutTargets(objects)
+	assert.Equal(t, objects[1:], targets)
+
+	kubectl := fakeKubectl(t, "", &calls)
+	require.NoError(t, kubectl.RolloutStatus(ctx, targets[0], 5*time.Minute))
+	require.NoError(t, kubectl.DeleteFile(ctx, "/ext/share/robotest/nginx.yaml"))
+
+	objects, err = appliedObjects(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "probe", "namespace": "kube-system"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, []ObjectReference{{Kind: "ConfigMap", Namespace: "kube-system", Name: "probe"}}, objects)
+
+	assert.Equal(t, []string{
+		"apply --filename /ext/share/robotest/nginx.yaml --output=json",
+		"rollout status deployment/nginx --namespace default --timeout=5m0s",
+		"delete --filename /ext/share/robotest/nginx.yaml --ignore-not-found --wait",
+	}, calls)
+}
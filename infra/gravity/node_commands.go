@@ -30,6 +30,7 @@ import (
 	"github.com/gravitational/robotest/infra"
 	"github.com/gravitational/robotest/lib/constants"
 	"github.com/gravitational/robotest/lib/defaults"
+	"github.com/gravitational/robotest/lib/loc"
 	sshutils "github.com/gravitational/robotest/lib/ssh"
 	"github.com/gravitational/robotest/lib/wait"
 
@@ -80,14 +81,22 @@ type Gravity interface {
 	Upload(ctx context.Context) error
 	// Upgrade takes currently active installer (see SetInstaller) and tries to perform upgrade
 	Upgrade(ctx context.Context) error
+	// InstallerManifest describes the application of the currently active installer (see SetInstaller)
+	InstallerManifest(ctx context.Context) (*InstallerManifest, error)
+	// InstalledPackages lists the packages installed on the node
+	InstalledPackages(ctx context.Context) ([]loc.Locator, error)
 	// RunInPlanet runs specific command inside Planet container and returns its result
 	RunInPlanet(ctx context.Context, cmd string, args ...string) (string, error)
 	// Node returns underlying VM instance
 	Node() infra.Node
+	// Group returns the name of the node group the node was provisioned in
+	Group() string
 	// Offline returns true if node was previously powered off
 	Offline() bool
 	// Client returns SSH client to VM instance
 	Client() *ssh.Client
+	// ConnHistory returns the state history of the SSH connection to the node
+	ConnHistory() []sshutils.ConnEvent
 	// Will log using extended info such as current tag, node info, etc
 	Logger() logrus.FieldLogger
 }
@@ -143,11 +152,15 @@ type JoinCmd struct {
 
 type gravity struct {
 	node       infra.Node
-	ssh        *ssh.Client
+	ssh        *sshutils.Conn
 	installDir string
 	param      cloudDynamicParams
 	ts         time.Time
 	log        logrus.FieldLogger
+	// airgapped indicates that the outbound traffic of this node is blocked
+	airgapped bool
+	// disqualified indicates that the node disks failed the qualification
+	disqualified bool
 }
 
 func (g *gravity) MarshalJSON() ([]byte, error) {
@@ -157,27 +170,34 @@ func (g *gravity) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// waits for SSH to be up on node and returns client
-func sshClient(ctx context.Context, node infra.Node, log logrus.FieldLogger) (*ssh.Client, error) {
+// waits for SSH to be up on node and returns a managed connection
+// that keeps itself alive and reconnects when the node drops off
+func sshConn(ctx context.Context, node infra.Node, log logrus.FieldLogger) (*sshutils.Conn, error) {
 	ctx, cancel := context.WithTimeout(ctx, deadlineSSH)
 	defer cancel()
 
-	var client *ssh.Client
-	b := backoff.NewConstantBackOff(retrySSH)
-	err := wait.RetryWithInterval(ctx, b, func() (err error) {
-		client, err = node.Client()
-		if err == nil {
+	conn, err := sshutils.NewConn(ctx, sshutils.ConnConfig{
+		Dial: func() (*ssh.Client, error) {
+			client, err := node.Client()
+			if err != nil {
+				log.WithError(err).Debug("Waiting for SSH.")
+				return nil, trace.Wrap(err)
+			}
 			log.Debug("Connected via SSH.")
-			return nil
-		}
-
-		log.WithError(err).Debug("Waiting for SSH.")
-		return trace.Wrap(err)
-	}, log)
+			return client, nil
+		},
+		Backoff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.MaxInterval = retrySSH
+			b.MaxElapsedTime = 0
+			return b
+		},
+		FieldLogger: log,
+	})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	return client, nil
+	return conn, nil
 }
 
 func (g *gravity) Logger() logrus.FieldLogger {
@@ -194,9 +214,45 @@ func (g *gravity) Node() infra.Node {
 	return g.node
 }
 
+// Group returns the name of the node group the node was provisioned in
+// or an empty string if the provisioner does not support node groups
+func (g *gravity) Group() string {
+	return infra.NodeGroup(g.node)
+}
+
+// NodesInGroup returns the subset of nodes from the specified node group
+func NodesInGroup(nodes []Gravity, group string) (result []Gravity) {
+	for _, node := range nodes {
+		if node.Group() == group {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// disconnect closes the SSH connections to nodes
+func disconnect(nodes []Gravity) {
+	for _, node := range nodes {
+		if g, ok := node.(*gravity); ok && g.ssh != nil {
+			g.ssh.Close()
+		}
+	}
+}
+
 // Client returns SSH client to the node
 func (g *gravity) Client() *ssh.Client {
-	return g.ssh
+	if g.ssh == nil {
+		return nil
+	}
+	return g.ssh.Client()
+}
+
+// ConnHistory returns the state history of the SSH connection to the node
+func (g *gravity) ConnHistory() []sshutils.ConnEvent {
+	if g.ssh == nil {
+		return nil
+	}
+	return g.ssh.History()
 }
 
 // Install runs gravity install with params
@@ -366,6 +422,46 @@ func (g *gravity) UninstallApp(ctx context.Context) error {
 	return trace.Wrap(err, cmd)
 }
 
+// InstallerManifest describes the application of the currently active installer
+func (g *gravity) InstallerManifest(ctx context.Context) (*InstallerManifest, error) {
+	cmd := fmt.Sprintf("cd %s && ./gravity app-package", g.installDir)
+	var out string
+	err := sshutils.RunAndParse(ctx, g.Client(), g.Logger(), cmd, nil, sshutils.ParseAsString(&out))
+	if err != nil {
+		return nil, trace.Wrap(err, cmd)
+	}
+	app, err := loc.ParseLocator(strings.TrimSpace(out))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	cmd = fmt.Sprintf("cat %s", filepath.Join(g.installDir, installerManifestFile))
+	err = sshutils.RunAndParse(ctx, g.Client(), g.Logger(), cmd, nil, sshutils.ParseAsString(&out))
+	if err != nil {
+		return nil, trace.Wrap(err, cmd)
+	}
+	manifest, err := parseAppManifest([]byte(out))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if manifest.App != *app {
+		return nil, trace.BadParameter("installer manifest describes %v, but app-package reports %v",
+			manifest.App, app)
+	}
+	return manifest, nil
+}
+
+// InstalledPackages lists the packages installed on the node
+func (g *gravity) InstalledPackages(ctx context.Context) ([]loc.Locator, error) {
+	cmd := fmt.Sprintf("sudo gravity package list --system-log-file=%v", defaults.AgentLogPath)
+	var out string
+	err := sshutils.RunAndParse(ctx, g.Client(), g.Logger(), cmd, nil, sshutils.ParseAsString(&out))
+	if err != nil {
+		return nil, trace.Wrap(err, cmd)
+	}
+	return parseInstalledPackages(out)
+}
+
 // PowerOff forcibly halts a machine
 func (g *gravity) PowerOff(ctx context.Context, graceful Graceful) error {
 	var cmd string
@@ -379,13 +475,14 @@ func (g *gravity) PowerOff(ctx context.Context, graceful Graceful) error {
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	g.ssh = nil
+	// the connection is kept closed to retain its history
+	g.ssh.Close()
 	// TODO: reliably destinguish between force close of SSH control channel and command being unable to run
 	return nil
 }
 
 func (g *gravity) Offline() bool {
-	return g.ssh == nil
+	return g.ssh == nil || g.ssh.State() == sshutils.ConnClosed
 }
 
 // Reboot gracefully restarts a machine and waits for it to become available again
@@ -403,12 +500,15 @@ func (g *gravity) Reboot(ctx context.Context, graceful Graceful) error {
 	}
 
 	// TODO: reliably destinguish between force close of SSH control channel and command being unable to run
-	client, err := sshClient(ctx, g.Node(), g.Logger())
+	ctx, cancel := context.WithTimeout(ctx, deadlineSSH)
+	defer cancel()
+	err = g.ssh.Reconnect(ctx)
 	if err != nil {
 		return trace.Wrap(err, "SSH reconnect")
 	}
 
-	g.ssh = client
+	// the egress firewall does not survive the reboot
+	g.airgapped = false
 	return nil
 }
 
@@ -417,7 +517,7 @@ func (g *gravity) Reboot(ctx context.Context, graceful Graceful) error {
 // arguments to the report command.
 // Returns the local path where the report files will be stored
 func (g *gravity) CollectLogs(ctx context.Context, prefix string, args ...string) (localPath string, err error) {
-	if g.ssh == nil {
+	if g.Offline() {
 		return "", trace.AccessDenied("cannot collect logs from an offline node %v", g)
 	}
 
@@ -437,7 +537,7 @@ func (g *gravity) SetInstaller(ctx context.Context, installerURL string, subdir
 
 	log.Infof("Transfer installer %v -> %v.", installerURL, installDir)
 
-	tgz, err := sshutils.TransferFile(ctx, g.Client(), log, installerURL, installDir, g.param.env)
+	tgz, err := g.transferFile(ctx, log, installerURL, installDir)
 	if err != nil {
 		log.WithError(err).Warnf("Failed to transfer installer %v -> %v.", installerURL, installDir)
 		return trace.Wrap(err)
@@ -462,7 +562,7 @@ func (g *gravity) TransferFile(ctx context.Context, url, subdir string) error {
 
 	log.Infof("Transfer %v -> %v.", url, dir)
 
-	_, err := sshutils.TransferFile(ctx, g.Client(), log, url, dir, g.param.env)
+	_, err := g.transferFile(ctx, log, url, dir)
 	if err != nil {
 		log.WithError(err).Warnf("Failed to transfer file %v -> %v.", url, dir)
 		return trace.Wrap(err)
@@ -472,6 +572,21 @@ func (g *gravity) TransferFile(ctx context.Context, url, subdir string) error {
 	return nil
 }
 
+// transferFile transfers the file specified with fileURL into the remote directory dir.
+// Nodes that cannot download the file themselves, because they are airgapped or
+// the download has failed, get it uploaded from the runner-side file cache
+func (g *gravity) transferFile(ctx context.Context, log logrus.FieldLogger, fileURL, dir string) (path string, err error) {
+	if !g.airgapped {
+		path, err = sshutils.TransferFile(ctx, g.Client(), log, fileURL, dir, g.param.env)
+		if err == nil || trace.IsCompareFailed(err) || trace.IsBadParameter(err) {
+			return path, trace.Wrap(err)
+		}
+		log.WithError(err).Warn("Failed to download file on node, will upload it from the runner.")
+	}
+	path, err = fileCache.TransferFile(ctx, g.Client(), log, fileURL, dir, g.param.env)
+	return path, trace.Wrap(err)
+}
+
 // ExecScript will transfer and execute script provided with given args
 func (g *gravity) ExecScript(ctx context.Context, scriptUrl string, args []string) error {
 	log := g.Logger().WithFields(logrus.Fields{
@@ -479,8 +594,7 @@ func (g *gravity) ExecScript(ctx context.Context, scriptUrl string, args []strin
 
 	log.Debug("Execute.")
 
-	spath, err := sshutils.TransferFile(ctx, g.Client(), log,
-		scriptUrl, defaults.TmpDir, g.param.env)
+	spath, err := g.transferFile(ctx, log, scriptUrl, defaults.TmpDir)
 	if err != nil {
 		log.WithError(err).Error("failed to transfer script")
 		return trace.Wrap(err)
@@ -18,6 +18,7 @@ package gravity
 
 import (
 	"context"
+	"fmt"
 
 	sshutil "github.com/gravitational/robotest/lib/ssh"
 
@@ -26,16 +27,20 @@ import (
 
 func (g *gravity) streamLogs(ctx context.Context) error {
 	// journalctl lives at /usr/bin/journalctl on SUSE and /bin/journalctl on RHEL, Ubuntu, etc.
-	// Thus robotest must either rely on the journalctl from $PATH or plumb through os specific
-	// config. -- walt 2020-06
+	// The path is specified in the OS catalog
 	return trace.Wrap(sshutil.Run(ctx, g.Client(), g.Logger().WithField("source", "journalctl"),
-		"sudo journalctl --follow --output=cat", nil))
+		fmt.Sprintf("sudo %v --follow --output=cat", g.journalctl()), nil))
 }
 
 func (g *gravity) streamStartupLogs(ctx context.Context) error {
-	// journalctl lives at /usr/bin/journalctl on SUSE and /bin/journalctl on RHEL, Ubuntu, etc.
-	// Thus robotest must either rely on the journalctl from $PATH or plumb through os specific
-	// config. -- walt 2020-06
 	return trace.Wrap(sshutil.Run(ctx, g.Client(), g.Logger().WithField("source", "journalctl"),
-		"sudo journalctl --identifier=startup-script --lines=all --output=cat --follow", nil))
+		fmt.Sprintf("sudo %v --identifier=startup-script --lines=all --output=cat --follow", g.journalctl()), nil))
+}
+
+// journalctl returns the path to journalctl on the node
+func (g *gravity) journalctl() string {
+	if g.param.image.JournalctlPath == "" {
+		return "journalctl"
+	}
+	return g.param.image.JournalctlPath
 }
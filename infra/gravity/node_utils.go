@@ -49,56 +49,72 @@ func RelocateClusterMaster(ctx context.Context, g Gravity) error {
 }
 
 func doRelocate(ctx context.Context, g Gravity) error {
-	pods, err := KubectlGetPods(ctx, g, kubeSystemNS, appGravityLabel)
+	kubectl := NewKubectl(g)
+	pods, err := gravitySitePods(ctx, kubectl)
 	if err != nil {
 		return wait.Abort(trace.Wrap(err))
 	}
 
-	var master *Pod
-	for _, pod := range pods {
-		if pod.Ready {
-			master = &pod
-			break
-		}
-	}
+	master := clusterMasterPod(pods)
 	if master == nil {
-		return wait.Abort(trace.NotFound("no current cluster master: %+v", pods))
+		return wait.Abort(trace.NotFound("no current cluster master: %v", podNames(pods)))
 	}
 
-	if err = KubectlDeletePod(ctx, g, kubeSystemNS, master.Name); err != nil {
-		return wait.Abort(trace.Wrap(err, "removing pod %s", master.Name))
+	if err = kubectl.DeletePod(ctx, kubeSystemNS, master.Name()); err != nil {
+		return wait.Abort(trace.Wrap(err, "removing pod %s", master.Name()))
 	}
 
-	var newMaster *Pod
+	var newMaster *KubePod
 	// wait for relocation to complete
 	err = wait.Retry(ctx, func() error {
-		pods, err := KubectlGetPods(ctx, g, kubeSystemNS, appGravityLabel)
+		pods, err := gravitySitePods(ctx, kubectl)
 		if err != nil {
 			return wait.Abort(err)
 		}
 
-		for _, pod := range pods {
-			if pod.Ready {
-				newMaster = &pod
-				return nil
-			}
+		newMaster = clusterMasterPod(pods)
+		if newMaster == nil {
+			return wait.Continue("waiting for gravity-site master to be ready")
 		}
-
-		return wait.Continue("waiting for gravity-site master to be ready")
+		return nil
 	})
 
 	if err != nil {
 		return wait.Abort(err)
 	}
 
-	if newMaster == nil {
-		return wait.Abort(trace.Errorf("new master was not located"))
-	}
-
-	if newMaster.NodeIP == master.NodeIP {
+	if newMaster.Status.HostIP == master.Status.HostIP {
 		return wait.Continue(
-			"new master %+v was elected on same node as old %+v", newMaster, master)
+			"new master %v was elected on same node %v as old %v",
+			newMaster.Name(), newMaster.Status.HostIP, master.Name())
 	}
 
 	return nil
 }
+
+// gravitySitePods lists the gravity-site pods
+func gravitySitePods(ctx context.Context, kubectl *Kubectl) ([]KubePod, error) {
+	pods, err := kubectl.Pods(ctx, ListOptions{
+		Namespace:     kubeSystemNS,
+		LabelSelector: appGravityLabel,
+	})
+	return pods, trace.Wrap(err)
+}
+
+// clusterMasterPod returns the gravity-site pod of the current cluster master, i.e.
+// the only ready one, or nil if there is no master
+func clusterMasterPod(pods []KubePod) *KubePod {
+	for i := range pods {
+		if pods[i].Ready() {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+func podNames(pods []KubePod) (names []string) {
+	for _, pod := range pods {
+		names = append(names, pod.Name())
+	}
+	return names
+}
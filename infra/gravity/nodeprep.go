@@ -0,0 +1,39 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+
+	"github.com/gravitational/robotest/infra/nodeprep"
+
+	"github.com/gravitational/trace"
+)
+
+// prepareVM prepares the cloud VM for installation with the steps
+// for its OS and verifies the result
+func prepareVM(ctx context.Context, node *gravity, param cloudDynamicParams) error {
+	config := nodeprep.Config{
+		Provider:   param.CloudProvider,
+		Image:      param.image,
+		EtcdDevice: param.etcdDevice,
+	}
+	if devices := vmPoolDevices(param); len(devices) != 0 {
+		config.DockerDevice = devices[0]
+	}
+	return trace.Wrap(nodeprep.Prepare(ctx, node, config))
+}
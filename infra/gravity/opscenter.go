@@ -17,110 +17,59 @@ limitations under the License.
 package gravity
 
 import (
-	"bytes"
-	"fmt"
-	"os/exec"
-	"strings"
-	"text/template"
+	"context"
 	"time"
 
+	"github.com/gravitational/robotest/infra/providers/ops"
+
 	"github.com/gravitational/trace"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
-// getTeleClusterStatus will attempt to get the cluster status from an ops center
-// for now, it's just looking for the specific line of output from tele get clusters
-// but could parse the entire yaml structure if imported from gravity
-func getTeleClusterStatus(clusterName string) (string, error) {
-	out, err := exec.Command("tele", "get", "clusters", clusterName, "--format", "yaml").Output()
-	if err != nil {
-		logrus.WithError(err).Error("unable to parse tele get clusters: ", string(out))
-		return "", trace.WrapWithMessage(err, string(out))
-	}
-
-	res, err := parseClusterStatus(clusterName, out)
-	if err != nil {
-		logrus.WithError(err).Error("unable to parse tele get clusters: ", string(out))
-		return "", trace.WrapWithMessage(err, string(out))
+// opsCluster returns the ops center cluster definition to provision
+// a cluster with the specified name
+func opsCluster(cfg ProvisionerConfig, clusterName string) ops.Cluster {
+	return ops.Cluster{
+		Kind:    ops.KindCluster,
+		Version: ops.ClusterVersion,
+		Metadata: ops.ClusterMetadata{
+			Name:   clusterName,
+			Labels: map[string]string{"Name": clusterName},
+		},
+		Spec: ops.ClusterSpec{
+			App:      cfg.Ops.App,
+			Provider: "aws",
+			AWS: &ops.ClusterAWS{
+				KeyName: "ops",
+				Region:  cfg.Ops.EC2Region,
+			},
+			Nodes: []ops.ClusterNodes{{
+				Profile:      "node",
+				Count:        int(cfg.NodeCount),
+				InstanceType: "c4.2xlarge",
+			}},
+		},
 	}
-	return res, nil
-}
-
-// ClusterV2 spec is a minimal copy of the Cluster definition from the gravitational/gravity project
-// Unneeded fields have been removed, and only required fields are included in the local copy
-// https://github.com/gravitational/gravity/blob/20cfcef8d50ab403f0a9452376ccd52e145ae90c/lib/storage/cluster.go#L65
-type ClusterV2 struct {
-	// Spec contains cluster specification
-	Spec ClusterSpecV2 `json:"spec" yaml:"spec"`
 }
 
-// ClusterSpecV2 is cluster V2 specification from gravitational/gravity project
-// it is a minimal copy of only needed fields
-type ClusterSpecV2 struct {
-	// Status is a cluster status, initialized for existing clusters only
-	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+// newOpsClient returns the client to manage the clusters of the ops center
+// authenticated with the specified key
+func newOpsClient(cfg ProvisionerConfig, key string) (*ops.TeleClient, error) {
+	client, err := ops.NewTeleClient(cfg.Ops.URL, key, cfg.StateDir)
+	return client, trace.Wrap(err)
 }
 
-// parseClusterStatus will attempt to unmarshal the cluster status from tele get clusters output
-func parseClusterStatus(clusterName string, data []byte) (string, error) {
-	if len(data) == 0 {
-		return "", trace.BadParameter("missing cluster data")
-	}
-
-	if strings.Contains(string(data), fmt.Sprintf("cluster %v not found", clusterName)) {
-		return "", trace.NotFound("cluster not found")
+// acquireOpsKey leases the ops center key for a test.
+// The returned function releases the lease
+func (c ProvisionerConfig) acquireOpsKey() (key string, release func()) {
+	if c.opsKeys == nil {
+		return c.Ops.OpsKey, func() {}
 	}
-
-	cluster := ClusterV2{}
-	err := yaml.Unmarshal(data, &cluster)
-	if err != nil {
-		return "", trace.Wrap(err)
-	}
-
-	return cluster.Spec.Status, nil
-}
-
-// generateClusterConfig will generate a cluster configuration for the ops center based
-// on the built in template
-func generateClusterConfig(cfg ProvisionerConfig, clusterName string) (string, error) {
-	template, err := template.New("cluster").Parse(`
-kind: cluster
-version: v2
-metadata:
-  labels:
-    Name: {{ .ClusterName }}
-  name: {{ .ClusterName }}
-spec:
-  app: {{ .Cfg.Ops.App }}
-  aws:
-    keyName: ops
-    region: {{ .Cfg.Ops.EC2Region }}
-  nodes:
-  - profile: node
-    count: {{ .Cfg.NodeCount }}
-    instanceType: c4.2xlarge
-  provider: aws`)
-
-	if err != nil {
-		return "", trace.Wrap(err)
-	}
-	buf := &bytes.Buffer{}
-	err = template.Execute(buf, struct {
-		Cfg         ProvisionerConfig
-		ClusterName string
-	}{
-		cfg,
-		clusterName,
-	})
-	if err != nil {
-		return "", trace.Wrap(err)
-	}
-	return buf.String(), nil
+	return c.opsKeys.Acquire()
 }
 
 // DestroyOpsFn will destroy the cluster by making a request to the ops center to de-provision the cluster
-func (c ProvisionerConfig) DestroyOpsFn(tc *TestContext, clusterName string) func() error {
+func (c ProvisionerConfig) DestroyOpsFn(tc *TestContext, client *ops.TeleClient, clusterName string) func() error {
 	return func() error {
 		log := tc.Logger().WithFields(logrus.Fields{
 			"cluster": clusterName,
@@ -143,24 +92,26 @@ func (c ProvisionerConfig) DestroyOpsFn(tc *TestContext, clusterName string) fun
 
 		log.Info("destroying cluster")
 
-		out, err := exec.Command("tele", "rm", "cluster", clusterName).CombinedOutput()
+		// the test context may already be cancelled at this point
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeouts.Uninstall)
+		defer cancel()
+
+		err := client.DeleteCluster(ctx, clusterName)
 		if err != nil {
-			return err
+			return trace.Wrap(err)
 		}
-		log.Debug("tele rm result: ", string(out))
 
 		// monitor the cluster until it's gone
-		timeout := time.After(DefaultTimeouts.Uninstall)
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-timeout:
+			case <-ctx.Done():
 				return trace.LimitExceeded("clusterDestroy timeout exceeded")
 			case <-ticker.C:
 				// check provisioning status
-				status, err := getTeleClusterStatus(clusterName)
+				status, err := client.ClusterStatus(ctx, clusterName)
 				if err != nil && trace.IsNotFound(err) {
 					// de-provisioning completed
 					return nil
@@ -170,7 +121,7 @@ func (c ProvisionerConfig) DestroyOpsFn(tc *TestContext, clusterName string) fun
 				}
 
 				switch status {
-				case "uninstalling":
+				case ops.ClusterUninstalling:
 					// we're still uninstalling, just continue the loop
 				default:
 					return trace.BadParameter("unexpected cluster status: %v", status)
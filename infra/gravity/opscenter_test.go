@@ -17,46 +17,31 @@ limitations under the License.
 package gravity
 
 import (
+	"encoding/json"
 	"testing"
 
-	"github.com/gravitational/trace"
+	"github.com/gravitational/robotest/infra/providers/ops"
 )
 
-func TestParseClusterStatus(t *testing.T) {
-	t.Run("NotFound", func(t *testing.T) {
-		txt := `cluster kevin-ci.22.7 not found`
-
-		status, err := parseClusterStatus("kevin-ci.22.7", []byte(txt))
-		if !trace.IsNotFound(err) {
-			t.Error("expected not found error:", err)
-		}
-		if status != "" {
-			t.Error("expected empty status")
-		}
-	})
-
-	t.Run("Status", func(t *testing.T) {
-		txt := `kind: cluster
-metadata:
-  labels:
-    Name: kevin-ci.22.2
-  name: kevin-ci.22.2
-spec:
-  app: ci:1.0.0-ci.22
-  aws:
-    keyName: ops
-    region: us-east-2
-  nodes: null
-  provider: aws
-  status: failed
-version: v2`
-
-		status, err := parseClusterStatus("kevin-ci.22.2", []byte(txt))
-		if err != nil {
-			t.Error("unexpected error: ", err)
-		}
-		if status != "failed" {
-			t.Error("unexpected status: ", status)
-		}
-	})
+func TestOpsCluster(t *testing.T) {
+	cfg := ProvisionerConfig{
+		NodeCount: 3,
+		Ops: &ops.Config{
+			App:       "ci:1.0.0-ci.22",
+			EC2Region: "us-east-2",
+		},
+	}
+
+	cluster := opsCluster(cfg, "kevin-ci.22.2")
+	data, err := json.Marshal(cluster)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	expected := `{"kind":"cluster","version":"v2","metadata":{"name":"kevin-ci.22.2","labels":{"Name":"kevin-ci.22.2"}},` +
+		`"spec":{"app":"ci:1.0.0-ci.22","provider":"aws","aws":{"keyName":"ops","region":"us-east-2"},` +
+		`"nodes":[{"profile":"node","count":3,"instanceType":"c4.2xlarge"}]}}`
+	if string(data) != expected {
+		t.Error("unexpected cluster definition: ", string(data))
+	}
 }
@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gravitational/robotest/lib/constants"
+	"github.com/gravitational/robotest/lib/defaults"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchPreemption watches the nodes for preemption if the configuration
+// requests preemptible VMs.
+// A preempted node cancels the test which is then retried as preempted
+func (c *TestContext) watchPreemption(cfg ProvisionerConfig, nodes []*gravity) {
+	cmd := preemptionWatchCmd(cfg)
+	if cmd == "" {
+		return
+	}
+	c.Logger().Debug("Watching nodes for preemption.")
+	for _, node := range nodes {
+		go func(node *gravity) {
+			preempted := watchNode(c.monitorCtx, node.Logger(), defaults.RetryDelay, func(ctx context.Context) error {
+				client := node.Client()
+				if client == nil {
+					// the connection has been closed with the cluster
+					return errWatchStopped
+				}
+				return sshutil.Run(ctx, client, node.Logger(), cmd, nil)
+			})
+			if preempted {
+				c.markPreempted(node)
+			}
+		}(node)
+	}
+}
+
+// watchNode runs the preemption watch command with run until it completes
+// which signals the node is being preempted.
+// The watch runs on a single SSH session which ends if the node reboots or the
+// connection is re-established, so it is re-armed after delay until ctx expires.
+// Returns true if the node is being preempted
+func watchNode(ctx context.Context, logger logrus.FieldLogger, delay time.Duration, run func(context.Context) error) bool {
+	for {
+		err := run(ctx)
+		if err == nil {
+			return true
+		}
+		if err == errWatchStopped || utils.IsContextCancelledError(err) || ctx.Err() != nil {
+			return false
+		}
+		logger.WithError(err).Debug("Preemption watch interrupted, re-arming.")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// preemptionWatchCmd returns the command that waits for the preemption notice
+// in the instance metadata or an empty string if VMs are not preemptible
+func preemptionWatchCmd(cfg ProvisionerConfig) string {
+	switch {
+	case cfg.CloudProvider == constants.GCE && cfg.GCE != nil && cfg.GCE.Preemptible:
+		return gcePreemptionWatchCmd
+	}
+	return ""
+}
+
+// errWatchStopped is returned by the watch command runner to stop watching
+var errWatchStopped = errors.New("preemption watch stopped")
+
+const (
+	// gcePreemptionWatchCmd waits until the preempted flag is set
+	// https://cloud.google.com/compute/docs/instances/create-start-preemptible-instance#detecting_if_an_instance_was_preempted
+	gcePreemptionWatchCmd = `until [ "$(curl --silent --header 'Metadata-Flavor: Google' ` +
+		`'http://metadata.google.internal/computeMetadata/v1/instance/preempted?wait_for_change=true')" = TRUE ]; ` +
+		`do sleep 1; done`
+)
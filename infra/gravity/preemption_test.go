@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gravitational/robotest/infra/providers/aws"
+	"github.com/gravitational/robotest/infra/providers/gce"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchesPreemptionOnlyForPreemptibleVMs(t *testing.T) {
+	assert.Empty(t, preemptionWatchCmd(ProvisionerConfig{CloudProvider: "gce", GCE: &gce.Config{}}))
+	assert.Equal(t, gcePreemptionWatchCmd,
+		preemptionWatchCmd(ProvisionerConfig{CloudProvider: "gce", GCE: &gce.Config{Preemptible: true}}))
+	assert.Empty(t, preemptionWatchCmd(ProvisionerConfig{CloudProvider: "aws", AWS: &aws.Config{}}))
+
+	assert.Empty(t, preemptionWatchCmd(ProvisionerConfig{CloudProvider: "azure"}))
+}
+
+func TestPreemptionWatchIsRearmed(t *testing.T) {
+	var runs int
+	preempted := watchNode(context.Background(), logrus.New(), time.Millisecond, func(context.Context) error {
+		runs++
+		if runs < 3 {
+			// session dropped on reboot or reconnect
+			return trace.ConnectionProblem(nil, "connection lost")
+		}
+		return nil
+	})
+	assert.True(t, preempted)
+	assert.Equal(t, 3, runs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	preempted = watchNode(ctx, logrus.New(), time.Millisecond, func(context.Context) error {
+		cancel()
+		return trace.ConnectionProblem(nil, "connection lost")
+	})
+	assert.False(t, preempted)
+
+	runs = 0
+	preempted = watchNode(context.Background(), logrus.New(), time.Millisecond, func(context.Context) error {
+		runs++
+		return errWatchStopped
+	})
+	assert.False(t, preempted)
+	assert.Equal(t, 1, runs)
+}
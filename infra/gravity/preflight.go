@@ -0,0 +1,416 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gravitational/robotest/infra/providers/azure"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/google"
+)
+
+// PreflightTarget describes the parts of a test that are checked
+// before the test suite starts
+type PreflightTarget struct {
+	// OS is the OS of the provisioned nodes
+	OS OS
+	// URLs lists the installers and binaries transferred to the nodes
+	URLs []string
+}
+
+// PreflightParam is implemented by test parameters to have
+// their OS and installers checked in preflight
+type PreflightParam interface {
+	// PreflightTarget returns the parts of the test to check
+	PreflightTarget() PreflightTarget
+}
+
+// PreflightTarget returns the OS and installer used by the install
+func (r InstallParam) PreflightTarget() PreflightTarget {
+	return PreflightTarget{OS: r.OSFlavor, URLs: []string{r.InstallerURL}}
+}
+
+// PreflightFailure describes a failed preflight check
+type PreflightFailure struct {
+	// Check names the check
+	Check string
+	// Err is the reason of the failure
+	Err error
+}
+
+// PreflightError is the consolidated report of the failed preflight checks
+type PreflightError struct {
+	// Failures lists the failed checks
+	Failures []PreflightFailure
+}
+
+// Error returns the report of the failed checks
+func (r *PreflightError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v preflight check(s) failed:", len(r.Failures))
+	for _, failure := range r.Failures {
+		fmt.Fprintf(&b, "\n * %v: %v", failure.Check, trace.UserMessage(failure.Err))
+	}
+	return b.String()
+}
+
+// Preflight checks the provisioner configuration and its environment once
+// before any test is scheduled so that misconfiguration does not fail
+// every test after minutes of retries.
+// It returns a PreflightError with all failed checks
+func Preflight(ctx context.Context, config ProvisionerConfig, targets []PreflightTarget, logger logrus.FieldLogger) error {
+	return newPreflight(config, logger).run(ctx, targets)
+}
+
+// preflight implements the preflight checks
+type preflight struct {
+	config ProvisionerConfig
+	log    logrus.FieldLogger
+	client *http.Client
+	// awsEndpoint optionally overrides the AWS API endpoint (STS and S3)
+	awsEndpoint string
+	// azureAuthority is the Azure Active Directory endpoint
+	azureAuthority string
+	// gcsEndpoint is the Google Cloud Storage XML API endpoint
+	gcsEndpoint string
+	// lookPath searches for the executable with the given name
+	lookPath func(string) (string, error)
+	failures []PreflightFailure
+}
+
+func newPreflight(config ProvisionerConfig, logger logrus.FieldLogger) *preflight {
+	return &preflight{
+		config:         config,
+		log:            logger,
+		client:         &http.Client{Timeout: preflightTimeout},
+		azureAuthority: "https://login.microsoftonline.com",
+		gcsEndpoint:    "https://storage.googleapis.com",
+		lookPath:       exec.LookPath,
+	}
+}
+
+func (r *preflight) run(ctx context.Context, targets []PreflightTarget) error {
+	r.check("configuration", validateConfig(r.config.WithNodes(1)))
+	if r.usesTerraform() {
+		r.check("terraform", r.checkTerraform())
+		r.checkOSes(targets)
+	}
+	r.checkSSHKeys()
+	r.checkCredentials(ctx)
+	r.checkURLs(ctx, targets)
+	if len(r.failures) != 0 {
+		return &PreflightError{Failures: r.failures}
+	}
+	r.log.Info("Preflight checks passed.")
+	return nil
+}
+
+// check records the result of the named check
+func (r *preflight) check(name string, err error) {
+	log := r.log.WithField("check", name)
+	if err != nil {
+		log.WithError(err).Warn("Preflight check failed.")
+		r.failures = append(r.failures, PreflightFailure{Check: name, Err: err})
+		return
+	}
+	log.Debug("Preflight check passed.")
+}
+
+func (r *preflight) usesTerraform() bool {
+	switch r.config.CloudProvider {
+	case constants.AWS, constants.Azure, constants.GCE:
+		return true
+	}
+	return false
+}
+
+// checkTerraform verifies that the terraform binary, the script and
+// the plugins are available
+func (r *preflight) checkTerraform() error {
+	var errs []error
+	if _, err := r.lookPath("terraform"); err != nil {
+		errs = append(errs, trace.NotFound("terraform binary not found in PATH"))
+	}
+	if _, err := os.Stat(r.config.ScriptPath); err != nil {
+		errs = append(errs, trace.ConvertSystemError(err))
+	}
+	pluginDir := r.config.TerraformPluginDir
+	if pluginDir == "" {
+		pluginDir = defaultTerraformPluginDir
+	}
+	plugins, err := ioutil.ReadDir(pluginDir)
+	if err != nil {
+		errs = append(errs, trace.ConvertSystemError(err))
+	} else if len(plugins) == 0 {
+		errs = append(errs, trace.NotFound("no terraform plugins in %v", pluginDir))
+	}
+	return trace.NewAggregate(errs...)
+}
+
+// checkOSes verifies that the OS catalog describes the OS of every test
+func (r *preflight) checkOSes(targets []PreflightTarget) {
+	catalog, err := loadOSCatalog(r.config)
+	if err != nil {
+		r.check("os catalog", err)
+		return
+	}
+	seen := make(map[OS]bool)
+	for _, target := range targets {
+		if seen[target.OS] {
+			continue
+		}
+		seen[target.OS] = true
+		image, err := catalog.Lookup(r.config.CloudProvider, target.OS.String())
+		if err == nil && image.SSHUser == "" {
+			err = trace.BadParameter("OS catalog defines no SSH user for %v", image)
+		}
+		r.check(fmt.Sprintf("os %v", target.OS), err)
+	}
+}
+
+// checkSSHKeys verifies that the SSH keys of the configured provider parse
+func (r *preflight) checkSSHKeys() {
+	for _, path := range r.sshKeyPaths() {
+		_, err := sshutil.MakePrivateKeySignerFromFile(path)
+		r.check(fmt.Sprintf("ssh key %v", path), err)
+	}
+}
+
+func (r *preflight) sshKeyPaths() (paths []string) {
+	config := r.config
+	var jumpHosts []sshutil.JumpHost
+	switch config.CloudProvider {
+	case constants.AWS:
+		paths = append(paths, config.AWS.SSHKeyPath)
+		jumpHosts = config.AWS.JumpHosts
+	case constants.Azure:
+		paths = append(paths, config.Azure.SSHKeyPath)
+		jumpHosts = config.Azure.JumpHosts
+	case constants.GCE:
+		paths = append(paths, config.GCE.SSHKeyPath)
+		jumpHosts = config.GCE.JumpHosts
+	case constants.Ops:
+		paths = append(paths, config.Ops.SSHKeyPath)
+		jumpHosts = config.Ops.JumpHosts
+	case constants.Static:
+		for _, host := range config.Static.Hosts {
+			paths = append(paths, host.SSHKeyPath)
+		}
+		jumpHosts = config.Static.JumpHosts
+	case constants.Docker:
+		paths = append(paths, config.Docker.SSHKeyPath)
+	}
+	for _, jumpHost := range jumpHosts {
+		paths = append(paths, jumpHost.SSHKeyPath)
+	}
+	return uniqueNonEmpty(paths)
+}
+
+// checkCredentials verifies the cloud credentials with a read-only request
+func (r *preflight) checkCredentials(ctx context.Context) {
+	config := r.config
+	if config.AWS != nil {
+		r.check("aws credentials", r.checkAWSCredentials(ctx, config.AWS.AccessKey, config.AWS.SecretKey, config.AWS.Region))
+	}
+	switch config.CloudProvider {
+	case constants.Ops:
+		r.check("aws credentials", r.checkAWSCredentials(ctx, config.Ops.EC2AccessKey, config.Ops.EC2SecretKey, config.Ops.EC2Region))
+	case constants.Azure:
+		r.check("azure credentials", r.checkAzureCredentials(ctx, *config.Azure))
+	case constants.GCE:
+		r.check("gce credentials", r.checkGCECredentials(ctx, config.GCE.Credentials))
+	}
+}
+
+func (r *preflight) checkAWSCredentials(ctx context.Context, accessKey, secretKey, region string) error {
+	sess, err := r.awsSession(accessKey, secretKey, region)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	return trace.Wrap(err)
+}
+
+func (r *preflight) awsSession(accessKey, secretKey, region string) (*session.Session, error) {
+	config := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, "")).
+		WithRegion(strings.Split(region, ",")[0]).
+		WithHTTPClient(r.client)
+	if r.awsEndpoint != "" {
+		config = config.WithEndpoint(r.awsEndpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSession(config)
+	return sess, trace.Wrap(err)
+}
+
+// checkAzureCredentials obtains a token for the service principal
+func (r *preflight) checkAzureCredentials(ctx context.Context, config azure.Config) error {
+	credentials := clientcredentials.Config{
+		ClientID:       config.ClientId,
+		ClientSecret:   config.ClientSecret,
+		TokenURL:       fmt.Sprintf("%v/%v/oauth2/token", r.azureAuthority, config.TenantId),
+		EndpointParams: url.Values{"resource": {"https://management.azure.com/"}},
+		AuthStyle:      oauth2.AuthStyleInParams,
+	}
+	_, err := credentials.Token(context.WithValue(ctx, oauth2.HTTPClient, r.client))
+	return trace.Wrap(err)
+}
+
+// checkGCECredentials obtains a token for the service account
+func (r *preflight) checkGCECredentials(ctx context.Context, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, r.client)
+	credentials, err := google.CredentialsFromJSON(ctx, data, "https://www.googleapis.com/auth/compute.readonly")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = credentials.TokenSource.Token()
+	return trace.Wrap(err)
+}
+
+// checkURLs verifies that the installers and binaries are reachable
+func (r *preflight) checkURLs(ctx context.Context, targets []PreflightTarget) {
+	urls := []string{r.config.InstallerURL, r.config.GravityURL}
+	for _, target := range targets {
+		urls = append(urls, target.URLs...)
+	}
+	for _, url := range uniqueNonEmpty(urls) {
+		r.check(fmt.Sprintf("url %v", url), r.checkURL(ctx, url))
+	}
+}
+
+func (r *preflight) checkURL(ctx context.Context, fileURL string) error {
+	file, err := sshutil.ParseFileURL(fileURL)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if file.URL == nil {
+		_, err := os.Stat(file.LocalPath)
+		return trace.ConvertSystemError(err)
+	}
+	u := file.URL
+	switch u.Scheme {
+	case "http", "https":
+		return r.checkHTTP(ctx, r.client, u.String())
+	case "s3":
+		return r.checkS3Object(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "gs":
+		return r.checkGCSObject(ctx, u)
+	}
+	return trace.BadParameter("unsupported URL scheme %q", u.Scheme)
+}
+
+func (r *preflight) checkHTTP(ctx context.Context, client *http.Client, fileURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fileURL, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return trace.NotFound("%v: %v", fileURL, resp.Status)
+	}
+	return nil
+}
+
+// checkGCSObject verifies that the object exists using the GCE service account
+// if configured, and anonymously otherwise
+func (r *preflight) checkGCSObject(ctx context.Context, u *url.URL) error {
+	client := r.client
+	if r.config.GCE != nil {
+		data, err := ioutil.ReadFile(r.config.GCE.Credentials)
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		ctx := context.WithValue(ctx, oauth2.HTTPClient, r.client)
+		credentials, err := google.CredentialsFromJSON(ctx, data, "https://www.googleapis.com/auth/devstorage.read_only")
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		client = oauth2.NewClient(ctx, credentials.TokenSource)
+	}
+	return r.checkHTTP(ctx, client, fmt.Sprintf("%v/%v%v", r.gcsEndpoint, u.Host, u.EscapedPath()))
+}
+
+func (r *preflight) checkS3Object(ctx context.Context, bucket, key string) error {
+	accessKey, secretKey, region := r.awsKeys()
+	if accessKey == "" {
+		return trace.BadParameter("no AWS credentials configured to access S3")
+	}
+	sess, err := r.awsSession(accessKey, secretKey, region)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	bucketRegion, err := s3manager.GetBucketRegion(ctx, sess, bucket, aws.StringValue(sess.Config.Region))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = s3.New(sess, aws.NewConfig().WithRegion(bucketRegion)).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return trace.Wrap(err)
+}
+
+// awsKeys returns the AWS credentials used to download from S3
+func (r *preflight) awsKeys() (accessKey, secretKey, region string) {
+	switch {
+	case r.config.AWS != nil:
+		return r.config.AWS.AccessKey, r.config.AWS.SecretKey, r.config.AWS.Region
+	case r.config.Ops != nil:
+		return r.config.Ops.EC2AccessKey, r.config.Ops.EC2SecretKey, r.config.Ops.EC2Region
+	}
+	return "", "", ""
+}
+
+func uniqueNonEmpty(values []string) (result []string) {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	return result
+}
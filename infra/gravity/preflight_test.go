@@ -0,0 +1,216 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/robotest/infra/providers/aws"
+	"github.com/gravitational/robotest/infra/providers/gce"
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflightPasses(t *testing.T) {
+	env := newPreflightEnv(t)
+	defer env.Close()
+
+	err := env.preflight(env.config).run(context.TODO(), []PreflightTarget{
+		{OS: OS{Vendor: "ubuntu", Version: "18"}, URLs: []string{env.URL + "/installer.tar"}},
+		{OS: OS{Vendor: "centos", Version: "7"}, URLs: []string{"s3://robotest/installer.tar"}},
+		{OS: OS{Vendor: "centos", Version: "7"}, URLs: []string{
+			"gs://robotest/installer.tar",
+			"file://" + env.config.InstallerURL,
+			env.URL + "/installer.tar#sha256=" + strings.Repeat("0", 64),
+		}},
+	})
+	require.NoError(t, err)
+}
+
+func TestPreflightReportsAllFailures(t *testing.T) {
+	env := newPreflightEnv(t)
+	defer env.Close()
+
+	config := env.config
+	gceConfig := *config.GCE
+	gceConfig.SSHKeyPath = filepath.Join(env.dir, "missing.pem")
+	config.GCE = &gceConfig
+	awsConfig := *config.AWS
+	awsConfig.AccessKey = "invalid"
+	config.AWS = &awsConfig
+	config.InstallerURL = filepath.Join(env.dir, "missing.tar")
+
+	preflight := env.preflight(config)
+	preflight.lookPath = func(string) (string, error) { return "", os.ErrNotExist }
+	err := preflight.run(context.TODO(), []PreflightTarget{
+		{OS: OS{Vendor: "windows", Version: "10"}, URLs: []string{env.URL + "/missing.tar"}},
+	})
+	require.IsType(t, &PreflightError{}, err)
+
+	var checks []string
+	for _, failure := range err.(*PreflightError).Failures {
+		checks = append(checks, failure.Check)
+	}
+	sort.Strings(checks)
+	assert.Equal(t, []string{
+		"aws credentials",
+		"os windows:10",
+		"ssh key " + gceConfig.SSHKeyPath,
+		"terraform",
+		"url " + config.InstallerURL,
+		"url " + env.URL + "/missing.tar",
+	}, checks)
+	assert.Contains(t, err.Error(), "6 preflight check(s) failed:")
+}
+
+func TestPreflightRejectsInvalidConfig(t *testing.T) {
+	env := newPreflightEnv(t)
+	defer env.Close()
+
+	config := env.config
+	config.ScriptPath = ""
+	config.CloudProvider = "unknown"
+	err := env.preflight(config).run(context.TODO(), nil)
+	require.IsType(t, &PreflightError{}, err)
+	assert.Equal(t, "configuration", err.(*PreflightError).Failures[0].Check)
+}
+
+// preflightEnv is a local stand-in for the cloud APIs and the
+// files referenced by the provisioner configuration
+type preflightEnv struct {
+	*httptest.Server
+	dir    string
+	config ProvisionerConfig
+}
+
+func newPreflightEnv(t *testing.T) *preflightEnv {
+	dir, err := ioutil.TempDir("", "robotest-preflight")
+	require.NoError(t, err)
+	env := &preflightEnv{dir: dir}
+	env.Server = httptest.NewServer(http.HandlerFunc(env.serve))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	keyPath := env.writeFile(t, "key.pem", keyPEM)
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "robotest@example.iam.gserviceaccount.com",
+		"private_key_id": "1",
+		"private_key":    string(keyPEM),
+		"token_uri":      env.URL + "/token",
+	})
+	require.NoError(t, err)
+
+	pluginDir := filepath.Join(dir, "plugins")
+	require.NoError(t, os.Mkdir(pluginDir, constants.SharedDirMask))
+	env.writeFile(t, "plugins/terraform-provider-google", nil)
+
+	env.config = ProvisionerConfig{
+		CloudProvider:      constants.GCE,
+		ScriptPath:         dir,
+		TerraformPluginDir: pluginDir,
+		InstallerURL:       env.writeFile(t, "installer.tar", nil),
+		GravityURL:         env.URL + "/gravity",
+		StateDir:           dir,
+		GCE: &gce.Config{
+			Project:          "robotest",
+			Credentials:      env.writeFile(t, "credentials.json", credentials),
+			VMType:           "n1-standard-2",
+			SSHKeyPath:       keyPath,
+			SSHPublicKeyPath: keyPath + ".pub",
+		},
+		AWS: &aws.Config{
+			AccessKey:    "access",
+			SecretKey:    "secret",
+			Region:       "us-east-1",
+			KeyPair:      "robotest",
+			VPC:          "Create new",
+			SSHUser:      "robotest",
+			DockerDevice: "/dev/xvdb",
+		},
+	}
+	return env
+}
+
+func (r *preflightEnv) preflight(config ProvisionerConfig) *preflight {
+	preflight := newPreflight(config, logrus.StandardLogger())
+	preflight.awsEndpoint = r.URL
+	preflight.gcsEndpoint = r.URL
+	preflight.lookPath = func(name string) (string, error) { return name, nil }
+	return preflight
+}
+
+func (r *preflightEnv) writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(r.dir, name)
+	require.NoError(t, ioutil.WriteFile(path, data, constants.SharedReadMask))
+	return path
+}
+
+func (r *preflightEnv) Close() {
+	r.Server.Close()
+	os.RemoveAll(r.dir)
+}
+
+func (r *preflightEnv) serve(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/token":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "token_type": "Bearer", "expires_in": 3600}`))
+	case req.URL.Path == "/" && req.Method == http.MethodPost:
+		req.ParseForm()
+		w.Header().Set("Content-Type", "text/xml")
+		if req.Form.Get("Action") != "GetCallerIdentity" || !authorizedAWS(req) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code>` +
+				`<Message>invalid token</Message></Error><RequestId>1</RequestId></ErrorResponse>`))
+			return
+		}
+		w.Write([]byte(`<GetCallerIdentityResponse><GetCallerIdentityResult><Arn>arn:aws:iam::1:user/robotest</Arn>` +
+			`<UserId>robotest</UserId><Account>1</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`))
+	case req.Method == http.MethodHead && req.URL.Path == "/robotest":
+		// S3 reports the bucket region to anonymous requests
+		w.Header().Set("X-Amz-Bucket-Region", "us-east-1")
+	case req.Method == http.MethodHead && authorizedAWS(req) && req.URL.Path == "/robotest/installer.tar",
+		req.Method == http.MethodHead && req.Header.Get("Authorization") == "Bearer token" && req.URL.Path == "/robotest/installer.tar",
+		req.Method == http.MethodHead && req.URL.Path == "/installer.tar",
+		req.Method == http.MethodHead && req.URL.Path == "/gravity":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// authorizedAWS returns true if the request is signed with the valid access key
+func authorizedAWS(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Authorization"), "Credential=access/")
+}
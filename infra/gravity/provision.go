@@ -18,18 +18,19 @@ package gravity
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/oscatalog"
+	"github.com/gravitational/robotest/infra/providers/ops"
 	"github.com/gravitational/robotest/infra/terraform"
 	"github.com/gravitational/robotest/lib/constants"
 	sshutil "github.com/gravitational/robotest/lib/ssh"
@@ -40,7 +41,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/dustin/go-humanize"
 	"github.com/gravitational/trace"
 	uuid "github.com/satori/go.uuid"
 	"github.com/sirupsen/logrus"
@@ -53,6 +53,8 @@ type cloudDynamicParams struct {
 	homeDir   string
 	terraform terraform.Config
 	env       map[string]string
+	// image describes the OS of the nodes
+	image oscatalog.Image
 }
 
 func configureVMs(baseCtx context.Context, log logrus.FieldLogger, params cloudDynamicParams, nodes []*gravity) error {
@@ -83,6 +85,10 @@ func (c *TestContext) Provision(cfg ProvisionerConfig) (cluster Cluster, err err
 
 	switch cfg.CloudProvider {
 	case constants.Azure, constants.AWS, constants.GCE:
+		if policy.ReuseVMs && len(cfg.NodeGroups) == 0 {
+			cluster, err = c.provisionPooled(cfg)
+			break
+		}
 		var config *terraform.Config
 		cluster, config, err = c.provisionCloud(cfg)
 		if err == nil && cfg.CloudProvider == constants.GCE {
@@ -90,10 +96,23 @@ func (c *TestContext) Provision(cfg ProvisionerConfig) (cluster Cluster, err err
 		}
 	case constants.Ops:
 		cluster, err = c.provisionOps(cfg)
+	case constants.Static:
+		cluster, err = c.provisionStatic(cfg)
+	case constants.Docker:
+		cluster, err = c.provisionDocker(cfg)
+	case constants.Vagrant:
+		cluster, err = c.provisionVagrant(cfg)
 	default:
 		err = trace.BadParameter("unkown cloud provider: %q", cfg.CloudProvider)
 	}
 
+	if err == nil && cfg.Airgap {
+		c.egressPeers = nil
+		for _, node := range cluster.Nodes {
+			c.egressPeers = append(c.egressPeers, node.Node().PrivateAddr())
+		}
+	}
+
 	// call `destroyFn` if provided to destroy infrastructure
 	if err != nil && cluster.Destroy != nil {
 		destroyErr := cluster.Destroy()
@@ -122,9 +141,19 @@ func (c *TestContext) provisionOps(cfg ProvisionerConfig) (cluster Cluster, err
 		return cluster, trace.Wrap(err)
 	}
 	c.Logger().Debug("logging into the ops center")
-	out, err := exec.Command("tele", "login", "-o", cfg.Ops.URL, "--key", cfg.Ops.OpsKey).CombinedOutput()
+	key, releaseKey := cfg.acquireOpsKey()
+	defer func() {
+		if err != nil {
+			releaseKey()
+		}
+	}()
+	client, err := newOpsClient(cfg, key)
 	if err != nil {
-		return cluster, trace.WrapWithMessage(err, string(out))
+		return cluster, trace.Wrap(err)
+	}
+	err = client.Login(c.Context())
+	if err != nil {
+		return cluster, trace.Wrap(err)
 	}
 
 	// generate a random cluster name
@@ -139,29 +168,34 @@ func (c *TestContext) provisionOps(cfg ProvisionerConfig) (cluster Cluster, err
 	}
 
 	c.Logger().Debug("generating ops center cluster configuration")
-	clusterPath := path.Join(cfg.StateDir, "cluster.yaml")
+	clusterPath := path.Join(cfg.StateDir, "cluster.json")
 	err = os.MkdirAll(cfg.StateDir, constants.SharedDirMask)
 	if err != nil {
 		return cluster, trace.Wrap(err)
 	}
-	defn, err := generateClusterConfig(cfg, clusterName)
+	defn := opsCluster(cfg, clusterName)
+	data, err := json.MarshalIndent(defn, "", "  ")
 	if err != nil {
 		return cluster, trace.Wrap(err)
 	}
-	err = ioutil.WriteFile(clusterPath, []byte(defn), constants.SharedReadMask)
+	err = ioutil.WriteFile(clusterPath, data, constants.SharedReadMask)
 	if err != nil {
 		return cluster, trace.Wrap(err)
 	}
 
 	// next, we need to tell the ops center to create our cluster
 	c.Logger().Debug("requesting ops center to provision our cluster")
-	out, err = exec.Command("tele", "create", clusterPath).CombinedOutput()
+	err = client.CreateCluster(c.Context(), defn)
 	if err != nil {
-		return cluster, trace.Wrap(err, string(out))
+		return cluster, trace.Wrap(err)
 	}
 
 	// destroyFn defines the clean up function that will destroy provisioned resources
-	cluster.Destroy = cfg.DestroyOpsFn(c, clusterName)
+	destroyOps := cfg.DestroyOpsFn(c, client, clusterName)
+	cluster.Destroy = func() error {
+		defer releaseKey()
+		return destroyOps()
+	}
 
 	// monitor the cluster until it's created or times out
 	timeout := time.After(cloudInitTimeout)
@@ -176,16 +210,16 @@ Loop:
 			return cluster, errors.New("clusterInitTimeout exceeded")
 		case <-ticker.C:
 			// check provisioning status
-			status, err := getTeleClusterStatus(clusterName)
+			status, err := client.ClusterStatus(c.Context(), clusterName)
 			c.Logger().WithField("status", status).Debug("provisioning status")
 			if err != nil {
 				return cluster, trace.Wrap(err)
 			}
 
 			switch status {
-			case "installing":
+			case ops.ClusterInstalling:
 				// we're still installing, just continue the loop
-			case "active":
+			case ops.ClusterActive:
 				// the cluster install completed, we can continue the install process
 				break Loop
 			default:
@@ -205,15 +239,17 @@ Loop:
 	c.Logger().Debugf("Running post provisioning tasks.")
 	err = c.postProvision(gravityNodes)
 	if err != nil {
-		return cluster, trace.Wrap(err)
-	}
-
-	err = validateDiskSpeed(c.Context(), gravityNodes, cfg.dockerDevice, c.Logger())
-	if err != nil {
+		disconnect(asNodes(gravityNodes))
 		return cluster, trace.Wrap(err)
 	}
 
 	cluster.Nodes = asNodes(gravityNodes)
+	destroy := cluster.Destroy
+	cluster.Destroy = func() error {
+		// stop keeping connections to the nodes alive
+		defer disconnect(cluster.Nodes)
+		return destroy()
+	}
 	c.Logger().Info("Provisioning complete.")
 	return cluster, nil
 }
@@ -250,8 +286,14 @@ func (c *TestContext) provisionCloud(cfg ProvisionerConfig) (cluster Cluster, co
 		log.WithError(err).Error("Some nodes failed to connect, tear down as unusable.")
 		return cluster, nil, trace.NewAggregate(err, destroyResource(infra.destroyFn))
 	}
+	defer func() {
+		if err != nil {
+			disconnect(asNodes(gravityNodes))
+		}
+	}()
 	// Start streaming logs as soon as connected
 	c.streamLogs(gravityNodes)
+	c.watchPreemption(cfg, gravityNodes)
 
 	log.Debug("Configuring VMs.")
 	err = configureVMs(ctx, c.Logger(), infra.params, gravityNodes)
@@ -266,18 +308,11 @@ func (c *TestContext) provisionCloud(cfg ProvisionerConfig) (cluster Cluster, co
 		return cluster, nil, trace.Wrap(err)
 	}
 
-	if cfg.CloudProvider == constants.Azure {
-		err = validateDiskSpeed(c.Context(), gravityNodes, cfg.dockerDevice, c.Logger())
-		if err != nil {
-			return cluster, nil, trace.Wrap(err)
-		}
-	}
-
 	log.WithField("nodes", gravityNodes).Debug("Provisioning complete.")
 
 	nodes := asNodes(gravityNodes)
 	cluster.Nodes = nodes
-	cluster.Destroy = wrapDestroyFunc(c, cfg.Tag(), nodes, infra.destroyFn)
+	cluster.Destroy = wrapDestroyFunc(c, infra.tag, nodes, infra.destroyFn, nil)
 
 	return cluster, &infra.params.terraform, nil
 }
@@ -299,7 +334,9 @@ func (c *TestContext) streamLogs(gravityNodes []*gravity) {
 						// This test has already been cancelled / has timed out
 						return
 					}
-					c.markPreempted(node)
+					// Preemption is detected by watchPreemption, this is likely
+					// a dropped SSH connection or a node reboot
+					c.Logger().WithField("node", node).Warn("Log stream interrupted.")
 				case utils.IsContextCancelledError(err):
 					// Ignore
 				default:
@@ -310,7 +347,8 @@ func (c *TestContext) streamLogs(gravityNodes []*gravity) {
 	}
 }
 
-// postProvision runs common tasks for both ops and cloud provisioners once the VMs have been setup and are running
+// postProvision runs common tasks for all provisioners once the VMs have been setup and are running:
+// it synchronizes clocks and qualifies the node disks
 func (c *TestContext) postProvision(gravityNodes []*gravity) error {
 	ctx, cancel := context.WithTimeout(c.Context(), clockSyncTimeout)
 	defer cancel()
@@ -323,7 +361,9 @@ func (c *TestContext) postProvision(gravityNodes []*gravity) error {
 	if err := sshutil.WaitTimeSync(ctx, timeNodes); err != nil {
 		return trace.Wrap(err)
 	}
-	return nil
+
+	c.Logger().Debug("qualifying disks")
+	return trace.Wrap(c.qualifyDisks(c.Context(), gravityNodes))
 }
 
 const (
@@ -363,7 +403,7 @@ func bootstrapAzure(ctx context.Context, g *gravity, param cloudDynamicParams) (
 
 	// apparently cloud-init scripts are not supported for given OS
 	err = sshutil.RunScript(ctx, g.Client(), g.Logger(),
-		filepath.Join(param.ScriptPath, "bootstrap", fmt.Sprintf("%s.sh", param.os.Vendor)),
+		filepath.Join(param.ScriptPath, param.image.BootstrapScript),
 		sshutil.SUDO)
 	return trace.Wrap(err)
 }
@@ -402,8 +442,11 @@ func (g *gravity) waitForBootstrapScript(ctx context.Context) error {
 }
 
 func connectVMs(ctx context.Context, log logrus.FieldLogger, params cloudDynamicParams, nodes []infra.Node) (out []*gravity, err error) {
-	errC := make(chan error, len(nodes))
-	nodeC := make(chan interface{}, len(nodes))
+	type result struct {
+		node *gravity
+		err  error
+	}
+	resultC := make(chan result, len(nodes))
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -411,18 +454,25 @@ func connectVMs(ctx context.Context, log logrus.FieldLogger, params cloudDynamic
 	for _, node := range nodes {
 		go func(node infra.Node) {
 			gnode, err := connectVM(ctx, log, node, params)
-			nodeC <- gnode
-			errC <- err
+			resultC <- result{node: gnode, err: err}
 		}(node)
 	}
 
-	gnodes, err := utils.Collect(ctx, cancel, errC, nodeC)
-	if err != nil {
-		return nil, trace.Wrap(err)
+	// Wait for all nodes so no connection established after a failure is leaked
+	var errs []error
+	for range nodes {
+		result := <-resultC
+		if result.err != nil {
+			errs = append(errs, result.err)
+			// the other connections are no longer needed
+			cancel()
+			continue
+		}
+		out = append(out, result.node)
 	}
-
-	for _, node := range gnodes {
-		out = append(out, node.(*gravity))
+	if len(errs) != 0 {
+		disconnect(asNodes(out))
+		return nil, trace.NewAggregate(errs...)
 	}
 
 	sort.Slice(out, func(i, j int) bool {
@@ -443,20 +493,18 @@ func connectVM(ctx context.Context, log logrus.FieldLogger, node infra.Node, par
 		}),
 	}
 
-	client, err := sshClient(ctx, g.node, g.log)
+	conn, err := sshConn(ctx, g.node, g.log)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 
-	g.ssh = client
+	g.ssh = conn
 	return g, nil
 }
 
 // ConfigureNode is used to configure a provisioned node
-// 1. wait for node to boot
-// 2. (TODO) run bootstrap scripts - as Azure doesn't support them for RHEL/CentOS, will migrate here
-// 2.  - i.e. run bootstrap commands, load installer, etc.
-// TODO: migrate bootstrap scripts here as well;
+// 1. wait for node to boot and the bootstrap script to set up SSH access
+// 2. prepare cloud VMs for installation with the OS driver and verify the result (see prepareVM)
 func configureVM(ctx context.Context, log logrus.FieldLogger, node *gravity, param cloudDynamicParams) (err error) {
 	switch param.CloudProvider {
 	case constants.AWS:
@@ -467,6 +515,12 @@ func configureVM(ctx context.Context, log logrus.FieldLogger, node *gravity, par
 		err = bootstrapCloud(ctx, node, param)
 	case constants.Ops:
 		// For ops installs the installer is not needed
+	case constants.Static:
+		// Inventory hosts are expected to be ready for use
+	case constants.Docker:
+		err = bootstrapDocker(ctx, node, param)
+	case constants.Vagrant:
+		// Nodes are provisioned by the Vagrantfile
 	default:
 		return trace.BadParameter("unsupported cloud provider %s", param.CloudProvider)
 	}
@@ -474,67 +528,10 @@ func configureVM(ctx context.Context, log logrus.FieldLogger, node *gravity, par
 		return trace.Wrap(err)
 	}
 
-	return nil
-}
-
-func validateDiskSpeed(ctx context.Context, nodes []*gravity, device string, logger logrus.FieldLogger) error {
-	logger.Debug("Ensuring disk speed is adequate across nodes.")
-	ctx, cancel := context.WithTimeout(ctx, diskWaitTimeout)
-	defer cancel()
-	err := waitDisks(ctx, nodes, []string{"/iotest", device}, logger)
-	if err != nil {
-		err = trace.Wrap(err, "VM disks did not meet performance requirements, tear down as non-usable")
-		logger.WithError(err).Error("VM disks did not meet performance requirements, tear down as non-usable.")
-		return trace.Wrap(err)
-	}
-	return nil
-}
-
-// waitDisks is a necessary workaround for Azure VMs to wait until their disk initialization processes are complete
-// otherwise it'll fail telekube pre-install checks
-func waitDisks(ctx context.Context, nodes []*gravity, paths []string, logger logrus.FieldLogger) error {
-	errs := make(chan error, len(nodes))
-
-	for _, node := range nodes {
-		go func(node *gravity) {
-			errs <- waitDisk(ctx, node, paths, minDiskSpeed, logger)
-		}(node)
+	switch param.CloudProvider {
+	case constants.AWS, constants.Azure, constants.GCE:
+		err = prepareVM(ctx, node, param)
 	}
-
-	return trace.Wrap(utils.CollectErrors(ctx, errs))
-}
-
-// waitDisk will wait specific disk performance to report OK
-func waitDisk(ctx context.Context, node *gravity, paths []string, minSpeed uint64, logger logrus.FieldLogger) error {
-	err := wait.Retry(ctx, func() error {
-		for _, path := range paths {
-			if !strings.HasPrefix(path, "/dev") {
-				defer func() {
-					errRemove := sshutil.Run(ctx, node.Client(), node.Logger(),
-						fmt.Sprintf("sudo /bin/rm -f %s", path), nil)
-					if errRemove != nil {
-						logger.Warnf("Failed to remove path: %v.", errRemove)
-					}
-				}()
-			}
-			var out string
-			err := sshutil.RunAndParse(ctx, node.Client(), node.Logger(),
-				fmt.Sprintf("sudo dd if=/dev/zero of=%s bs=100K count=1024 conv=fdatasync 2>&1", path),
-				nil, sshutil.ParseAsString(&out))
-			if err != nil {
-				return wait.Abort(trace.Wrap(err))
-			}
-			speed, err := ParseDDOutput(out)
-			if err != nil {
-				return wait.Abort(trace.Wrap(err))
-			}
-			if speed < minSpeed {
-				return wait.Continue("%s has %v/s < minimum of %v/s",
-					path, humanize.Bytes(speed), humanize.Bytes(minSpeed))
-			}
-		}
-		return nil
-	})
 	return trace.Wrap(err)
 }
 
@@ -0,0 +1,235 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/providers/static"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// provisionStatic leases nodes from the inventory of existing hosts.
+// Hosts are reset and returned to the inventory when the cluster is destroyed.
+// Hosts that fail the disk qualification are rejected, i.e. not returned
+func (c *TestContext) provisionStatic(cfg ProvisionerConfig) (cluster Cluster, err error) {
+	log := c.Logger().WithField("config", cfg)
+	log.Debug("Leasing inventory hosts.")
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	inventory := *cfg.Static
+	err = inventory.CheckAndSetDefaults()
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	params, err := makeDynamicParams(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	nodes, err := staticPools.lease(inventory, int(cfg.NodeCount))
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	var gravityNodes []*gravity
+	defer func() {
+		if err == nil {
+			return
+		}
+		// The hosts have not been touched yet, so they can be reused as-is
+		free, rejected := rejectDisqualified(nodes, gravityNodes)
+		if len(rejected) != 0 {
+			log.WithField("hosts", rejected).Warn("Rejecting inventory hosts that failed disk qualification.")
+		}
+		if errFree := staticPools.release(inventory, free); errFree != nil {
+			log.WithError(errFree).Warn("Failed to release inventory hosts.")
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(c.Context(), cloudInitTimeout)
+	defer cancel()
+
+	log.Debug("Connecting to inventory hosts.")
+	gravityNodes, err = connectVMs(ctx, c.Logger(), *params, nodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			disconnect(asNodes(gravityNodes))
+		}
+	}()
+	users := staticUsers(inventory)
+	for _, node := range gravityNodes {
+		node.param.user = users[node.Node().Addr()]
+		node.param.homeDir = userHomeDir(node.param.user)
+	}
+	c.streamLogs(gravityNodes)
+
+	err = c.postProvision(gravityNodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	log.WithField("nodes", gravityNodes).Debug("Provisioning complete.")
+
+	cluster.Nodes = asNodes(gravityNodes)
+	cluster.Destroy = wrapDestroyFunc(c, cfg.Tag(), cluster.Nodes, resetStaticFn(inventory, gravityNodes, c.Logger()), nil)
+	return cluster, nil
+}
+
+// resetStaticFn returns a function that removes gravity from the given nodes
+// and runs the inventory cleanup script.
+// Nodes that have been reset are returned to the inventory, others are kept
+// out of circulation as their state is unknown
+func resetStaticFn(inventory static.Config, nodes []*gravity, log logrus.FieldLogger) func(context.Context) error {
+	return func(ctx context.Context) error {
+		errs := make(chan error, len(nodes))
+		for _, node := range nodes {
+			go func(node *gravity) {
+				err := resetStaticNode(ctx, node, inventory.CleanupScript)
+				if err != nil {
+					log.WithError(err).WithField("node", node).Error("Failed to reset inventory host, will not reuse it.")
+					errs <- trace.Wrap(err)
+					return
+				}
+				errs <- trace.Wrap(staticPools.release(inventory, []infra.Node{node.Node()}))
+			}(node)
+		}
+		return trace.Wrap(utils.CollectErrors(ctx, errs))
+	}
+}
+
+func resetStaticNode(ctx context.Context, node *gravity, cleanupScript string) error {
+	err := sshutil.Run(ctx, node.Client(), node.Logger(), staticLeaveCmd, nil)
+	if err != nil {
+		return trace.Wrap(err, "failed to remove gravity")
+	}
+	if node.airgapped {
+		err = sshutil.Run(ctx, node.Client(), node.Logger(), egressUnlockScript(), nil)
+		if err != nil {
+			return trace.Wrap(err, "failed to remove egress firewall")
+		}
+		node.airgapped = false
+	}
+	if cleanupScript == "" {
+		return nil
+	}
+	err = sshutil.RunScript(ctx, node.Client(), node.Logger(), cleanupScript, sshutil.SUDO)
+	return trace.Wrap(err, "failed to run cleanup script %v", cleanupScript)
+}
+
+// rejectDisqualified splits the leased hosts into those that can be returned
+// to the inventory and those that failed the disk qualification.
+// Rejected hosts stay leased, so they are not used for the rest of the suite
+func rejectDisqualified(nodes []infra.Node, gravityNodes []*gravity) (free []infra.Node, rejected []string) {
+	disqualified := make(map[string]bool)
+	for _, node := range gravityNodes {
+		if node.disqualified {
+			disqualified[node.Node().Addr()] = true
+		}
+	}
+	for _, node := range nodes {
+		if disqualified[node.Addr()] {
+			rejected = append(rejected, node.Addr())
+			continue
+		}
+		free = append(free, node)
+	}
+	return free, rejected
+}
+
+// staticPools tracks the inventory hosts leased to tests.
+// Concurrent tests with the same inventory lease hosts from a shared pool
+// so no host is ever used by two tests at once
+var staticPools = &staticPoolSet{pools: make(map[string]infra.NodePool)}
+
+type staticPoolSet struct {
+	sync.Mutex
+	// pools maps inventory ID to its node pool
+	pools map[string]infra.NodePool
+}
+
+// lease allocates amount hosts from the inventory.
+// Fails if the inventory does not have as many free hosts
+func (r *staticPoolSet) lease(inventory static.Config, amount int) ([]infra.Node, error) {
+	r.Lock()
+	defer r.Unlock()
+	pool := r.pool(inventory)
+	if amount > pool.Size() {
+		return nil, trace.BadParameter("test requires %v nodes, but inventory has only %v hosts",
+			amount, pool.Size())
+	}
+	if free := pool.Size() - pool.SizeAllocated(); amount > free {
+		return nil, trace.LimitExceeded("test requires %v nodes, but only %v of %v inventory hosts are free",
+			amount, free, pool.Size())
+	}
+	nodes, err := pool.Allocate(amount)
+	return nodes, trace.Wrap(err)
+}
+
+// release returns the specified hosts to the inventory
+func (r *staticPoolSet) release(inventory static.Config, nodes []infra.Node) error {
+	r.Lock()
+	defer r.Unlock()
+	return trace.Wrap(r.pool(inventory).Free(nodes))
+}
+
+func (r *staticPoolSet) pool(inventory static.Config) infra.NodePool {
+	id := inventory.ID()
+	pool, ok := r.pools[id]
+	if !ok {
+		nodes := make([]infra.Node, 0, len(inventory.Hosts))
+		for _, host := range inventory.Hosts {
+			nodes = append(nodes, static.New(host, inventory.JumpHosts))
+		}
+		pool = infra.NewNodePool(nodes, nil)
+		r.pools[id] = pool
+	}
+	return pool
+}
+
+// staticUsers maps inventory host addresses to their SSH users
+func staticUsers(inventory static.Config) map[string]string {
+	users := make(map[string]string, len(inventory.Hosts))
+	for _, host := range inventory.Hosts {
+		users[host.Addr] = host.SSHUser
+	}
+	return users
+}
+
+// userHomeDir returns the home directory of the specified user
+func userHomeDir(user string) string {
+	if user == "root" {
+		return "/root"
+	}
+	return filepath.Join("/home", user)
+}
+
+// staticLeaveCmd removes gravity from a host if it is installed
+const staticLeaveCmd = "if command -v gravity >/dev/null; then sudo gravity leave --force; fi"
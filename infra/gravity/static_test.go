@@ -0,0 +1,94 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"testing"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/providers/static"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticPoolLeasesDistinctHosts(t *testing.T) {
+	inventory := static.Config{Hosts: []static.Host{
+		{Addr: "10.0.0.1", SSHUser: "centos", SSHKeyPath: "/keys/id_rsa"},
+		{Addr: "10.0.0.2", PrivateAddr: "192.168.0.2", SSHUser: "root", SSHKeyPath: "/keys/id_rsa"},
+		{Addr: "10.0.0.3", SSHUser: "ubuntu", SSHKeyPath: "/keys/id_rsa"},
+	}}
+	require.NoError(t, inventory.CheckAndSetDefaults())
+	assert.Equal(t, "10.0.0.1", inventory.Hosts[0].PrivateAddr)
+	pools := &staticPoolSet{pools: make(map[string]infra.NodePool)}
+
+	first, err := pools.lease(inventory, 2)
+	require.NoError(t, err)
+	second, err := pools.lease(inventory, 1)
+	require.NoError(t, err)
+	addrs := map[string]bool{}
+	for _, node := range append(first, second...) {
+		addrs[node.Addr()] = true
+	}
+	assert.Len(t, addrs, 3, "hosts are never leased twice")
+
+	_, err = pools.lease(inventory, 1)
+	assert.True(t, trace.IsLimitExceeded(err), "expected limit exceeded, got %v", err)
+	_, err = pools.lease(inventory, 4)
+	assert.True(t, trace.IsBadParameter(err), "expected bad parameter, got %v", err)
+
+	require.NoError(t, pools.release(inventory, first))
+	leased, err := pools.lease(inventory, 2)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, first, leased)
+}
+
+func TestStaticInventoryValidation(t *testing.T) {
+	var testCases = []struct {
+		comment   string
+		inventory static.Config
+	}{
+		{comment: "empty", inventory: static.Config{}},
+		{comment: "no address", inventory: static.Config{Hosts: []static.Host{{SSHUser: "centos"}}}},
+		{comment: "duplicate", inventory: static.Config{Hosts: []static.Host{{Addr: "10.0.0.1"}, {Addr: "10.0.0.1"}}}},
+	}
+	for _, tc := range testCases {
+		assert.Error(t, tc.inventory.CheckAndSetDefaults(), tc.comment)
+	}
+	assert.Equal(t, "/root", userHomeDir("root"))
+	assert.Equal(t, "/home/centos", userHomeDir("centos"))
+}
+
+func TestStaticPoolRejectsDisqualifiedHosts(t *testing.T) {
+	nodes := []infra.Node{
+		static.New(static.Host{Addr: "10.0.0.1"}, nil),
+		static.New(static.Host{Addr: "10.0.0.2"}, nil),
+	}
+	gravityNodes := []*gravity{
+		{node: nodes[0]},
+		{node: nodes[1], disqualified: true},
+	}
+	free, rejected := rejectDisqualified(nodes, gravityNodes)
+	assert.Equal(t, nodes[:1], free)
+	assert.Equal(t, []string{"10.0.0.2"}, rejected)
+
+	// hosts are returned as-is if connecting to them failed
+	free, rejected = rejectDisqualified(nodes, nil)
+	assert.Equal(t, nodes, free)
+	assert.Empty(t, rejected)
+}
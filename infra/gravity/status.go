@@ -20,10 +20,12 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/cenkalti/backoff"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/gravitational/robotest/lib/loc"
 	sshutils "github.com/gravitational/robotest/lib/ssh"
 	"github.com/gravitational/robotest/lib/utils"
 	"github.com/gravitational/robotest/lib/wait"
@@ -105,12 +107,16 @@ func parseStatus(status *GravityStatus) sshutils.OutputParseFn {
 }
 
 // GravityStatus describes the status of the Gravity cluster
+// as reported by `gravity status --output=json`
 type GravityStatus struct {
 	// Cluster describes the cluster status
 	Cluster ClusterStatus `json:"cluster"`
 }
 
 // ClusterStatus describes the status of a Gravity cluster
+//
+// The structure mirrors https://github.com/gravitational/gravity/blob/7.0.0/lib/status/status.go#L49-L116
+// with the agent status (system status and nodes) flattened in, as gravity does.
 type ClusterStatus struct {
 	// Application defines the cluster application
 	Application Application `json:"application"`
@@ -118,35 +124,215 @@ type ClusterStatus struct {
 	Cluster string `json:"domain"`
 	// State is the cluster state
 	State string `json:"state"`
+	// Reason is the optional reason the cluster is in its current state,
+	// e.g. "status_check_failed" for a degraded cluster
+	Reason string `json:"reason,omitempty"`
 	// SystemStatus is the cluster status
 	SystemStatus SystemStatus `json:"system_status"`
 	// Token is secure token which prevents rogue nodes from joining the cluster during installation
 	Token Token `json:"token"`
+	// Operation is the most recent cluster operation
+	Operation *ClusterOperation `json:"operation,omitempty"`
+	// ActiveOperations lists operations currently in progress.
+	// Only reported by Gravity 6.x+
+	ActiveOperations []ClusterOperation `json:"active_operations,omitempty"`
+	// Endpoints lists cluster and application endpoints
+	Endpoints Endpoints `json:"endpoints"`
+	// ServerVersion is the version of the gravity-site process
+	ServerVersion *Version `json:"server_version,omitempty"`
+	// ClientVersion is the version of the gravity binary used to query the status
+	ClientVersion *Version `json:"client_version,omitempty"`
 	// Nodes describes the nodes in the cluster
 	Nodes []NodeStatus `json:"nodes"`
 }
 
+// Node returns the status of the node with the given advertise address
+func (s ClusterStatus) Node(addr string) (*NodeStatus, bool) {
+	for i, node := range s.Nodes {
+		if node.Addr == addr {
+			return &s.Nodes[i], true
+		}
+	}
+	return nil, false
+}
+
+// ActiveOperation returns the operation currently in progress or nil
+// if the cluster is not running any operations
+func (s ClusterStatus) ActiveOperation() *ClusterOperation {
+	for i, op := range s.ActiveOperations {
+		if !op.IsCompleted() {
+			return &s.ActiveOperations[i]
+		}
+	}
+	// Gravity 5.x does not report active operations separately
+	if s.Operation != nil && !s.Operation.IsCompleted() {
+		return s.Operation
+	}
+	return nil
+}
+
 // Application defines the cluster application
 type Application struct {
+	// Repository is the package repository of the cluster application
+	Repository string `json:"repository"`
 	// Name is the name of the cluster application
 	Name string `json:"name"`
+	// Version is the version of the cluster application
+	Version string `json:"version"`
+}
+
+// Locator returns the package locator of the cluster application
+func (a Application) Locator() loc.Locator {
+	return *loc.NewLocator(a.Repository, a.Name, a.Version)
+}
+
+// ClusterOperation describes a cluster operation
+type ClusterOperation struct {
+	// Type is the operation type, e.g. "operation_install"
+	Type string `json:"type"`
+	// ID is the operation ID
+	ID string `json:"id"`
+	// State is the operation state
+	State string `json:"state"`
+	// Created is the time the operation was created
+	Created time.Time `json:"created"`
+	// Description is the optional human-readable operation description
+	Description string `json:"description,omitempty"`
+	// Progress is the last reported operation progress
+	Progress OperationProgress `json:"progress"`
+}
+
+// IsCompleted returns true if the operation has finished, successfully or not
+func (o ClusterOperation) IsCompleted() bool {
+	return o.State == opStatusCompleted || o.State == opStatusFailed
+}
+
+// OperationProgress describes the progress of a cluster operation
+type OperationProgress struct {
+	// Message is the last progress message
+	Message string `json:"message"`
+	// Completion is the completion percentage
+	Completion int `json:"completion"`
+	// Created is the time the progress entry was created
+	Created time.Time `json:"created"`
+}
+
+// Endpoints lists cluster and application endpoints
+type Endpoints struct {
+	// Applications lists endpoints of the cluster applications
+	Applications ApplicationsEndpoints `json:"applications"`
+	// Cluster lists the cluster endpoints
+	Cluster ClusterEndpoints `json:"cluster"`
+}
+
+// ApplicationsEndpoints lists endpoints of the cluster applications
+type ApplicationsEndpoints struct {
+	// Endpoints lists endpoints per application
+	Endpoints []ApplicationEndpoints `json:"Endpoints"`
+}
+
+// ApplicationEndpoints lists endpoints of a single application
+type ApplicationEndpoints struct {
+	// Application is the application the endpoints belong to
+	Application Application `json:"application"`
+	// Endpoints lists the application endpoints
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Endpoint describes a single application endpoint
+type Endpoint struct {
+	// Name is the endpoint name
+	Name string `json:"name"`
+	// Description is the endpoint description
+	Description string `json:"description"`
+	// Addresses lists the endpoint addresses
+	Addresses []string `json:"addresses"`
+}
+
+// ClusterEndpoints lists the cluster endpoints
+type ClusterEndpoints struct {
+	// AuthGateway lists the addresses of the cluster auth gateway
+	AuthGateway []string `json:"auth_gateway"`
+	// UI lists the addresses of the cluster control panel
+	UI []string `json:"ui"`
 }
 
+// Version describes the version of a gravity binary
+type Version struct {
+	// Edition is the gravity edition, e.g. "open-source" or "enterprise"
+	Edition string `json:"edition"`
+	// Version is the gravity version
+	Version string `json:"version"`
+	// GitCommit is the git commit gravity was built from
+	GitCommit string `json:"gitCommit"`
+	// Helm is the helm version gravity was built with
+	Helm string `json:"helm"`
+}
+
+// NodeStatus* consts come from https://github.com/gravitational/gravity/blob/7.0.0/lib/status/status.go#L470-L477
+const (
+	// NodeStatusHealthy is a node with all probes passing
+	NodeStatusHealthy = "healthy"
+	// NodeStatusDegraded is a node with failing probes
+	NodeStatusDegraded = "degraded"
+	// NodeStatusOffline is a node not reachable by the cluster
+	NodeStatusOffline = "offline"
+)
+
 // NodeStatus describes the status of a cluster node
 type NodeStatus struct {
+	// Hostname is the name of the host
+	Hostname string `json:"hostname"`
 	// Addr is the advertised address of this cluster node
 	Addr string `json:"advertise_ip"`
+	// Role is the node's cluster role, e.g. "master" or "node"
+	Role string `json:"role"`
+	// Profile is the node's profile from the application manifest
+	Profile string `json:"profile"`
+	// Status is the node health status
+	Status string `json:"status"`
+	// FailedProbes lists the probes failing on this node
+	FailedProbes []string `json:"failed_probes,omitempty"`
+	// WarnProbes lists the probes in warning state on this node.
+	// Only reported by Gravity 6.x+
+	WarnProbes []string `json:"warn_probes,omitempty"`
+	// TeleportNode describes the node as seen by teleport.
+	// Only reported by Gravity 6.x+
+	TeleportNode *TeleportNode `json:"teleport_node,omitempty"`
+}
+
+// TeleportNode describes a cluster node as seen by teleport
+type TeleportNode struct {
+	// Hostname is the name of the host
+	Hostname string `json:"hostname"`
+	// Addr is the advertised address of the node
+	Addr string `json:"advertise_ip"`
+	// PublicAddr is the public address of the node
+	PublicAddr string `json:"public_ip"`
+	// Profile is the node's profile from the application manifest
+	Profile string `json:"profile"`
+	// InstanceType is the cloud instance type of the node
+	InstanceType string `json:"instance_type"`
 }
 
 // Token describes the cluster join token
 type Token struct {
 	// Token is the join token value
 	Token string `json:"token"`
+	// Expires is the token expiration time, zero for tokens that do not expire
+	Expires time.Time `json:"expires"`
+	// Type is the token type, e.g. "expand"
+	Type string `json:"type"`
+	// AccountID is the ID of the account the token belongs to
+	AccountID string `json:"account_id"`
+	// ClusterName is the name of the cluster the token belongs to
+	ClusterName string `json:"site_domain"`
+	// OperationID is the ID of the operation the token is bound to, if any
+	OperationID string `json:"operation_id"`
+	// UserEmail is the name of the agent user associated with the token
+	UserEmail string `json:"user_email"`
 }
 
-// statusValidator returns nil if the Gravity Status is the expected status or an error otherwise.
-type statusValidator func(s GravityStatus) error
-
 // checkNotDegraded returns an error if the cluster status is Degraded.
 //
 // This function is a reimplementation of the logic in https://github.com/gravitational/gravity/blob/7.0.0/lib/status/status.go#L180-L185
@@ -174,30 +360,31 @@ func checkActive(s GravityStatus) error {
 // WaitForActiveStatus blocks until all nodes report state = Active and notDegraded or an internal timeout expires.
 func (c *TestContext) WaitForActiveStatus(nodes []Gravity) error {
 	c.Logger().WithField("nodes", Nodes(nodes)).Info("Waiting for active status.")
-	return c.WaitForStatus(nodes, checkActive)
+	return c.WaitForStatus(nodes, Active)
 }
 
-// WaitForStatus blocks until all nodes satisfy the expected statusValidator or an internal timeout expires.
-func (c *TestContext) WaitForStatus(nodes []Gravity, expected statusValidator) error {
+// WaitForStatus blocks until all nodes satisfy the expected StatusValidator or an internal timeout expires.
+func (c *TestContext) WaitForStatus(nodes []Gravity, expected StatusValidator) error {
 	b := backoff.NewExponentialBackOff()
 	b.MaxElapsedTime = c.timeouts.ClusterStatus
 
 	expectStatus := func() (err error) {
-		statuses, err := c.Status(nodes)
+		reports, err := c.StatusReports(nodes)
 		if err != nil {
 			return trace.Wrap(err)
 		}
-		for _, status := range statuses {
-			err = expected(status)
-			if err != nil {
-				c.Logger().WithError(err).WithField("status", status).Warn("Unexpected Status.")
-				return trace.Wrap(err)
-			}
+		err = expected(reports)
+		if err != nil {
+			c.Logger().WithError(err).WithField("reports", reports).Warn("Unexpected Status.")
+			return trace.Wrap(err)
 		}
 		return nil
 	}
 
 	err := wait.RetryWithInterval(c.ctx, b, expectStatus, c.Logger())
+	if err != nil && c.ctx.Err() == nil {
+		c.captureDiagnostics(c.diagnostics.next("status-wait"), nodes)
+	}
 
 	return trace.Wrap(err)
 
@@ -205,10 +392,26 @@ func (c *TestContext) WaitForStatus(nodes []Gravity, expected statusValidator) e
 
 // Status queries `gravity status` once from each node in nodes.
 func (c *TestContext) Status(nodes []Gravity) (statuses []GravityStatus, err error) {
+	reports, err := c.StatusReports(nodes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, report := range reports {
+		statuses = append(statuses, report.Status)
+	}
+	return statuses, nil
+}
+
+// StatusReports queries `gravity status` once from each node in nodes
+// and returns the statuses along with the nodes that reported them.
+func (c *TestContext) StatusReports(nodes []Gravity) (reports []NodeReport, err error) {
 	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.NodeStatus)
 	defer cancel()
+	return statusReports(ctx, nodes)
+}
 
-	valueC := make(chan GravityStatus, len(nodes))
+func statusReports(ctx context.Context, nodes []Gravity) (reports []NodeReport, err error) {
+	valueC := make(chan NodeReport, len(nodes))
 	g, ctx := errgroup.WithContext(ctx)
 	for _, node := range nodes {
 		node := node
@@ -218,7 +421,7 @@ func (c *TestContext) Status(nodes []Gravity) (statuses []GravityStatus, err err
 				return trace.Wrap(err)
 			}
 			if status != nil {
-				valueC <- *status
+				valueC <- NodeReport{Node: node.String(), Status: *status}
 			}
 			return nil
 		})
@@ -228,10 +431,10 @@ func (c *TestContext) Status(nodes []Gravity) (statuses []GravityStatus, err err
 		return nil, trace.Wrap(err)
 	}
 	close(valueC)
-	for status := range valueC {
-		statuses = append(statuses, status)
+	for report := range valueC {
+		reports = append(reports, report)
 	}
-	return statuses, nil
+	return reports, nil
 }
 
 // CheckTime walks around all nodes and checks whether their time is within acceptable limits
@@ -324,7 +527,7 @@ func (c *TestContext) NodesByRole(nodes []Gravity) (roles *ClusterNodesByRole, e
 	ctx, cancel = context.WithTimeout(c.ctx, c.timeouts.GetPods)
 	defer cancel()
 	// Run query on the apiserver
-	pods, err := KubectlGetPods(ctx, roles.ApiMaster, kubeSystemNS, appGravityLabel)
+	pods, err := gravitySitePods(ctx, NewKubectl(roles.ApiMaster))
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -334,8 +537,8 @@ L:
 		ip := node.Node().PrivateAddr()
 
 		for _, pod := range pods {
-			if ip == pod.NodeIP {
-				if pod.Ready {
+			if ip == pod.Status.HostIP {
+				if pod.Ready() {
 					roles.ClusterMaster = node
 				} else {
 					roles.ClusterBackup = append(roles.ClusterBackup, node)
@@ -0,0 +1,187 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// NodeReport is the status of the cluster as reported by a single node
+type NodeReport struct {
+	// Node describes the node the status was queried from
+	Node string
+	// Status is the status reported by the node
+	Status GravityStatus
+}
+
+// StatusValidator returns nil if the statuses reported by the queried nodes
+// are the expected statuses or an error otherwise.
+//
+// Validators are composable, i.e.:
+//
+//   g.WaitForStatus(nodes, AllOf(Active, NodeCount(5), AppVersion("7.0.12")))
+type StatusValidator func(reports []NodeReport) error
+
+var (
+	// Active requires every node to report the cluster as active and not degraded
+	Active = ForEachNode("Active", checkActive)
+	// NotDegraded requires every node to report the cluster as not degraded
+	NotDegraded = ForEachNode("NotDegraded", checkNotDegraded)
+	// NoActiveOperation requires every node to report no operation in progress
+	NoActiveOperation = ForEachNode("NoActiveOperation", func(s GravityStatus) error {
+		if op := s.Cluster.ActiveOperation(); op != nil {
+			return trace.CompareFailed("operation %v (%v) is %v", op.Type, op.ID, op.State)
+		}
+		return nil
+	})
+)
+
+// ForEachNode returns a validator that applies check to the status
+// reported by every node. name identifies the check in failure messages
+func ForEachNode(name string, check func(s GravityStatus) error) StatusValidator {
+	return func(reports []NodeReport) error {
+		for _, report := range reports {
+			if err := check(report.Status); err != nil {
+				return trace.CompareFailed("%v failed on %v: %v", name, report.Node, err)
+			}
+		}
+		return nil
+	}
+}
+
+// AllOf returns a validator that requires all of the given validators to pass.
+// All failures are reported
+func AllOf(validators ...StatusValidator) StatusValidator {
+	return func(reports []NodeReport) error {
+		var errs []error
+		for _, validator := range validators {
+			if err := validator(reports); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return trace.NewAggregate(errs...)
+	}
+}
+
+// AnyOf returns a validator that requires at least one of the given validators to pass
+func AnyOf(validators ...StatusValidator) StatusValidator {
+	return func(reports []NodeReport) error {
+		var errs []error
+		for _, validator := range validators {
+			err := validator(reports)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return trace.CompareFailed("none of the expected statuses matched: %v", trace.NewAggregate(errs...))
+	}
+}
+
+// NodeCount requires every node to report a cluster of n nodes
+func NodeCount(n int) StatusValidator {
+	return ForEachNode(fmt.Sprintf("NodeCount(%v)", n), func(s GravityStatus) error {
+		if len(s.Cluster.Nodes) != n {
+			return trace.CompareFailed("expected %v nodes, found %v", n, len(s.Cluster.Nodes))
+		}
+		return nil
+	})
+}
+
+// AppVersion requires every node to report the cluster application at version
+func AppVersion(version string) StatusValidator {
+	return ForEachNode(fmt.Sprintf("AppVersion(%v)", version), func(s GravityStatus) error {
+		if s.Cluster.Application.Version != version {
+			return trace.CompareFailed("expected application version %v, found %v",
+				version, s.Cluster.Application.Locator())
+		}
+		return nil
+	})
+}
+
+// NodeHasRole requires every node to report the node with the advertise address addr
+// in the specified role
+func NodeHasRole(addr, role string) StatusValidator {
+	return ForEachNode(fmt.Sprintf("NodeHasRole(%v, %v)", addr, role), func(s GravityStatus) error {
+		node, ok := s.Cluster.Node(addr)
+		if !ok {
+			return trace.CompareFailed("node %v is not a cluster member", addr)
+		}
+		if node.Role != role {
+			return trace.CompareFailed("expected node %v to have role %q, found %q", addr, role, node.Role)
+		}
+		return nil
+	})
+}
+
+// ProbeHealthy requires no node to report the health probe given with name as failed
+func ProbeHealthy(name string) StatusValidator {
+	return ForEachNode(fmt.Sprintf("ProbeHealthy(%v)", name), func(s GravityStatus) error {
+		for _, node := range s.Cluster.Nodes {
+			for _, probe := range node.FailedProbes {
+				if probeName(probe) == name {
+					return trace.CompareFailed("probe failed on %v: %v", node.Addr, probe)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ConsistentAcrossNodes requires every node to report the same view of the cluster:
+// the same application, cluster state and set of nodes with their roles and health
+func ConsistentAcrossNodes(reports []NodeReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	first := reports[0]
+	expected := clusterView(first.Status)
+	for _, report := range reports[1:] {
+		if view := clusterView(report.Status); view != expected {
+			return trace.CompareFailed("ConsistentAcrossNodes failed on %v: %v reports %v, %v reports %v",
+				report.Node, first.Node, expected, report.Node, view)
+		}
+	}
+	return nil
+}
+
+// clusterView formats the parts of the status that all nodes are expected to agree upon
+func clusterView(s GravityStatus) string {
+	nodes := make([]string, 0, len(s.Cluster.Nodes))
+	for _, node := range s.Cluster.Nodes {
+		nodes = append(nodes, fmt.Sprintf("%v/%v/%v", node.Addr, node.Role, node.Status))
+	}
+	sort.Strings(nodes)
+	return fmt.Sprintf("app=%v state=%v system_status=%v nodes=[%v]",
+		s.Cluster.Application.Locator(), s.Cluster.State, s.Cluster.SystemStatus,
+		strings.Join(nodes, ","))
+}
+
+// probeName extracts the name of the probe from a failed probe description.
+// Gravity formats failed probes as "name (detail)", e.g.:
+//
+//   etcd-healthz (unexpected HTTP status: Service Unavailable)
+func probeName(probe string) string {
+	if i := strings.Index(probe, " ("); i != -1 {
+		return probe[:i]
+	}
+	return probe
+}
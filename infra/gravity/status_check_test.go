@@ -0,0 +1,135 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"bufio"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadReports(t *testing.T, file string, nodes ...string) []NodeReport {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var status GravityStatus
+	err = parseStatus(&status)(bufio.NewReader(f))
+	require.NoError(t, err)
+
+	var reports []NodeReport
+	for _, node := range nodes {
+		reports = append(reports, NodeReport{Node: node, Status: status})
+	}
+	return reports
+}
+
+func TestStatusValidators(t *testing.T) {
+	active := loadReports(t, "testdata/status-active-7.0.12.json", "node-0", "node-1", "node-2")
+	degraded := loadReports(t, "testdata/status-degraded-1641.json", "node-0", "node-1", "node-2")
+	updating := loadReports(t, "testdata/status-updating-7.0.30.json", "node-0", "node-1")
+
+	var testCases = []struct {
+		comment   string
+		reports   []NodeReport
+		validator StatusValidator
+		// failure is the expected failure message, empty if the validator is expected to pass
+		failure string
+	}{
+		{
+			comment:   "active cluster",
+			reports:   active,
+			validator: AllOf(Active, NodeCount(3), AppVersion("7.0.12"), NoActiveOperation, ConsistentAcrossNodes),
+		},
+		{
+			comment:   "wrong node count",
+			reports:   active,
+			validator: AllOf(Active, NodeCount(5)),
+			failure:   "NodeCount(5) failed on node-0: expected 5 nodes, found 3",
+		},
+		{
+			comment:   "wrong application version",
+			reports:   active,
+			validator: AppVersion("7.0.30"),
+			failure:   "AppVersion(7.0.30) failed on node-0: expected application version 7.0.30, found gravitational.io/telekube:7.0.12",
+		},
+		{
+			comment:   "node role",
+			reports:   active,
+			validator: NodeHasRole("10.138.0.72", "master"),
+		},
+		{
+			comment:   "wrong node role",
+			reports:   active,
+			validator: NodeHasRole("10.138.0.72", "node"),
+			failure:   `NodeHasRole(10.138.0.72, node) failed on node-0: expected node 10.138.0.72 to have role "node", found "master"`,
+		},
+		{
+			comment:   "unknown node",
+			reports:   active,
+			validator: NodeHasRole("10.0.0.1", "master"),
+			failure:   "NodeHasRole(10.0.0.1, master) failed on node-0: node 10.0.0.1 is not a cluster member",
+		},
+		{
+			comment:   "failed probe",
+			reports:   degraded,
+			validator: ProbeHealthy("etcd-healthz"),
+			failure:   "ProbeHealthy(etcd-healthz) failed on node-0: probe failed on 10.138.0.56: etcd-healthz (unexpected HTTP status: Service Unavailable)",
+		},
+		{
+			comment:   "unrelated probe",
+			reports:   degraded,
+			validator: ProbeHealthy("etcd"),
+		},
+		{
+			comment:   "operation in progress",
+			reports:   updating,
+			validator: NoActiveOperation,
+			failure:   "NoActiveOperation failed on node-0: operation operation_update (f1b9a2c4-6e1d-4a1e-8f5e-0b7f3d2e9c11) is update_in_progress",
+		},
+		{
+			comment:   "any of",
+			reports:   updating,
+			validator: AnyOf(AppVersion("7.0.30"), AppVersion("7.0.12")),
+		},
+		{
+			comment:   "none of",
+			reports:   updating,
+			validator: AnyOf(Active, NoActiveOperation),
+			failure:   "none of the expected statuses matched",
+		},
+		{
+			comment:   "inconsistent view",
+			reports:   append(append([]NodeReport{}, active[:2]...), updating[1]),
+			validator: ConsistentAcrossNodes,
+			failure:   "ConsistentAcrossNodes failed on node-1: node-0 reports app=gravitational.io/telekube:7.0.12 state=active",
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.validator(tc.reports)
+		if tc.failure == "" {
+			assert.NoError(t, err, tc.comment)
+			continue
+		}
+		require.Error(t, err, tc.comment)
+		assert.Contains(t, err.Error(), tc.failure, tc.comment)
+	}
+}
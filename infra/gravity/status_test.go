@@ -21,28 +21,48 @@ import (
 	"bytes"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGravityOutput(t *testing.T) {
 	var testStatusStr = []byte(`
 {"cluster":{"application":{"repository":"gravitational.io","name":"telekube","version":"0.0.1"},"state":"active","domain":"testcluster","token":{"token":"fac3b88014367fe4e98a8664755e2be4","expires":"0001-01-01T00:00:00Z","type":"expand","account_id":"00000000-0000-0000-0000-000000000001","site_domain":"testcluster","operation_id":"","user_email":"agent@testcluster"},"operation":{"type":"operation_install","id":"55298dfd-2094-47a3-a787-8b2a546c0fd1","state":"completed","created":"2008-01-01T12:00:00.0Z","progress":{"message":"Operation has completed","completion":100,"created":"2008-01-01T12:05:00.0Z"}},"system_status":1,"nodes":[{"hostname":"node-0","advertise_ip":"10.40.2.4","role":"master","profile":"node","status":"healthy"},{"hostname":"node-2","advertise_ip":"10.40.2.5","role":"master","profile":"node","status":"healthy"},{"hostname":"node-1","advertise_ip":"10.40.2.7","role":"master","profile":"node","status":"healthy"},{"hostname":"node-5","advertise_ip":"10.40.2.6","role":"node","profile":"node","status":"healthy"},{"hostname":"node-3","advertise_ip":"10.40.2.3","role":"node","profile":"node","status":"healthy"},{"hostname":"node-4","advertise_ip":"10.40.2.2","role":"node","profile":"node","status":"healthy"}]}}
 `)
+	created := time.Date(2008, 1, 1, 12, 0, 0, 0, time.UTC)
 	expectedStatus := &GravityStatus{
 		Cluster: ClusterStatus{
 			Cluster:      "testcluster",
-			Application:  Application{Name: "telekube"},
+			Application:  Application{Repository: "gravitational.io", Name: "telekube", Version: "0.0.1"},
 			State:        "active",
 			SystemStatus: 1,
-			Token:        Token{Token: "fac3b88014367fe4e98a8664755e2be4"},
+			Token: Token{
+				Token:       "fac3b88014367fe4e98a8664755e2be4",
+				Type:        "expand",
+				AccountID:   "00000000-0000-0000-0000-000000000001",
+				ClusterName: "testcluster",
+				UserEmail:   "agent@testcluster",
+			},
+			Operation: &ClusterOperation{
+				Type:    "operation_install",
+				ID:      "55298dfd-2094-47a3-a787-8b2a546c0fd1",
+				State:   "completed",
+				Created: created,
+				Progress: OperationProgress{
+					Message:    "Operation has completed",
+					Completion: 100,
+					Created:    created.Add(5 * time.Minute),
+				},
+			},
 			Nodes: []NodeStatus{
-				NodeStatus{Addr: "10.40.2.4"},
-				NodeStatus{Addr: "10.40.2.5"},
-				NodeStatus{Addr: "10.40.2.7"},
-				NodeStatus{Addr: "10.40.2.6"},
-				NodeStatus{Addr: "10.40.2.3"},
-				NodeStatus{Addr: "10.40.2.2"},
+				NodeStatus{Hostname: "node-0", Addr: "10.40.2.4", Role: "master", Profile: "node", Status: "healthy"},
+				NodeStatus{Hostname: "node-2", Addr: "10.40.2.5", Role: "master", Profile: "node", Status: "healthy"},
+				NodeStatus{Hostname: "node-1", Addr: "10.40.2.7", Role: "master", Profile: "node", Status: "healthy"},
+				NodeStatus{Hostname: "node-5", Addr: "10.40.2.6", Role: "node", Profile: "node", Status: "healthy"},
+				NodeStatus{Hostname: "node-3", Addr: "10.40.2.3", Role: "node", Profile: "node", Status: "healthy"},
+				NodeStatus{Hostname: "node-4", Addr: "10.40.2.2", Role: "node", Profile: "node", Status: "healthy"},
 			},
 		},
 	}
@@ -150,6 +170,140 @@ func TestGravity5036DegradedStatusValidation(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// TestStatusGoldenFiles ensures Robotest decodes the full status document
+// captured from several Gravity versions.
+func TestStatusGoldenFiles(t *testing.T) {
+	var testCases = []struct {
+		file             string
+		app              string
+		state            string
+		systemStatus     SystemStatus
+		serverVersion    string
+		nodes            []NodeStatus
+		activeOperation  string
+		uiEndpoints      int
+		appEndpointNames []string
+	}{
+		{
+			file:          "testdata/status-active-5.0.36.json",
+			app:           "gravitational.io/telekube:5.0.36",
+			state:         ClusterStateActive,
+			systemStatus:  SystemStatus_Running,
+			serverVersion: "",
+			nodes: []NodeStatus{
+				{Hostname: "robotest-unit-test-node-0", Addr: "10.138.0.27", Role: "master", Status: NodeStatusHealthy},
+			},
+		},
+		{
+			file:          "testdata/status-active-5.5.50.json",
+			app:           "gravitational.io/telekube:5.5.50",
+			state:         ClusterStateActive,
+			systemStatus:  SystemStatus_Running,
+			serverVersion: "5.5.50",
+			nodes: []NodeStatus{
+				{Hostname: "robotest-unit-test-node-0", Addr: "10.138.0.61", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+				{Hostname: "robotest-unit-test-node-1", Addr: "10.138.0.62", Role: "node", Profile: "knode", Status: NodeStatusHealthy},
+			},
+			uiEndpoints: 1,
+		},
+		{
+			file:          "testdata/status-degraded-1641.json",
+			app:           "gravitational.io/telekube:6.1.27",
+			state:         ClusterStateActive,
+			systemStatus:  SystemStatus_Degraded,
+			serverVersion: "6.1.27",
+			nodes: []NodeStatus{
+				{Hostname: "robotest-unit-test-node-1", Addr: "10.138.0.56", Role: "master", Profile: "node", Status: NodeStatusDegraded,
+					FailedProbes: []string{"etcd-healthz (unexpected HTTP status: Service Unavailable)"}},
+				{Hostname: "robotest-unit-test-node-0", Addr: "10.138.0.23", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+				{Hostname: "robotest-unit-test-node-2", Addr: "10.138.0.53", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+			},
+			uiEndpoints:      3,
+			appEndpointNames: []string{"Gravity Control Panel"},
+		},
+		{
+			file:          "testdata/status-active-7.0.12.json",
+			app:           "gravitational.io/telekube:7.0.12",
+			state:         ClusterStateActive,
+			systemStatus:  SystemStatus_Running,
+			serverVersion: "7.0.12",
+			nodes: []NodeStatus{
+				{Hostname: "robotest-unit-test-node-0", Addr: "10.138.0.71", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+				{Hostname: "robotest-unit-test-node-1", Addr: "10.138.0.72", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+				{Hostname: "robotest-unit-test-node-2", Addr: "10.138.0.73", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+			},
+			uiEndpoints:      3,
+			appEndpointNames: []string{"Gravity Control Panel"},
+		},
+		{
+			file:          "testdata/status-updating-7.0.30.json",
+			app:           "gravitational.io/telekube:7.0.12",
+			state:         "updating",
+			systemStatus:  SystemStatus_Running,
+			serverVersion: "7.0.12",
+			nodes: []NodeStatus{
+				{Hostname: "robotest-unit-test-node-0", Addr: "10.138.0.81", Role: "master", Profile: "node", Status: NodeStatusHealthy},
+				{Hostname: "robotest-unit-test-node-1", Addr: "10.138.0.82", Role: "master", Profile: "node", Status: NodeStatusHealthy,
+					WarnProbes: []string{"kubelet (node is cordoned)"}},
+			},
+			activeOperation: "operation_update",
+			uiEndpoints:     2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			f, err := os.Open(tc.file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			var status GravityStatus
+			err = parseStatus(&status)(bufio.NewReader(f))
+			require.NoError(t, err)
+
+			cluster := status.Cluster
+			assert.Equal(t, "robotest-unit-test", cluster.Cluster)
+			assert.Equal(t, "ROBOTEST", cluster.Token.Token)
+			assert.Equal(t, tc.app, cluster.Application.Locator().String())
+			assert.Equal(t, tc.state, cluster.State)
+			assert.Equal(t, tc.systemStatus, cluster.SystemStatus)
+			if tc.serverVersion == "" {
+				assert.Nil(t, cluster.ServerVersion)
+			} else {
+				require.NotNil(t, cluster.ServerVersion)
+				assert.Equal(t, tc.serverVersion, cluster.ServerVersion.Version)
+			}
+			assert.Len(t, cluster.Endpoints.Cluster.UI, tc.uiEndpoints)
+			var appEndpointNames []string
+			for _, app := range cluster.Endpoints.Applications.Endpoints {
+				for _, endpoint := range app.Endpoints {
+					appEndpointNames = append(appEndpointNames, endpoint.Name)
+				}
+			}
+			assert.Equal(t, tc.appEndpointNames, appEndpointNames)
+
+			require.Len(t, cluster.Nodes, len(tc.nodes))
+			for i, expected := range tc.nodes {
+				node := cluster.Nodes[i]
+				// teleport info is optional and checked separately
+				node.TeleportNode = nil
+				assert.Equal(t, expected, node)
+				if cluster.Nodes[i].TeleportNode != nil {
+					assert.Equal(t, expected.Addr, cluster.Nodes[i].TeleportNode.Addr)
+				}
+			}
+
+			op := cluster.ActiveOperation()
+			if tc.activeOperation == "" {
+				assert.Nil(t, op)
+			} else {
+				require.NotNil(t, op)
+				assert.Equal(t, tc.activeOperation, op.Type)
+			}
+		})
+	}
+}
+
 func TestSystemStatusStringDegradedUnmarshal(t *testing.T) {
 	data := []byte(`"degraded"`)
 	expected := SystemStatus_Degraded
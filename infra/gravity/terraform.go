@@ -22,14 +22,17 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/oscatalog"
 	"github.com/gravitational/robotest/infra/providers/gce"
 	"github.com/gravitational/robotest/infra/terraform"
 	"github.com/gravitational/robotest/lib/constants"
 	"github.com/gravitational/robotest/lib/defaults"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
 	"github.com/gravitational/robotest/lib/wait"
 
 	"github.com/gravitational/trace"
@@ -46,14 +49,26 @@ type ProvisionerPolicy struct {
 	DestroyOnFailure bool
 	// AlwaysCollectLogs requests to fetch logs also from VMs where tests completed OK
 	AlwaysCollectLogs bool
-	// ResourceListFile keeps record of allocated and not cleaned up resources
+	// ResourceListFile is the resource ledger: the journal of allocated and destroyed resources
 	ResourceListFile string
+	// ReuseVMs enables the pool mode: cloud VMs are provisioned once per OS and storage driver
+	// and are reset and reused across tests. The pool is destroyed when the suite is closed
+	ReuseVMs bool
+	// FileCacheDir is the directory of the runner-side cache of files
+	// uploaded to the nodes that cannot download them themselves
+	FileCacheDir string
 }
 
 var policy ProvisionerPolicy
 
+// fileCache is the runner-side cache of the files transferred to the nodes
+var fileCache = sshutils.NewFileCache(filepath.Join(os.TempDir(), defaultFileCacheDir))
+
 func SetProvisionerPolicy(p ProvisionerPolicy) {
 	policy = p
+	if p.FileCacheDir != "" {
+		fileCache = sshutils.NewFileCache(p.FileCacheDir)
+	}
 }
 
 var testStatus = map[bool]string{true: "failed", false: "ok"}
@@ -62,7 +77,8 @@ const finalTeardownTimeout = 20 * time.Minute
 
 // wrapDestroyFunc returns a function that wraps the specified set of nodes
 // and the given clean up function that implements report collection and resource clean up.
-func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(context.Context) error) DestroyFn {
+// If not nil, keep is invoked instead of destroy when the nodes are kept per policy
+func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(context.Context) error, keep func()) DestroyFn {
 	return func() error {
 		defer func() {
 			if r := recover(); r != nil {
@@ -74,6 +90,8 @@ func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(c
 				).Error("Panic in terraform destroy.")
 			}
 		}()
+		// stop keeping connections to the nodes alive
+		defer disconnect(nodes)
 
 		log := c.Logger().WithFields(logrus.Fields{
 			"nodes":              nodes,
@@ -86,6 +104,9 @@ func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(c
 
 		if ctx.Err() != nil && !policy.DestroyOnFailure {
 			log.WithError(ctx.Err()).Info("Skipping destroy.")
+			if keep != nil {
+				keep()
+			}
 			return trace.Wrap(ctx.Err())
 		}
 
@@ -93,6 +114,10 @@ func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(c
 			skipLogCollection = true
 		}
 
+		if !skipLogCollection && c.Failed() {
+			c.captureDiagnostics(fmt.Sprintf("failed %v", c.failedStep), nodes)
+		}
+
 		if !skipLogCollection && (c.Failed() || policy.AlwaysCollectLogs) {
 			log.Debug("Collecting logs from nodes...")
 			err := c.CollectLogs("postmortem", nodes)
@@ -104,6 +129,9 @@ func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(c
 		if !policy.DestroyOnSuccess ||
 			(c.Failed() && !policy.DestroyOnFailure) {
 			log.Info("not destroying VMs per policy")
+			if keep != nil {
+				keep()
+			}
 			return nil
 		}
 
@@ -127,103 +155,97 @@ func wrapDestroyFunc(c *TestContext, tag string, nodes []Gravity, destroy func(c
 
 var resourceAllocations = struct {
 	sync.Mutex
-	tags map[string]bool
-}{tags: map[string]bool{}}
+	resources map[string]ResourceRecord
+}{resources: map[string]ResourceRecord{}}
 
-// resourceAllocated adds resource allocated into local index file for shell-based cleanup
+// resourceAllocated records the resources allocated with the tag of the specified record in the resource ledger
 // as test might crash and leak resources in the cloud
-func resourceAllocated(tag string) error {
+func resourceAllocated(record ResourceRecord) error {
 	resourceAllocations.Lock()
 	defer resourceAllocations.Unlock()
 
-	if _, there := resourceAllocations.tags[tag]; there {
-		return trace.Errorf("resource tag not unique : %s", tag)
+	if _, there := resourceAllocations.resources[record.Tag]; there {
+		return trace.Errorf("resource tag not unique : %s", record.Tag)
 	}
 
-	resourceAllocations.tags[tag] = true
-	return saveResourceAllocations()
+	record.Event = ResourceAllocated
+	record.Time = time.Now().UTC()
+	resourceAllocations.resources[record.Tag] = record
+	return appendLedger(policy.ResourceListFile, record)
 }
 
+// resourceDestroyed records the destruction of the resources allocated with the given tag
 func resourceDestroyed(tag string) error {
 	resourceAllocations.Lock()
 	defer resourceAllocations.Unlock()
 
-	delete(resourceAllocations.tags, tag)
-	return saveResourceAllocations()
-}
-
-func saveResourceAllocations() error {
-	if policy.ResourceListFile == "" {
+	record, there := resourceAllocations.resources[tag]
+	if !there {
 		return nil
 	}
+	delete(resourceAllocations.resources, tag)
 
-	file, err := os.OpenFile(policy.ResourceListFile, os.O_RDWR|os.O_CREATE, constants.SharedReadMask)
-	if err != nil {
-		return trace.ConvertSystemError(err)
-	}
-	defer file.Close()
-
-	for res := range resourceAllocations.tags {
-		_, err = fmt.Fprintln(file, res)
-		if err != nil {
-			return trace.ConvertSystemError(err)
-		}
-	}
-
-	return nil
+	record.Event = ResourceDestroyed
+	record.Time = time.Now().UTC()
+	return appendLedger(policy.ResourceListFile, record)
 }
 
 // makeDynamicParams takes base config, validates it and returns cloudDynamicParams
 func makeDynamicParams(baseConfig ProvisionerConfig) (*cloudDynamicParams, error) {
 	param := cloudDynamicParams{ProvisionerConfig: baseConfig}
 
-	// OS name is cloud-init script specific
-	// enforce compatible values
-	var ok bool
-	usernames := map[string]map[string]string{
-		constants.Azure: {
-			"ubuntu": "robotest",
-			"debian": "admin",
-			"redhat": "redhat",
-			"centos": "centos",
-			"suse":   "robotest",
-		},
-		constants.GCE: {
-			"ubuntu": "ubuntu",
-			"debian": "robotest",
-			"redhat": "redhat",
-			"centos": "centos",
-			"sles":   "robotest",
-			"suse":   "robotest",
-		},
-		constants.AWS: {
-			"ubuntu": "ubuntu",
-			"debian": "admin",
-			"redhat": "redhat",
-			"centos": "centos",
-		},
-		constants.Ops: {
-			"centos": "centos",
-		},
+	catalog, err := loadOSCatalog(baseConfig)
+	if err != nil {
+		return nil, trace.Wrap(err)
 	}
 
-	param.user, ok = usernames[baseConfig.CloudProvider][baseConfig.os.Vendor]
-	if !ok {
-		return nil, trace.BadParameter("unknown OS vendor: %q", baseConfig.os.Vendor)
+	switch baseConfig.CloudProvider {
+	case constants.Static, constants.Docker:
+		// The SSH users are configured explicitly, the catalog only
+		// describes the OS if it knows the vendor
+		image, err := catalog.Lookup("", baseConfig.os.String())
+		if err != nil && !trace.IsNotFound(err) {
+			return nil, trace.Wrap(err)
+		}
+		if image == nil {
+			image = &oscatalog.Image{Vendor: baseConfig.os.Vendor}
+			if err := image.CheckAndSetDefaults(); err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+		param.image = *image
+		if baseConfig.CloudProvider == constants.Docker {
+			param.user = baseConfig.Docker.SSHUser
+			param.homeDir = userHomeDir(param.user)
+		}
+		// Inventory hosts specify SSH users individually
+	default:
+		image, err := catalog.Lookup(baseConfig.CloudProvider, baseConfig.os.String())
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if image.SSHUser == "" {
+			return nil, trace.BadParameter("OS catalog defines no SSH user for %v", image)
+		}
+		param.image = *image
+		param.user = image.SSHUser
+		param.homeDir = filepath.Join("/home", param.user)
 	}
 
-	param.homeDir = filepath.Join("/home", param.user)
-
 	if baseConfig.TerraformPluginDir == "" {
-		baseConfig.TerraformPluginDir = "/etc/terraform/plugins"
+		baseConfig.TerraformPluginDir = defaultTerraformPluginDir
 	}
 
 	param.terraform = terraform.Config{
-		CloudProvider: baseConfig.CloudProvider,
-		ScriptPath:    baseConfig.ScriptPath,
-		NumNodes:      int(baseConfig.NodeCount),
-		OS:            baseConfig.os.String(),
-		PluginDir:     baseConfig.TerraformPluginDir,
+		CloudProvider:   baseConfig.CloudProvider,
+		ScriptPath:      baseConfig.ScriptPath,
+		NumNodes:        int(baseConfig.NodeCount),
+		NodeGroups:      baseConfig.NodeGroups,
+		OS:              baseConfig.os.String(),
+		PluginDir:       baseConfig.TerraformPluginDir,
+		Image:           param.image.Image,
+		BootstrapScript: param.image.BootstrapScript,
+		Catalog:         catalog,
 	}
 
 	if baseConfig.AWS != nil {
@@ -233,6 +255,10 @@ func makeDynamicParams(baseConfig ProvisionerConfig) (*cloudDynamicParams, error
 		param.terraform.AWS = &config
 		param.terraform.AWS.ClusterName = baseConfig.tag
 		param.terraform.AWS.SSHUser = param.user
+		param.terraform.AWS.Region = strings.Split(config.Region, ",")[0]
+		if baseConfig.CloudProvider == constants.AWS && baseConfig.cloudRegions != nil {
+			param.terraform.AWS.Region = baseConfig.nextRegion()
+		}
 		param.env = map[string]string{
 			"AWS_ACCESS_KEY_ID":     param.terraform.AWS.AccessKey,
 			"AWS_SECRET_ACCESS_KEY": param.terraform.AWS.SecretKey,
@@ -246,12 +272,12 @@ func makeDynamicParams(baseConfig ProvisionerConfig) (*cloudDynamicParams, error
 		param.terraform.Azure = &config
 		param.terraform.Azure.ResourceGroup = baseConfig.tag
 		param.terraform.Azure.SSHUser = param.user
-		param.terraform.Azure.Location = baseConfig.cloudRegions.Next()
+		param.terraform.Azure.Location = baseConfig.nextRegion()
 	case baseConfig.GCE != nil:
 		config := *baseConfig.GCE
 		param.terraform.GCE = &config
 		param.terraform.GCE.SSHUser = param.user
-		param.terraform.GCE.Region = baseConfig.cloudRegions.Next()
+		param.terraform.GCE.Region = baseConfig.nextRegion()
 		param.terraform.GCE.NodeTag = gce.TranslateClusterName(baseConfig.tag)
 		param.terraform.VarFilePath = baseConfig.GCE.VarFilePath
 	}
@@ -259,6 +285,54 @@ func makeDynamicParams(baseConfig ProvisionerConfig) (*cloudDynamicParams, error
 	return &param, nil
 }
 
+// loadOSCatalog returns the OS catalog of the config, loading it once
+func loadOSCatalog(config ProvisionerConfig) (*oscatalog.Catalog, error) {
+	if config.osCatalog == nil {
+		return readOSCatalog(config)
+	}
+	config.osCatalog.once.Do(func() {
+		config.osCatalog.catalog, config.osCatalog.err = readOSCatalog(config)
+	})
+	return config.osCatalog.catalog, config.osCatalog.err
+}
+
+// readOSCatalog reads the OS catalog configured with os_catalog merged over
+// the catalog found in the provisioner script directory and the default catalog
+func readOSCatalog(config ProvisionerConfig) (*oscatalog.Catalog, error) {
+	catalog, err := oscatalog.LoadDir(config.ScriptPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if config.OSCatalog == "" {
+		return catalog, nil
+	}
+	custom, err := oscatalog.Load(config.OSCatalog)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return custom.Merge(catalog), nil
+}
+
+// osCatalog caches the OS catalog of a config
+type osCatalog struct {
+	once    sync.Once
+	catalog *oscatalog.Catalog
+	err     error
+}
+
+// region returns the cloud region the resources are allocated in
+func (r cloudDynamicParams) region() string {
+	switch {
+	case r.CloudProvider == constants.Azure && r.terraform.Azure != nil:
+		return r.terraform.Azure.Location
+	case r.CloudProvider == constants.GCE && r.terraform.GCE != nil:
+		return r.terraform.GCE.Region
+	case r.CloudProvider == constants.AWS && r.terraform.AWS != nil:
+		return r.terraform.AWS.Region
+	}
+	return ""
+}
+
 func runTerraform(ctx context.Context, baseConfig ProvisionerConfig, logger logrus.FieldLogger) (resp *terraformResp, err error) {
 	retryer := wait.Retryer{
 		Delay:       defaults.TerraformRetryDelay,
@@ -288,7 +362,27 @@ func runTerraform(ctx context.Context, baseConfig ProvisionerConfig, logger logr
 			return nil
 		}
 
-		logger.WithError(err).Warn("terraform provisioning failed")
+		reason := terraform.FailureReasonOf(err)
+		log := logger.WithFields(logrus.Fields{
+			"region": params.region(),
+			"reason": reason,
+		})
+		switch {
+		case reason.Permanent():
+			log.WithError(err).Error("terraform provisioning failed permanently")
+			return wait.Abort(trace.BadParameter("terraform provisioning failed (%v): %v",
+				reason, trace.UserMessage(err)))
+		case reason.Regional() && cfg.cloudRegions != nil && cfg.region != "":
+			// the test is retried in another region
+			cfg.cloudRegions.Cooldown(params.region(), defaults.RegionCooldown)
+			log.WithError(err).Error("terraform provisioning failed in the pinned region")
+			return wait.Abort(trace.Wrap(err))
+		case reason.Regional() && cfg.cloudRegions != nil:
+			cfg.cloudRegions.Cooldown(params.region(), defaults.RegionCooldown)
+			log.WithError(err).Warn("terraform provisioning failed, will retry in another region")
+		default:
+			log.WithError(err).Warn("terraform provisioning failed")
+		}
 		return wait.Continue(err.Error())
 	})
 	return resp, trace.Wrap(err)
@@ -307,7 +401,8 @@ func runTerraformOnce(
 	// only second chance is provided
 	//
 	// TODO: this seems to require more thorough testing, and same approach applied to Destroy
-	p, err := terraform.New(filepath.Join(baseConfig.StateDir, "tf"), params.terraform)
+	stateDir := filepath.Join(baseConfig.StateDir, "tf")
+	p, err := terraform.New(stateDir, params.terraform)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -326,14 +421,25 @@ func runTerraformOnce(
 		}
 
 		if err != nil {
+			if reason := terraform.FailureReasonOf(err); reason != terraform.FailureUnknown {
+				// retrying in the same place would fail the same way
+				break
+			}
 			continue
 		}
 
-		if errAlloc := resourceAllocated(baseConfig.Tag()); errAlloc != nil {
+		errAlloc := resourceAllocated(ResourceRecord{
+			Tag:      baseConfig.Tag(),
+			Provider: baseConfig.CloudProvider,
+			Region:   params.region(),
+			StateDir: stateDir,
+		})
+		if errAlloc != nil {
 			logger.Warnf("Failed to account for resource allocation: %v.", errAlloc)
 		}
 
 		return &terraformResp{
+			tag:       baseConfig.Tag(),
 			nodes:     p.NodePool().Nodes(),
 			destroyFn: p.Destroy,
 			params:    params,
@@ -345,6 +451,8 @@ func runTerraformOnce(
 
 // terraformResp describes the result of provisioning infrastructure with terraform.
 type terraformResp struct {
+	// tag is the tag the resources have been allocated with
+	tag       string
 	nodes     []infra.Node
 	destroyFn func(context.Context) error
 	params    cloudDynamicParams
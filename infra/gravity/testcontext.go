@@ -51,6 +51,8 @@ type OpTimeouts struct {
 	TimeSync         time.Duration
 	ResolveInPlanet  time.Duration
 	GetPods          time.Duration
+	KubeHealth       time.Duration
+	Rollout          time.Duration
 }
 
 // TestContext aggregates common parameters for better test suite readability
@@ -81,6 +83,23 @@ type TestContext struct {
 	// preempted indicates that a node belonging to this test context
 	// was preempted
 	preempted bool
+
+	// timeline records notable test events, i.e. cluster health transitions
+	timeline *timeline
+	// health is the state of the background cluster health monitor
+	health *healthMonitor
+	// installer describes the installer most recently set with SetInstaller or Upgrade
+	installer *InstallerManifest
+	// attempt is the number of this test attempt, starting with 1
+	attempt int
+	// diagnostics lists the diagnostic snapshots captured during this test
+	diagnostics *diagnosticsList
+	// failedStep names the step that failed the test
+	failedStep string
+	// diskMetrics lists the disk performance measured on the provisioned nodes
+	diskMetrics *diskMetricsList
+	// egressPeers lists the addresses airgapped nodes are allowed to connect to
+	egressPeers []string
 }
 
 // Run allows a running test to spawn a subtest
@@ -110,11 +129,14 @@ func (c *TestContext) SetTimeouts(tm OpTimeouts) {
 
 // Failed checks if this test failed
 func (c *TestContext) Failed() bool {
-	return c.err != nil
+	return c.Error() != nil
 }
 
 // Error returns reason this test failed
 func (c *TestContext) Error() error {
+	if c.err == nil && c.health != nil {
+		return c.healthError()
+	}
 	return c.err
 }
 
@@ -139,6 +161,10 @@ func (c *TestContext) OK(msg string, err error) {
 		fields[name] = value
 	}
 
+	if err == nil && c.health != nil {
+		// the cluster has degraded unexpectedly since the last step
+		err = c.healthError()
+	}
 	if err == nil {
 		c.log.WithFields(fields).Info(msg)
 		return
@@ -147,6 +173,7 @@ func (c *TestContext) OK(msg string, err error) {
 	fields["error"] = err
 	c.log.WithFields(fields).Error(msg)
 	c.err = trace.Wrap(err)
+	c.failedStep = msg
 	panic(msg)
 }
 
@@ -190,12 +217,18 @@ func (c *TestContext) Require(msg string, condition bool, args ...interface{}) {
 	panic(msg)
 }
 
-// Sleep will just sleep with log message
+// Sleep will just sleep with log message.
+// Sleep is cut short if the cluster degrades unexpectedly
 func (c *TestContext) Sleep(msg string, d time.Duration) {
 	c.log.Debugf("sleep %v %s...", d, msg)
+	var failed <-chan struct{}
+	if c.health != nil {
+		failed = c.health.failed
+	}
 	select {
 	case <-time.After(d):
 	case <-c.ctx.Done():
+	case <-failed:
 	}
 }
 
@@ -248,6 +281,17 @@ func (c *TestContext) updateStatus(status string) {
 	case TestStatusScheduled, TestStatusRunning:
 		log.Info(c.status)
 		return
+	}
+	if events := c.Timeline(); len(events) != 0 {
+		log = log.WithField("timeline", xlog.ToJSON(events))
+	}
+	if dirs := c.diagnostics.list(); len(dirs) != 0 {
+		log = log.WithField("diagnostics", dirs)
+	}
+	if metrics := c.DiskMetrics(); len(metrics) != 0 {
+		log = log.WithField("disk_metrics", xlog.ToJSON(metrics))
+	}
+	switch c.status {
 	case TestStatusPassed:
 		log.Info(c.status)
 	default:
@@ -280,10 +324,10 @@ func (c *TestContext) updateStatus(status string) {
 	}
 }
 
+// markPreempted cancels the test after the node has been preempted.
+// The test is retried without counting the attempt as a failure
 func (c *TestContext) markPreempted(node Gravity) {
-	// Consider the abort to be an indication of node preemption and
-	// cancel the test
-	c.Logger().Infof("%v was stopped/preempted, cancelling test.", node)
+	c.Logger().Infof("%v was preempted, cancelling test.", node)
 	c.preempted = true
 	c.cancel()
 }
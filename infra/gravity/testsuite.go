@@ -72,6 +72,11 @@ type TestStatus struct {
 	Status        string
 	LogUrl        string
 	Param         interface{}
+	// DiskMetrics lists the disk performance measured on the nodes
+	DiskMetrics []DiskMetrics
+	// Diagnostics lists the directories of the diagnostic snapshots
+	// captured during the test attempt
+	Diagnostics []string
 }
 
 // testSuite logically groups multiple test runs for centralized progress and status reporting
@@ -159,6 +164,11 @@ func (s *testSuite) failingFast() bool {
 }
 
 func (s *testSuite) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), finalTeardownTimeout)
+	defer cancel()
+	if err := vmPools.destroy(ctx, s.Logger()); err != nil {
+		s.Logger().WithError(err).Error("Failed to destroy VM pools.")
+	}
 	if s.client != nil {
 		s.client.Close()
 		s.client = nil
@@ -226,7 +236,7 @@ func (s *testSuite) wrap(fn TestFunc, baseConfig ProvisionerConfig, param interf
 					cfg.Tag(), b.numTries, b.maxTries)
 			}
 
-			testCtx, err := s.runTestFunc(t, fn, cfg, param)
+			testCtx, err := s.runTestFunc(t, fn, cfg, param, try)
 			if err == nil {
 				return nil
 			}
@@ -267,7 +277,7 @@ func (s *testSuite) wrap(fn TestFunc, baseConfig ProvisionerConfig, param interf
 	}
 }
 
-func (s *testSuite) runTestFunc(t *testing.T, testFunc TestFunc, cfg ProvisionerConfig, param interface{}) (testCtx *TestContext, err error) {
+func (s *testSuite) runTestFunc(t *testing.T, testFunc TestFunc, cfg ProvisionerConfig, param interface{}, attempt int) (testCtx *TestContext, err error) {
 	uid := uuid.NewV4().String()
 	labels := logrus.Fields{}
 	var logLink string
@@ -302,11 +312,17 @@ func (s *testSuite) runTestFunc(t *testing.T, testFunc TestFunc, cfg Provisioner
 		}),
 		monitorCtx:    monitorCtx,
 		monitorCancel: monitorCancel,
+		timeline:      &timeline{},
+		health:        newHealthMonitor(),
+		attempt:       attempt,
+		diagnostics:   &diagnosticsList{},
+		diskMetrics:   &diskMetricsList{},
 	}
 
 	defer func() {
 		r := recover()
-		if r == nil {
+		healthErr := testCtx.healthError()
+		if r == nil && healthErr == nil {
 			testCtx.updateStatus(TestStatusPassed)
 			return
 		}
@@ -316,6 +332,14 @@ func (s *testSuite) runTestFunc(t *testing.T, testFunc TestFunc, cfg Provisioner
 			return
 		}
 
+		if healthErr != nil {
+			// the health monitor has failed the test, any failed
+			// step is likely a consequence of the degradation
+			testCtx.updateStatus(TestStatusFailed)
+			err = healthErr
+			return
+		}
+
 		if testCtx.Failed() {
 			testCtx.updateStatus(TestStatusFailed)
 			err = testCtx.Error()
@@ -361,12 +385,14 @@ func (s *testSuite) Run() []TestStatus {
 	status := []TestStatus{}
 	for _, test := range s.tests {
 		status = append(status, TestStatus{
-			Name:     test.name,
-			Status:   test.status,
-			Param:    test.param,
-			UID:      test.uid,
-			SuiteUID: test.suite.uid,
-			LogUrl:   test.logLink,
+			Name:        test.name,
+			Status:      test.status,
+			Param:       test.param,
+			UID:         test.uid,
+			SuiteUID:    test.suite.uid,
+			LogUrl:      test.logLink,
+			DiskMetrics: test.DiskMetrics(),
+			Diagnostics: test.diagnostics.list(),
 		})
 	}
 	return status
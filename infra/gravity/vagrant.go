@@ -0,0 +1,144 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gravitational/robotest/infra/vagrant"
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+)
+
+// provisionVagrant boots the cluster nodes as vagrant VMs on the local host
+func (c *TestContext) provisionVagrant(cfg ProvisionerConfig) (cluster Cluster, err error) {
+	log := c.Logger().WithField("config", cfg)
+	log.Debug("Booting vagrant VMs.")
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	params, err := makeDynamicParams(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	config := *cfg.Vagrant
+	config.ClusterName = cfg.Tag()
+	config.ScriptPath = cfg.ScriptPath
+	config.NumNodes = int(cfg.NodeCount)
+	config.Box, err = vagrantBox(cfg.os, config.Boxes, params.image.Image)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	err = config.Validate()
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	stateDir := filepath.Join(cfg.StateDir, "vagrant")
+	err = os.MkdirAll(stateDir, constants.SharedDirMask)
+	if err != nil {
+		return cluster, trace.ConvertSystemError(err)
+	}
+	p, err := vagrant.New(stateDir, config)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), cloudInitTimeout)
+	defer cancel()
+
+	_, err = p.Create(ctx, false)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if errDestroy := destroyResource(p.Destroy); errDestroy != nil {
+			log.WithError(errDestroy).Error("Failed to destroy vagrant VMs.")
+		}
+	}()
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	log.Debug("Connecting to VMs.")
+	gravityNodes, err := connectVMs(ctx, c.Logger(), *params, p.NodePool().Nodes())
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			disconnect(asNodes(gravityNodes))
+		}
+	}()
+	c.streamLogs(gravityNodes)
+
+	err = c.postProvision(gravityNodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	log.WithField("nodes", gravityNodes).Debug("Provisioning complete.")
+
+	cluster.Nodes = asNodes(gravityNodes)
+	cluster.Destroy = wrapDestroyFunc(c, cfg.Tag(), cluster.Nodes, p.Destroy, nil)
+	return cluster, nil
+}
+
+// vagrantBox returns the vagrant box to boot for the specified OS.
+// boxes overrides the box from the OS catalog (catalogBox), which in turn
+// overrides the default box selection.
+// The box is looked up by the complete OS version first and by the major version after that
+func vagrantBox(os OS, boxes map[string]string, catalogBox string) (string, error) {
+	major := strings.SplitN(os.Version, ".", 2)[0]
+	keys := []string{os.String(), OS{Vendor: os.Vendor, Version: major}.String()}
+	for _, key := range keys {
+		if box, ok := boxes[key]; ok {
+			return box, nil
+		}
+	}
+	if catalogBox != "" {
+		return catalogBox, nil
+	}
+	for _, key := range keys {
+		if box, ok := vagrantBoxes[key]; ok {
+			return box, nil
+		}
+	}
+	return "", trace.BadParameter("no vagrant box for OS %v", os)
+}
+
+// vagrantBoxes maps OS to the default vagrant box.
+// The boxes support the libvirt provider and use vagrant as the SSH user
+var vagrantBoxes = map[string]string{
+	"centos:7":  "centos/7",
+	"centos:8":  "centos/8",
+	"ubuntu:16": "generic/ubuntu1604",
+	"ubuntu:18": "generic/ubuntu1804",
+	"ubuntu:20": "generic/ubuntu2004",
+	"debian:9":  "generic/debian9",
+	"debian:10": "generic/debian10",
+	"redhat:7":  "generic/rhel7",
+	"redhat:8":  "generic/rhel8",
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVagrantBox(t *testing.T) {
+	var testCases = []struct {
+		os         OS
+		boxes      map[string]string
+		catalogBox string
+		expected   string
+	}{
+		{os: OS{Vendor: "centos", Version: "7"}, expected: "centos/7"},
+		{os: OS{Vendor: "centos", Version: "7.9"}, expected: "centos/7"},
+		{os: OS{Vendor: "ubuntu", Version: "18"}, expected: "generic/ubuntu1804"},
+		{os: OS{Vendor: "centos", Version: "7.9"}, boxes: map[string]string{"centos:7.9": "bento/centos-7.9"}, expected: "bento/centos-7.9"},
+		{os: OS{Vendor: "sles", Version: "12"}, boxes: map[string]string{"sles:12": "suse/sles12sp3"}, expected: "suse/sles12sp3"},
+		{os: OS{Vendor: "rocky", Version: "8"}, catalogBox: "generic/rocky8", expected: "generic/rocky8"},
+		{os: OS{Vendor: "centos", Version: "7"}, boxes: map[string]string{"centos:7": "bento/centos-7"}, catalogBox: "generic/centos7", expected: "bento/centos-7"},
+	}
+	for _, tc := range testCases {
+		box, err := vagrantBox(tc.os, tc.boxes, tc.catalogBox)
+		require.NoError(t, err, tc.os.String())
+		assert.Equal(t, tc.expected, box, tc.os.String())
+	}
+
+	_, err := vagrantBox(OS{Vendor: "sles", Version: "12"}, nil, "")
+	assert.Error(t, err)
+}
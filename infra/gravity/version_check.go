@@ -0,0 +1,290 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gravitational/robotest/lib/loc"
+	"github.com/gravitational/robotest/lib/utils"
+
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
+)
+
+// InstallerManifest describes the application packaged in an installer
+type InstallerManifest struct {
+	// App is the application locator
+	App loc.Locator `json:"app"`
+	// Planet is the runtime package locator, nil if not specified in the manifest
+	Planet *loc.Locator `json:"planet,omitempty"`
+	// Teleport is the teleport package locator, nil if not specified in the manifest
+	Teleport *loc.Locator `json:"teleport,omitempty"`
+}
+
+// String returns a textual representation of this manifest
+func (r InstallerManifest) String() string {
+	return fmt.Sprintf("app=%v planet=%v teleport=%v", r.App, optionalLocator(r.Planet), optionalLocator(r.Teleport))
+}
+
+// ReadInstallerManifest reads the application manifest from the local installer tarball at path
+func ReadInstallerManifest(path string) (*InstallerManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var archive io.Reader = r
+	// Installers are usually plain tarballs, but might be compressed
+	if magic, err := r.Peek(2); err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, trace.NotFound("no %v in installer %v", installerManifestFile, path)
+		}
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to read installer %v", path)
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != installerManifestFile {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return parseAppManifest(data)
+	}
+}
+
+// setInstallerManifest records the manifest of the installer given with installerURL
+// which has just been transferred to node.
+// Local installers are read directly, remote ones are queried on the node
+func (c *TestContext) setInstallerManifest(ctx context.Context, node Gravity, installerURL string) error {
+	var manifest *InstallerManifest
+	var err error
+	if u, _ := url.Parse(installerURL); u != nil && u.Scheme == "" {
+		manifest, err = ReadInstallerManifest(installerURL)
+	} else {
+		manifest, err = node.InstallerManifest(ctx)
+	}
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	c.Logger().WithField("installer", manifest.String()).Info("Installer manifest.")
+	c.installer = manifest
+	return nil
+}
+
+// VerifyInstalledVersion verifies that every node runs the application from the
+// installer most recently set with SetInstaller or Upgrade.
+// Both the application reported by `gravity status` and the planet and teleport
+// packages installed on every node are compared
+func (c *TestContext) VerifyInstalledVersion(nodes []Gravity) error {
+	if c.installer == nil {
+		return trace.NotFound("no installer set")
+	}
+	expected := *c.installer
+	c.Logger().WithField("expected", expected.String()).Info("Verify installed version.")
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeouts.ClusterStatus)
+	defer cancel()
+
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node Gravity) {
+			status, err := node.Status(ctx)
+			if err != nil {
+				errs <- trace.Wrap(err)
+				return
+			}
+			packages, err := node.InstalledPackages(ctx)
+			if err != nil {
+				errs <- trace.Wrap(err)
+				return
+			}
+			errs <- trace.Wrap(checkInstalledVersion(expected, status.Cluster.Application.Locator(), packages),
+				"on node %v", node)
+		}(node)
+	}
+	return trace.Wrap(utils.CollectErrors(ctx, errs))
+}
+
+// checkInstalledVersion compares the application and packages installed on a node
+// with the expected installer manifest
+func checkInstalledVersion(expected InstallerManifest, app loc.Locator, packages []loc.Locator) error {
+	var errors []error
+	if app != expected.App {
+		errors = append(errors, trace.CompareFailed("expected application %v, found %v", expected.App, app))
+	}
+	for _, pkg := range []*loc.Locator{expected.Planet, expected.Teleport} {
+		if pkg == nil {
+			continue
+		}
+		installed := findPackage(packages, pkg.Repository, pkg.Name)
+		if installed == nil {
+			errors = append(errors, trace.CompareFailed("expected package %v, but none is installed", pkg))
+			continue
+		}
+		if *installed != *pkg {
+			errors = append(errors, trace.CompareFailed("expected package %v, found %v", pkg, installed))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// parseAppManifest extracts the locators of the application and its
+// runtime and teleport packages from the application manifest
+func parseAppManifest(data []byte) (*InstallerManifest, error) {
+	var manifest struct {
+		Metadata struct {
+			Name            string `yaml:"name"`
+			ResourceVersion string `yaml:"resourceVersion"`
+			Repository      string `yaml:"repository"`
+		} `yaml:"metadata"`
+		Dependencies struct {
+			Packages []string `yaml:"packages"`
+		} `yaml:"dependencies"`
+		SystemOptions struct {
+			Runtime struct {
+				Version string `yaml:"version"`
+			} `yaml:"runtime"`
+			Dependencies struct {
+				RuntimePackage string `yaml:"runtimePackage"`
+			} `yaml:"dependencies"`
+		} `yaml:"systemOptions"`
+	}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, trace.Wrap(err, "failed to parse application manifest")
+	}
+	meta := manifest.Metadata
+	if meta.Name == "" || meta.ResourceVersion == "" {
+		return nil, trace.BadParameter("application manifest has no name or version")
+	}
+	if meta.Repository == "" {
+		meta.Repository = defaultRepository
+	}
+	result := InstallerManifest{
+		App: *loc.NewLocator(meta.Repository, meta.Name, meta.ResourceVersion),
+	}
+
+	switch {
+	case manifest.SystemOptions.Dependencies.RuntimePackage != "":
+		planet, err := loc.ParseLocator(manifest.SystemOptions.Dependencies.RuntimePackage)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		result.Planet = planet
+	case manifest.SystemOptions.Runtime.Version != "":
+		result.Planet = loc.NewLocator(defaultRepository, planetPackage, manifest.SystemOptions.Runtime.Version)
+	}
+
+	for _, dependency := range manifest.Dependencies.Packages {
+		pkg, err := loc.ParseLocator(dependency)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if pkg.Name == teleportPackage {
+			result.Teleport = pkg
+		}
+	}
+	return &result, nil
+}
+
+// parseInstalledPackages parses the output of `gravity package list` and returns
+// the packages labeled as installed:
+//
+//   [gravitational.io]
+//   ------------------
+//
+//   * gravitational.io/planet:7.0.35-11706 428MB installed:installed purpose:runtime
+func parseInstalledPackages(out string) (packages []loc.Locator, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "*" {
+			continue
+		}
+		if !hasLabel(fields[2:], installedLabel) {
+			continue
+		}
+		pkg, err := loc.ParseLocator(fields[1])
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		packages = append(packages, *pkg)
+	}
+	return packages, trace.Wrap(scanner.Err())
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func findPackage(packages []loc.Locator, repository, name string) *loc.Locator {
+	for i := range packages {
+		if packages[i].Repository == repository && packages[i].Name == name {
+			return &packages[i]
+		}
+	}
+	return nil
+}
+
+func optionalLocator(locator *loc.Locator) string {
+	if locator == nil {
+		return "<unknown>"
+	}
+	return locator.String()
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+const (
+	// installerManifestFile is the name of the application manifest in the installer tarball
+	installerManifestFile = "app.yaml"
+	// installedLabel labels the packages installed on a node in `gravity package list`
+	installedLabel = "installed:installed"
+
+	defaultRepository = "gravitational.io"
+	planetPackage     = "planet"
+	teleportPackage   = "teleport"
+)
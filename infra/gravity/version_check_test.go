@@ -0,0 +1,132 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/robotest/lib/loc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadInstallerManifest(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/app.yaml")
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "installer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	expected := &InstallerManifest{
+		App:      loc.MustParseLocator("gravitational.io/telekube:7.0.12"),
+		Planet:   loc.NewLocator("gravitational.io", "planet", "7.0.35-11706"),
+		Teleport: loc.NewLocator("gravitational.io", "teleport", "3.0.5"),
+	}
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(dir, "installer.tar")
+		writeInstaller(t, path, compress, map[string][]byte{
+			"./gravity":  []byte("#!/bin/sh"),
+			"./app.yaml": manifest,
+		})
+		result, err := ReadInstallerManifest(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, result)
+	}
+
+	path := filepath.Join(dir, "empty.tar")
+	writeInstaller(t, path, false, map[string][]byte{"./gravity": []byte("#!/bin/sh")})
+	_, err = ReadInstallerManifest(path)
+	assert.Error(t, err)
+}
+
+func TestParseAppManifest(t *testing.T) {
+	manifest, err := parseAppManifest([]byte(`
+kind: Cluster
+metadata:
+  name: robotest
+  resourceVersion: 1.0.0
+systemOptions:
+  runtime:
+    version: 6.1.39
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &InstallerManifest{
+		App:    loc.MustParseLocator("gravitational.io/robotest:1.0.0"),
+		Planet: loc.NewLocator("gravitational.io", "planet", "6.1.39"),
+	}, manifest)
+
+	_, err = parseAppManifest([]byte("kind: Cluster"))
+	assert.Error(t, err)
+}
+
+func TestCheckInstalledVersion(t *testing.T) {
+	out, err := ioutil.ReadFile("testdata/package-list.txt")
+	require.NoError(t, err)
+	packages, err := parseInstalledPackages(string(out))
+	require.NoError(t, err)
+	assert.Equal(t, []loc.Locator{
+		loc.MustParseLocator("gravitational.io/planet:7.0.35-11706"),
+		loc.MustParseLocator("gravitational.io/planet-config-10138071robotest:7.0.35-11706"),
+		loc.MustParseLocator("gravitational.io/teleport:3.0.5"),
+	}, packages)
+
+	expected := InstallerManifest{
+		App:      loc.MustParseLocator("gravitational.io/telekube:7.0.12"),
+		Planet:   loc.NewLocator("gravitational.io", "planet", "7.0.35-11706"),
+		Teleport: loc.NewLocator("gravitational.io", "teleport", "3.0.5"),
+	}
+	assert.NoError(t, checkInstalledVersion(expected, expected.App, packages))
+
+	err = checkInstalledVersion(expected, loc.MustParseLocator("gravitational.io/telekube:6.1.39"), packages[:1])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected application gravitational.io/telekube:7.0.12, found gravitational.io/telekube:6.1.39")
+	assert.Contains(t, err.Error(), "expected package gravitational.io/teleport:3.0.5, but none is installed")
+
+	expected.Planet = loc.NewLocator("gravitational.io", "planet", "7.0.40-11709")
+	expected.Teleport = nil
+	err = checkInstalledVersion(expected, expected.App, packages)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		"expected package gravitational.io/planet:7.0.40-11709, found gravitational.io/planet:7.0.35-11706")
+}
+
+func writeInstaller(t *testing.T, path string, compress bool, files map[string][]byte) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for name, data := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+}
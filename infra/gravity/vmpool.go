@@ -0,0 +1,458 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+	"github.com/gravitational/robotest/lib/wait"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// provisionPooled leases cloud VMs from the warm VM pool.
+// VMs are provisioned with terraform only if the pool does not have enough free VMs,
+// and are reset and returned to the pool when the cluster is destroyed.
+// VMs kept per policy are quarantined until the pool is destroyed
+func (c *TestContext) provisionPooled(cfg ProvisionerConfig) (cluster Cluster, err error) {
+	log := c.Logger().WithField("config", cfg)
+	log.Debug("Leasing VMs from the pool.")
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	params, err := makeDynamicParams(cfg)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	// pools are regional, so missing VMs are provisioned in the same region
+	cfg = cfg.withRegion(params.region())
+	key := vmPoolKey(cfg)
+	nodes, err := vmPools.lease(key, int(cfg.NodeCount), func(count int) (*vmBatch, error) {
+		batchCfg := cfg.WithTag(fmt.Sprintf("pool%d", vmPools.nextBatchID()))
+		batchCfg.NodeCount = uint(count)
+		resp, err := runTerraform(c.Context(), batchCfg, c.Logger())
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return &vmBatch{tag: resp.tag, nodes: resp.nodes, destroy: resp.destroyFn}, nil
+	})
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// VMs that failed to come up are not trusted to be reused
+		if errRetire := vmPools.retire(key, nodes, log); errRetire != nil {
+			log.WithError(errRetire).Warn("Failed to retire pool VMs.")
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(c.Context(), cloudInitTimeout)
+	defer cancel()
+
+	log.Debug("Connecting to VMs.")
+	gravityNodes, err := connectVMs(ctx, c.Logger(), *params, nodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			disconnect(asNodes(gravityNodes))
+		}
+	}()
+	c.streamLogs(gravityNodes)
+	c.watchPreemption(cfg, gravityNodes)
+
+	log.Debug("Configuring VMs.")
+	err = configureVMs(ctx, c.Logger(), *params, gravityNodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	err = c.postProvision(gravityNodes)
+	if err != nil {
+		return cluster, trace.Wrap(err)
+	}
+
+	log.WithField("nodes", gravityNodes).Debug("Provisioning complete.")
+
+	cluster.Nodes = asNodes(gravityNodes)
+	cluster.Destroy = wrapDestroyFunc(c, cfg.Tag(), cluster.Nodes,
+		releasePooledFn(key, gravityNodes, c.Logger()), quarantinePooledFn(key, nodes, c.Logger()))
+	return cluster, nil
+}
+
+// quarantinePooledFn returns a function that takes the given nodes out of
+// circulation without destroying them
+func quarantinePooledFn(key string, nodes []infra.Node, log logrus.FieldLogger) func() {
+	return func() {
+		if err := vmPools.quarantine(key, nodes); err != nil {
+			log.WithError(err).Warn("Failed to quarantine pool VMs.")
+		}
+	}
+}
+
+// releasePooledFn returns a function that resets the given nodes and returns
+// them to the pool.
+// Nodes that fail to reset are retired and replaced with new VMs on demand
+func releasePooledFn(key string, nodes []*gravity, log logrus.FieldLogger) func(context.Context) error {
+	return func(ctx context.Context) error {
+		errs := make(chan error, len(nodes))
+		for _, node := range nodes {
+			go func(node *gravity) {
+				err := resetPooledNode(ctx, node)
+				if err != nil {
+					log.WithError(err).WithField("node", node).Error("Failed to reset pool VM, will replace it.")
+					errs <- trace.Wrap(vmPools.retire(key, []infra.Node{node.Node()}, log))
+					return
+				}
+				errs <- trace.Wrap(vmPools.release(key, []infra.Node{node.Node()}))
+			}(node)
+		}
+		return trace.Wrap(utils.CollectErrors(ctx, errs))
+	}
+}
+
+// resetPooledNode brings the node back into the state it has been provisioned in:
+// it removes gravity, wipes the disks used by the cluster, reboots the node
+// and verifies that it came back clean
+func resetPooledNode(ctx context.Context, node *gravity) error {
+	log := node.Logger()
+	err := sshutil.Run(ctx, node.Client(), log, staticLeaveCmd, nil)
+	if err != nil {
+		return trace.Wrap(err, "failed to remove gravity")
+	}
+
+	devices := vmPoolDevices(node.param)
+	wipe := []string{vmPoolWipeScript(devices)}
+	if node.installDir != "" && node.installDir != node.param.homeDir {
+		wipe = append(wipe, fmt.Sprintf("sudo rm -rf %v", node.installDir))
+	}
+	if node.param.CloudProvider == constants.GCE {
+		// The bootstrap script runs on every boot on GCE:
+		// remove the completion marker so the reboot below waits for it
+		wipe = append(wipe, fmt.Sprintf("sudo rm -f %v", cloudInitCompleteFile))
+	}
+	for _, cmd := range wipe {
+		err = sshutil.Run(ctx, node.Client(), log, cmd, nil)
+		if err != nil {
+			return trace.Wrap(err, "failed to wipe node")
+		}
+	}
+
+	err = rebootPooledNode(ctx, node)
+	if err != nil {
+		return trace.Wrap(err, "failed to reboot node")
+	}
+
+	err = configureVM(ctx, log, node, node.param)
+	if err != nil {
+		return trace.Wrap(err, "node failed to initialize after reboot")
+	}
+
+	err = sshutil.Run(ctx, node.Client(), log, vmPoolCheckCleanCmd(devices), nil)
+	if err != nil {
+		return trace.Wrap(err, "node is not clean after reset")
+	}
+	node.installDir = ""
+	// the egress firewall does not survive the reboot
+	node.airgapped = false
+	return nil
+}
+
+// rebootPooledNode reboots the node and waits until it is available again.
+// The boot ID is compared to make sure the node has actually been restarted
+func rebootPooledNode(ctx context.Context, node *gravity) error {
+	var bootID string
+	err := sshutil.RunAndParse(ctx, node.Client(), node.Logger(), bootIDCmd, nil, sshutil.ParseAsString(&bootID))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	err = node.Reboot(ctx, Graceful(true))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	return trace.Wrap(wait.Retry(ctx, func() error {
+		var newBootID string
+		err := sshutil.RunAndParse(ctx, node.Client(), node.Logger(), bootIDCmd, nil, sshutil.ParseAsString(&newBootID))
+		if err != nil {
+			// SSH might have reconnected before the node went down,
+			// the connection re-establishes itself once the node is back
+			return wait.Continue("node is not reachable: %v", trace.UserMessage(err))
+		}
+		if newBootID == bootID {
+			return wait.Continue("node has not rebooted yet")
+		}
+		return nil
+	}))
+}
+
+// vmPoolDevices returns the block devices to wipe on reset
+func vmPoolDevices(param cloudDynamicParams) []string {
+	if !strings.HasPrefix(param.dockerDevice, "/dev/") {
+		return nil
+	}
+	return []string{param.dockerDevice}
+}
+
+// vmPoolWipeScript returns the command that removes the gravity state directory
+// and wipes the specified devices.
+// The etcd volume mounted during node preparation is reformatted in place
+// to keep its /etc/fstab entry valid
+func vmPoolWipeScript(devices []string) string {
+	script := []string{
+		"set -o errexit",
+		"etcd_dir=/var/lib/gravity/planet/etcd",
+		"etcd_device=$(findmnt --noheadings --output SOURCE --mountpoint $etcd_dir || true)",
+		`if [ -n "$etcd_device" ]; then umount $etcd_dir; fi`,
+		"rm -rf /var/lib/gravity",
+		`if [ -n "$etcd_device" ]; then mkfs.ext4 -F -q $etcd_device; mkdir -p $etcd_dir; fi`,
+		fmt.Sprintf("for device in %v; do if [ -b $device ]; then wipefs --all --force $device; fi; done",
+			strings.Join(devices, " ")),
+	}
+	return fmt.Sprintf("sudo bash -c '%v'", strings.Join(script, "\n"))
+}
+
+// vmPoolCheckCleanCmd returns the command that fails unless the node
+// has no traces of a previous installation
+func vmPoolCheckCleanCmd(devices []string) string {
+	checks := []string{
+		"! command -v gravity >/dev/null",
+		"! systemctl list-unit-files --no-legend \"gravity__*\" | grep -q .",
+		"[ ! -e /var/lib/gravity/local ]",
+		`[ -z "$(ls -A /var/lib/gravity/planet/etcd 2>/dev/null | grep -v lost+found)" ]`,
+	}
+	for _, device := range devices {
+		checks = append(checks, fmt.Sprintf("! blkid -p %v >/dev/null", device))
+	}
+	return fmt.Sprintf("sudo bash -c '%v'", strings.Join(checks, " && "))
+}
+
+// vmPoolKey returns the pool to lease VMs for the given configuration from.
+// VMs are shared between configurations with the same region, OS and storage driver
+func vmPoolKey(cfg ProvisionerConfig) string {
+	driver := cfg.storageDriver.Driver()
+	if driver == "" {
+		driver = "none"
+	}
+	return fmt.Sprintf("%v-%v-%v-%v", cfg.CloudProvider, cfg.region, cfg.os, driver)
+}
+
+// vmPools tracks the VMs provisioned in the pool mode (see ProvisionerPolicy.ReuseVMs)
+var vmPools = newVMPoolSet()
+
+func newVMPoolSet() *vmPoolSet {
+	return &vmPoolSet{pools: make(map[string]*vmPool)}
+}
+
+type vmPoolSet struct {
+	sync.Mutex
+	// pools maps pool key to its pool
+	pools map[string]*vmPool
+	// batches counts the batches provisioned to generate unique tags
+	batches int
+}
+
+// vmPool is a set of interchangeable VMs
+type vmPool struct {
+	// free lists the VMs ready to be leased
+	free []infra.Node
+	// vms maps VM address to the batch it has been provisioned in
+	vms map[string]*vmBatch
+}
+
+// vmBatch is a set of VMs provisioned together.
+// VMs can only be destroyed a batch at a time
+type vmBatch struct {
+	tag     string
+	nodes   []infra.Node
+	destroy func(context.Context) error
+	// retired counts VMs that have been taken out of circulation
+	retired int
+	// quarantined counts retired VMs kept until the pool is destroyed
+	quarantined int
+}
+
+func (r *vmPoolSet) nextBatchID() int {
+	r.Lock()
+	defer r.Unlock()
+	r.batches++
+	return r.batches
+}
+
+// lease allocates amount VMs from the specified pool.
+// If the pool does not have enough free VMs, provision is invoked to create the missing VMs
+func (r *vmPoolSet) lease(key string, amount int, provision func(count int) (*vmBatch, error)) ([]infra.Node, error) {
+	r.Lock()
+	pool := r.pool(key)
+	count := amount
+	if count > len(pool.free) {
+		count = len(pool.free)
+	}
+	nodes := make([]infra.Node, 0, amount)
+	nodes = append(nodes, pool.free[:count]...)
+	pool.free = pool.free[count:]
+	r.Unlock()
+
+	if len(nodes) == amount {
+		return nodes, nil
+	}
+
+	// Provision outside of the lock as it takes a while
+	batch, err := provision(amount - len(nodes))
+	if err != nil {
+		if errRelease := r.release(key, nodes); errRelease != nil {
+			return nil, trace.NewAggregate(err, errRelease)
+		}
+		return nil, trace.Wrap(err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	for _, node := range batch.nodes {
+		pool.vms[node.Addr()] = batch
+	}
+	return append(nodes, batch.nodes...), nil
+}
+
+// release returns the specified VMs to the pool
+func (r *vmPoolSet) release(key string, nodes []infra.Node) error {
+	r.Lock()
+	defer r.Unlock()
+	pool := r.pool(key)
+	for _, node := range nodes {
+		if _, ok := pool.vms[node.Addr()]; !ok {
+			return trace.NotFound("VM %v is not in pool %v", node.Addr(), key)
+		}
+		pool.free = append(pool.free, node)
+	}
+	return nil
+}
+
+// retire takes the specified VMs out of circulation.
+// Batches without VMs in circulation are destroyed
+func (r *vmPoolSet) retire(key string, nodes []infra.Node, log logrus.FieldLogger) error {
+	r.Lock()
+	pool := r.pool(key)
+	var unused []*vmBatch
+	for _, node := range nodes {
+		batch, ok := pool.vms[node.Addr()]
+		if !ok {
+			r.Unlock()
+			return trace.NotFound("VM %v is not in pool %v", node.Addr(), key)
+		}
+		delete(pool.vms, node.Addr())
+		batch.retired++
+		if batch.retired == len(batch.nodes) && batch.quarantined == 0 {
+			unused = append(unused, batch)
+		}
+	}
+	r.Unlock()
+
+	var errs []error
+	for _, batch := range unused {
+		log.WithField("tag", batch.tag).Info("Destroying VMs retired from the pool.")
+		errs = append(errs, destroyVMBatch(batch))
+	}
+	return trace.NewAggregate(errs...)
+}
+
+// quarantine takes the specified VMs out of circulation, i.e. when they are kept
+// for investigation. Unlike retired VMs, they are only destroyed with the pool
+func (r *vmPoolSet) quarantine(key string, nodes []infra.Node) error {
+	r.Lock()
+	defer r.Unlock()
+	pool := r.pool(key)
+	for _, node := range nodes {
+		batch, ok := pool.vms[node.Addr()]
+		if !ok {
+			return trace.NotFound("VM %v is not in pool %v", node.Addr(), key)
+		}
+		// the VM stays registered so the batch is found when the pool is destroyed
+		batch.retired++
+		batch.quarantined++
+	}
+	return nil
+}
+
+// destroy destroys all batches in all pools, including VMs still leased
+func (r *vmPoolSet) destroy(ctx context.Context, log logrus.FieldLogger) error {
+	r.Lock()
+	var batches []*vmBatch
+	seen := make(map[*vmBatch]bool)
+	for _, pool := range r.pools {
+		for _, batch := range pool.vms {
+			if !seen[batch] {
+				seen[batch] = true
+				batches = append(batches, batch)
+			}
+		}
+	}
+	r.pools = make(map[string]*vmPool)
+	r.Unlock()
+
+	if len(batches) == 0 {
+		return nil
+	}
+	log.WithField("batches", len(batches)).Info("Destroying VM pools.")
+	errs := make(chan error, len(batches))
+	for _, batch := range batches {
+		go func(batch *vmBatch) {
+			errs <- destroyVMBatch(batch)
+		}(batch)
+	}
+	return trace.Wrap(utils.CollectErrors(ctx, errs))
+}
+
+func (r *vmPoolSet) pool(key string) *vmPool {
+	pool, ok := r.pools[key]
+	if !ok {
+		pool = &vmPool{vms: make(map[string]*vmBatch)}
+		r.pools[key] = pool
+	}
+	return pool
+}
+
+func destroyVMBatch(batch *vmBatch) error {
+	err := destroyResource(batch.destroy)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if errDestroy := resourceDestroyed(batch.tag); errDestroy != nil {
+		logrus.WithError(errDestroy).Warn("Failed to remove resource account.")
+	}
+	return nil
+}
+
+// bootIDCmd outputs the ID that changes with each boot
+const bootIDCmd = "cat /proc/sys/kernel/random/boot_id"
@@ -0,0 +1,185 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gravity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/providers/static"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVMPoolProvisionsOnlyMissingVMs(t *testing.T) {
+	pools := newVMPoolSet()
+	provisioner := &fakeBatchProvisioner{}
+
+	first, err := pools.lease("gce-ubuntu:18-overlay2", 2, provisioner.provision)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.NoError(t, pools.release("gce-ubuntu:18-overlay2", first))
+
+	second, err := pools.lease("gce-ubuntu:18-overlay2", 3, provisioner.provision)
+	require.NoError(t, err)
+	require.Len(t, second, 3)
+	assert.Equal(t, []int{2, 1}, provisioner.batchCounts())
+	assert.ElementsMatch(t, addrs(first), addrs(second[:2]))
+
+	_, err = pools.lease("gce-centos:7-overlay2", 1, provisioner.provision)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 1}, provisioner.batchCounts(), "pools are not shared between keys")
+}
+
+func TestVMPoolReturnsVMsOnProvisioningFailure(t *testing.T) {
+	pools := newVMPoolSet()
+	provisioner := &fakeBatchProvisioner{}
+
+	nodes, err := pools.lease("key", 1, provisioner.provision)
+	require.NoError(t, err)
+	require.NoError(t, pools.release("key", nodes))
+
+	_, err = pools.lease("key", 2, func(int) (*vmBatch, error) {
+		return nil, trace.ConnectionProblem(nil, "quota exceeded")
+	})
+	require.Error(t, err)
+
+	again, err := pools.lease("key", 1, provisioner.provision)
+	require.NoError(t, err)
+	assert.Equal(t, addrs(nodes), addrs(again))
+	assert.Equal(t, []int{1}, provisioner.batchCounts())
+}
+
+func TestVMPoolDestroysRetiredBatches(t *testing.T) {
+	pools := newVMPoolSet()
+	provisioner := &fakeBatchProvisioner{}
+	log := logrus.New()
+
+	nodes, err := pools.lease("key", 2, provisioner.provision)
+	require.NoError(t, err)
+
+	require.NoError(t, pools.retire("key", nodes[:1], log))
+	assert.Equal(t, 0, provisioner.destroyedCount(), "batch still has a VM in circulation")
+	require.NoError(t, pools.release("key", nodes[1:]))
+
+	// The retired VM is replaced with a new one
+	replaced, err := pools.lease("key", 2, provisioner.provision)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, provisioner.batchCounts())
+	assert.NotContains(t, addrs(replaced), nodes[0].Addr())
+
+	require.NoError(t, pools.retire("key", nodes[1:], log))
+	assert.Equal(t, 1, provisioner.destroyedCount())
+
+	require.NoError(t, pools.destroy(context.Background(), log))
+	assert.Equal(t, 2, provisioner.destroyedCount(), "leased VMs are destroyed with the pool")
+	require.NoError(t, pools.destroy(context.Background(), log))
+	assert.Equal(t, 2, provisioner.destroyedCount())
+}
+
+func TestVMPoolKeepsQuarantinedVMsUntilDestroyed(t *testing.T) {
+	pools := newVMPoolSet()
+	provisioner := &fakeBatchProvisioner{}
+	log := logrus.New()
+
+	nodes, err := pools.lease("key", 2, provisioner.provision)
+	require.NoError(t, err)
+
+	require.NoError(t, pools.quarantine("key", nodes[:1]))
+	require.NoError(t, pools.retire("key", nodes[1:], log))
+	assert.Equal(t, 0, provisioner.destroyedCount(), "quarantined VM is kept")
+
+	again, err := pools.lease("key", 1, provisioner.provision)
+	require.NoError(t, err)
+	assert.NotContains(t, addrs(again), nodes[0].Addr(), "quarantined VM is not reused")
+
+	require.NoError(t, pools.destroy(context.Background(), log))
+	assert.Equal(t, 2, provisioner.destroyedCount())
+}
+
+func TestVMPoolResetCommands(t *testing.T) {
+	assert.Empty(t, vmPoolDevices(cloudDynamicParams{}))
+	assert.Empty(t, vmPoolDevices(cloudDynamicParams{ProvisionerConfig: ProvisionerConfig{dockerDevice: "/var/lib/gravity"}}))
+	devices := vmPoolDevices(cloudDynamicParams{ProvisionerConfig: ProvisionerConfig{dockerDevice: "/dev/sdc"}})
+	assert.Equal(t, []string{"/dev/sdc"}, devices)
+
+	assert.Contains(t, vmPoolWipeScript(devices), "for device in /dev/sdc; do")
+	assert.Contains(t, vmPoolCheckCleanCmd(devices), "! blkid -p /dev/sdc")
+	assert.NotContains(t, vmPoolCheckCleanCmd(nil), "blkid")
+
+	cfg := ProvisionerConfig{CloudProvider: "gce", os: OS{"ubuntu", "18"}}.withRegion("us-west1")
+	assert.Equal(t, "gce-us-west1-ubuntu:18-none", vmPoolKey(cfg))
+	assert.Equal(t, "gce-us-west1-ubuntu:18-overlay2", vmPoolKey(cfg.WithStorageDriver("overlay2")))
+	assert.Equal(t, "gce-us-east1-ubuntu:18-none", vmPoolKey(cfg.withRegion("us-east1")))
+}
+
+// fakeBatchProvisioner creates batches of static nodes with unique addresses.
+// It is safe for concurrent use
+type fakeBatchProvisioner struct {
+	sync.Mutex
+	// counts lists the sizes of the batches provisioned
+	counts    []int
+	nodes     int
+	destroyed int
+}
+
+func (r *fakeBatchProvisioner) provision(count int) (*vmBatch, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.counts = append(r.counts, count)
+	batch := &vmBatch{
+		tag: fmt.Sprintf("pool%d", len(r.counts)),
+		destroy: func(context.Context) error {
+			r.Lock()
+			defer r.Unlock()
+			r.destroyed++
+			return nil
+		},
+	}
+	for i := 0; i < count; i++ {
+		r.nodes++
+		batch.nodes = append(batch.nodes, static.New(static.Host{Addr: fmt.Sprintf("10.0.0.%d", r.nodes)}, nil))
+	}
+	return batch, nil
+}
+
+// batchCounts returns the sizes of the batches provisioned so far
+func (r *fakeBatchProvisioner) batchCounts() []int {
+	r.Lock()
+	defer r.Unlock()
+	return append([]int(nil), r.counts...)
+}
+
+// destroyedCount returns the number of batches destroyed so far
+func (r *fakeBatchProvisioner) destroyedCount() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.destroyed
+}
+
+func addrs(nodes []infra.Node) (addrs []string) {
+	for _, node := range nodes {
+		addrs = append(addrs, node.Addr())
+	}
+	return addrs
+}
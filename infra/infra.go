@@ -157,6 +157,15 @@ type Node interface {
 	Client() (*ssh.Client, error)
 }
 
+// NodeGroup returns the name of the node group the specified node belongs to.
+// Returns an empty string if the node's provisioner does not support node groups
+func NodeGroup(node Node) string {
+	if grouped, ok := node.(interface{ Group() string }); ok {
+		return grouped.Group()
+	}
+	return ""
+}
+
 // ExternalStateLoader loads provisioner state from external source
 type ExternalStateLoader interface {
 	// LoadFromExternalState loads the state from the specified reader r.
@@ -0,0 +1,497 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodeprep prepares cloud VMs for the cluster installation.
+// Bootstrap scripts passed to the VMs only set up SSH access, all other
+// preparation is done with the idempotent steps of this package
+package nodeprep
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/robotest/infra/oscatalog"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Node is a node to prepare
+type Node interface {
+	fmt.Stringer
+	// Client returns the SSH client of the node
+	Client() *ssh.Client
+	// Logger returns the logger of the node
+	Logger() logrus.FieldLogger
+}
+
+// Config describes the preparation of a node
+type Config struct {
+	// Provider is the cloud provider of the node
+	Provider string
+	// Image is the OS image of the node
+	Image oscatalog.Image
+	// EtcdDevice is the optional device to mount the etcd directory from
+	EtcdDevice string
+	// DockerDevice is the optional device to release for Docker
+	DockerDevice string
+}
+
+// CheckAndSetDefaults validates the configuration
+func (r *Config) CheckAndSetDefaults() error {
+	if r.EtcdDevice != "" && r.DockerDevice == r.EtcdDevice {
+		return trace.BadParameter("docker device %v is reserved for etcd", r.DockerDevice)
+	}
+	return nil
+}
+
+// Prepare prepares the node for installation with the steps for its OS
+// and verifies the result
+func Prepare(ctx context.Context, node Node, config Config) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	driver, err := newOSDriver(config.Image)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	steps := nodePrepSteps(driver, config)
+	if err := prepareNode(ctx, node, steps); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(verifyNode(ctx, node, steps))
+}
+
+// InstallCmd returns the command that installs the packages on a node
+// with the given OS image
+func InstallCmd(image oscatalog.Image, packages ...string) (string, error) {
+	driver, err := newOSDriver(image)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return prepCmd(driver.installCmd(packages)), nil
+}
+
+// prepStep is a single idempotent node preparation step.
+// Commands are executed with bash as root
+type prepStep struct {
+	// name identifies the step in logs and errors
+	name string
+	// check succeeds if the step is already in effect on the node.
+	// It is also used to verify the node after preparation
+	check string
+	// apply brings the node to the state expected by check
+	apply string
+}
+
+// osDriver generates the OS specific parts of node preparation
+type osDriver interface {
+	// installCmd returns the command that installs the given packages
+	installCmd(packages []string) string
+	// installedCmd returns the command that fails unless all given packages are installed
+	installedCmd(packages []string) string
+	// packages lists the packages required on the node
+	packages() []string
+	// conflictingServices lists the services that interfere with the cluster
+	conflictingServices() []string
+	// sysctls lists kernel parameters set in addition to the common ones
+	sysctls() []sysctl
+}
+
+// sysctl describes a kernel parameter
+type sysctl struct {
+	key   string
+	value string
+	// optional parameters are only set if supported by the kernel
+	optional bool
+}
+
+// path returns the location of the parameter in /proc/sys
+func (s sysctl) path() string {
+	return "/proc/sys/" + strings.Replace(s.key, ".", "/", -1)
+}
+
+const (
+	// modulesConfig persists the kernel modules loaded during preparation
+	modulesConfig = "/etc/modules-load.d/telekube.conf"
+	// sysctlConfig persists the kernel parameters set during preparation
+	sysctlConfig = "/etc/sysctl.d/50-telekube.conf"
+	// EtcdDir is the etcd data directory which is mounted from a dedicated device
+	EtcdDir = "/var/lib/gravity/planet/etcd"
+	// serviceUser is the owner of the gravity directories as uid:gid
+	serviceUser = "1000:1000"
+	// awsCLIURL is the archive of the AWS CLI used to download the installers
+	awsCLIURL = "https://awscli.amazonaws.com/awscli-exe-linux-x86_64-2.0.30.zip"
+	// hostTimeSyncClass is the class of the Hyper-V time synchronization device
+	hostTimeSyncClass = "{9527e630-d0ae-497b-adce-e80ab0175caf}"
+)
+
+// kernelModules lists the kernel modules required by the cluster
+var kernelModules = []string{"br_netfilter", "overlay", "ebtable_filter", "ip_tables", "iptable_filter", "iptable_nat"}
+
+// kernelParams lists the kernel parameters required by the cluster on all OSes
+var kernelParams = []sysctl{
+	{key: "net.ipv4.ip_forward", value: "1"},
+	{key: "net.bridge.bridge-nf-call-iptables", value: "1"},
+	{key: "net.ipv4.tcp_keepalive_time", value: "60"},
+	{key: "net.ipv4.tcp_keepalive_intvl", value: "60"},
+	{key: "net.ipv4.tcp_keepalive_probes", value: "5"},
+}
+
+// gravityDirs lists the directories owned by the gravity service user
+var gravityDirs = []string{"/var/lib/gravity", "/var/lib/data"}
+
+// newOSDriver returns the driver for the given OS image.
+// The driver is selected by the package manager and falls back to the vendor
+func newOSDriver(image oscatalog.Image) (osDriver, error) {
+	manager := image.PackageManager
+	if manager == "" {
+		switch image.Vendor {
+		case "ubuntu", "debian":
+			manager = "apt"
+		case "centos", "redhat", "rocky":
+			manager = "yum"
+		case "suse", "sles":
+			manager = "zypper"
+		}
+	}
+	switch manager {
+	case "apt":
+		return aptDriver{}, nil
+	case "yum", "dnf":
+		return yumDriver{binary: manager}, nil
+	case "zypper":
+		return zypperDriver{}, nil
+	}
+	return nil, trace.NotImplemented("no node preparation driver for %v (package manager %q)",
+		image.Vendor, image.PackageManager)
+}
+
+// aptDriver prepares Debian based distributions
+type aptDriver struct{}
+
+func (aptDriver) installCmd(packages []string) string {
+	return fmt.Sprintf("apt-get update -o Acquire::Retries=3 && "+
+		"DEBIAN_FRONTEND=noninteractive apt-get install -y -o Acquire::Retries=3 %v",
+		strings.Join(packages, " "))
+}
+
+func (aptDriver) installedCmd(packages []string) string {
+	var checks []string
+	for _, pkg := range packages {
+		checks = append(checks, fmt.Sprintf(`dpkg -s %v 2>/dev/null | grep -q "^Status: install ok installed"`, pkg))
+	}
+	return strings.Join(checks, " && ")
+}
+
+func (aptDriver) packages() []string {
+	return []string{"chrony", "lvm2", "curl", "wget", "unzip", "thin-provisioning-tools"}
+}
+
+func (aptDriver) conflictingServices() []string {
+	return []string{"sshguard"}
+}
+
+func (aptDriver) sysctls() []sysctl {
+	return nil
+}
+
+// yumDriver prepares RedHat based distributions
+type yumDriver struct {
+	// binary is either yum or dnf
+	binary string
+}
+
+func (r yumDriver) installCmd(packages []string) string {
+	return fmt.Sprintf("%v install -y %v", r.binary, strings.Join(packages, " "))
+}
+
+func (yumDriver) installedCmd(packages []string) string {
+	return rpmInstalledCmd(packages)
+}
+
+func (yumDriver) packages() []string {
+	return []string{"chrony", "lvm2", "device-mapper-persistent-data", "curl", "unzip"}
+}
+
+func (yumDriver) conflictingServices() []string {
+	return []string{"dnsmasq", "firewalld"}
+}
+
+func (yumDriver) sysctls() []sysctl {
+	return []sysctl{{key: "fs.may_detach_mounts", value: "1", optional: true}}
+}
+
+// zypperDriver prepares SuSE based distributions
+type zypperDriver struct{}
+
+func (zypperDriver) installCmd(packages []string) string {
+	return fmt.Sprintf("zypper --non-interactive install %v", strings.Join(packages, " "))
+}
+
+func (zypperDriver) installedCmd(packages []string) string {
+	return rpmInstalledCmd(packages)
+}
+
+func (zypperDriver) packages() []string {
+	return []string{"lvm2", "curl", "unzip"}
+}
+
+func (zypperDriver) conflictingServices() []string {
+	return nil
+}
+
+func (zypperDriver) sysctls() []sysctl {
+	return nil
+}
+
+func rpmInstalledCmd(packages []string) string {
+	return fmt.Sprintf("rpm -q %v >/dev/null", strings.Join(packages, " "))
+}
+
+// nodePrepSteps returns the steps to prepare a node with the given driver
+func nodePrepSteps(driver osDriver, config Config) []prepStep {
+	var steps []prepStep
+	if config.Provider == constants.Azure {
+		steps = append(steps, hostTimeSyncStep(), hostnameStep())
+	}
+	if packages := driver.packages(); len(packages) != 0 {
+		steps = append(steps, prepStep{
+			name:  "packages",
+			check: driver.installedCmd(packages),
+			apply: driver.installCmd(packages),
+		})
+	}
+	for _, service := range driver.conflictingServices() {
+		steps = append(steps, disableServiceStep(service))
+	}
+	steps = append(steps, awsCLIStep())
+	steps = append(steps, kernelModulesStep(kernelModules))
+	steps = append(steps, sysctlStep(append(kernelParams, driver.sysctls()...)))
+	if config.EtcdDevice != "" {
+		steps = append(steps, etcdDeviceStep(config.EtcdDevice))
+	}
+	steps = append(steps, gravityDirsStep(gravityDirs))
+	if config.DockerDevice != "" {
+		steps = append(steps, dockerDeviceStep(config.DockerDevice))
+	}
+	return steps
+}
+
+// hostTimeSyncStep unbinds the Hyper-V time synchronization device
+// so the clock is only synchronized by chrony
+func hostTimeSyncStep() prepStep {
+	return prepStep{
+		name: "host time sync",
+		check: fmt.Sprintf(`! grep -qsx "%v" /sys/bus/vmbus/drivers/hv_util/*/class_id`,
+			hostTimeSyncClass),
+		apply: fmt.Sprintf(`for dev in /sys/bus/vmbus/drivers/hv_util/*/; do `+
+			`if grep -qsx "%v" ${dev}class_id; then basename $dev > /sys/bus/vmbus/drivers/hv_util/unbind; fi; done`,
+			hostTimeSyncClass),
+	}
+}
+
+// hostnameStep makes the hostname of the node resolvable
+func hostnameStep() prepStep {
+	return prepStep{
+		name:  "hostname",
+		check: `grep -qw "$(hostname)" /etc/hosts`,
+		apply: `printf "127.0.0.1\t%s\n" "$(hostname)" >> /etc/hosts`,
+	}
+}
+
+// awsCLIStep installs the AWS CLI used to download the installers from S3
+func awsCLIStep() prepStep {
+	apply := []string{
+		"dir=$(mktemp -d)",
+		`trap "rm -rf $dir" EXIT`,
+		fmt.Sprintf("curl -fsSL --retry 3 -o $dir/awscliv2.zip %v", awsCLIURL),
+		"unzip -q $dir/awscliv2.zip -d $dir",
+		"$dir/aws/install",
+	}
+	return prepStep{
+		name:  "aws cli",
+		check: "[ -x /usr/local/bin/aws ] || command -v aws >/dev/null",
+		apply: strings.Join(apply, " && "),
+	}
+}
+
+// disableServiceStep stops and disables the specified service.
+// Services that are not installed are considered disabled
+func disableServiceStep(service string) prepStep {
+	return prepStep{
+		name: "disable " + service,
+		check: fmt.Sprintf("! systemctl is-active --quiet %[1]v && ! systemctl is-enabled --quiet %[1]v",
+			service),
+		apply: fmt.Sprintf("systemctl disable --now %v", service),
+	}
+}
+
+// kernelModulesStep loads the kernel modules and persists them across reboots.
+// Built-in modules are found in /sys/module as well
+func kernelModulesStep(modules []string) prepStep {
+	var checks, apply []string
+	for _, module := range modules {
+		checks = append(checks,
+			fmt.Sprintf("[ -d /sys/module/%v ]", module),
+			fmt.Sprintf("grep -qx %v %v", module, modulesConfig))
+		apply = append(apply, fmt.Sprintf("modprobe %v", module))
+	}
+	apply = append(apply, fmt.Sprintf("printf \"%%s\\n\" %v > %v", strings.Join(modules, " "), modulesConfig))
+	return prepStep{
+		name:  "kernel modules",
+		check: strings.Join(checks, " && "),
+		apply: strings.Join(apply, " && "),
+	}
+}
+
+// sysctlStep persists the kernel parameters and applies them.
+// Optional parameters unsupported by the kernel are skipped
+func sysctlStep(params []sysctl) prepStep {
+	var checks, lines []string
+	for _, param := range params {
+		check := fmt.Sprintf(`[ "$(sysctl -n %v)" = "%v" ] && grep -qx %v=%v %v`,
+			param.key, param.value, param.key, param.value, sysctlConfig)
+		line := fmt.Sprintf("echo %v=%v", param.key, param.value)
+		if param.optional {
+			check = fmt.Sprintf("{ [ ! -e %v ] || { %v; }; }", param.path(), check)
+			line = fmt.Sprintf("if [ -e %v ]; then %v; fi", param.path(), line)
+		}
+		checks = append(checks, check)
+		lines = append(lines, line)
+	}
+	return prepStep{
+		name:  "sysctls",
+		check: strings.Join(checks, " && "),
+		apply: fmt.Sprintf("{ %v; } > %v && sysctl -p %v",
+			strings.Join(lines, "; "), sysctlConfig, sysctlConfig),
+	}
+}
+
+// etcdDeviceStep mounts the etcd directory from the specified device.
+// The device is only formatted if it does not have a filesystem yet
+func etcdDeviceStep(device string) prepStep {
+	apply := []string{
+		fmt.Sprintf("mkdir -p %v", EtcdDir),
+		fmt.Sprintf("{ blkid -p %v >/dev/null || mkfs.ext4 -F -q %v; }", device, device),
+		fmt.Sprintf(`sed -i "\|%v|d" /etc/fstab`, EtcdDir),
+		fmt.Sprintf(`printf "%v\t%v\text4\tdefaults\t0\t2\n" >> /etc/fstab`, device, EtcdDir),
+		fmt.Sprintf("mount %v", EtcdDir),
+	}
+	return prepStep{
+		name: "etcd device",
+		check: fmt.Sprintf("findmnt --noheadings --mountpoint %v >/dev/null && grep -q %v /etc/fstab",
+			EtcdDir, EtcdDir),
+		apply: strings.Join(apply, " && "),
+	}
+}
+
+// gravityDirsStep creates the gravity directories and hands them over
+// to the gravity service user
+func gravityDirsStep(dirs []string) prepStep {
+	var checks []string
+	for _, dir := range append(dirs, EtcdDir) {
+		checks = append(checks, fmt.Sprintf(`[ "$(stat -c %%u:%%g %v)" = "%v" ]`, dir, serviceUser))
+	}
+	return prepStep{
+		name:  "gravity directories",
+		check: strings.Join(checks, " && "),
+		apply: fmt.Sprintf("mkdir -p %[1]v %[2]v && chown -R %[3]v %[1]v",
+			strings.Join(dirs, " "), EtcdDir, serviceUser),
+	}
+}
+
+// dockerDeviceStep releases the Docker device for gravity which expects
+// a device without partitions or filesystems.
+// Cloud images might mount ephemeral disks on the device
+func dockerDeviceStep(device string) prepStep {
+	apply := []string{
+		fmt.Sprintf("{ ! findmnt --noheadings --source %v >/dev/null || umount %v; }", device, device),
+		fmt.Sprintf(`sed -i "\|^%v\s|d" /etc/fstab`, device),
+		fmt.Sprintf("wipefs --all --force %v", device),
+	}
+	return prepStep{
+		name: "docker device",
+		check: fmt.Sprintf("[ -b %[1]v ] && ! findmnt --noheadings --source %[1]v >/dev/null && ! blkid -p %[1]v >/dev/null",
+			device),
+		apply: strings.Join(apply, " && "),
+	}
+}
+
+// prepareNode runs the steps that are not yet in effect on the node
+func prepareNode(ctx context.Context, node Node, steps []prepStep) error {
+	for _, step := range steps {
+		log := node.Logger().WithField("step", step.name)
+		done, err := checkStep(ctx, node, step)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if done {
+			log.Info("Already applied.")
+			continue
+		}
+		err = sshutil.Run(ctx, node.Client(), log, prepCmd(step.apply), nil)
+		if err != nil {
+			log.WithError(err).Warn("Failed to apply.")
+			return trace.Wrap(err, "failed to apply %q on %v", step.name, node)
+		}
+		log.Info("Applied.")
+	}
+	return nil
+}
+
+// verifyNode fails unless all steps are in effect on the node
+func verifyNode(ctx context.Context, node Node, steps []prepStep) error {
+	var failed []string
+	for _, step := range steps {
+		done, err := checkStep(ctx, node, step)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if !done {
+			failed = append(failed, step.name)
+		}
+	}
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return trace.CompareFailed("node %v failed verification: %v", node, strings.Join(failed, ", "))
+	}
+	node.Logger().WithField("steps", len(steps)).Info("Node preparation verified.")
+	return nil
+}
+
+// checkStep returns true if the step is in effect on the node
+func checkStep(ctx context.Context, node Node, step prepStep) (bool, error) {
+	err := sshutil.RunAndParse(ctx, node.Client(), node.Logger().WithField("step", step.name),
+		prepCmd(step.check), nil, sshutil.ParseDiscard)
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := trace.Unwrap(err).(sshutil.ExitStatusError); ok {
+		return false, nil
+	}
+	return false, trace.Wrap(err, "failed to check %q on %v", step.name, node)
+}
+
+// prepCmd returns the command to run the specified preparation script as root
+func prepCmd(script string) string {
+	return fmt.Sprintf("sudo bash -c '%v'", script)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeprep
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/robotest/infra/oscatalog"
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOSDriverSelection(t *testing.T) {
+	var testCases = []struct {
+		image    oscatalog.Image
+		expected osDriver
+	}{
+		{image: oscatalog.Image{Vendor: "ubuntu", PackageManager: "apt"}, expected: aptDriver{}},
+		{image: oscatalog.Image{Vendor: "rocky", PackageManager: "dnf"}, expected: yumDriver{binary: "dnf"}},
+		{image: oscatalog.Image{Vendor: "centos"}, expected: yumDriver{binary: "yum"}},
+		{image: oscatalog.Image{Vendor: "sles"}, expected: zypperDriver{}},
+	}
+	for _, tc := range testCases {
+		driver, err := newOSDriver(tc.image)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, driver, "%v", tc.image)
+	}
+
+	_, err := newOSDriver(oscatalog.Image{Vendor: "windows"})
+	assert.True(t, trace.IsNotImplemented(err), "expected not implemented, got %v", err)
+}
+
+func TestNodePrepSteps(t *testing.T) {
+	config := Config{Provider: constants.Azure, EtcdDevice: "/dev/sdb", DockerDevice: "/dev/sdc"}
+	for _, driver := range []osDriver{aptDriver{}, yumDriver{binary: "yum"}, zypperDriver{}} {
+		steps := nodePrepSteps(driver, config)
+		var names []string
+		for _, step := range steps {
+			names = append(names, step.name)
+			assertValidBash(t, sudoScript(t, prepCmd(step.check)))
+			assertValidBash(t, sudoScript(t, prepCmd(step.apply)))
+		}
+		assert.Equal(t, []string{"host time sync", "hostname", "packages"}, names[:3], "%T", driver)
+		assert.Equal(t, []string{"aws cli", "kernel modules", "sysctls", "etcd device", "gravity directories", "docker device"},
+			names[len(names)-6:], "%T", driver)
+	}
+}
+
+func TestNodePrepStepsWithoutDevices(t *testing.T) {
+	steps := nodePrepSteps(zypperDriver{}, Config{Provider: constants.GCE})
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	assert.Equal(t, []string{"packages", "aws cli", "kernel modules", "sysctls", "gravity directories"}, names)
+}
+
+func TestPackageCommands(t *testing.T) {
+	packages := []string{"chrony", "lvm2"}
+	assert.Equal(t, `dpkg -s chrony 2>/dev/null | grep -q "^Status: install ok installed" && `+
+		`dpkg -s lvm2 2>/dev/null | grep -q "^Status: install ok installed"`,
+		aptDriver{}.installedCmd(packages))
+	assert.Equal(t, "dnf install -y chrony lvm2", yumDriver{binary: "dnf"}.installCmd(packages))
+	assert.Equal(t, "rpm -q chrony lvm2 >/dev/null", zypperDriver{}.installedCmd(packages))
+	assert.Equal(t, "zypper --non-interactive install chrony lvm2", zypperDriver{}.installCmd(packages))
+}
+
+func TestDisableServiceStep(t *testing.T) {
+	step := disableServiceStep("dnsmasq")
+	assert.Equal(t, "! systemctl is-active --quiet dnsmasq && ! systemctl is-enabled --quiet dnsmasq", step.check)
+	assert.Equal(t, "systemctl disable --now dnsmasq", step.apply)
+}
+
+func TestKernelModulesStep(t *testing.T) {
+	step := kernelModulesStep([]string{"overlay", "br_netfilter"})
+	assert.Equal(t, "[ -d /sys/module/overlay ] && grep -qx overlay /etc/modules-load.d/telekube.conf && "+
+		"[ -d /sys/module/br_netfilter ] && grep -qx br_netfilter /etc/modules-load.d/telekube.conf",
+		step.check)
+	assert.Equal(t, `modprobe overlay && modprobe br_netfilter && `+
+		`printf "%s\n" overlay br_netfilter > /etc/modules-load.d/telekube.conf`, step.apply)
+}
+
+func TestSysctlStep(t *testing.T) {
+	step := sysctlStep([]sysctl{
+		{key: "net.ipv4.ip_forward", value: "1"},
+		{key: "fs.may_detach_mounts", value: "1", optional: true},
+	})
+	assert.Equal(t, `[ "$(sysctl -n net.ipv4.ip_forward)" = "1" ] && `+
+		`grep -qx net.ipv4.ip_forward=1 /etc/sysctl.d/50-telekube.conf && `+
+		`{ [ ! -e /proc/sys/fs/may_detach_mounts ] || { [ "$(sysctl -n fs.may_detach_mounts)" = "1" ] && `+
+		`grep -qx fs.may_detach_mounts=1 /etc/sysctl.d/50-telekube.conf; }; }`, step.check)
+	assert.Equal(t, "{ echo net.ipv4.ip_forward=1; "+
+		"if [ -e /proc/sys/fs/may_detach_mounts ]; then echo fs.may_detach_mounts=1; fi; } "+
+		"> /etc/sysctl.d/50-telekube.conf && sysctl -p /etc/sysctl.d/50-telekube.conf", step.apply)
+}
+
+func TestDeviceSteps(t *testing.T) {
+	etcd := etcdDeviceStep("/dev/sdb")
+	assert.Contains(t, etcd.apply, "{ blkid -p /dev/sdb >/dev/null || mkfs.ext4 -F -q /dev/sdb; }",
+		"existing filesystem is kept")
+	assert.True(t, strings.HasSuffix(etcd.apply, "mount "+EtcdDir))
+
+	docker := dockerDeviceStep("/dev/sdc")
+	assert.Equal(t, "[ -b /dev/sdc ] && ! findmnt --noheadings --source /dev/sdc >/dev/null && "+
+		"! blkid -p /dev/sdc >/dev/null", docker.check)
+	assert.True(t, strings.HasSuffix(docker.apply, "wipefs --all --force /dev/sdc"))
+}
+
+func TestGravityDirsStep(t *testing.T) {
+	step := gravityDirsStep([]string{"/var/lib/gravity", "/var/lib/data"})
+	assert.Equal(t, `[ "$(stat -c %u:%g /var/lib/gravity)" = "1000:1000" ] && `+
+		`[ "$(stat -c %u:%g /var/lib/data)" = "1000:1000" ] && `+
+		`[ "$(stat -c %u:%g /var/lib/gravity/planet/etcd)" = "1000:1000" ]`, step.check)
+	assert.Equal(t, "mkdir -p /var/lib/gravity /var/lib/data /var/lib/gravity/planet/etcd && "+
+		"chown -R 1000:1000 /var/lib/gravity /var/lib/data", step.apply)
+}
+
+func TestInstallCmd(t *testing.T) {
+	cmd, err := InstallCmd(oscatalog.Image{Vendor: "centos"}, "fio")
+	require.NoError(t, err)
+	assert.Equal(t, "sudo bash -c 'yum install -y fio'", cmd)
+
+	_, err = InstallCmd(oscatalog.Image{Vendor: "windows"}, "fio")
+	assert.True(t, trace.IsNotImplemented(err), "expected not implemented, got %v", err)
+}
+
+func TestPrepareRejectsEtcdDevice(t *testing.T) {
+	config := Config{
+		Provider:     constants.GCE,
+		Image:        oscatalog.Image{Vendor: "ubuntu", PackageManager: "apt"},
+		EtcdDevice:   "/dev/sdb",
+		DockerDevice: "/dev/sdb",
+	}
+	err := Prepare(context.TODO(), nil, config)
+	assert.True(t, trace.IsBadParameter(err), "expected bad parameter, got %v", err)
+}
+
+// sudoScript returns the script run by cmd with sudo bash -c
+func sudoScript(t *testing.T, cmd string) string {
+	const prefix = "sudo bash -c '"
+	require.True(t, strings.HasPrefix(cmd, prefix) && strings.HasSuffix(cmd, "'"), cmd)
+	script := strings.TrimSuffix(strings.TrimPrefix(cmd, prefix), "'")
+	require.NotContains(t, script, "'", "script must not break out of single quotes")
+	return script
+}
+
+func assertValidBash(t *testing.T, script string) {
+	out, err := exec.Command("bash", "-n", "-c", script).CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
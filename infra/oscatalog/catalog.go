@@ -0,0 +1,209 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oscatalog describes the operating systems robotest can provision
+// with each cloud provider
+package oscatalog
+
+import (
+	_ "embed" // embeds the default catalog
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
+)
+
+// FileName is the name of the catalog file looked up in the provisioner script directory
+const FileName = "os_catalog.yaml"
+
+// Catalog lists the known OS images
+type Catalog struct {
+	// Images lists the OS images.
+	// Earlier entries take precedence over later entries matching the same OS
+	Images []Image `yaml:"images"`
+}
+
+// Image describes an OS image available with a cloud provider
+type Image struct {
+	// Provider names the cloud provider the image is available with.
+	// Empty matches any provider
+	Provider string `yaml:"provider"`
+	// Vendor names the OS vendor, i.e. ubuntu or centos
+	Vendor string `yaml:"vendor"`
+	// Version is the OS version, either complete (i.e. 7.9) or major (i.e. 7).
+	// Empty matches any version
+	Version string `yaml:"version"`
+	// Image is the provider-specific image reference, i.e. a GCE image.
+	// Empty to use the default image of the provisioner scripts
+	Image string `yaml:"image"`
+	// SSHUser is the user to access the nodes with
+	SSHUser string `yaml:"ssh_user"`
+	// BootstrapScript is the path to the bootstrap script relative
+	// to the provisioner script directory.
+	// Defaults to bootstrap/<vendor>.sh
+	BootstrapScript string `yaml:"bootstrap_script"`
+	// JournalctlPath is the path to journalctl on the nodes.
+	// Defaults to journalctl from $PATH
+	JournalctlPath string `yaml:"journalctl"`
+	// PackageManager names the package manager of the OS, i.e. apt or yum
+	PackageManager string `yaml:"package_manager"`
+}
+
+// CheckAndSetDefaults validates this image and sets defaults
+func (r *Image) CheckAndSetDefaults() error {
+	if r.Vendor == "" {
+		return trace.BadParameter("image vendor is required")
+	}
+	if strings.Contains(r.Vendor, ":") || strings.Contains(r.Version, ":") {
+		return trace.BadParameter("image %v: vendor and version must not contain ':'", r)
+	}
+	if r.BootstrapScript == "" {
+		r.BootstrapScript = filepath.Join("bootstrap", fmt.Sprintf("%v.sh", r.Vendor))
+	}
+	if r.JournalctlPath == "" {
+		r.JournalctlPath = "journalctl"
+	}
+	return nil
+}
+
+// String returns a textual representation of this image
+func (r Image) String() string {
+	version := r.Version
+	if version == "" {
+		version = "*"
+	}
+	return fmt.Sprintf("%v/%v:%v", r.Provider, r.Vendor, version)
+}
+
+// Load reads the catalog from the file at path
+func Load(path string) (*Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	catalog, err := parse(data)
+	if err != nil {
+		return nil, trace.Wrap(err, "invalid OS catalog %v", path)
+	}
+	return catalog, nil
+}
+
+// LoadDir reads the catalog file from the specified directory merged over
+// the default catalog. Returns the default catalog if the directory
+// has no catalog file
+func LoadDir(dir string) (*Catalog, error) {
+	catalog, err := Load(filepath.Join(dir, FileName))
+	if err != nil {
+		if trace.IsNotFound(err) {
+			return Default(), nil
+		}
+		return nil, trace.Wrap(err)
+	}
+	return catalog.Merge(Default()), nil
+}
+
+// Default returns the built-in catalog
+func Default() *Catalog {
+	return defaultCatalog
+}
+
+// Merge returns a new catalog with the images of this catalog taking
+// precedence over the images of other
+func (r *Catalog) Merge(other *Catalog) *Catalog {
+	images := make([]Image, 0, len(r.Images)+len(other.Images))
+	images = append(images, r.Images...)
+	images = append(images, other.Images...)
+	return &Catalog{Images: images}
+}
+
+// Lookup returns the image for the specified provider and OS given as
+// vendor[:version].
+// An image for the exact version is preferred over the image for the major
+// version which is in turn preferred over the image for any version.
+// OS without version matches images of any version.
+// An empty provider matches images of any provider
+func (r *Catalog) Lookup(provider, osName string) (*Image, error) {
+	vendor, version := splitOS(osName)
+	matches := func(image Image) bool {
+		return image.Vendor == vendor &&
+			(provider == "" || image.Provider == "" || image.Provider == provider)
+	}
+	if version == "" {
+		for _, image := range r.Images {
+			if matches(image) {
+				return &image, nil
+			}
+		}
+		return nil, trace.NotFound("OS %q is not in the catalog for provider %q", osName, provider)
+	}
+	versions := []string{version}
+	if major := strings.SplitN(version, ".", 2)[0]; major != version {
+		versions = append(versions, major)
+	}
+	versions = append(versions, "")
+	for _, version := range versions {
+		for _, image := range r.Images {
+			if matches(image) && image.Version == version {
+				return &image, nil
+			}
+		}
+	}
+	return nil, trace.NotFound("OS %q is not in the catalog for provider %q", osName, provider)
+}
+
+// Validate verifies that the specified OS is in the catalog for the provider
+func (r *Catalog) Validate(provider, osName string) error {
+	_, err := r.Lookup(provider, osName)
+	return trace.Wrap(err)
+}
+
+func splitOS(osName string) (vendor, version string) {
+	split := strings.SplitN(osName, ":", 2)
+	if len(split) == 1 {
+		return split[0], ""
+	}
+	return split[0], split[1]
+}
+
+func parse(data []byte) (*Catalog, error) {
+	var catalog Catalog
+	err := yaml.UnmarshalStrict(data, &catalog)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for i := range catalog.Images {
+		if err := catalog.Images[i].CheckAndSetDefaults(); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return &catalog, nil
+}
+
+//go:embed default.yaml
+var defaultCatalogData []byte
+
+var defaultCatalog = mustParse(defaultCatalogData)
+
+func mustParse(data []byte) *Catalog {
+	catalog, err := parse(data)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default OS catalog: %v", err))
+	}
+	return catalog
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oscatalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupPrefersMostSpecificVersion(t *testing.T) {
+	catalog, err := parse([]byte(`
+images:
+- {provider: gce, vendor: centos, ssh_user: any}
+- {provider: gce, vendor: centos, version: "7", ssh_user: major}
+- {provider: gce, vendor: centos, version: "7.9", ssh_user: exact}
+- {vendor: centos, version: "8", ssh_user: anyprovider}
+`))
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		provider, os string
+		user         string
+	}{
+		{provider: "gce", os: "centos:7.9", user: "exact"},
+		{provider: "gce", os: "centos:7.8", user: "major"},
+		{provider: "gce", os: "centos:7", user: "major"},
+		{provider: "gce", os: "centos:6", user: "any"},
+		{provider: "gce", os: "centos:8", user: "anyprovider"},
+		{provider: "gce", os: "centos", user: "any"},
+		{provider: "aws", os: "centos:8.2", user: "anyprovider"},
+	}
+	for _, tc := range testCases {
+		image, err := catalog.Lookup(tc.provider, tc.os)
+		require.NoError(t, err, "%v/%v", tc.provider, tc.os)
+		assert.Equal(t, tc.user, image.SSHUser, "%v/%v", tc.provider, tc.os)
+	}
+
+	_, err = catalog.Lookup("aws", "centos:7")
+	assert.True(t, trace.IsNotFound(err), "expected not found, got %v", err)
+	_, err = catalog.Lookup("gce", "rocky:8")
+	assert.True(t, trace.IsNotFound(err), "expected not found, got %v", err)
+}
+
+func TestImageDefaults(t *testing.T) {
+	catalog, err := parse([]byte(`
+images:
+- {provider: gce, vendor: ubuntu, ssh_user: ubuntu}
+`))
+	require.NoError(t, err)
+	image, err := catalog.Lookup("gce", "ubuntu:18")
+	require.NoError(t, err)
+	assert.Equal(t, "bootstrap/ubuntu.sh", image.BootstrapScript)
+	assert.Equal(t, "journalctl", image.JournalctlPath)
+
+	_, err = parse([]byte(`
+images:
+- {provider: gce, ssh_user: ubuntu}
+`))
+	assert.Error(t, err, "vendor is required")
+	_, err = parse([]byte(`
+images:
+- {provider: gce, vendor: ubuntu, user: ubuntu}
+`))
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestLoadDirMergesOverDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oscatalog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	catalog, err := LoadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), catalog, "no catalog file")
+
+	err = ioutil.WriteFile(filepath.Join(dir, FileName), []byte(`
+images:
+- {provider: gce, vendor: ubuntu, version: "20", ssh_user: robotest}
+- {provider: gce, vendor: rocky, version: "8", image: rocky-linux-cloud/rocky-linux-8, ssh_user: rocky}
+`), 0644)
+	require.NoError(t, err)
+	catalog, err = LoadDir(dir)
+	require.NoError(t, err)
+
+	image, err := catalog.Lookup("gce", "rocky:8")
+	require.NoError(t, err)
+	assert.Equal(t, "rocky-linux-cloud/rocky-linux-8", image.Image)
+	image, err = catalog.Lookup("gce", "ubuntu:20")
+	require.NoError(t, err)
+	assert.Equal(t, "robotest", image.SSHUser, "catalog file takes precedence")
+	image, err = catalog.Lookup("gce", "ubuntu:18")
+	require.NoError(t, err)
+	assert.Equal(t, "ubuntu", image.SSHUser, "defaults are retained")
+}
+
+func TestAssetCatalogs(t *testing.T) {
+	for _, provider := range []string{"aws", "azure", "gce"} {
+		_, err := LoadDir(filepath.Join("..", "..", "assets", "terraform", provider))
+		assert.NoError(t, err, provider)
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package aws
 
+import sshutils "github.com/gravitational/robotest/lib/ssh"
+
 // Config describes AWS EC2 test configuration
 type Config struct {
 	// AccessKey http://docs.aws.amazon.com/general/latest/gr/managing-aws-access-keys.html
@@ -48,9 +50,17 @@ type Config struct {
 	ClusterName string `json:"cluster_name" yaml:"cluster_name"`
 	// DockerDevice block device for docker data - set to /dev/xvdb
 	DockerDevice string `json:"docker_device" yaml:"docker_device" validate:"required"`
+	// EtcdDevice block device for etcd data. Defaults to DefaultEtcdDevice
+	EtcdDevice string `json:"etcd_device,omitempty" yaml:"etcd_device"`
+	// JumpHosts optionally lists the SSH jump hosts to connect to the nodes
+	// through, in order. Nodes are then accessed on their private addresses
+	JumpHosts []sshutils.JumpHost `json:"jump_hosts,omitempty" yaml:"jump_hosts" validate:"omitempty,dive"`
 }
 
 // IsEmpty determines whether this configuration is empty
 func (r Config) IsEmpty() bool {
 	return r.AccessKey == "" && r.SecretKey == ""
 }
+
+// DefaultEtcdDevice is the etcd data device attached by assets/terraform/aws/node.tf
+const DefaultEtcdDevice = "/dev/xvdc"
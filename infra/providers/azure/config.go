@@ -16,6 +16,8 @@ limitations under the License.
 
 package azure
 
+import sshutils "github.com/gravitational/robotest/lib/ssh"
+
 // Config specifies Azure Cloud specific parameters
 type Config struct {
 	// SubscriptionId https://docs.microsoft.com/en-us/azure/azure-resource-manager/resource-group-create-service-principal-portal
@@ -42,4 +44,12 @@ type Config struct {
 	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
 	// DockerDevice block device for docker data - set to /dev/sdd
 	DockerDevice string `json:"docker_device" yaml:"docker_device" validate:"required"`
+	// EtcdDevice block device for etcd data. Defaults to DefaultEtcdDevice
+	EtcdDevice string `json:"etcd_device,omitempty" yaml:"etcd_device"`
+	// JumpHosts optionally lists the SSH jump hosts to connect to the nodes
+	// through, in order. Nodes are then accessed on their private addresses
+	JumpHosts []sshutils.JumpHost `json:"jump_hosts,omitempty" yaml:"jump_hosts" validate:"omitempty,dive"`
 }
+
+// DefaultEtcdDevice is the etcd data device attached by assets/terraform/azure/node.tf
+const DefaultEtcdDevice = "/dev/sdc"
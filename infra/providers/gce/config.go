@@ -16,7 +16,11 @@ limitations under the License.
 
 package gce
 
-import "github.com/gravitational/trace"
+import (
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+)
 
 // Config specifies Google Compute Engine specific parameters
 type Config struct {
@@ -55,6 +59,18 @@ type Config struct {
 	Network string `json:"network" yaml:"network"`
 	// Subnet specifies the GCP subnet the nodes will reside upon.
 	Subnet string `json:"subnet" yaml:"subnet"`
+	// Preemptible specifies whether to provision preemptible VMs.
+	// https://cloud.google.com/compute/docs/instances/preemptible
+	Preemptible bool `json:"preemptible" yaml:"preemptible"`
+	// Bastion specifies whether to provision the nodes without public addresses
+	// and an SSH bastion host to access them through.
+	// The subnet must provide outbound connectivity, i.e. with Cloud NAT
+	Bastion bool `json:"bastion" yaml:"bastion"`
+	// EtcdDevice block device for etcd data. Defaults to DefaultEtcdDevice
+	EtcdDevice string `json:"-" yaml:"etcd_device"`
+	// JumpHosts optionally lists the SSH jump hosts to connect to the nodes
+	// through, in order. Nodes are then accessed on their private addresses
+	JumpHosts []sshutils.JumpHost `json:"jump_hosts,omitempty" yaml:"jump_hosts" validate:"omitempty,dive"`
 }
 
 func (c *Config) CheckAndSetDefaults() error {
@@ -85,5 +101,13 @@ func (c *Config) CheckAndSetDefaults() error {
 	if c.Subnet == "" {
 		c.Subnet = "default"
 	}
+	for i := range c.JumpHosts {
+		if err := c.JumpHosts[i].CheckAndSetDefaults(); err != nil {
+			return trace.Wrap(err, "gcp config")
+		}
+	}
 	return nil
 }
+
+// DefaultEtcdDevice is the etcd data device attached by assets/terraform/gce/node.tf
+const DefaultEtcdDevice = "/dev/sdb"
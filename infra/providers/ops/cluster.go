@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+// Cluster is the cluster resource of the Ops Center
+type Cluster struct {
+	Kind     string          `json:"kind"`
+	Version  string          `json:"version"`
+	Metadata ClusterMetadata `json:"metadata"`
+	Spec     ClusterSpec     `json:"spec"`
+}
+
+// ClusterMetadata describes the cluster resource
+type ClusterMetadata struct {
+	// Name is the cluster name
+	Name string `json:"name"`
+	// Labels are the cluster labels
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ClusterSpec is the cluster specification
+type ClusterSpec struct {
+	// App is the application to install
+	App string `json:"app"`
+	// Provider is the cloud provider to provision the cluster with
+	Provider string `json:"provider"`
+	// AWS specifies the AWS parameters
+	AWS *ClusterAWS `json:"aws,omitempty"`
+	// Nodes lists the node profiles to provision
+	Nodes []ClusterNodes `json:"nodes"`
+	// Status is the cluster status, set for existing clusters only
+	Status string `json:"status,omitempty"`
+}
+
+// ClusterAWS specifies the AWS parameters of the cluster
+type ClusterAWS struct {
+	// KeyName is the name of the EC2 key pair
+	KeyName string `json:"keyName"`
+	// Region is the EC2 region
+	Region string `json:"region"`
+}
+
+// ClusterNodes describes the nodes of a profile
+type ClusterNodes struct {
+	// Profile is the node profile
+	Profile string `json:"profile"`
+	// Count is the number of nodes
+	Count int `json:"count"`
+	// InstanceType is the EC2 instance type
+	InstanceType string `json:"instanceType"`
+}
+
+const (
+	// KindCluster is the kind of the cluster resource
+	KindCluster = "cluster"
+	// ClusterVersion is the version of the cluster resource
+	ClusterVersion = "v2"
+
+	// ClusterInstalling is the status of the cluster being installed
+	ClusterInstalling = "installing"
+	// ClusterActive is the status of the installed cluster
+	ClusterActive = "active"
+	// ClusterUninstalling is the status of the cluster being removed
+	ClusterUninstalling = "uninstalling"
+)
@@ -16,12 +16,17 @@ limitations under the License.
 
 package ops
 
+import sshutils "github.com/gravitational/robotest/lib/ssh"
+
 // Config specified Ops Center specific parameters
 type Config struct {
 	// URL to the ops center to use for deployment
 	URL string `json:"url" yaml:"url" validate:"required"`
 	// OpsKey is the key to connect to the ops center
 	OpsKey string `json:"ops_key" yaml:"ops_key" validate:"required"`
+	// OpsKeys optionally lists additional keys to connect to the ops center.
+	// Concurrent tests are given different keys while there are enough
+	OpsKeys []string `json:"ops_keys,omitempty" yaml:"ops_keys"`
 	// App is the ops center application to deploy
 	App string `json:"app" yaml:"app" validate:"required"`
 	// EC2AccessKey http://docs.aws.amazon.com/general/latest/gr/managing-aws-access-keys.html
@@ -35,4 +40,12 @@ type Config struct {
 	SSHKeyPath string `json:"key_path" yaml:"key_path"`
 	// SSHUser defines SSH user used to connect to the provisioned machines
 	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
+	// JumpHosts optionally lists the SSH jump hosts to connect to the nodes
+	// through, in order. Nodes are then accessed on their private addresses
+	JumpHosts []sshutils.JumpHost `json:"jump_hosts,omitempty" yaml:"jump_hosts" validate:"omitempty,dive"`
+}
+
+// Keys returns all configured keys to connect to the ops center
+func (r Config) Keys() []string {
+	return append([]string{r.OpsKey}, r.OpsKeys...)
 }
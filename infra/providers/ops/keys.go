@@ -0,0 +1,56 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import "sync"
+
+// KeyPool hands out the Ops Center keys to the tests.
+// Every test leases the least used key, so concurrent tests use
+// different keys while there are enough
+type KeyPool struct {
+	sync.Mutex
+	keys []string
+	// leases counts the tests using each key
+	leases map[string]int
+}
+
+// NewKeyPool returns a new pool of the specified keys
+func NewKeyPool(keys []string) *KeyPool {
+	return &KeyPool{keys: keys, leases: make(map[string]int)}
+}
+
+// Acquire leases the least used key.
+// The returned function releases the lease
+func (r *KeyPool) Acquire() (key string, release func()) {
+	r.Lock()
+	defer r.Unlock()
+	key = r.keys[0]
+	for _, k := range r.keys[1:] {
+		if r.leases[k] < r.leases[key] {
+			key = k
+		}
+	}
+	r.leases[key]++
+	var once sync.Once
+	return key, func() {
+		once.Do(func() {
+			r.Lock()
+			defer r.Unlock()
+			r.leases[key]--
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPool(t *testing.T) {
+	pool := NewKeyPool(Config{OpsKey: "key1", OpsKeys: []string{"key2"}}.Keys())
+
+	key1, release1 := pool.Acquire()
+	key2, release2 := pool.Acquire()
+	require.Equal(t, "key1", key1)
+	require.Equal(t, "key2", key2)
+
+	// keys are shared once all are in use
+	key3, release3 := pool.Acquire()
+	require.Equal(t, "key1", key3)
+
+	release2()
+	release2()
+	key4, _ := pool.Acquire()
+	require.Equal(t, "key2", key4)
+
+	release1()
+	release3()
+	key5, _ := pool.Acquire()
+	require.Equal(t, "key1", key5)
+}
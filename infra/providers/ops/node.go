@@ -31,14 +31,18 @@ type node struct {
 	privateIP  string
 	sshKeyPath string
 	sshUser    string
+	jumpHosts  []sshutils.JumpHost
 }
 
-func New(publicIP string, privateIP string, sshUser string, sshKeyPath string) infra.Node {
+// New returns a new node. Nodes with jump hosts are accessed
+// through the jump hosts on their private address
+func New(publicIP string, privateIP string, sshUser string, sshKeyPath string, jumpHosts []sshutils.JumpHost) infra.Node {
 	res := &node{
 		publicIP:   publicIP,
 		privateIP:  privateIP,
 		sshKeyPath: sshKeyPath,
 		sshUser:    sshUser,
+		jumpHosts:  jumpHosts,
 	}
 
 	return res
@@ -66,8 +70,15 @@ func (r *node) Client() (*ssh.Client, error) {
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+	if len(r.jumpHosts) == 0 {
+		return sshutils.Client(fmt.Sprintf("%v:22", r.publicIP), r.sshUser, signer)
+	}
+	hops, err := sshutils.LoadHops(r.jumpHosts)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
 
-	return sshutils.Client(fmt.Sprintf("%v:22", r.publicIP), r.sshUser, signer)
+	return sshutils.ClientVia(hops, fmt.Sprintf("%v:22", r.privateIP), r.sshUser, signer)
 }
 
 func (r node) String() string {
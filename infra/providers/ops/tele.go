@@ -0,0 +1,143 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
+)
+
+// TeleClient manages the clusters of an Ops Center with the tele binary.
+// tele keeps the login profile in the home directory, so every client
+// runs tele with its own home directory to not share the profile
+// with concurrent tests
+type TeleClient struct {
+	opsURL string
+	key    string
+	// homeDir is the home directory of tele
+	homeDir string
+	// binary is the path to the tele binary
+	binary string
+}
+
+// NewTeleClient returns a new tele client for the Ops Center at opsURL
+// authenticated with the given API key, keeping its state in stateDir
+func NewTeleClient(opsURL, key, stateDir string) (*TeleClient, error) {
+	if opsURL == "" {
+		return nil, trace.BadParameter("Ops Center URL is required")
+	}
+	if key == "" {
+		return nil, trace.BadParameter("Ops Center API key is required")
+	}
+	homeDir := filepath.Join(stateDir, "tele")
+	if err := os.MkdirAll(homeDir, constants.SharedDirMask); err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	return &TeleClient{
+		opsURL:  opsURL,
+		key:     key,
+		homeDir: homeDir,
+		binary:  "tele",
+	}, nil
+}
+
+// Login logs into the Ops Center
+func (c *TeleClient) Login(ctx context.Context) error {
+	_, err := c.run(ctx, "login", "-o", c.opsURL, "--key", c.key)
+	return trace.Wrap(err, "failed to log into Ops Center %v", c.opsURL)
+}
+
+// CreateCluster requests the Ops Center to provision and install the cluster
+func (c *TeleClient) CreateCluster(ctx context.Context, cluster Cluster) error {
+	// tele reads resources as YAML, which JSON is a subset of
+	data, err := json.MarshalIndent(cluster, "", "  ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	path := filepath.Join(c.homeDir, fmt.Sprintf("%v.json", cluster.Metadata.Name))
+	if err := ioutil.WriteFile(path, data, constants.SharedReadMask); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	_, err = c.run(ctx, "create", path)
+	return trace.Wrap(err)
+}
+
+// ClusterStatus returns the status of the cluster with the given name.
+// Returns trace.NotFound if the cluster does not exist
+func (c *TeleClient) ClusterStatus(ctx context.Context, name string) (string, error) {
+	out, err := c.run(ctx, "get", "clusters", name, "--format", "yaml")
+	if err != nil {
+		if isClusterNotFound(name, out) {
+			return "", trace.NotFound("cluster %v not found", name)
+		}
+		return "", trace.Wrap(err)
+	}
+	return parseClusterStatus(name, out)
+}
+
+// DeleteCluster requests the Ops Center to uninstall and deprovision the cluster
+func (c *TeleClient) DeleteCluster(ctx context.Context, name string) error {
+	_, err := c.run(ctx, "rm", "cluster", name)
+	return trace.Wrap(err)
+}
+
+// run executes tele with the specified arguments and returns its output.
+// Errors include the output of tele
+func (c *TeleClient) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, c.binary, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("HOME=%v", c.homeDir))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, trace.Wrap(err, "tele %v: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// parseClusterStatus returns the cluster status from the output of tele get clusters
+func parseClusterStatus(name string, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", trace.BadParameter("missing cluster data")
+	}
+	if isClusterNotFound(name, data) {
+		return "", trace.NotFound("cluster %v not found", name)
+	}
+	var cluster struct {
+		Spec struct {
+			Status string `yaml:"status"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &cluster); err != nil {
+		return "", trace.Wrap(err, "invalid cluster %v: %s", name, data)
+	}
+	return cluster.Spec.Status, nil
+}
+
+// isClusterNotFound returns true if the tele output reports that the cluster does not exist
+func isClusterNotFound(name string, out []byte) bool {
+	return strings.Contains(string(out), fmt.Sprintf("cluster %v not found", name))
+}
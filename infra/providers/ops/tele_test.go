@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is the API key used by the tests
+const testKey = "robotest-key"
+
+func TestParseClusterStatus(t *testing.T) {
+	_, err := parseClusterStatus("kevin-ci.22.7", []byte("cluster kevin-ci.22.7 not found"))
+	require.True(t, trace.IsNotFound(err), "expected not found error: %v", err)
+
+	status, err := parseClusterStatus("kevin-ci.22.2", []byte(`kind: cluster
+metadata:
+  labels:
+    Name: kevin-ci.22.2
+  name: kevin-ci.22.2
+spec:
+  app: ci:1.0.0-ci.22
+  aws:
+    keyName: ops
+    region: us-east-2
+  nodes: null
+  provider: aws
+  status: failed
+version: v2`))
+	require.NoError(t, err)
+	require.Equal(t, "failed", status)
+}
+
+func TestTeleClient(t *testing.T) {
+	stateDir := t.TempDir()
+	client, err := NewTeleClient("https://ops.example.com", testKey, stateDir)
+	require.NoError(t, err)
+	// the stand-in for tele records its arguments and home directory
+	client.binary = filepath.Join(t.TempDir(), "tele")
+	script := `#!/bin/sh
+echo "$HOME $@" >> "$HOME/calls"
+if [ "$1" = get ]; then echo "cluster $3 not found"; exit 1; fi
+`
+	require.NoError(t, ioutil.WriteFile(client.binary, []byte(script), 0755))
+	ctx := context.Background()
+
+	require.NoError(t, client.Login(ctx))
+	require.NoError(t, client.CreateCluster(ctx, Cluster{Metadata: ClusterMetadata{Name: "robotest-1"}}))
+	_, err = client.ClusterStatus(ctx, "robotest-1")
+	require.True(t, trace.IsNotFound(err), "expected not found error: %v", err)
+	require.NoError(t, client.DeleteCluster(ctx, "robotest-1"))
+
+	homeDir := filepath.Join(stateDir, "tele")
+	calls, err := ioutil.ReadFile(filepath.Join(homeDir, "calls"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		homeDir + " login -o https://ops.example.com --key " + testKey,
+		homeDir + " create " + filepath.Join(homeDir, "robotest-1.json"),
+		homeDir + " get clusters robotest-1 --format yaml",
+		homeDir + " rm cluster robotest-1",
+	}, strings.Split(strings.TrimSpace(string(calls)), "\n"))
+}
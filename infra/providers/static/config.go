@@ -0,0 +1,88 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"strings"
+
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+)
+
+// Config describes an inventory of existing hosts to run tests on
+type Config struct {
+	// Hosts lists the hosts in the inventory
+	Hosts []Host `json:"hosts" yaml:"hosts" validate:"required,min=1,dive"`
+	// CleanupScript specifies the location of an optional script to run
+	// on every host after gravity has been removed to reset it for the next test
+	CleanupScript string `json:"cleanup_script" yaml:"cleanup_script"`
+	// DockerDevice block device for docker data
+	DockerDevice string `json:"docker_device" yaml:"docker_device" validate:"required"`
+	// JumpHosts optionally lists the SSH jump hosts to connect to the hosts
+	// through, in order
+	JumpHosts []sshutils.JumpHost `json:"jump_hosts,omitempty" yaml:"jump_hosts" validate:"omitempty,dive"`
+}
+
+// Host describes a single host in the inventory
+type Host struct {
+	// Addr is the address used to connect to the host
+	Addr string `json:"addr" yaml:"addr" validate:"required"`
+	// PrivateAddr is the address the cluster is installed on.
+	// Defaults to Addr
+	PrivateAddr string `json:"private_addr" yaml:"private_addr"`
+	// SSHUser defines SSH user used to connect to the host
+	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
+	// SSHKeyPath specifies the location of the SSH key to use for remote access
+	SSHKeyPath string `json:"key_path" yaml:"key_path" validate:"required"`
+}
+
+// CheckAndSetDefaults validates this configuration and sets defaults
+func (r *Config) CheckAndSetDefaults() error {
+	if len(r.Hosts) == 0 {
+		return trace.BadParameter("inventory has no hosts")
+	}
+	addrs := make(map[string]struct{}, len(r.Hosts))
+	for i := range r.Hosts {
+		host := &r.Hosts[i]
+		if host.Addr == "" {
+			return trace.BadParameter("host %v has no address", i)
+		}
+		if _, exists := addrs[host.Addr]; exists {
+			return trace.BadParameter("host %v is listed more than once", host.Addr)
+		}
+		addrs[host.Addr] = struct{}{}
+		if host.PrivateAddr == "" {
+			host.PrivateAddr = host.Addr
+		}
+	}
+	for i := range r.JumpHosts {
+		if err := r.JumpHosts[i].CheckAndSetDefaults(); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// ID returns a key identifying this inventory
+func (r Config) ID() string {
+	addrs := make([]string, 0, len(r.Hosts))
+	for _, host := range r.Hosts {
+		addrs = append(addrs, host.Addr)
+	}
+	return strings.Join(addrs, ",")
+}
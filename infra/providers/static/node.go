@@ -0,0 +1,72 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"fmt"
+
+	"github.com/gravitational/robotest/infra"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+type node struct {
+	host      Host
+	jumpHosts []sshutils.JumpHost
+}
+
+// New returns a new node for the specified inventory host
+// accessed through the optional jump hosts
+func New(host Host, jumpHosts []sshutils.JumpHost) infra.Node {
+	return &node{host: host, jumpHosts: jumpHosts}
+}
+
+func (r *node) Addr() string {
+	return r.host.Addr
+}
+
+func (r *node) PrivateAddr() string {
+	return r.host.PrivateAddr
+}
+
+func (r *node) Connect() (*ssh.Session, error) {
+	client, err := r.Client()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return client.NewSession()
+}
+
+func (r *node) Client() (*ssh.Client, error) {
+	signer, err := sshutils.MakePrivateKeySignerFromFile(r.host.SSHKeyPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	hops, err := sshutils.LoadHops(r.jumpHosts)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return sshutils.ClientVia(hops, fmt.Sprintf("%v:22", r.host.Addr), r.host.SSHUser, signer)
+}
+
+func (r node) String() string {
+	return fmt.Sprintf("node(addr=%v, private_addr=%v)", r.host.Addr, r.host.PrivateAddr)
+}
@@ -18,10 +18,12 @@ package terraform
 
 import (
 	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/oscatalog"
 	"github.com/gravitational/robotest/infra/providers/aws"
 	"github.com/gravitational/robotest/infra/providers/azure"
 	"github.com/gravitational/robotest/infra/providers/gce"
 	"github.com/gravitational/robotest/lib/constants"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
 
 	"github.com/gravitational/trace"
 	"gopkg.in/go-playground/validator.v9"
@@ -43,6 +45,14 @@ func (c *Config) Validate() error {
 		return trace.NewAggregate(errors...)
 	}
 
+	catalog := c.Catalog
+	if catalog == nil {
+		catalog = oscatalog.Default()
+	}
+	if err := catalog.Validate(c.CloudProvider, c.OS); err != nil {
+		return trace.Wrap(err)
+	}
+
 	switch c.CloudProvider {
 	case constants.AWS:
 		if c.AWS == nil {
@@ -59,12 +69,43 @@ func (c *Config) Validate() error {
 			return trace.BadParameter("Azure SSH access configuration is required")
 		}
 	case constants.GCE:
-		return trace.Wrap(c.GCE.CheckAndSetDefaults())
+		if err := c.GCE.CheckAndSetDefaults(); err != nil {
+			return trace.Wrap(err)
+		}
 	}
 
+	return trace.Wrap(c.validateNodeGroups())
+}
+
+// validateNodeGroups verifies that node groups are uniquely named
+// and add up to the number of nodes
+func (c *Config) validateNodeGroups() error {
+	if len(c.NodeGroups) == 0 {
+		return nil
+	}
+	names := make(map[string]struct{}, len(c.NodeGroups))
+	total := 0
+	for _, group := range c.NodeGroups {
+		if _, exists := names[group.Name]; exists {
+			return trace.BadParameter("node group %q is defined more than once", group.Name)
+		}
+		names[group.Name] = struct{}{}
+		total += group.Count
+	}
+	if total != c.NumNodes {
+		return trace.BadParameter("node groups define %v nodes, expected %v", total, c.NumNodes)
+	}
 	return nil
 }
 
+// NodeCount returns the total number of nodes in the specified groups
+func NodeCount(groups []NodeGroup) (count int) {
+	for _, group := range groups {
+		count += group.Count
+	}
+	return count
+}
+
 func (c Config) SSHConfig() (user, keypath string) {
 	switch c.CloudProvider {
 	case constants.AWS:
@@ -78,6 +119,26 @@ func (c Config) SSHConfig() (user, keypath string) {
 	}
 }
 
+// JumpHosts returns the configured SSH jump hosts to access the nodes through
+func (c Config) JumpHosts() []sshutils.JumpHost {
+	switch c.CloudProvider {
+	case constants.AWS:
+		return c.AWS.JumpHosts
+	case constants.Azure:
+		return c.Azure.JumpHosts
+	case constants.GCE:
+		return c.GCE.JumpHosts
+	default:
+		return nil
+	}
+}
+
+// Bastion returns true if the nodes are provisioned without public addresses
+// along with an SSH bastion host
+func (c Config) Bastion() bool {
+	return c.CloudProvider == constants.GCE && c.GCE != nil && c.GCE.Bastion
+}
+
 // Config represents terraform provisioning configuration
 type Config struct {
 	// Config specifies common infrastructure configuration
@@ -90,8 +151,17 @@ type Config struct {
 	Azure *azure.Config
 	// GCE defines Google Compute Engine connection parameters
 	GCE *gce.Config
-	// OS specified the OS distribution
-	OS string `json:"os" yaml:"os" validate:"required,eq=ubuntu|eq=redhat|eq=centos|eq=debian|eq=sles|eq=suse"`
+	// OS specifies the OS distribution as vendor[:version].
+	// It is validated against the OS catalog
+	OS string `json:"os" yaml:"os" validate:"required"`
+	// Image optionally overrides the OS image of the terraform scripts
+	Image string `json:"image,omitempty" yaml:"image,omitempty"`
+	// BootstrapScript optionally overrides the path to the bootstrap script
+	// relative to ScriptPath
+	BootstrapScript string `json:"bootstrap_script,omitempty" yaml:"bootstrap_script,omitempty"`
+	// Catalog is the OS catalog to validate OS against.
+	// Defaults to the built-in catalog
+	Catalog *oscatalog.Catalog `json:"-" yaml:"-"`
 	// ScriptPath is the path to the terraform script or directory for provisioning
 	ScriptPath string `json:"script_path" validate:"required"`
 	// NumNodes defines the capacity of the cluster to provision
@@ -109,4 +179,21 @@ type Config struct {
 	OnpremProvider bool `json:"onprem_provider" yaml:"onprem_provider"`
 	// PluginDir is the directory terraform plugins reside in
 	PluginDir string `json:"plugin_dir,omitempty" yaml:"plugin_dir,omitempty"`
+	// NodeGroups optionally splits the nodes into named groups of differently
+	// configured nodes. Node counts of all groups must add up to NumNodes
+	NodeGroups []NodeGroup `json:"node_groups,omitempty" yaml:"node_groups,omitempty" validate:"omitempty,dive"`
+}
+
+// NodeGroup describes a named group of identically configured nodes
+type NodeGroup struct {
+	// Name identifies the group
+	Name string `json:"name" yaml:"name" validate:"required"`
+	// Count is the number of nodes in the group
+	Count int `json:"count" yaml:"count" validate:"gte=1"`
+	// VMType overrides the type of VM to provision for nodes in the group
+	VMType string `json:"vm_type,omitempty" yaml:"vm_type"`
+	// Disks lists the sizes (in GB) of extra disks attached to every node in the group
+	Disks []int `json:"disks,omitempty" yaml:"disks"`
+	// Labels are attached to the VMs of the group
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels"`
 }
@@ -0,0 +1,155 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import (
+	"bytes"
+
+	"github.com/gravitational/trace"
+)
+
+// FailureReason classifies terraform failures
+type FailureReason string
+
+const (
+	// FailureUnknown is a failure of unknown reason, possibly transient
+	FailureUnknown FailureReason = "unknown"
+	// FailureQuota means that a cloud quota has been exceeded
+	FailureQuota FailureReason = "quota"
+	// FailureCapacity means that the cloud has no capacity for the requested resources
+	FailureCapacity FailureReason = "capacity"
+	// FailureImage means that the VM image does not exist or is invalid
+	FailureImage FailureReason = "image"
+	// FailureAuth means that the cloud credentials are invalid or lack permissions
+	FailureAuth FailureReason = "auth"
+)
+
+// Regional returns true if the failure is specific to the region (or zone)
+// and provisioning might succeed in another one
+func (r FailureReason) Regional() bool {
+	return r == FailureQuota || r == FailureCapacity
+}
+
+// Permanent returns true if the failure is caused by configuration
+// and retrying is pointless
+func (r FailureReason) Permanent() bool {
+	return r == FailureImage || r == FailureAuth
+}
+
+// ClassifyFailure determines the failure reason from the terraform output
+func ClassifyFailure(output []byte) FailureReason {
+	output = bytes.ToLower(output)
+	// Checked in order as output might match several reasons,
+	// i.e. failures to authenticate are often reported as unavailable resources
+	for _, reason := range []FailureReason{FailureAuth, FailureImage, FailureQuota, FailureCapacity} {
+		for _, pattern := range failurePatterns[reason] {
+			if bytes.Contains(output, []byte(pattern)) {
+				return reason
+			}
+		}
+	}
+	return FailureUnknown
+}
+
+// FailureReasonOf returns the reason of the terraform failure err
+func FailureReasonOf(err error) FailureReason {
+	switch err := trace.Unwrap(err).(type) {
+	case *ApplyError:
+		return err.Reason
+	case trace.Aggregate:
+		for _, err := range err.Errors() {
+			if reason := FailureReasonOf(err); reason != FailureUnknown {
+				return reason
+			}
+		}
+	}
+	return FailureUnknown
+}
+
+// ApplyError is the failure to apply terraform configuration
+type ApplyError struct {
+	// Reason is the classified failure reason
+	Reason FailureReason
+	// Err is the original error
+	Err error
+}
+
+// Error returns the error message
+func (r *ApplyError) Error() string {
+	return r.Err.Error()
+}
+
+// failurePatterns lists lowercase output fragments of AWS, Azure and GCE errors
+// for each failure reason
+var failurePatterns = map[FailureReason][]string{
+	FailureAuth: {
+		// AWS
+		"authfailure",
+		"unauthorizedoperation",
+		"invalidclienttokenid",
+		"signaturedoesnotmatch",
+		"no valid credential sources",
+		// Azure
+		"authorizationfailed",
+		"invalidauthenticationtoken",
+		"aadsts",
+		// GCE
+		"oauth2: cannot fetch token",
+		"invalid_grant",
+		"could not find default credentials",
+		"error 401",
+		"error 403: required",
+		"permission denied on resource",
+	},
+	FailureImage: {
+		// AWS
+		"invalidamiid",
+		// Azure
+		"platformimagenotfound",
+		"imagenotfound",
+		// GCE
+		"error resolving image name",
+		"sourceimage",
+	},
+	FailureQuota: {
+		// AWS
+		"instancelimitexceeded",
+		"vcpulimitexceeded",
+		"addresslimitexceeded",
+		"vpclimitexceeded",
+		// Azure
+		"quotaexceeded",
+		"exceeding approved",
+		// GCE
+		"quota_exceeded",
+		"quotaexceeded",
+	},
+	FailureCapacity: {
+		// AWS
+		"insufficientinstancecapacity",
+		"insufficientcapacity",
+		"your requested instance type",
+		// Azure
+		"allocationfailed",
+		"zonalallocationfailed",
+		"skunotavailable",
+		// GCE
+		"zone_resource_pool_exhausted",
+		"does not have enough resources available",
+		"resource_availability",
+	},
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gravitational/trace"
+)
+
+func TestClassifiesFailures(t *testing.T) {
+	var testCases = []struct {
+		output string
+		reason FailureReason
+	}{
+		{
+			output: "Error: Error creating instance: googleapi: Error 403: Quota 'CPUS' exceeded.  Limit: 24.0 in region us-west1., quotaExceeded",
+			reason: FailureQuota,
+		},
+		{
+			output: "Error: Error waiting for instance to create: The zone 'projects/robotest/zones/us-west1-b' does not have enough resources available to fulfill the request.",
+			reason: FailureCapacity,
+		},
+		{
+			output: "Error: Error launching source instance: InsufficientInstanceCapacity: We currently do not have sufficient c5.2xlarge capacity",
+			reason: FailureCapacity,
+		},
+		{
+			output: "Error: Error launching source instance: InvalidAMIID.NotFound: The image id '[ami-12345678]' does not exist",
+			reason: FailureImage,
+		},
+		{
+			output: "Error: error retrieving account details: AuthFailure: AWS was not able to validate the provided access credentials",
+			reason: FailureAuth,
+		},
+		{
+			output: `Error: compute.VirtualMachinesClient#CreateOrUpdate: Failure sending request: StatusCode=409 Code="OperationNotAllowed" Message="Operation results in exceeding approved standardFSFamily Cores quota."`,
+			reason: FailureQuota,
+		},
+		{
+			output: "Error: Error creating Network: Post https://compute.googleapis.com/: dial tcp: i/o timeout",
+			reason: FailureUnknown,
+		},
+	}
+	for _, tc := range testCases {
+		reason := ClassifyFailure([]byte(tc.output))
+		if reason != tc.reason {
+			t.Errorf("Expected %q for %q, got %q.", tc.reason, tc.output, reason)
+		}
+	}
+}
+
+func TestFindsFailureReason(t *testing.T) {
+	err := trace.Wrap(&ApplyError{Reason: FailureCapacity, Err: errors.New("exit status 1")}, "failed to boot terraform cluster")
+	err = trace.Wrap(err, "terraform failed")
+	if reason := FailureReasonOf(err); reason != FailureCapacity {
+		t.Errorf("Expected capacity failure, got %q.", reason)
+	}
+
+	aggregate := trace.NewAggregate(err, trace.ConnectionProblem(nil, "failed to destroy"))
+	if reason := FailureReasonOf(aggregate); reason != FailureCapacity {
+		t.Errorf("Expected capacity failure from aggregate, got %q.", reason)
+	}
+
+	if reason := FailureReasonOf(errors.New("exit status 1")); reason != FailureUnknown {
+		t.Errorf("Expected unknown failure, got %q.", reason)
+	}
+
+	if !FailureQuota.Regional() || FailureQuota.Permanent() {
+		t.Error("Expected quota failures to be regional.")
+	}
+	if !FailureAuth.Permanent() || FailureAuth.Regional() {
+		t.Error("Expected auth failures to be permanent.")
+	}
+}
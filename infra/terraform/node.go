@@ -26,6 +26,8 @@ type node struct {
 	owner     *terraform
 	publicIP  string
 	privateIP string
+	// group names the node group this node belongs to
+	group string
 }
 
 func (r *node) Addr() string {
@@ -36,12 +38,26 @@ func (r *node) PrivateAddr() string {
 	return r.privateIP
 }
 
+// Group returns the name of the node group this node belongs to
+func (r *node) Group() string {
+	return r.group
+}
+
 func (r *node) Connect() (*ssh.Session, error) {
-	return r.owner.Connect(fmt.Sprintf("%v:22", r.publicIP))
+	return r.owner.Connect(r.sshAddr())
 }
 
 func (r *node) Client() (*ssh.Client, error) {
-	return r.owner.Client(fmt.Sprintf("%v:22", r.publicIP))
+	return r.owner.Client(r.sshAddr())
+}
+
+// sshAddr returns the address to connect to the node on.
+// Nodes behind jump hosts are accessed on their private address
+func (r *node) sshAddr() string {
+	if r.owner.viaJumpHosts() {
+		return fmt.Sprintf("%v:22", r.privateIP)
+	}
+	return fmt.Sprintf("%v:22", r.publicIP)
 }
 
 func (r node) String() string {
@@ -80,6 +80,7 @@ func NewFromState(config Config, stateConfig infra.ProvisionerState) (*terraform
 	switch specific := stateConfig.Specific.(type) {
 	case *State:
 		t.loadbalancerIP = specific.LoadBalancerAddr
+		t.bastionIP = specific.BastionAddr
 	}
 
 	t.sshUser, t.sshKeyPath = config.SSHConfig()
@@ -173,19 +174,35 @@ func (r *terraform) loadFromState(rdr io.Reader) error {
 		return trace.Wrap(err)
 	}
 
-	if len(outputs.PublicAddrs.Addrs) == 0 {
+	publicAddrs := outputs.PublicAddrs.Addrs
+	r.bastionIP = outputs.BastionAddr.Addr
+	if r.bastionIP != "" && len(publicAddrs) == 0 {
+		// nodes behind the bastion have no public addresses
+		publicAddrs = outputs.PrivateAddrs.Addrs
+	}
+	if len(publicAddrs) == 0 {
 		// one of the reasons is that public IP allocation is incomplete yet
 		// which happens for Azure; we will just repeat boot process once again
 		return trace.NotFound("terraform output contains no public node IPs")
 	}
 
-	nodes := make([]infra.Node, 0, len(outputs.PublicAddrs.Addrs))
-	for i, addr := range outputs.PublicAddrs.Addrs {
-		nodes = append(nodes, &node{
+	groups := outputs.NodeGroups.Groups
+	if len(groups) != 0 && len(groups) != len(publicAddrs) {
+		return trace.BadParameter("terraform output lists %v node groups for %v nodes",
+			len(groups), len(publicAddrs))
+	}
+
+	nodes := make([]infra.Node, 0, len(publicAddrs))
+	for i, addr := range publicAddrs {
+		node := &node{
 			privateIP: outputs.PrivateAddrs.Addrs[i],
 			publicIP:  addr,
 			owner:     r,
-		})
+		}
+		if len(groups) != 0 {
+			node.group = groups[i]
+		}
+		nodes = append(nodes, node)
 	}
 	r.pool = infra.NewNodePool(nodes, nil)
 
@@ -259,8 +276,31 @@ func (r *terraform) Client(addr string) (*ssh.Client, error) {
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+	hops, err := sshutils.LoadHops(r.jumpHosts())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return sshutils.ClientVia(hops, addr, r.sshUser, signer)
+}
+
+// jumpHosts returns the jump hosts to access the nodes through.
+// The provisioned bastion is the last hop
+func (r *terraform) jumpHosts() []sshutils.JumpHost {
+	jumpHosts := append([]sshutils.JumpHost{}, r.Config.JumpHosts()...)
+	if r.bastionIP != "" {
+		jumpHosts = append(jumpHosts, sshutils.JumpHost{
+			Addr:       r.bastionIP,
+			SSHUser:    r.sshUser,
+			SSHKeyPath: r.sshKeyPath,
+		})
+	}
+	return jumpHosts
+}
 
-	return sshutils.Client(addr, r.sshUser, signer)
+// viaJumpHosts returns true if the nodes are accessed through jump hosts
+func (r *terraform) viaJumpHosts() bool {
+	return r.bastionIP != "" || len(r.Config.JumpHosts()) != 0
 }
 
 func (r *terraform) StartInstall(session *ssh.Session) error {
@@ -305,6 +345,7 @@ func (r *terraform) State() infra.ProvisionerState {
 		Allocated:     allocated,
 		Specific: &State{
 			LoadBalancerAddr: r.loadbalancerIP,
+			BastionAddr:      r.bastionIP,
 		},
 	}
 }
@@ -335,6 +376,7 @@ func (r *terraform) boot(ctx context.Context) (rc io.ReadCloser, err error) {
 
 	out, err = r.command(ctx, applyCommand)
 	if err != nil {
+		err = &ApplyError{Reason: ClassifyFailure(out), Err: err}
 		return nil, trace.Wrap(err, "failed to boot terraform cluster: %s", out)
 	}
 
@@ -417,6 +459,18 @@ func configToTerraformVars(cfg Config) (tfvars map[string]interface{}, err error
 		if cfg.GCE.Zone != "" {
 			tfvars["zone"] = cfg.GCE.Zone
 		}
+		if cfg.GCE.Preemptible {
+			tfvars["preemptible"] = "true"
+		}
+		if cfg.GCE.Bastion {
+			tfvars["bastion"] = "true"
+		}
+		if cfg.Image != "" {
+			tfvars["image"] = cfg.Image
+		}
+		if cfg.BootstrapScript != "" {
+			tfvars["bootstrap_script"] = cfg.BootstrapScript
+		}
 	default:
 		return nil, trace.BadParameter("invalid cloud provider: %v", cfg.CloudProvider)
 	}
@@ -424,10 +478,33 @@ func configToTerraformVars(cfg Config) (tfvars map[string]interface{}, err error
 	// the following are common to all cloud providers, and are critical TF vars
 	tfvars["os"] = cfg.OS
 	tfvars["nodes"] = cfg.NumNodes
+	if len(cfg.NodeGroups) != 0 {
+		tfvars["node_groups"] = nodeGroupsToTerraformVars(cfg.NodeGroups)
+	}
 
 	return tfvars, nil
 }
 
+// converts node groups into a list of objects with all attributes set
+// as terraform expects (see node_groups in assets/terraform/gce/config.tf)
+func nodeGroupsToTerraformVars(groups []NodeGroup) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(groups))
+	for _, group := range groups {
+		labels := make(map[string]string, len(group.Labels))
+		for key, value := range group.Labels {
+			labels[key] = value
+		}
+		result = append(result, map[string]interface{}{
+			"name":    group.Name,
+			"count":   group.Count,
+			"vm_type": group.VMType,
+			"disks":   append([]int{}, group.Disks...),
+			"labels":  labels,
+		})
+	}
+	return result
+}
+
 // serializes the relevant parts of the test config to a terraform vars file
 func (r *terraform) saveTerraformVars(varFile string) error {
 	tfvars, err := configToTerraformVars(r.Config)
@@ -463,6 +540,8 @@ func (r *State) MarshalJSON() ([]byte, error) {
 type State struct {
 	// LoadBalancerAddr defines the DNS name of the load balancer
 	LoadBalancerAddr string `json:"loadbalancer"`
+	// BastionAddr defines the address of the SSH bastion host
+	BastionAddr string `json:"bastion,omitempty"`
 }
 
 // terraform is the terraform-based infrastructure provider
@@ -476,6 +555,8 @@ type terraform struct {
 	stateDir       string
 	installerIP    string
 	loadbalancerIP string
+	// bastionIP is the public address of the provisioned SSH bastion host
+	bastionIP string
 }
 
 type outputs struct {
@@ -505,4 +586,12 @@ type outputs struct {
 	InstallerAddr struct {
 		Addr string `json:"value"`
 	} `json:"installer_ip"`
+	// NodeGroups lists the node group of every node in the order of PublicAddrs
+	NodeGroups struct {
+		Groups []string `json:"value"`
+	} `json:"node_groups"`
+	// BastionAddr specifies the public IP address of the SSH bastion host
+	BastionAddr struct {
+		Addr string `json:"value"`
+	} `json:"bastion_ip"`
 }
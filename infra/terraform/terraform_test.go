@@ -19,9 +19,13 @@ package terraform
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/gravitational/robotest/infra"
 	"github.com/gravitational/robotest/infra/providers/gce"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func TestConvertConfigToTerraformVars(t *testing.T) {
@@ -91,6 +95,8 @@ func TestConvertConfigToTerraformVarsOptionalValues(t *testing.T) {
 		NodeTag:          "unittest",
 		Network:          "unittest",
 		Subnet:           "unittest",
+		Preemptible:      true,
+		Bastion:          true,
 	}
 	cfg := Config{
 		CloudProvider: "gce",
@@ -125,6 +131,8 @@ func TestConvertConfigToTerraformVarsOptionalValues(t *testing.T) {
 	expected["node_tag"] = "unittest"
 	expected["network"] = "unittest"
 	expected["subnet"] = "unittest"
+	expected["preemptible"] = "true"
+	expected["bastion"] = "true"
 
 	b, err := json.Marshal(configMap)
 	if err != nil {
@@ -138,3 +146,112 @@ func TestConvertConfigToTerraformVarsOptionalValues(t *testing.T) {
 		t.Errorf("\ngot:\t\t%q\nexpected:\t%q", b, e)
 	}
 }
+
+func TestConvertConfigToTerraformVarsNodeGroups(t *testing.T) {
+	gceConfig := gce.Config{
+		Credentials:      "/robotest/gce-creds.json",
+		Project:          "unittesting",
+		VMType:           "excellent",
+		SSHUser:          "ubuntu",
+		SSHPublicKeyPath: "/robotest/.ssh/robo.pub",
+		SSHKeyPath:       "/robotest/.ssh/robo",
+		NodeTag:          "unittest",
+	}
+	cfg := Config{
+		CloudProvider: "gce",
+		GCE:           &gceConfig,
+		OS:            "ubuntu",
+		ScriptPath:    "/robotest/assets/terraform/gce",
+		NumNodes:      4,
+		InstallerURL:  "s3://hub.gravitational.io/gravity/oss/app/telekube/7.0.0/linux/x86_64/telekube-7.0.0-linux-x86_64.tar",
+		DockerDevice:  "/dev/xvdb",
+		NodeGroups: []NodeGroup{
+			{Name: "master", Count: 3, VMType: "n1-standard-8"},
+			{Name: "db", Count: 1, Disks: []int{100, 200}, Labels: map[string]string{"workload": "db"}},
+		},
+	}
+	err := cfg.Validate()
+	if err != nil {
+		t.Error(err)
+	}
+
+	configMap, err := configToTerraformVars(cfg)
+	if err != nil {
+		t.Error(err)
+	}
+	b, err := json.Marshal(configMap["node_groups"])
+	if err != nil {
+		t.Error(err)
+	}
+	expected := `[{"count":3,"disks":[],"labels":{},"name":"master","vm_type":"n1-standard-8"},` +
+		`{"count":1,"disks":[100,200],"labels":{"workload":"db"},"name":"db","vm_type":""}]`
+	if string(b) != expected {
+		t.Errorf("\ngot:\t\t%s\nexpected:\t%s", b, expected)
+	}
+
+	cfg.NumNodes = 3
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected node count mismatch to fail validation")
+	}
+}
+
+func TestLoadsNodeGroupsFromState(t *testing.T) {
+	r := &terraform{FieldLogger: log.StandardLogger()}
+	err := r.loadFromState(strings.NewReader(`{
+  "public_ips": {"value": ["35.0.0.1", "35.0.0.2"]},
+  "private_ips": {"value": ["10.0.0.1", "10.0.0.2"]},
+  "node_groups": {"value": ["master", "db"]}
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"35.0.0.1", "35.0.0.2"} {
+		n, err := r.pool.Node(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		group := map[string]string{"35.0.0.1": "master", "35.0.0.2": "db"}[addr]
+		if infra.NodeGroup(n) != group {
+			t.Errorf("expected node %v in group %q, got %q", addr, group, infra.NodeGroup(n))
+		}
+	}
+
+	err = r.loadFromState(strings.NewReader(`{
+  "public_ips": {"value": ["35.0.0.1", "35.0.0.2"]},
+  "private_ips": {"value": ["10.0.0.1", "10.0.0.2"]},
+  "node_groups": {"value": ["master"]}
+}`))
+	if err == nil {
+		t.Error("expected mismatched node groups to fail")
+	}
+}
+
+func TestLoadsNodesBehindBastionFromState(t *testing.T) {
+	r := &terraform{
+		FieldLogger: log.StandardLogger(),
+		Config:      Config{CloudProvider: "gce", GCE: &gce.Config{Bastion: true}},
+		sshUser:     "robotest",
+		sshKeyPath:  "/robotest/.ssh/robo",
+	}
+	err := r.loadFromState(strings.NewReader(`{
+  "public_ips": {"value": []},
+  "private_ips": {"value": ["10.0.0.1", "10.0.0.2"]},
+  "bastion_ip": {"value": "35.0.0.1"}
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2"} {
+		n, err := r.pool.Node(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sshAddr := n.(*node).sshAddr(); sshAddr != addr+":22" {
+			t.Errorf("expected node %v to be accessed on its private address, got %v", addr, sshAddr)
+		}
+	}
+	jumpHosts := r.jumpHosts()
+	if len(jumpHosts) != 1 || jumpHosts[0].Addr != "35.0.0.1" || jumpHosts[0].SSHUser != "robotest" {
+		t.Errorf("expected the bastion as the only jump host, got %v", jumpHosts)
+	}
+}
@@ -34,13 +34,18 @@ func (r *Config) Validate() error {
 }
 
 type Config struct {
-	infra.Config
+	infra.Config `yaml:"-"`
 	// ScriptPath is the path to the Vagrantfile for provisioning
-	ScriptPath string `json:"script_path"`
+	ScriptPath string `json:"script_path" yaml:"-"`
 	// InstallerURL is a path to the installer
-	InstallerURL string `json:"installer_url"`
+	InstallerURL string `json:"installer_url" yaml:"-"`
 	// NumNodes defines the capacity of the cluster to provision
-	NumNodes int `json:"nodes"`
+	NumNodes int `json:"nodes" yaml:"-"`
 	// DockerDevice block device for docker data - set to /dev/xvdb
-	DockerDevice string `json:"docker_device"`
+	DockerDevice string `json:"docker_device" yaml:"docker_device"`
+	// Box names the vagrant box to boot.
+	// Defaults to the box configured in the Vagrantfile
+	Box string `json:"box,omitempty" yaml:"box"`
+	// Boxes maps OS (as vendor:version) to the vagrant box to boot for it
+	Boxes map[string]string `json:"boxes,omitempty" yaml:"boxes"`
 }
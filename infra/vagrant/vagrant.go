@@ -314,6 +314,9 @@ func (r *vagrant) command(args []string, opts ...system.CommandOptionSetter) ([]
 	cmd := exec.Command("vagrant", args...)
 	var out bytes.Buffer
 	opts = append(opts, system.Dir(r.stateDir), system.SetEnv(fmt.Sprintf("ROBO_NUM_NODES=%v", r.Config.NumNodes)))
+	if r.Config.Box != "" {
+		opts = append(opts, system.SetEnv(fmt.Sprintf("ROBO_VAGRANT_BOX=%v", r.Config.Box)))
+	}
 	err := system.ExecL(cmd, io.MultiWriter(&out, r), r.Entry, opts...)
 	if err != nil {
 		return out.Bytes(), trace.Wrap(err, "command %q failed (args %q, wd %q)", cmd.Path, cmd.Args, cmd.Dir)
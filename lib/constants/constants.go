@@ -69,4 +69,10 @@ const (
 	GCE = "gce"
 	// Ops specifies a special cloud provider - a telekube Ops Center
 	Ops = "ops"
+	// Static specifies a fixed inventory of existing hosts
+	Static = "static"
+	// Docker specifies local containers running systemd
+	Docker = "docker"
+	// Vagrant specifies VMs managed by vagrant on the local host
+	Vagrant = "vagrant"
 )
@@ -39,6 +39,14 @@ const (
 
 	// SSHConnectTimeout defines the timeout for establishing an SSH connection
 	SSHConnectTimeout = 30 * time.Second
+	// SSHKeepAliveInterval defines the frequency of keepalive requests on managed SSH connections
+	SSHKeepAliveInterval = 15 * time.Second
+	// SSHKeepAliveTimeout defines the time to wait for a keepalive reply
+	// before the SSH transport is considered dead
+	SSHKeepAliveTimeout = 15 * time.Second
+	// SSHMaxSessions limits the number of concurrent sessions on a managed SSH connection.
+	// It is kept below the sshd MaxSessions default of 10
+	SSHMaxSessions = 8
 
 	// MinDiskSpeed is minimum write performance
 	MinDiskSpeed = uint64(1e7)
@@ -60,6 +68,10 @@ const (
 	// infrastructure upon encountering an error from 'terraform apply'
 	TerraformRetries = 2
 
+	// RegionCooldown specifies how long a cloud region is avoided after it
+	// has run out of capacity or quota
+	RegionCooldown = 30 * time.Minute
+
 	// BQDataset is the BigQuery dataset where run data is stored
 	BQDataset = "robotest"
 
@@ -0,0 +1,353 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gravitational/robotest/lib/defaults"
+	"github.com/gravitational/robotest/lib/wait"
+
+	"github.com/cenkalti/backoff"
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConnConfig configures a managed SSH connection
+type ConnConfig struct {
+	// Dial establishes a new SSH connection
+	Dial func() (*ssh.Client, error)
+	// KeepAliveInterval defines the frequency of keepalive requests
+	KeepAliveInterval time.Duration
+	// KeepAliveTimeout defines the time to wait for a keepalive reply
+	// before the transport is considered dead
+	KeepAliveTimeout time.Duration
+	// MaxSessions limits the number of concurrent sessions on the connection
+	MaxSessions int
+	// Backoff returns the backoff policy for connect attempts
+	Backoff func() backoff.BackOff
+	// FieldLogger specifies the log sink
+	logrus.FieldLogger
+}
+
+// CheckAndSetDefaults validates the configuration and sets defaults
+func (r *ConnConfig) CheckAndSetDefaults() error {
+	if r.Dial == nil {
+		return trace.BadParameter("Dial is required")
+	}
+	if r.KeepAliveInterval == 0 {
+		r.KeepAliveInterval = defaults.SSHKeepAliveInterval
+	}
+	if r.KeepAliveTimeout == 0 {
+		r.KeepAliveTimeout = defaults.SSHKeepAliveTimeout
+	}
+	if r.MaxSessions == 0 {
+		r.MaxSessions = defaults.SSHMaxSessions
+	}
+	if r.Backoff == nil {
+		r.Backoff = wait.NewUnlimitedExponentialBackoff
+	}
+	if r.FieldLogger == nil {
+		r.FieldLogger = logrus.StandardLogger()
+	}
+	return nil
+}
+
+// ConnState describes the state of a managed SSH connection
+type ConnState string
+
+const (
+	// ConnConnected is the state of a connection with a live transport
+	ConnConnected ConnState = "connected"
+	// ConnDisconnected is the state of a connection being re-established
+	ConnDisconnected ConnState = "disconnected"
+	// ConnClosed is the state of a connection that has been closed
+	ConnClosed ConnState = "closed"
+)
+
+// ConnEvent records a state transition of a managed SSH connection
+type ConnEvent struct {
+	// Time is the time of the transition
+	Time time.Time `json:"time"`
+	// State is the new connection state
+	State ConnState `json:"state"`
+	// Reason optionally describes the cause of the transition
+	Reason string `json:"reason,omitempty"`
+}
+
+// Conn is an SSH connection that is kept alive with keepalive requests
+// and transparently re-established with backoff once its transport dies.
+// The number of concurrent sessions on the connection is limited by MaxSessions
+// for all session-based functions in this package
+type Conn struct {
+	ConnConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+	// sessions is the semaphore limiting concurrent sessions
+	sessions chan struct{}
+
+	mu      sync.Mutex
+	client  *ssh.Client
+	state   ConnState
+	history []ConnEvent
+	// changed is closed and replaced on every state transition
+	changed chan struct{}
+}
+
+// NewConn establishes a new managed SSH connection.
+// It retries connecting with the configured backoff until ctx expires
+func NewConn(ctx context.Context, config ConnConfig) (*Conn, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	client, err := dialWithBackoff(ctx, config)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	connCtx, cancel := context.WithCancel(context.Background())
+	c := &Conn{
+		ConnConfig: config,
+		ctx:        connCtx,
+		cancel:     cancel,
+		sessions:   make(chan struct{}, config.MaxSessions),
+		changed:    make(chan struct{}),
+	}
+	c.connected(client)
+	return c, nil
+}
+
+// Client returns the current SSH client.
+// While the connection is being re-established, the previous (dead) client is returned
+// so commands fail fast and are expected to be retried by the caller.
+// Returns nil once the connection has been closed
+func (c *Conn) Client() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == ConnClosed {
+		return nil
+	}
+	return c.client
+}
+
+// State returns the current connection state
+func (c *Conn) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// History returns the state transitions of the connection, oldest first
+func (c *Conn) History() []ConnEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ConnEvent(nil), c.history...)
+}
+
+// Reconnect drops the current transport and waits until the connection
+// has been re-established or ctx expires
+func (c *Conn) Reconnect(ctx context.Context) error {
+	c.mu.Lock()
+	old := c.client
+	c.mu.Unlock()
+	// the monitor observes the closed transport and starts reconnecting
+	old.Close()
+	for {
+		c.mu.Lock()
+		state, client, changed := c.state, c.client, c.changed
+		c.mu.Unlock()
+		switch {
+		case state == ConnClosed:
+			return trace.ConnectionProblem(nil, "connection closed")
+		case state == ConnConnected && client != old:
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return trace.ConnectionProblem(ctx.Err(), "failed to reconnect")
+		}
+	}
+}
+
+// Close closes the connection and stops reconnect attempts
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == ConnClosed {
+		return nil
+	}
+	c.cancel()
+	conns.Delete(c.client)
+	c.setState(ConnClosed, "")
+	return trace.Wrap(c.client.Close())
+}
+
+// connected makes client the current client and starts monitoring it
+func (c *Conn) connected(client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == ConnClosed {
+		client.Close()
+		return
+	}
+	c.client = client
+	conns.Store(client, c)
+	c.setState(ConnConnected, "")
+	go c.monitor(client)
+}
+
+// monitor sends keepalive requests on client until it is closed
+// or stops responding and then reconnects
+func (c *Conn) monitor(client *ssh.Client) {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+	ticker := time.NewTicker(c.KeepAliveInterval)
+	defer ticker.Stop()
+	var err error
+Loop:
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case err = <-closed:
+			err = trace.ConnectionProblem(err, "transport closed")
+			break Loop
+		case <-ticker.C:
+			if err = keepAlive(client, c.KeepAliveTimeout); err != nil {
+				client.Close()
+				break Loop
+			}
+		}
+	}
+	c.reconnect(client, err)
+}
+
+// reconnect re-establishes the connection after the transport of client died
+func (c *Conn) reconnect(client *ssh.Client, reason error) {
+	c.mu.Lock()
+	if c.state == ConnClosed || c.client != client {
+		c.mu.Unlock()
+		return
+	}
+	conns.Delete(client)
+	c.setState(ConnDisconnected, trace.UserMessage(reason))
+	c.mu.Unlock()
+
+	c.WithError(reason).Warn("SSH connection lost, reconnecting.")
+	newClient, err := dialWithBackoff(c.ctx, c.ConnConfig)
+	if err != nil {
+		// the connection has been closed
+		return
+	}
+	c.Info("SSH connection re-established.")
+	c.connected(newClient)
+}
+
+// setState records the transition to the specified state.
+// Must be called with the lock held
+func (c *Conn) setState(state ConnState, reason string) {
+	c.state = state
+	c.history = append(c.history, ConnEvent{Time: time.Now().UTC(), State: state, Reason: reason})
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// acquire waits for a free session slot
+func (c *Conn) acquire(ctx context.Context) error {
+	select {
+	case c.sessions <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return trace.ConnectionProblem(ctx.Err(), "timed out waiting for a free SSH session")
+	}
+}
+
+// release frees a session slot
+func (c *Conn) release() {
+	<-c.sessions
+}
+
+func dialWithBackoff(ctx context.Context, config ConnConfig) (client *ssh.Client, err error) {
+	err = wait.RetryWithInterval(ctx, config.Backoff(), func() (err error) {
+		client, err = config.Dial()
+		return trace.Wrap(err)
+	}, config.FieldLogger)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return client, nil
+}
+
+// keepAlive sends a keepalive request on client and waits for the reply.
+// Any reply, including a failure one, indicates a live transport
+func keepAlive(client *ssh.Client, timeout time.Duration) error {
+	errC := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+		errC <- err
+	}()
+	select {
+	case err := <-errC:
+		if err != nil {
+			return trace.ConnectionProblem(err, "keepalive failed")
+		}
+		return nil
+	case <-time.After(timeout):
+		return trace.ConnectionProblem(nil, "no keepalive reply in %v", timeout)
+	}
+}
+
+// newSession opens a new session on client.
+// If client belongs to a managed connection, it waits for a free session slot first.
+// The returned function closes the session and frees the slot
+func newSession(ctx context.Context, client *ssh.Client) (*ssh.Session, func(), error) {
+	if client == nil {
+		// i.e. the managed connection has been closed
+		return nil, nil, trace.ConnectionProblem(nil, "no SSH connection")
+	}
+	value, managed := conns.Load(client)
+	if !managed {
+		session, err := client.NewSession()
+		if err != nil {
+			return nil, nil, trace.Wrap(err)
+		}
+		return session, func() { session.Close() }, nil
+	}
+	conn := value.(*Conn)
+	if err := conn.acquire(ctx); err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		conn.release()
+		return nil, nil, trace.Wrap(err)
+	}
+	return session, func() {
+		session.Close()
+		conn.release()
+	}, nil
+}
+
+// conns maps SSH clients to the managed connections they belong to
+var conns sync.Map
+
+const keepAliveRequest = "keepalive@openssh.com"
@@ -0,0 +1,248 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnReconnectsAfterTransportLoss(t *testing.T) {
+	d := newTestDialer(t)
+	conn := newTestConn(t, ConnConfig{Dial: d.dial})
+
+	old := conn.Client()
+	d.dropAll()
+
+	require.Eventually(t, func() bool {
+		return conn.State() == ConnConnected && conn.Client() != old
+	}, 5*time.Second, 10*time.Millisecond)
+	session, err := conn.Client().NewSession()
+	require.NoError(t, err)
+	assert.NoError(t, session.Run("true"))
+	assert.Equal(t, []ConnState{ConnConnected, ConnDisconnected, ConnConnected}, states(conn.History()))
+}
+
+func TestConnKeepsHealthyTransport(t *testing.T) {
+	d := newTestDialer(t)
+	conn := newTestConn(t, ConnConfig{
+		Dial:              d.dial,
+		KeepAliveInterval: 10 * time.Millisecond,
+		KeepAliveTimeout:  time.Second,
+	})
+
+	client := conn.Client()
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	// the session outlives many keepalive intervals
+	require.NoError(t, session.Run("sleep 300ms"))
+
+	assert.Equal(t, client, conn.Client())
+	assert.Equal(t, []ConnState{ConnConnected}, states(conn.History()))
+	assert.Equal(t, 1, d.dials())
+}
+
+func TestConnDetectsDeadTransport(t *testing.T) {
+	d := newTestDialer(t)
+	conn := newTestConn(t, ConnConfig{
+		Dial:              d.dial,
+		KeepAliveInterval: 10 * time.Millisecond,
+		KeepAliveTimeout:  50 * time.Millisecond,
+	})
+
+	old := conn.Client()
+	// the transport stays open but stops delivering data
+	d.stall()
+
+	require.Eventually(t, func() bool {
+		return conn.State() == ConnConnected && conn.Client() != old
+	}, 5*time.Second, 10*time.Millisecond)
+	history := conn.History()
+	require.True(t, len(history) >= 2)
+	assert.Equal(t, ConnDisconnected, history[1].State)
+	assert.Contains(t, history[1].Reason, "keepalive")
+}
+
+func TestConnReconnect(t *testing.T) {
+	d := newTestDialer(t)
+	conn := newTestConn(t, ConnConfig{Dial: d.dial})
+
+	old := conn.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, conn.Reconnect(ctx))
+	assert.NotEqual(t, old, conn.Client())
+	assert.Equal(t, ConnConnected, conn.State())
+}
+
+func TestConnLimitsSessions(t *testing.T) {
+	d := newTestDialer(t)
+	conn := newTestConn(t, ConnConfig{Dial: d.dial, MaxSessions: 2})
+	ctx := context.Background()
+
+	var closers []func()
+	for i := 0; i < 2; i++ {
+		_, closeSession, err := newSession(ctx, conn.Client())
+		require.NoError(t, err)
+		closers = append(closers, closeSession)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, _, err := newSession(timeoutCtx, conn.Client())
+	require.Error(t, err)
+
+	closers[0]()
+	_, closeSession, err := newSession(ctx, conn.Client())
+	require.NoError(t, err)
+	closeSession()
+	closers[1]()
+}
+
+func TestConnClose(t *testing.T) {
+	d := newTestDialer(t)
+	conn, err := NewConn(context.Background(), ConnConfig{Dial: d.dial})
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+	assert.Nil(t, conn.Client())
+	assert.Equal(t, ConnClosed, conn.State())
+	assert.Equal(t, []ConnState{ConnConnected, ConnClosed}, states(conn.History()))
+	assert.Equal(t, 1, d.dials())
+
+	// commands on a closed connection fail instead of panicking
+	err = Run(context.Background(), conn.Client(), logrus.New(), "true", nil)
+	assert.True(t, trace.IsConnectionProblem(err), "expected connection problem, got %v", err)
+}
+
+func newTestConn(t *testing.T, config ConnConfig) *Conn {
+	config.Backoff = func() backoff.BackOff {
+		return backoff.NewConstantBackOff(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConn(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func states(history []ConnEvent) (result []ConnState) {
+	for _, event := range history {
+		result = append(result, event.State)
+	}
+	return result
+}
+
+// testDialer connects to a test SSH server and keeps track
+// of the underlying network connections
+type testDialer struct {
+	addr   string
+	signer ssh.Signer
+
+	mu    sync.Mutex
+	conns []*stallConn
+}
+
+func newTestDialer(t *testing.T) *testDialer {
+	signer := newSigner(t)
+	return &testDialer{
+		addr:   startServer(t, "robotest", signer.PublicKey()),
+		signer: signer,
+	}
+}
+
+func (r *testDialer) dial() (*ssh.Client, error) {
+	netConn, err := net.Dial("tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &stallConn{Conn: netConn, stalled: make(chan struct{})}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, r.addr, clientConfig("robotest", r.signer))
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	r.mu.Lock()
+	r.conns = append(r.conns, conn)
+	r.mu.Unlock()
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// dropAll closes all network connections
+func (r *testDialer) dropAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+}
+
+// stall stops data delivery on all current network connections
+func (r *testDialer) stall() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range r.conns {
+		conn.stall()
+	}
+}
+
+func (r *testDialer) dials() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// stallConn is a network connection that can be made to stop
+// delivering data without being closed
+type stallConn struct {
+	net.Conn
+	once    sync.Once
+	stalled chan struct{}
+}
+
+func (r *stallConn) stall() {
+	r.once.Do(func() { close(r.stalled) })
+}
+
+// Read discards incoming data once the connection has stalled
+// and blocks until the connection is closed
+func (r *stallConn) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	select {
+	case <-r.stalled:
+		_, err = io.Copy(ioutil.Discard, r.Conn)
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	default:
+		return n, err
+	}
+}
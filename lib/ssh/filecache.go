@@ -0,0 +1,326 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/sync/singleflight"
+)
+
+// FileCache downloads remote files on the runner once and uploads them
+// to the nodes, for nodes that cannot reach the file source themselves.
+// Cached files are kept in the cache directory across runs, but only reused
+// in later runs if their checksum is known, as files without a checksum
+// might have been republished under the same URL
+type FileCache struct {
+	dir   string
+	group singleflight.Group
+	// mu guards downloaded
+	mu sync.Mutex
+	// downloaded lists the paths of the files downloaded by this cache instance
+	downloaded map[string]bool
+	// fetchers maps URL schemes to the functions that download from them
+	fetchers map[string]fetchFunc
+	// client is the HTTP client for http(s) downloads
+	client *http.Client
+	// gcsEndpoint is the endpoint of the Google Cloud Storage XML API
+	gcsEndpoint string
+}
+
+// fetchFunc writes the contents of the remote file at u to w.
+// Returns trace.NotFound if the file does not exist
+type fetchFunc func(ctx context.Context, u *url.URL, env map[string]string, w io.Writer) error
+
+// NewFileCache returns a new cache that keeps files in the directory dir
+func NewFileCache(dir string) *FileCache {
+	c := &FileCache{
+		dir:         dir,
+		downloaded:  make(map[string]bool),
+		client:      http.DefaultClient,
+		gcsEndpoint: "https://storage.googleapis.com",
+	}
+	c.fetchers = map[string]fetchFunc{
+		"s3":    c.fetchS3,
+		"gs":    c.fetchGCS,
+		"http":  c.fetchHTTP,
+		"https": c.fetchHTTP,
+	}
+	return c
+}
+
+// TransferFile downloads the file at fileUrl to the cache unless it is already cached
+// and uploads it to the remote directory dstDir.
+// Local files are uploaded directly.
+// The file is verified as described in TransferFile.
+// env specifies the AWS credentials for S3 downloads in the same variables as on the nodes
+func (c *FileCache) TransferFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, fileUrl, dstDir string, env map[string]string) (path string, err error) {
+	file, err := ParseFileURL(fileUrl)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	log = log.WithFields(logrus.Fields{"file_url": fileUrl, "dst_dir": dstDir})
+
+	if file.URL == nil {
+		return putLocalFile(ctx, client, log, file, dstDir)
+	}
+
+	localPath, checksum, err := c.Get(ctx, log, file, env)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	remotePath, err := PutFile(ctx, client, log, localPath, dstDir)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	err = VerifyChecksum(ctx, client, log, remotePath, checksum)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return remotePath, nil
+}
+
+// Get returns the path to the cached copy of the remote file and its checksum,
+// downloading the file if it has not been cached yet.
+// Concurrent requests for the same file share a single download which is
+// not interrupted if one of the callers gives up
+func (c *FileCache) Get(ctx context.Context, log logrus.FieldLogger, file *FileURL, env map[string]string) (path, checksum string, err error) {
+	key := file.URL.String()
+	resultC := c.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
+		defer cancel()
+		path, checksum, err := c.get(ctx, log, file, env)
+		return cachedFile{path: path, checksum: checksum}, err
+	})
+	select {
+	case result := <-resultC:
+		if result.Err != nil {
+			return "", "", trace.Wrap(result.Err)
+		}
+		cached := result.Val.(cachedFile)
+		return cached.path, cached.checksum, nil
+	case <-ctx.Done():
+		return "", "", trace.Wrap(ctx.Err())
+	}
+}
+
+func (c *FileCache) get(ctx context.Context, log logrus.FieldLogger, file *FileURL, env map[string]string) (path, checksum string, err error) {
+	fetch, ok := c.fetchers[file.URL.Scheme]
+	if !ok {
+		return "", "", trace.BadParameter("unsupported URL schema %s", file.URL)
+	}
+
+	expected := file.Checksum
+	if expected == "" {
+		expected, err = c.sidecarChecksum(ctx, log, fetch, file.URL, env)
+		if err != nil {
+			return "", "", trace.Wrap(err)
+		}
+	}
+
+	// files are kept in a directory per URL to keep their names
+	key := sha256.Sum256([]byte(file.URL.String()))
+	dir := filepath.Join(c.dir, hex.EncodeToString(key[:8]))
+	path = filepath.Join(dir, file.Name())
+	log = log.WithField("path", path)
+
+	checksum, err = fileChecksum(path)
+	if err == nil && (expected == checksum || (expected == "" && c.isDownloaded(path))) {
+		log.Debug("Using cached file.")
+		return path, checksum, nil
+	}
+	if err != nil && !trace.IsNotFound(err) {
+		return "", "", trace.Wrap(err)
+	}
+
+	log.Info("Downloading file to cache.")
+	if err := os.MkdirAll(dir, constants.SharedDirMask); err != nil {
+		return "", "", trace.ConvertSystemError(err)
+	}
+	tmp, err := ioutil.TempFile(dir, file.Name()+".*.partial")
+	if err != nil {
+		return "", "", trace.ConvertSystemError(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	err = fetch(ctx, file.URL, env, io.MultiWriter(tmp, hash))
+	if err != nil {
+		return "", "", trace.Wrap(err, "downloading %v", file.URL)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", "", trace.ConvertSystemError(err)
+	}
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && expected != checksum {
+		return "", "", trace.CompareFailed("checksum mismatch for %v: expected %v, got %v", file.URL, expected, checksum)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", "", trace.ConvertSystemError(err)
+	}
+	c.mu.Lock()
+	c.downloaded[path] = true
+	c.mu.Unlock()
+	log.WithField("sha256", checksum).Info("Downloaded file to cache.")
+	return path, checksum, nil
+}
+
+// isDownloaded returns true if the file at path has been downloaded by this cache instance
+func (c *FileCache) isDownloaded(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.downloaded[path]
+}
+
+// sidecarChecksum returns the checksum published in the sidecar file of
+// the remote file at u or an empty string if there is none
+func (c *FileCache) sidecarChecksum(ctx context.Context, log logrus.FieldLogger, fetch fetchFunc, u *url.URL, env map[string]string) (string, error) {
+	var buf strings.Builder
+	err := fetch(ctx, sidecarURL(u), env, &buf)
+	if trace.IsNotFound(err) || trace.IsAccessDenied(err) {
+		// the sidecar file is optional
+		return "", nil
+	}
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return parseSidecar(log, u, buf.String()), nil
+}
+
+func (c *FileCache) fetchHTTP(ctx context.Context, u *url.URL, env map[string]string, w io.Writer) error {
+	return trace.Wrap(fetchHTTP(ctx, c.client, u.String(), w))
+}
+
+// fetchGCS downloads the object with the application default credentials
+// if available, and anonymously otherwise
+func (c *FileCache) fetchGCS(ctx context.Context, u *url.URL, env map[string]string, w io.Writer) error {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+	if err != nil {
+		client = c.client
+	}
+	objectURL := fmt.Sprintf("%v/%v%v", c.gcsEndpoint, u.Host, u.EscapedPath())
+	return trace.Wrap(fetchHTTP(ctx, client, objectURL, w))
+}
+
+// fetchS3 downloads the object with the credentials and the region
+// given in the AWS environment variables if set
+func (c *FileCache) fetchS3(ctx context.Context, u *url.URL, env map[string]string, w io.Writer) error {
+	config := aws.NewConfig().WithRegion("us-east-1")
+	if region := env["AWS_DEFAULT_REGION"]; region != "" {
+		config = config.WithRegion(region)
+	}
+	if accessKey := env["AWS_ACCESS_KEY_ID"]; accessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(accessKey, env["AWS_SECRET_ACCESS_KEY"], ""))
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	bucket := u.Host
+	bucketRegion, err := s3manager.GetBucketRegion(ctx, sess, bucket, aws.StringValue(sess.Config.Region))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	out, err := s3.New(sess, aws.NewConfig().WithRegion(bucketRegion)).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+	if err != nil {
+		return trace.Wrap(convertS3Error(err))
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(w, out.Body)
+	return trace.ConvertSystemError(err)
+}
+
+func fetchHTTP(ctx context.Context, client *http.Client, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return trace.ConnectionProblem(err, "GET %v failed", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return trace.Wrap(trace.ReadError(resp.StatusCode, body), "GET %v: %v", url, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return trace.ConvertSystemError(err)
+}
+
+func convertS3Error(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket:
+			return trace.NotFound(aerr.Message())
+		case "AccessDenied":
+			return trace.AccessDenied(aerr.Message())
+		}
+	}
+	return err
+}
+
+// fileChecksum returns the SHA-256 checksum of the local file at path.
+// Returns trace.NotFound if the file does not exist
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type cachedFile struct {
+	path, checksum string
+}
+
+// downloadTimeout limits the time to download a file to the cache
+const downloadTimeout = 30 * time.Minute
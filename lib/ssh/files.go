@@ -18,9 +18,15 @@ package sshutils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/gravitational/robotest/lib/wait"
 
@@ -29,44 +35,226 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// TransferFile takes file URL which may be S3 or HTTP or local file and transfers it to remote the machine
-// fileUrl - file to download, could be S3:// or http(s)://
+// TransferFile takes file URL which may be S3, GCS, HTTP or local file and transfers it to remote the machine.
+// fileUrl - file to download, could be s3://, gs://, http(s)://, file:// or a local path.
+// The file is verified against the SHA-256 checksum given with a #sha256=<checksum> URL fragment
+// or, if there is none, the one published in the sidecar <fileUrl>.sha256 file, if present
 func TransferFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, fileUrl, dstDir string, env map[string]string) (path string, err error) {
-	u, err := url.Parse(fileUrl)
+	file, err := ParseFileURL(fileUrl)
 	if err != nil {
-		return "", trace.Wrap(err, "parsing %s", fileUrl)
+		return "", trace.Wrap(err)
 	}
 
 	log = log.WithFields(logrus.Fields{"file_url": fileUrl, "dst_dir": dstDir})
 
-	fname := filepath.Base(u.Path)
-	dstPath := filepath.Join(dstDir, fname)
-	var cmd string
-	switch u.Scheme {
-	case "s3":
-		cmd = fmt.Sprintf(`aws s3 cp %s - > %s`, fileUrl, dstPath)
-	case "http":
-	case "https":
-		cmd = fmt.Sprintf("wget %s -O %s/", fileUrl, dstPath)
-	case "":
-		remotePath, err := PutFile(ctx, client, log, fileUrl, dstDir)
-		return remotePath, trace.Wrap(err)
-	case "gs":
-	default:
-		// TODO : implement SCP and GCLOUD methods
-		return "", fmt.Errorf("unsupported URL schema %s", fileUrl)
+	if file.URL == nil {
+		return putLocalFile(ctx, client, log, file, dstDir)
 	}
 
+	cmd, err := fetchCmd(file.URL)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	dstPath := filepath.Join(dstDir, file.Name())
 	err = RunCommands(ctx, client, log, []Cmd{
 		{fmt.Sprintf("mkdir -p %s", dstDir), nil},
-		{cmd, env},
+		{fmt.Sprintf("%s > %s", cmd, dstPath), env},
 	})
-	if err == nil {
-		return dstPath, nil
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	checksum := file.Checksum
+	if checksum == "" {
+		checksum, err = fetchSidecarChecksum(ctx, client, log, file.URL, env)
+		if err != nil {
+			return "", trace.Wrap(err)
+		}
+	}
+	err = VerifyChecksum(ctx, client, log, dstPath, checksum)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return dstPath, nil
+}
+
+// FileURL is a file URL accepted by TransferFile
+type FileURL struct {
+	// URL is the URL of a remote file without the checksum fragment.
+	// nil for local files
+	URL *url.URL
+	// LocalPath is the path of a local file
+	LocalPath string
+	// Checksum is the expected SHA-256 checksum of the file
+	// if given with the URL fragment
+	Checksum string
+}
+
+// ParseFileURL parses the file URL accepted by TransferFile
+func ParseFileURL(fileURL string) (*FileURL, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return nil, trace.Wrap(err, "parsing %s", fileURL)
+	}
+	var file FileURL
+	if u.Fragment != "" {
+		file.Checksum, err = parseChecksumFragment(u.Fragment)
+		if err != nil {
+			return nil, trace.Wrap(err, "parsing %s", fileURL)
+		}
+		u.Fragment = ""
+	}
+	switch u.Scheme {
+	case "":
+		file.LocalPath = u.Path
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, trace.BadParameter("file URL %s must specify an absolute path", fileURL)
+		}
+		file.LocalPath = u.Path
+	case "s3", "gs", "http", "https":
+		file.URL = u
+	default:
+		return nil, trace.BadParameter("unsupported URL schema %s", fileURL)
+	}
+	return &file, nil
+}
+
+// Name returns the file name
+func (r FileURL) Name() string {
+	if r.URL == nil {
+		return filepath.Base(r.LocalPath)
+	}
+	return path.Base(r.URL.Path)
+}
+
+// fetchCmd returns the command that writes the remote file at u to stdout
+func fetchCmd(u *url.URL) (string, error) {
+	switch u.Scheme {
+	case "s3":
+		return fmt.Sprintf("aws s3 cp '%s' -", u), nil
+	case "gs":
+		return fmt.Sprintf("gsutil cat '%s'", u), nil
+	case "http", "https":
+		return fmt.Sprintf("wget --quiet --output-document=- '%s'", u), nil
+	}
+	return "", trace.BadParameter("unsupported URL schema %s", u)
+}
+
+// fetchSidecarChecksum returns the checksum published in the sidecar file of
+// the remote file at u or an empty string if there is none
+func fetchSidecarChecksum(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, u *url.URL, env map[string]string) (string, error) {
+	cmd, err := fetchCmd(sidecarURL(u))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	var out string
+	// the sidecar file is optional
+	err = RunAndParse(ctx, client, log, fmt.Sprintf("%s 2>/dev/null || true", cmd), env, ParseAsString(&out))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if out == "" {
+		return "", nil
+	}
+	return parseSidecar(log, u, out), nil
+}
+
+// parseSidecar parses the contents of the sidecar file of the remote file at u.
+// Contents that are not a checksum, i.e. an error page served in place of
+// a missing sidecar file, are treated as no sidecar file
+func parseSidecar(log logrus.FieldLogger, u *url.URL, contents string) string {
+	checksum, err := parseChecksum(contents)
+	if err != nil {
+		log.WithError(err).WithField("url", sidecarURL(u)).Warn("Ignoring invalid checksum file.")
+		return ""
+	}
+	return checksum
+}
+
+// putLocalFile uploads the local file to the remote directory dstDir
+// and verifies its checksum
+func putLocalFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, file *FileURL, dstDir string) (string, error) {
+	checksum := file.Checksum
+	if checksum == "" {
+		data, err := ioutil.ReadFile(file.LocalPath + checksumSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return "", trace.ConvertSystemError(err)
+		}
+		if err == nil {
+			checksum, err = parseChecksum(string(data))
+			if err != nil {
+				return "", trace.Wrap(err)
+			}
+		}
+	}
+	remotePath, err := PutFile(ctx, client, log, file.LocalPath, dstDir)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	err = VerifyChecksum(ctx, client, log, remotePath, checksum)
+	if err != nil {
+		return "", trace.Wrap(err)
 	}
-	return "", trace.Wrap(err)
+	return remotePath, nil
 }
 
+// VerifyChecksum verifies that the remote file at path has the specified SHA-256 checksum.
+// An empty checksum is not verified
+func VerifyChecksum(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, path, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	var out string
+	err := RunAndParse(ctx, client, log, fmt.Sprintf("sha256sum %s", path), nil, ParseAsString(&out))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	actual, err := parseChecksum(out)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if actual != checksum {
+		return trace.CompareFailed("checksum mismatch for %v: expected %v, got %v", path, checksum, actual)
+	}
+	log.WithField("sha256", checksum).Debug("Verified checksum.")
+	return nil
+}
+
+// sidecarURL returns the URL of the checksum file published alongside the file at u
+func sidecarURL(u *url.URL) *url.URL {
+	sidecar := *u
+	sidecar.Path += checksumSuffix
+	sidecar.RawPath = ""
+	return &sidecar
+}
+
+// parseChecksumFragment parses the sha256=<checksum> URL fragment
+func parseChecksumFragment(fragment string) (string, error) {
+	const prefix = "sha256="
+	if !strings.HasPrefix(fragment, prefix) {
+		return "", trace.BadParameter("unsupported URL fragment %q, expected %v<checksum>", fragment, prefix)
+	}
+	return parseChecksum(strings.TrimPrefix(fragment, prefix))
+}
+
+// parseChecksum parses the SHA-256 checksum in the sha256sum output format:
+// the checksum optionally followed by the file name
+func parseChecksum(s string) (string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return "", trace.BadParameter("empty checksum")
+	}
+	checksum := strings.ToLower(fields[0])
+	if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != 2*sha256.Size {
+		return "", trace.BadParameter("invalid SHA-256 checksum %q", fields[0])
+	}
+	return checksum, nil
+}
+
+// checksumSuffix is the suffix of the sidecar checksum file
+const checksumSuffix = ".sha256"
+
 const (
 	// TestRegularFile file exists and is a regular file
 	TestRegularFile = "-f"
@@ -0,0 +1,313 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFileURL(t *testing.T) {
+	checksum := strings.Repeat("ab", sha256.Size)
+	var testCases = []struct {
+		url       string
+		remote    string
+		localPath string
+		checksum  string
+		name      string
+	}{
+		{url: "/robotest/installer.tar", localPath: "/robotest/installer.tar", name: "installer.tar"},
+		{url: "installer.tar#sha256=" + checksum, localPath: "installer.tar", checksum: checksum, name: "installer.tar"},
+		{url: "file:///robotest/installer.tar", localPath: "/robotest/installer.tar", name: "installer.tar"},
+		{url: "s3://builds/installer.tar", remote: "s3://builds/installer.tar", name: "installer.tar"},
+		{url: "gs://builds/7.0/installer.tar", remote: "gs://builds/7.0/installer.tar", name: "installer.tar"},
+		{url: "http://example.com/installer.tar", remote: "http://example.com/installer.tar", name: "installer.tar"},
+		{
+			url:      "https://example.com/installer.tar?v=1#sha256=" + strings.ToUpper(checksum),
+			remote:   "https://example.com/installer.tar?v=1",
+			checksum: checksum,
+			name:     "installer.tar",
+		},
+	}
+	for _, tc := range testCases {
+		file, err := ParseFileURL(tc.url)
+		require.NoError(t, err, tc.url)
+		if tc.remote != "" {
+			require.NotNil(t, file.URL, tc.url)
+			assert.Equal(t, tc.remote, file.URL.String(), tc.url)
+		} else {
+			assert.Nil(t, file.URL, tc.url)
+		}
+		assert.Equal(t, tc.localPath, file.LocalPath, tc.url)
+		assert.Equal(t, tc.checksum, file.Checksum, tc.url)
+		assert.Equal(t, tc.name, file.Name(), tc.url)
+	}
+
+	for _, url := range []string{
+		"ftp://example.com/installer.tar",
+		"file://example.com/installer.tar",
+		"https://example.com/installer.tar#md5=abc",
+		"https://example.com/installer.tar#sha256=abc",
+	} {
+		_, err := ParseFileURL(url)
+		assert.True(t, trace.IsBadParameter(err), "%v: %v", url, err)
+	}
+}
+
+func TestFetchCmd(t *testing.T) {
+	var testCases = []struct {
+		url      string
+		expected string
+	}{
+		{url: "s3://builds/installer.tar", expected: "aws s3 cp 's3://builds/installer.tar' -"},
+		{url: "gs://builds/installer.tar", expected: "gsutil cat 'gs://builds/installer.tar'"},
+		{url: "http://example.com/installer.tar", expected: "wget --quiet --output-document=- 'http://example.com/installer.tar'"},
+		{url: "https://example.com/installer.tar?a=1&b=2", expected: "wget --quiet --output-document=- 'https://example.com/installer.tar?a=1&b=2'"},
+	}
+	for _, tc := range testCases {
+		file, err := ParseFileURL(tc.url)
+		require.NoError(t, err)
+		cmd, err := fetchCmd(file.URL)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, cmd)
+	}
+	assert.Equal(t, "https://example.com/installer.tar.sha256?v=1",
+		sidecarURL(&url.URL{Scheme: "https", Host: "example.com", Path: "/installer.tar", RawQuery: "v=1"}).String())
+}
+
+func TestParseChecksum(t *testing.T) {
+	checksum := strings.Repeat("0f", sha256.Size)
+	for _, s := range []string{checksum, checksum + "  installer.tar\n", "\r\n" + checksum + "\r\n"} {
+		parsed, err := parseChecksum(s)
+		require.NoError(t, err)
+		assert.Equal(t, checksum, parsed)
+	}
+	for _, s := range []string{"", "<html>not found</html>", "0f0f"} {
+		_, err := parseChecksum(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestFileCacheDownloadsOnce(t *testing.T) {
+	content := "installer contents"
+	checksum := sha256Hex(content)
+	srv := newFileServer(t, map[string]string{
+		"/installer.tar":        content,
+		"/installer.tar.sha256": checksum + "  installer.tar\n",
+		"/script.sh":            "true",
+	})
+	cache := NewFileCache(t.TempDir())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path, sum, err := cache.Get(context.Background(), logrus.New(), parseFile(t, srv.URL+"/installer.tar"), nil)
+			assert.NoError(t, err)
+			assert.Equal(t, checksum, sum)
+			assertContent(t, content, path)
+		}()
+	}
+	wg.Wait()
+	path, _, err := cache.Get(context.Background(), logrus.New(), parseFile(t, srv.URL+"/installer.tar"), nil)
+	require.NoError(t, err)
+	assertContent(t, content, path)
+	assert.Equal(t, "installer.tar", filepath.Base(path))
+	assert.Equal(t, 1, srv.downloads("/installer.tar"))
+
+	// the sidecar file is optional
+	_, sum, err := cache.Get(context.Background(), logrus.New(), parseFile(t, srv.URL+"/script.sh"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex("true"), sum)
+}
+
+func TestFileCacheVerifiesChecksum(t *testing.T) {
+	srv := newFileServer(t, map[string]string{
+		"/installer.tar":        "corrupted",
+		"/installer.tar.sha256": sha256Hex("installer contents"),
+	})
+	cache := NewFileCache(t.TempDir())
+
+	_, _, err := cache.Get(context.Background(), logrus.New(), parseFile(t, srv.URL+"/installer.tar"), nil)
+	require.True(t, trace.IsCompareFailed(err), "expected checksum mismatch: %v", err)
+
+	// the fragment takes precedence over the sidecar file
+	_, sum, err := cache.Get(context.Background(), logrus.New(),
+		parseFile(t, srv.URL+"/installer.tar#sha256="+sha256Hex("corrupted")), nil)
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex("corrupted"), sum)
+
+	_, _, err = cache.Get(context.Background(), logrus.New(), parseFile(t, srv.URL+"/missing.tar"), nil)
+	assert.True(t, trace.IsNotFound(err), "expected not found: %v", err)
+}
+
+func TestFileCacheSchemeDispatch(t *testing.T) {
+	srv := newFileServer(t, map[string]string{
+		"/builds/gravity": "gcs object",
+	})
+	cache := NewFileCache(t.TempDir())
+	cache.gcsEndpoint = srv.URL
+
+	var s3Requests []string
+	cache.fetchers["s3"] = func(ctx context.Context, u *url.URL, env map[string]string, w io.Writer) error {
+		s3Requests = append(s3Requests, u.String())
+		assert.Equal(t, "key", env["AWS_ACCESS_KEY_ID"])
+		if strings.HasSuffix(u.Path, checksumSuffix) {
+			return trace.NotFound("no such key")
+		}
+		_, err := io.WriteString(w, "s3 object")
+		return err
+	}
+
+	path, _, err := cache.Get(context.Background(), logrus.New(), parseFile(t, "gs://builds/gravity"), nil)
+	require.NoError(t, err)
+	assertContent(t, "gcs object", path)
+
+	path, _, err = cache.Get(context.Background(), logrus.New(), parseFile(t, "s3://builds/gravity"),
+		map[string]string{"AWS_ACCESS_KEY_ID": "key"})
+	require.NoError(t, err)
+	assertContent(t, "s3 object", path)
+	assert.Equal(t, []string{"s3://builds/gravity.sha256", "s3://builds/gravity"}, s3Requests)
+}
+
+func TestFileCacheReusesOnlyVerifiedFilesAcrossRuns(t *testing.T) {
+	content := "installer contents"
+	srv := newFileServer(t, map[string]string{
+		"/installer.tar":        content,
+		"/installer.tar.sha256": sha256Hex(content),
+		"/latest.tar":           content,
+		// error page served in place of a missing sidecar file
+		"/latest.tar.sha256": "<html>not found</html>",
+	})
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		cache := NewFileCache(dir)
+		for j := 0; j < 2; j++ {
+			for _, name := range []string{"/installer.tar", "/latest.tar"} {
+				path, _, err := cache.Get(context.Background(), logrus.New(), parseFile(t, srv.URL+name), nil)
+				require.NoError(t, err)
+				assertContent(t, content, path)
+			}
+		}
+	}
+	assert.Equal(t, 1, srv.downloads("/installer.tar"))
+	// files without a checksum are downloaded again by a new cache
+	assert.Equal(t, 2, srv.downloads("/latest.tar"))
+}
+
+func TestFileCacheDownloadOutlivesCaller(t *testing.T) {
+	cache := NewFileCache(t.TempDir())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache.fetchers["s3"] = func(ctx context.Context, u *url.URL, env map[string]string, w io.Writer) error {
+		if strings.HasSuffix(u.Path, checksumSuffix) {
+			return trace.NotFound("no such key")
+		}
+		close(started)
+		<-release
+		_, err := io.WriteString(w, "s3 object")
+		return trace.Wrap(err)
+	}
+	file := parseFile(t, "s3://builds/gravity")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		_, _, err := cache.Get(ctx, logrus.New(), file, nil)
+		errC <- err
+	}()
+	<-started
+	type result struct {
+		path string
+		err  error
+	}
+	resultC := make(chan result, 1)
+	go func() {
+		path, _, err := cache.Get(context.Background(), logrus.New(), file, nil)
+		resultC <- result{path: path, err: err}
+	}()
+	cancel()
+	require.Error(t, <-errC)
+
+	close(release)
+	r := <-resultC
+	require.NoError(t, r.err)
+	assertContent(t, "s3 object", r.path)
+}
+
+func parseFile(t *testing.T, url string) *FileURL {
+	file, err := ParseFileURL(url)
+	require.NoError(t, err)
+	return file
+}
+
+func assertContent(t *testing.T, expected, path string) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// fileServer serves files from memory and counts the downloads
+type fileServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func newFileServer(t *testing.T, files map[string]string) *fileServer {
+	srv := &fileServer{count: make(map[string]int)}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		srv.mu.Lock()
+		srv.count[r.URL.Path]++
+		srv.mu.Unlock()
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (r *fileServer) downloads(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count[path]
+}
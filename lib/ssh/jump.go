@@ -0,0 +1,160 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"net"
+	"time"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHost describes an SSH jump host (bastion) used to reach nodes
+// that are not directly accessible
+type JumpHost struct {
+	// Addr is the address of the jump host as host:port.
+	// Port defaults to 22
+	Addr string `json:"addr" yaml:"addr" validate:"required"`
+	// SSHUser defines SSH user used to connect to the jump host
+	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
+	// SSHKeyPath specifies the location of the SSH key for the jump host
+	SSHKeyPath string `json:"key_path" yaml:"key_path" validate:"required"`
+}
+
+// CheckAndSetDefaults validates this jump host and sets defaults
+func (r *JumpHost) CheckAndSetDefaults() error {
+	if r.Addr == "" {
+		return trace.BadParameter("jump host address is required")
+	}
+	if r.SSHUser == "" {
+		return trace.BadParameter("jump host %v: SSH user is required", r.Addr)
+	}
+	if r.SSHKeyPath == "" {
+		return trace.BadParameter("jump host %v: SSH key path is required", r.Addr)
+	}
+	if _, _, err := net.SplitHostPort(r.Addr); err != nil {
+		r.Addr = net.JoinHostPort(r.Addr, "22")
+	}
+	return nil
+}
+
+// Hop describes a single SSH server on the path to a node
+type Hop struct {
+	// Addr is the address of the server as host:port
+	Addr string
+	// User is the SSH user
+	User string
+	// Signer authenticates the user
+	Signer ssh.Signer
+}
+
+// LoadHops validates the specified jump hosts and loads their SSH keys
+func LoadHops(jumpHosts []JumpHost) (hops []Hop, err error) {
+	for _, jumpHost := range jumpHosts {
+		err := jumpHost.CheckAndSetDefaults()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		signer, err := MakePrivateKeySignerFromFile(jumpHost.SSHKeyPath)
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to load SSH key for jump host %v", jumpHost.Addr)
+		}
+		hops = append(hops, Hop{Addr: jumpHost.Addr, User: jumpHost.SSHUser, Signer: signer})
+	}
+	return hops, nil
+}
+
+// ClientVia creates a new SSH client for the server specified with addr
+// and user, connecting through the given jump hosts in order.
+// Each hop authenticates with its own key.
+// The connections to the jump hosts are closed with the returned client
+func ClientVia(hops []Hop, addr, user string, signer ssh.Signer) (*ssh.Client, error) {
+	return clientVia(hops, addr, user, signer, realTimeoutDialer)
+}
+
+func clientVia(hops []Hop, addr, user string, signer ssh.Signer, dialer sshDialer) (*ssh.Client, error) {
+	if len(hops) == 0 {
+		return client(addr, user, signer, dialer)
+	}
+	first := hops[0]
+	jump, err := client(first.Addr, first.User, first.Signer, dialer)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to connect to jump host %v", first.Addr)
+	}
+	clients := []*ssh.Client{jump}
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+	for _, hop := range hops[1:] {
+		jump, err = clientThrough(jump, hop.Addr, hop.User, hop.Signer)
+		if err != nil {
+			closeAll()
+			return nil, trace.Wrap(err, "failed to connect to jump host %v", hop.Addr)
+		}
+		clients = append(clients, jump)
+	}
+	target, err := clientThrough(jump, addr, user, signer)
+	if err != nil {
+		closeAll()
+		return nil, trace.Wrap(err)
+	}
+	go func() {
+		_ = target.Wait()
+		closeAll()
+	}()
+	return target, nil
+}
+
+// clientThrough creates a new SSH client for addr over a connection
+// forwarded by the jump client
+func clientThrough(jump *ssh.Client, addr, user string, signer ssh.Signer) (*ssh.Client, error) {
+	type result struct {
+		client *ssh.Client
+		err    error
+	}
+	resultC := make(chan result, 1)
+	go func() {
+		conn, err := jump.Dial("tcp", addr)
+		if err != nil {
+			resultC <- result{err: trace.Wrap(err)}
+			return
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig(user, signer))
+		if err != nil {
+			conn.Close()
+			resultC <- result{err: trace.Wrap(err)}
+			return
+		}
+		resultC <- result{client: ssh.NewClient(c, chans, reqs)}
+	}()
+	// Forwarded connections do not support deadlines, so guard against
+	// hangs with the same timeout as direct connections
+	select {
+	case res := <-resultC:
+		return res.client, res.err
+	case <-time.After(sshDialTimeout):
+		go func() {
+			if res := <-resultC; res.client != nil {
+				res.client.Close()
+			}
+		}()
+		return nil, trace.ConnectionProblem(nil, "timed out connecting to %v", addr)
+	}
+}
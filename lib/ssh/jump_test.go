@@ -0,0 +1,186 @@
+/*
+Copyright 2020 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestClientViaJumpHosts(t *testing.T) {
+	nodeKey := newSigner(t)
+	jumpKeys := []ssh.Signer{newSigner(t), newSigner(t)}
+
+	node := startServer(t, "node", nodeKey.PublicKey())
+	second := startServer(t, "jump2", jumpKeys[1].PublicKey())
+	first := startServer(t, "jump1", jumpKeys[0].PublicKey())
+	hops := []Hop{
+		{Addr: first, User: "jump1", Signer: jumpKeys[0]},
+		{Addr: second, User: "jump2", Signer: jumpKeys[1]},
+	}
+
+	client, err := ClientVia(hops, node, "node", nodeKey)
+	require.NoError(t, err)
+	defer client.Close()
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	assert.NoError(t, session.Run("true"))
+}
+
+func TestClientViaRejectsWrongHopKey(t *testing.T) {
+	nodeKey := newSigner(t)
+	jumpKey := newSigner(t)
+
+	node := startServer(t, "node", nodeKey.PublicKey())
+	jump := startServer(t, "jump", jumpKey.PublicKey())
+
+	// the node key is not authorized on the jump host
+	_, err := ClientVia([]Hop{{Addr: jump, User: "jump", Signer: nodeKey}}, node, "node", nodeKey)
+	assert.Error(t, err)
+}
+
+func TestJumpHostDefaults(t *testing.T) {
+	jumpHost := JumpHost{Addr: "10.0.0.1", SSHUser: "robotest", SSHKeyPath: "/robotest/config/ops.pem"}
+	require.NoError(t, jumpHost.CheckAndSetDefaults())
+	assert.Equal(t, "10.0.0.1:22", jumpHost.Addr)
+
+	jumpHost = JumpHost{Addr: "10.0.0.1:2222", SSHUser: "robotest", SSHKeyPath: "/robotest/config/ops.pem"}
+	require.NoError(t, jumpHost.CheckAndSetDefaults())
+	assert.Equal(t, "10.0.0.1:2222", jumpHost.Addr)
+
+	jumpHost = JumpHost{Addr: "10.0.0.1"}
+	assert.Error(t, jumpHost.CheckAndSetDefaults())
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+// startServer starts an SSH server that authorizes the given user and key,
+// forwards TCP connections and runs every command successfully.
+// Returns the address of the server
+func startServer(t *testing.T, user string, authorized ssh.PublicKey) string {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == user && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized")
+		},
+	}
+	config.AddHostKey(newSigner(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			go forward(newChannel)
+		case "session":
+			go runSession(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType())
+		}
+	}
+}
+
+func forward(newChannel ssh.NewChannel) {
+	var dest struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &dest); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(dest.Host, fmt.Sprint(dest.Port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+func runSession(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		var exec struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &exec); err == nil && strings.HasPrefix(exec.Command, "sleep ") {
+			// sleep <duration> keeps the session open for the duration
+			if d, err := time.ParseDuration(strings.TrimPrefix(exec.Command, "sleep ")); err == nil {
+				time.Sleep(d)
+			}
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		channel.Close()
+	}
+}
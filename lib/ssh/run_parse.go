@@ -72,11 +72,11 @@ func RunAndParse(
 ) (err error) {
 	log = log.WithField("cmd", cmd)
 
-	session, err := client.NewSession()
+	session, closeSession, err := newSession(ctx, client)
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	defer session.Close()
+	defer closeSession()
 
 	err = session.RequestPty(term, termH, termW, termModes)
 	if err != nil {
@@ -42,11 +42,11 @@ func PutFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, sr
 		return "", trace.Wrap(err, mkdirCmd)
 	}
 
-	session, err := client.NewSession()
+	session, closeSession, err := newSession(ctx, client)
 	if err != nil {
 		return "", trace.Wrap(err)
 	}
-	defer session.Close()
+	defer closeSession()
 
 	f, err := os.Open(srcPath)
 	if err != nil {
@@ -117,11 +117,11 @@ func scpSendFile(out io.WriteCloser, file *os.File, fi os.FileInfo, buf []byte)
 
 // PipeCommand will run a remote command and store as local file
 func PipeCommand(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, cmd, dst string) error {
-	session, err := client.NewSession()
+	session, closeSession, err := newSession(ctx, client)
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	defer session.Close()
+	defer closeSession()
 
 	session.Stdin = new(bytes.Buffer)
 
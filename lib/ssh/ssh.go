@@ -178,7 +178,11 @@ func bytesID(data []byte, atEOF bool) (advance int, token []byte, err error) {
 }
 
 func client(addr, user string, signer ssh.Signer, dialer sshDialer) (*ssh.Client, error) {
-	conf := &ssh.ClientConfig{
+	return dialer.Dial("tcp", addr, clientConfig(user, signer))
+}
+
+func clientConfig(user string, signer ssh.Signer) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
 		User: user,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
@@ -188,8 +192,6 @@ func client(addr, user string, signer ssh.Signer, dialer sshDialer) (*ssh.Client
 			return nil
 		},
 	}
-
-	return dialer.Dial("tcp", addr, conf)
 }
 
 const (
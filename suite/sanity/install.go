@@ -103,9 +103,16 @@ func install(p interface{}) (gravity.TestFunc, error) {
 		}
 		g.OK("application installed", g.OfflineInstall(cluster.Nodes, param.InstallParam))
 		g.OK("wait for active status", g.WaitForActiveStatus(cluster.Nodes))
+		g.OK("kubernetes healthy", kubeHealthy(g, cluster.Nodes))
 	}, nil
 }
 
+// kubeHealthy waits for the workloads of the cluster formed by nodes to become healthy
+func kubeHealthy(g *gravity.TestContext, nodes []gravity.Gravity) error {
+	_, err := g.WaitForKubeHealth(nodes, gravity.KubeHealthParam{})
+	return err
+}
+
 func provision(p interface{}) (gravity.TestFunc, error) {
 	param := p.(installParam)
 
@@ -101,9 +101,12 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 		nodes := cluster.Nodes[0:param.NodeCount]
 		g.OK("install", g.OfflineInstall(nodes, param.InstallParam))
 		g.OK("wait for active status", g.WaitForActiveStatus(nodes))
+		g.StartHealthMonitor(nodes, 0)
 
+		recovered := g.ExpectDegradation("node loss")
 		nodes, removed, err := removeNode(g, nodes, param.ReplaceNodeType, param.PowerOff)
 		g.OK(fmt.Sprintf("node for removal=%v, poweroff=%v", removed, param.PowerOff), err)
+		g.StartHealthMonitor(nodes, 0)
 
 		now := time.Now()
 		g.OK("wait for active status", g.WaitForActiveStatus(nodes))
@@ -114,6 +117,7 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 			g.OK("expand before shrinking",
 				g.Expand(nodes, cluster.Nodes[param.NodeCount:param.NodeCount+1], param.InstallParam))
 			nodes = append(nodes, cluster.Nodes[param.NodeCount])
+			g.StartHealthMonitor(nodes, 0)
 
 			roles, err := g.NodesByRole(nodes)
 			g.OK("node roles after expand", err)
@@ -134,8 +138,12 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 			g.OK("replace node",
 				g.Expand(nodes, cluster.Nodes[param.NodeCount:param.NodeCount+1], param.InstallParam))
 			nodes = append(nodes, cluster.Nodes[param.NodeCount])
+			g.StartHealthMonitor(nodes, 0)
 		}
 
+		g.OK("wait for active status", g.WaitForActiveStatus(nodes))
+		recovered()
+
 		roles, err := g.NodesByRole(nodes)
 		g.OK("final node roles", err)
 		g.Logger().WithFields(logrus.Fields{"roles": roles, "nodes": nodes}).Info("Final Cluster Roles")
@@ -63,5 +63,6 @@ func resize(p interface{}) (gravity.TestFunc, error) {
 			g.Expand(cluster.Nodes[:param.NodeCount], cluster.Nodes[param.NodeCount:param.ToNodes],
 				param.InstallParam))
 		g.OK("wait for active status", g.WaitForActiveStatus(cluster.Nodes[:param.ToNodes]))
+		g.OK("kubernetes healthy", kubeHealthy(g, cluster.Nodes[:param.ToNodes]))
 	}, nil
 }
@@ -40,6 +40,13 @@ func (p upgradeParam) Save() (row map[string]bigquery.Value, insertID string, er
 	return row, "", nil
 }
 
+// PreflightTarget returns the OS and the installers used by the upgrade
+func (p upgradeParam) PreflightTarget() gravity.PreflightTarget {
+	target := p.installParam.PreflightTarget()
+	target.URLs = append(target.URLs, p.BaseInstallerURL, p.GravityURL)
+	return target
+}
+
 func upgrade(p interface{}) (gravity.TestFunc, error) {
 	param := p.(upgradeParam)
 
@@ -53,7 +60,14 @@ func upgrade(p interface{}) (gravity.TestFunc, error) {
 		g.OK("base installer", g.SetInstaller(cluster.Nodes, param.BaseInstallerURL, "base"))
 		g.OK("install", g.OfflineInstall(cluster.Nodes, param.InstallParam))
 		g.OK("wait for active status", g.WaitForActiveStatus(cluster.Nodes))
+		g.OK("installed version", g.VerifyInstalledVersion(cluster.Nodes))
+		g.StartHealthMonitor(cluster.Nodes, 0)
+		// planet is restarted on every node during upgrade
+		upgraded := g.ExpectDegradation("upgrade")
 		g.OK("upgrade", g.Upgrade(cluster.Nodes, param.InstallerURL, param.GravityURL, "upgrade"))
 		g.OK("wait for active status", g.WaitForActiveStatus(cluster.Nodes))
+		g.OK("kubernetes healthy", kubeHealthy(g, cluster.Nodes))
+		upgraded()
+		g.OK("upgraded version", g.VerifyInstalledVersion(cluster.Nodes))
 	}, nil
 }
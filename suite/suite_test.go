@@ -46,8 +46,10 @@ var retries = flag.Int("retries", defaults.MaxRetriesPerTest, "the number of tim
 var failFast = flag.Bool("fail-fast", false, "cancel all scheduled tests and retries on first failure")
 var destroyOnSuccess = flag.Bool("destroy-on-success", true, "remove resources after test success")
 var destroyOnFailure = flag.Bool("destroy-on-failure", false, "remove resources after test failure")
+var reuseVMs = flag.Bool("reuse-vms", false, "reset and reuse cloud VMs across tests, destroying them at the end of the suite")
+var fileCacheDir = flag.String("file-cache-dir", "", "directory to cache files uploaded to nodes that cannot download them (defaults to a temporary directory)")
 
-var resourceListFile = flag.String("resourcegroup-file", "", "file with list of resources created")
+var resourceListFile = flag.String("resourcegroup-file", "", "resource ledger file to journal allocated and destroyed resources")
 var collectLogs = flag.Bool("always-collect-logs", true, "collect logs from nodes once tests are finished. otherwise they will only be pulled for failed tests")
 
 var cloudLogProjectID = flag.String("gcl-project-id", "", "enable logging to the cloud")
@@ -55,6 +57,11 @@ var cloudLogProjectID = flag.String("gcl-project-id", "", "enable logging to the
 var debugFlag = flag.Bool("debug", false, "Verbose mode")
 var debugPort = flag.Int("debug-port", 6060, "Profiling port")
 
+var gc = flag.Bool("gc", false, "destroy leaked resources found in the resource ledger and state directory instead of running tests")
+var gcTTL = flag.Duration("gc-ttl", 24*time.Hour, "minimum age of the resources destroyed with -gc")
+
+var preflight = flag.Bool("preflight", true, "check the provisioner configuration, credentials and installers before scheduling tests")
+
 var versionFlag = flag.Bool("version", false, "Display version information")
 
 // max amount of time test will run
@@ -82,6 +89,11 @@ func TestMain(t *testing.T) {
 		os.Exit(0)
 	}
 
+	if *gc {
+		collectGarbage(t)
+		return
+	}
+
 	if *testSuite == "" || *tag == "" {
 		flag.Usage()
 		t.Fatal("options required")
@@ -117,11 +129,20 @@ func TestMain(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), testMaxTime)
 	defer cancel()
 
+	if *preflight {
+		err = gravity.Preflight(ctx, provisionerConfig, preflightTargets(testSet), log.StandardLogger())
+		if err != nil {
+			t.Fatalf("aborting test suite: %v", err)
+		}
+	}
+
 	policy := gravity.ProvisionerPolicy{
 		DestroyOnSuccess:  *destroyOnSuccess,
 		DestroyOnFailure:  *destroyOnFailure,
 		AlwaysCollectLogs: *collectLogs,
 		ResourceListFile:  *resourceListFile,
+		ReuseVMs:          *reuseVMs,
+		FileCacheDir:      *fileCacheDir,
 	}
 	gravity.SetProvisionerPolicy(policy)
 
@@ -155,6 +176,36 @@ func TestMain(t *testing.T) {
 	fmt.Println("\n******** TEST SUITE COMPLETED **********")
 	for _, res := range result {
 		fmt.Printf("%s %s %s %s\n", res.Status, res.Name, xlog.ToJSON(res.Param), res.LogUrl)
+		for _, metrics := range res.DiskMetrics {
+			fmt.Printf("  disk %v\n", metrics)
+		}
+		for _, dir := range res.Diagnostics {
+			fmt.Printf("  diagnostics %v\n", dir)
+		}
+	}
+}
+
+// preflightTargets returns the parts of the scheduled tests to check in preflight
+func preflightTargets(testSet config.TestSet) (targets []gravity.PreflightTarget) {
+	for _, entry := range testSet {
+		if param, ok := entry.Param.(gravity.PreflightParam); ok {
+			targets = append(targets, param.PreflightTarget())
+		}
+	}
+	return targets
+}
+
+// collectGarbage destroys cloud resources leaked by previous runs
+func collectGarbage(t *testing.T) {
+	initLogger(*debugFlag)
+
+	provisionerConfig := gravity.LoadConfig(t, []byte(*provision))
+	ctx, cancel := context.WithTimeout(context.Background(), testMaxTime)
+	defer cancel()
+
+	err := gravity.CollectGarbage(ctx, provisionerConfig, *resourceListFile, *gcTTL, log.StandardLogger())
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
 	}
 }
 